@@ -0,0 +1,71 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+// ExitForced is the exit code of maestro when it is interrupted a second time
+// while waiting for the commands to stop. It differs from ExitInterrupt so
+// that a caller can tell that the commands have been killed (128+SIGKILL)
+// instead of stopped gracefully.
+const ExitForced = 137
+
+// abortGrace is the time during which a second interrupt kills the commands
+// still running instead of waiting for them to stop.
+const abortGrace = 10 * time.Second
+
+// interruptContext gives a context cancelled when maestro is interrupted. The
+// returned function should be called to stop listening for signals once the
+// execution is done.
+//
+// The first interrupt cancels the context and let the commands stop
+// gracefully. A second interrupt received within abortGrace kills all the
+// processes started by maestro and exits with ExitForced.
+func interruptContext() (context.Context, context.CancelFunc) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		sig         = make(chan os.Signal, 1)
+		done        = make(chan struct{})
+		once        sync.Once
+	)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		for {
+			select {
+			case <-sig:
+			case <-done:
+				return
+			}
+			fmt.Fprintf(stdio.Stderr, "interrupted: waiting for commands to stop (interrupt again within %s to kill them)", abortGrace)
+			fmt.Fprintln(stdio.Stderr)
+			cancel()
+
+			timer := time.NewTimer(abortGrace)
+			select {
+			case <-sig:
+				fmt.Fprintln(stdio.Stderr, "interrupted: killing commands")
+				killChildren()
+				os.Exit(ExitForced)
+			case <-timer.C:
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	stop := func() {
+		once.Do(func() {
+			signal.Stop(sig)
+			close(done)
+			cancel()
+		})
+	}
+	return ctx, stop
+}
@@ -0,0 +1,105 @@
+package maestro
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+const defaultSince = "origin/main"
+
+// Affected executes the commands having at least one of their sources changed
+// since the given git revision.
+func (m *Maestro) Affected(args []string) error {
+	var (
+		set   = flag.NewFlagSet(CmdAffected, flag.ExitOnError)
+		since = set.String("since", defaultSince, "git revision to compare the working tree with")
+		list  = set.Bool("l", false, "only print the affected commands")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	files, err := changedFiles(filepath.Dir(m.File), *since)
+	if err != nil {
+		return err
+	}
+	names, err := m.affected(set.Args(), files)
+	if err != nil {
+		return err
+	}
+	if *list {
+		for _, n := range names {
+			fmt.Fprintln(stdio.Stdout, n)
+		}
+		return nil
+	}
+	for _, n := range names {
+		if err := m.execute(n, nil, stdio.Stdout, stdio.Stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// affected gives the sorted names of the commands (all the commands when none
+// are given) having at least one of their sources in files. The sources are
+// resolved from the working directory of their command.
+func (m *Maestro) affected(commands, files []string) ([]string, error) {
+	var names []string
+	for _, c := range m.getCommandByNames(commands) {
+		ok, err := affectedBy(workGlobs(c.Sources, c.workDir()), files)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name, err)
+		}
+		if ok {
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func affectedBy(sources, files []string) (bool, error) {
+	for _, s := range sources {
+		rule, err := compileGlob(s)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range files {
+			if rule.re.MatchString(filepath.ToSlash(filepath.Clean(f))) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// changedFiles gives the files modified since the given revision (committed
+// or not) and the untracked files found in dir. Paths are absolute.
+func changedFiles(dir, since string) ([]string, error) {
+	var list []string
+	for _, args := range [][]string{
+		{"diff", "--name-only", "--relative", since},
+		{"ls-files", "--others", "--exclude-standard"},
+	} {
+		out, err := runGit(dir, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range strings.Split(out, "\n") {
+			if f = strings.TrimSpace(f); f == "" {
+				continue
+			}
+			f = filepath.Join(dir, filepath.FromSlash(f))
+			if a, err := filepath.Abs(f); err == nil {
+				f = a
+			}
+			list = append(list, f)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,84 @@
+package maestro
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAffected(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=maestro", "-c", "user.email=maestro@localhost"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s", args[4], out)
+		}
+	}
+	for _, f := range []string{"src/main.go", "docs/README.md", "web/app.js", "web/style.css"} {
+		writeTestFile(t, filepath.Join(dir, f), f)
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+
+	writeTestFile(t, filepath.Join(dir, "src", "main.go"), "package main")
+	writeTestFile(t, filepath.Join(dir, "web", "lib", "new.js"), "new")
+
+	files, err := changedFiles(dir, "HEAD")
+	if err != nil {
+		t.Fatalf("fail to get changed files: %s", err)
+	}
+	var got []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	if want := "src/main.go web/lib/new.js"; strings.Join(got, " ") != want {
+		t.Errorf("changed files mismatched: want %s, got %s", want, got)
+	}
+
+	file := fmt.Sprintf(`
+build(workdir = %[1]q, sources = "src/*.go"): {
+	true
+}
+doc(workdir = %[1]q, sources = "docs/*.md"): {
+	true
+}
+web(workdir = %[2]q, sources = "**/*.js"): {
+	true
+}
+style(workdir = %[2]q, sources = "*.css"): {
+	true
+}
+clean: {
+	true
+}
+`, dir, filepath.Join(dir, "web"))
+	mst := decodeFile(t, file)
+	tests := []struct {
+		Commands []string
+		Want     string
+	}{
+		{Want: "build web"},
+		{Commands: []string{"doc", "build"}, Want: "build"},
+		{Commands: []string{"doc", "style"}, Want: ""},
+	}
+	for _, tt := range tests {
+		names, err := mst.affected(tt.Commands, files)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.Commands, err)
+			continue
+		}
+		if got := strings.Join(names, " "); got != tt.Want {
+			t.Errorf("%q: affected commands mismatched: want %q, got %q", tt.Commands, tt.Want, got)
+		}
+	}
+}
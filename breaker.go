@@ -0,0 +1,56 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// breaker counts the failures of the commands of a run and stops the run once
+// the maximum number of failures is reached. A breaker without limit never
+// trips.
+type breaker struct {
+	limit  int64
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	count int64
+}
+
+func createBreaker(limit int64, cancel context.CancelFunc) *breaker {
+	return &breaker{
+		limit:  limit,
+		cancel: cancel,
+	}
+}
+
+// Fail records a failure and reports whether the breaker has tripped.
+func (b *breaker) Fail() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.count++
+	tripped := b.limit > 0 && b.count >= b.limit
+	if tripped && b.cancel != nil {
+		b.cancel()
+	}
+	return tripped
+}
+
+func (b *breaker) Tripped() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit > 0 && b.count >= b.limit
+}
+
+func (b *breaker) Err() error {
+	if !b.Tripped() {
+		return nil
+	}
+	return fmt.Errorf("%d failure(s): no more commands are started", b.count)
+}
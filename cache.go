@@ -0,0 +1,194 @@
+package maestro
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	cacheDir      = "cache"
+	cacheManifest = "manifest.json"
+)
+
+// cachedCommand skips the execution of a command when a previous successful
+// run with the same script, environment and sources exists in the cache. The
+// targets recorded by this run are then restored instead. The sources and
+// the targets are resolved from the working directory of the command and are
+// recorded relative to it.
+type cachedCommand struct {
+	Executer
+
+	dir      string
+	work     string
+	ign      *ignoreList
+	settings CommandSettings
+	stderr   io.Writer
+}
+
+func (m *Maestro) cache(ex Executer, cmd CommandSettings) (Executer, error) {
+	ign, err := loadIgnore(filepath.Dir(m.File))
+	if err != nil {
+		return nil, err
+	}
+	dir := m.MetaExec.CacheDir
+	if dir == "" {
+		dir = filepath.Join(m.stateDir(), cacheDir)
+	}
+	cc := cachedCommand{
+		Executer: ex,
+		dir:      dir,
+		work:     cmd.workDir(),
+		ign:      ign,
+		settings: cmd,
+		stderr:   io.Discard,
+	}
+	return &cc, nil
+}
+
+func (c *cachedCommand) SetErr(w io.Writer) {
+	c.stderr = w
+	c.Executer.SetErr(w)
+}
+
+func (c *cachedCommand) Execute(ctx context.Context, args []string) error {
+	key, err := c.key(args)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(c.dir, key)
+	ok, err := restoreCache(dir, c.work)
+	if err != nil {
+		return err
+	}
+	if ok {
+		fmt.Fprintf(c.stderr, "%s: restored from cache", c.Command())
+		fmt.Fprintln(c.stderr)
+		return nil
+	}
+	if err := c.Executer.Execute(ctx, args); err != nil {
+		return err
+	}
+	files, err := expandGlobs(workGlobs(c.settings.Targets, c.work), nil)
+	if err != nil {
+		return err
+	}
+	return storeCache(dir, c.work, files)
+}
+
+func (c *cachedCommand) key(args []string) (string, error) {
+	script, err := c.Executer.Script(args)
+	if err != nil {
+		return "", err
+	}
+	sources, err := expandGlobs(workGlobs(c.settings.Sources, c.work), c.ign)
+	if err != nil {
+		return "", err
+	}
+	set, err := hashFiles(sources)
+	if err != nil {
+		return "", err
+	}
+	var keys []string
+	for k := range c.settings.Ev {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sum := sha256.New()
+	write := func(str string) {
+		io.WriteString(sum, strconv.Itoa(len(str)))
+		io.WriteString(sum, ":")
+		io.WriteString(sum, str)
+	}
+	write(c.Command())
+	for _, s := range script {
+		write(s)
+	}
+	for _, k := range keys {
+		write(k)
+		write(c.settings.Ev[k])
+	}
+	for _, p := range c.settings.PathPrepend {
+		write(p)
+	}
+	for _, f := range sources {
+		write(relPath(f, c.work))
+		write(set[f])
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// restoreCache copies back in work the files recorded in the cache entry. It
+// reports false when the entry does not exist.
+func restoreCache(dir, work string) (bool, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, cacheManifest))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return false, err
+	}
+	var files []string
+	if err := json.Unmarshal(buf, &files); err != nil {
+		return false, err
+	}
+	for i, f := range files {
+		if err := copyFile(filepath.Join(dir, strconv.Itoa(i)), absPath(f, work)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func storeCache(dir, work string, files []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	list := make([]string, 0, len(files))
+	for i, f := range files {
+		if err := copyFile(f, filepath.Join(dir, strconv.Itoa(i))); err != nil {
+			return err
+		}
+		list = append(list, relPath(f, work))
+	}
+	buf, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the manifest is written last: an entry without it is incomplete
+	return os.WriteFile(filepath.Join(dir, cacheManifest), buf, 0644)
+}
+
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	i, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, i.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
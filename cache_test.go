@@ -0,0 +1,103 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		cache = t.TempDir()
+		src   = filepath.Join(dir, "src.txt")
+		out   = filepath.Join(dir, "out", "out.txt")
+	)
+	writeTestFile(t, src, "version 1")
+
+	execute := func(mode, message string) string {
+		t.Helper()
+		file := fmt.Sprintf(`
+.CACHE_DIR = %q
+export MODE = %s
+build(
+	workdir = %q,
+	cache = true,
+	sources = src.txt,
+	targets = "out/*.txt",
+): {
+	echo %s
+	mkdir -p out
+	cp src.txt out/out.txt
+}
+`, cache, mode, dir, message)
+		var (
+			mst = decodeFile(t, file)
+			buf strings.Builder
+			ex  = resolveCommand(t, mst, "build", ctreeOption{})
+		)
+		if err := ex.Execute(context.Background(), &buf, io.Discard); err != nil {
+			t.Fatalf("build should have succeeded: %s", err)
+		}
+		return buf.String()
+	}
+	// the targets are removed first so that the command is never skipped
+	// because they are newer than its sources
+	check := func(mode, message string, run bool) {
+		t.Helper()
+		if err := os.RemoveAll(filepath.Dir(out)); err != nil {
+			t.Fatalf("fail to remove target: %s", err)
+		}
+		got := execute(mode, message)
+		if ran := got != ""; ran != run {
+			t.Errorf("%s/%s: executed: want %t, got %t (%q)", mode, message, run, ran, got)
+		}
+	}
+	content := func(want string) {
+		t.Helper()
+		buf, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("target not found: %s", err)
+		}
+		if string(buf) != want {
+			t.Errorf("target mismatched: want %q, got %q", want, buf)
+		}
+	}
+
+	check("debug", "building", true)
+	content("version 1")
+	check("debug", "building", false)
+	content("version 1")
+
+	writeTestFile(t, src, "version 2")
+	check("debug", "building", true)
+	content("version 2")
+
+	writeTestFile(t, src, "version 1")
+	check("debug", "building", false)
+	content("version 1")
+
+	check("debug", "compiling", true)
+	check("release", "compiling", true)
+	check("release", "compiling", false)
+	content("version 1")
+
+	entries, err := os.ReadDir(cache)
+	if err != nil {
+		t.Fatalf("fail to read cache: %s", err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("cache entries mismatched: want 4, got %d", len(entries))
+	}
+	buf, err := os.ReadFile(filepath.Join(cache, entries[0].Name(), cacheManifest))
+	if err != nil {
+		t.Fatalf("fail to read manifest: %s", err)
+	}
+	if !strings.Contains(string(buf), `"out/out.txt"`) {
+		t.Errorf("target not recorded relative to the workdir: %s", buf)
+	}
+}
@@ -0,0 +1,35 @@
+package maestro
+
+import (
+	"context"
+
+	"github.com/midbel/maestro/schedule"
+)
+
+// Clock gives the time used to schedule, retry, trace and report the
+// executions of the commands. It can be replaced (see Maestro.Clock) to get
+// deterministic times and durations in tests.
+type Clock = schedule.Clock
+
+// SystemClock gives the clock of the system.
+func SystemClock() Clock {
+	return schedule.SystemClock()
+}
+
+func (m *Maestro) clock() Clock {
+	if m.Clock == nil {
+		return SystemClock()
+	}
+	return m.Clock
+}
+
+// withClock gives a context carrying the clock used by the executers and the
+// commands executed with it. The schedulers give it to their runners too.
+func withClock(ctx context.Context, c Clock) context.Context {
+	return schedule.WithClock(ctx, c)
+}
+
+// clockFrom gives the clock carried by ctx or the clock of the system.
+func clockFrom(ctx context.Context) Clock {
+	return schedule.ClockFrom(ctx)
+}
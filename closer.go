@@ -0,0 +1,79 @@
+package maestro
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// closers keeps the resources opened by maestro (servers, watchers, runs in
+// progress...) that are released when maestro is closed.
+type closers struct {
+	mu     sync.Mutex
+	list   map[int]io.Closer
+	next   int
+	closed bool
+}
+
+func createClosers() *closers {
+	return &closers{
+		list: make(map[int]io.Closer),
+	}
+}
+
+// Add registers c to be closed with the other resources. The returned function
+// removes c from the list once it has been released by its owner. A resource
+// added once the closers are closed is closed immediately.
+func (c *closers) Add(x io.Closer) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		x.Close()
+		return func() {}
+	}
+	id := c.next
+	c.list[id] = x
+	c.next++
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.list, id)
+	}
+}
+
+// Close closes the resources in the reverse order of their registration. All
+// the resources are closed even if some of them fail.
+func (c *closers) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	var list []io.Closer
+	for i := c.next - 1; i >= 0; i-- {
+		if x, ok := c.list[i]; ok {
+			list = append(list, x)
+		}
+	}
+	c.list = nil
+	c.mu.Unlock()
+
+	var errs []error
+	for _, x := range list {
+		if err := x.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return hasError(errs...)
+}
+
+// shutdownTimeout is the time given to the requests in progress when the
+// server of serve is shut down.
+const shutdownTimeout = 10 * time.Second
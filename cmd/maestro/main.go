@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/midbel/maestro"
 )
@@ -17,9 +18,12 @@ var (
 	CmdHash    = ""
 )
 
-const MaestroEnv = "MAESTRO_FILE"
+const (
+	MaestroEnv = "MAESTRO_FILE"
+	PermsEnv   = "MAESTRO_CHECK_PERMS"
+)
 
-const help = `usage: maestro [options] [<command> [options] [<arguments>]]
+const help = `usage: maestro [options] [<command> [options] [<arguments>]...]
 
 maestro helps to organize all the tasks and/or commands that need to be
 performed regularly in a project whatever its nature. It could be the
@@ -47,34 +51,88 @@ listen:   run a HTTP server and execute command from the name available in the
           last element of the URL
 schedule: run commands that have a schedule property set properly at the given
           interval of time
+lint:     check the commands of the maestro file (and their scripts with
+          shellcheck if available) for common mistakes
+selftest: dry run the commands marked as testable and/or their examples to
+          check that the maestro file is still runnable
+affected: execute the commands having sources changed since a git revision
+          (given with --since, default: origin/main)
+watch:    execute a command each time one of the files given in its watch
+          property changes
+export:   write a shell script (to stdout or the file given with -o) that
+          executes a command and its dependencies without maestro
+import:   convert the tasks of another tool (given with --from: makefile,
+          npm, taskfile) into a maestro file
+validate: check that the arguments given after the name of a command are
+          accepted by it without executing it
+doc:      write the documentation of the maestro file or of the given
+          commands (--format: markdown, text, json)
+env:      print the variables of the project (--shell to use them with eval)
+status:   print a summary of the project (--porcelain for prompt segments)
+cancel:   cancel the runs with the given ids or list the runs in progress
+rerun:    execute again the last command (or the last invocation of the given
+          command) with the same arguments (--failed for the last failure)
+history:  print the last invocations of the commands (-a for all files)
+last:     print the last command executed (or the last invocation of the
+          given command)
+logs:     print the last log file of a command (-l to list its log files)
 
 Options:
 
+  --check-perms MODE                      warn about (warn) or refuse (refuse) the maestro files writable
+                                          by their group or by everyone or owned by another user
+  --color WHEN                            colorize the output of the commands (auto, always, never)
+  --confirm-plan                          print the commands that will be executed with their scripts and
+                                          ask for a confirmation before executing them
   -d, --dry                               only print commands that will be executed
   -D NAME[=VALUE], --define NAME[=VALUE]  define NAME with optional value
+  --drift                                 warn when required tools or environment changed since last run
   -f FILE, --file FILE                    read FILE as a maestro file
+  --force                                 execute commands even if their targets are up to date
+  --format FORMAT                         report executed commands in FORMAT (text, tap) or list
+                                          commands in FORMAT (text, json)
   -i, --ignore                            ignore all errors from command
   -I DIR, --includes DIR                  search DIR for included maestro files
+  -l, --list                              list available commands and exit
+  --lock-timeout DURATION                 wait at most DURATION for the lock of a command held by
+                                          another process (default: fail immediately)
+  --log-dir DIR                           write the output of the commands into log files under DIR
   -k, --skip                              don't execute command's dependencies
+  -K, --keep-going                        execute the other commands (of ALL, the dependencies, the
+                                          hosts) after a failure and report all the failures at the end
+  --no-progress                           do not render the progress of the commands running
+                                          concurrently (dependencies in background, hosts)
+  --no-input                              never prompt for missing required options
+                                          and arguments
+  --parallel N                            execute at most N commands of ALL at the same time
   -p, --with-prefix                       prefix each output line with the name of the command
   -r, --remote                            execute commands on remote server
+  --report FILE                           write a report of the executed commands into FILE
+  --report-format FORMAT                  write the report in FORMAT (json, junit)
   -t, --trace                             add tracing information with command execution
+  --trace-format FORMAT                   write tracing information in FORMAT (compact, long, json)
+  --trace-lines                           trace each line of the scripts with its time and write a
+                                          summary of the slowest commands
   -v, --version                           print maestro version and exit
+  -y, --yes                               do not ask for the confirmations of the commands and of
+                                          --confirm-plan
 `
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, help)
+		fmt.Fprint(os.Stderr, help)
 		os.Exit(2)
 	}
 	var (
 		file    = maestro.DefaultFile
 		mst     = maestro.New()
 		version bool
+		list    bool
 	)
 	if str, ok := os.LookupEnv(MaestroEnv); ok && str != "" {
 		file = str
 	}
+	mst.CheckPerms = os.Getenv(PermsEnv)
 
 	options := []Option{
 		{Short: "I", Long: "includes", Desc: "search include files in directories", Ptr: &mst.Includes},
@@ -82,11 +140,29 @@ func main() {
 		{Short: "i", Long: "ignore", Desc: "ignore errors from command", Ptr: &mst.MetaExec.Ignore},
 		{Short: "f", Long: "file", Desc: "read file as maestro file", Ptr: &file},
 		{Short: "k", Long: "skip", Desc: "skip command dependencies", Ptr: &mst.NoDeps},
+		{Short: "K", Long: "keep-going", Desc: "keep going after a failure and report all the failures", Ptr: &mst.KeepGoing},
+		{Long: "parallel", Desc: "number of commands of ALL executed at the same time", Ptr: &mst.Options.Parallel},
 		{Short: "r", Long: "remote", Desc: "execute command on remote server(s)", Ptr: &mst.Remote},
 		{Short: "t", Long: "trace", Desc: "add tracing information command execution", Ptr: &mst.MetaExec.Trace},
 		{Short: "v", Long: "version", Desc: "print maestro version and exit", Ptr: &version},
-		{Short: "D", Long: "define", Desc: "set variables", Ptr: &mst.Locals},
+		{Short: "D", Long: "define", Desc: "set variables", Ptr: mst.Locals},
 		{Short: "p", Long: "with-prefix", Desc: "add a prefix to each output line", Ptr: &mst.WithPrefix},
+		{Long: "check-perms", Desc: "warn about or refuse files writable by other users", Ptr: &mst.CheckPerms},
+		{Long: "drift", Desc: "warn when environment changed since last run", Ptr: &mst.Drift},
+		{Long: "force", Desc: "execute commands even if their targets are up to date", Ptr: &mst.Force},
+		{Long: "color", Desc: "colorize the output of the commands", Ptr: &mst.Color},
+		{Long: "no-progress", Desc: "do not render the progress of concurrent commands", Ptr: &mst.NoProgress},
+		{Long: "format", Desc: "report executed commands in the given format", Ptr: &mst.Format},
+		{Long: "trace-format", Desc: "format of the tracing information", Ptr: &mst.TraceFormat},
+		{Long: "trace-lines", Desc: "trace each line of the scripts", Ptr: &mst.MetaExec.TraceLines},
+		{Long: "report", Desc: "write a report of the execution into the given file", Ptr: &mst.Report},
+		{Long: "report-format", Desc: "format of the report (json, junit)", Ptr: &mst.ReportFormat},
+		{Long: "no-input", Desc: "never prompt for missing options and arguments", Ptr: &mst.NoInput},
+		{Long: "log-dir", Desc: "directory of the log files of the commands", Ptr: &mst.Options.LogDir},
+		{Long: "lock-timeout", Desc: "time to wait for the lock of a command", Ptr: &mst.LockTimeout},
+		{Long: "confirm-plan", Desc: "print the plan of the execution and ask for its confirmation", Ptr: &mst.ConfirmPlan},
+		{Short: "y", Long: "yes", Desc: "execute commands without asking for confirmation", Ptr: &mst.Yes},
+		{Short: "l", Long: "list", Desc: "list available commands and exit", Ptr: &list},
 	}
 
 	parseArgs(options)
@@ -97,11 +173,32 @@ func main() {
 		return
 	}
 
+	if cmd, args := arguments(); cmd == maestro.CmdImport {
+		exit(mst.Import(file, args), file)
+		return
+	}
+
 	err := mst.Load(file)
 	if err != nil {
 		exit(err, file)
 	}
-	switch cmd, args := arguments(); cmd {
+	if list {
+		exit(mst.ListCommands(), file)
+		return
+	}
+	var (
+		cmd, args = arguments()
+		sub       = cmd
+	)
+	if !mst.Builtin(cmd) {
+		sub = ""
+	} else if mst.Shadowed(cmd) {
+		fmt.Fprintf(os.Stderr, "%s: command hidden by the sub-command of maestro (use maestro %s %[1]s)", cmd, maestro.CmdRun)
+		fmt.Fprintln(os.Stderr)
+	}
+	switch sub {
+	case maestro.CmdRun:
+		err = mst.Run(args)
 	case maestro.CmdListen, maestro.CmdServe:
 		err = mst.ListenAndServe(args)
 	case maestro.CmdHelp:
@@ -117,13 +214,44 @@ func main() {
 		err = mst.ExecuteDefault(args)
 	case maestro.CmdSchedule:
 		err = mst.Schedule(args)
+	case maestro.CmdLint:
+		err = mst.Lint(args)
+	case maestro.CmdSelfTest:
+		err = mst.SelfTest(args)
+	case maestro.CmdWatch:
+		err = mst.Watch(args)
+	case maestro.CmdAffected:
+		err = mst.Affected(args)
+	case maestro.CmdExport:
+		err = mst.Export(args)
+	case maestro.CmdValidate:
+		err = mst.Validate(args)
+	case maestro.CmdDoc:
+		err = mst.Doc(args)
+	case maestro.CmdEnv:
+		err = mst.Env(args)
+	case maestro.CmdStatus:
+		err = mst.Status(args)
+	case maestro.CmdCancel:
+		err = mst.Cancel(args)
+	case maestro.CmdRerun:
+		err = mst.Rerun(args)
+	case maestro.CmdLast:
+		err = mst.Last(args)
+	case maestro.CmdLogs:
+		err = mst.Logs(args)
+	case maestro.CmdHistory:
+		err = mst.History(args)
 	case maestro.CmdGraph:
 		if len(args) > 0 {
 			cmd = args[0]
 		}
 		err = mst.Graph(cmd)
 	default:
-		err = mst.Execute(cmd, args)
+		err = mst.ExecuteCommands(cmd, args)
+	}
+	if e := mst.Close(); err == nil {
+		err = e
 	}
 	exit(err, file)
 }
@@ -140,7 +268,7 @@ func exit(err error, file string) {
 	default:
 		fmt.Fprintln(os.Stderr, err)
 	}
-	os.Exit(1)
+	os.Exit(maestro.ExitCode(err))
 }
 
 func printUnexpected(err maestro.UnexpectedError, file string) {
@@ -163,15 +291,8 @@ func printUnexpected(err maestro.UnexpectedError, file string) {
 	}
 	fmt.Fprintln(os.Stderr, strings.Repeat("^", n))
 
-	var msg string
-	if err.Invalid.IsInvalid() {
-		msg = "unexpected character found"
-	} else {
-		// TODO: improve alternative with err.Expected slice once filled by Decoder
-		msg = err.Invalid.String()
-	}
-
-	fmt.Fprintf(os.Stderr, "%s: syntax error - %s", file, msg)
+	// TODO: improve alternative with err.Expected slice once filled by Decoder
+	fmt.Fprintf(os.Stderr, "%s: syntax error - %s", file, err.Message())
 	fmt.Fprintln(os.Stderr)
 }
 
@@ -226,6 +347,20 @@ func parseArgs(options []Option) {
 			if o.Long != "" {
 				flag.BoolVar(v, o.Long, *v, o.Desc)
 			}
+		case *int64:
+			if o.Short != "" {
+				flag.Int64Var(v, o.Short, *v, o.Desc)
+			}
+			if o.Long != "" {
+				flag.Int64Var(v, o.Long, *v, o.Desc)
+			}
+		case *time.Duration:
+			if o.Short != "" {
+				flag.DurationVar(v, o.Short, *v, o.Desc)
+			}
+			if o.Long != "" {
+				flag.DurationVar(v, o.Long, *v, o.Desc)
+			}
 		default:
 		}
 	}
@@ -0,0 +1,97 @@
+package maestro
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+)
+
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// palette gives the colors of the prefixes of the output lines. Red is not
+// used since it is the color of the lines written on stderr.
+var palette = []string{
+	"36",
+	"33",
+	"35",
+	"34",
+	"32",
+	"96",
+	"93",
+	"95",
+	"94",
+	"92",
+}
+
+func checkColor(mode string) error {
+	switch mode {
+	case "", ColorAuto, ColorAlways, ColorNever:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported color mode", mode)
+	}
+}
+
+// colorize tells whether the output written to f should be colorized. In auto
+// mode, the output is colorized when f is a terminal and NO_COLOR is not set.
+func (m *Maestro) colorize(f *os.File) bool {
+	switch m.Color {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	default:
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// colorOf gives the color of the prefix of a command (or of a host). The same
+// name gets always the same color.
+func colorOf(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
+
+func paint(color, str string) string {
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, str)
+}
+
+// prefixLine gives the function used to prefix (and colorize) the lines of
+// the output of a command executed on a remote host. The prefix is given for
+// each line while its color only depends on the host.
+func prefixLine(prefix func() string, host string, color, errors bool) func(string) string {
+	return func(line string) string {
+		if color && errors {
+			line = paint(colorRed, line)
+		}
+		if prefix == nil {
+			return line
+		}
+		str := fmt.Sprintf("[%s]", prefix())
+		if color {
+			str = paint(colorOf(host), str)
+		}
+		return str + " " + line
+	}
+}
+
+// formatPrefix gives the prefix written before each line of the output of a
+// command.
+func formatPrefix(prefix string, color bool) string {
+	if prefix == "" {
+		return prefix
+	}
+	str := fmt.Sprintf("[%s]", prefix)
+	if color {
+		str = paint(colorOf(prefix), str)
+	}
+	return str + " "
+}
@@ -7,13 +7,16 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/midbel/maestro/help"
 	"github.com/midbel/maestro/internal/env"
-	"github.com/midbel/maestro/internal/help"
 	"github.com/midbel/tish"
 )
 
@@ -38,6 +41,15 @@ type CommandDep struct {
 	Bg        bool
 	Optional  bool
 	Mandatory bool
+
+	// the dependency is executed up to Retry times until it succeeds and it
+	// is cancelled after Timeout, in addition to the settings of the command
+	Retry   int64
+	Timeout time.Duration
+
+	// the commands reading the output of the dependency, each one reading
+	// the output of the previous one (eg: fetch -> transform -> load)
+	Pipe []CommandDep
 }
 
 func (c CommandDep) Key() string {
@@ -47,12 +59,75 @@ func (c CommandDep) Key() string {
 	return fmt.Sprintf("%s::%s", c.Space, c.Name)
 }
 
+// CommandTarget is a remote server where a command is executed. The port is
+// DefaultSSHPort when it is not given.
+type CommandTarget struct {
+	User string
+	Host string
+	Port int
+}
+
+func parseTarget(str string) (CommandTarget, error) {
+	var t CommandTarget
+	if x := strings.LastIndex(str, "@"); x >= 0 {
+		t.User, str = str[:x], str[x+1:]
+	}
+	t.Host, t.Port = str, DefaultSSHPort
+	if h, p, err := net.SplitHostPort(str); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return t, fmt.Errorf("%s: invalid port %s", str, p)
+		}
+		t.Host, t.Port = h, port
+	} else if strings.HasPrefix(str, "[") && strings.HasSuffix(str, "]") {
+		t.Host = str[1 : len(str)-1]
+	}
+	if t.Host == "" {
+		return t, fmt.Errorf("%s: missing host", str)
+	}
+	if strings.ContainsAny(t.Host, "[]") {
+		return t, fmt.Errorf("%s: invalid host", str)
+	}
+	return t, nil
+}
+
+// Addr gives the address used to connect to the remote server. The port
+// defaults to DefaultSSHPort.
+func (t CommandTarget) Addr() string {
+	port := t.Port
+	if port <= 0 {
+		port = DefaultSSHPort
+	}
+	return net.JoinHostPort(t.Host, strconv.Itoa(port))
+}
+
+// hasPort tells whether the target given by str sets its port.
+func hasPort(str string) bool {
+	if x := strings.LastIndex(str, "@"); x >= 0 {
+		str = str[x+1:]
+	}
+	_, _, err := net.SplitHostPort(str)
+	return err == nil
+}
+
+func (t CommandTarget) String() string {
+	if t.User == "" {
+		return t.Addr()
+	}
+	return fmt.Sprintf("%s@%s", t.User, t.Addr())
+}
+
 type CommandOption struct {
-	Short    string
-	Long     string
-	Help     string
-	Required bool
-	Flag     bool
+	Short     string
+	Long      string
+	Help      string
+	Required  bool
+	Flag      bool
+	Sensitive bool
+	Prompt    string
+	Choices   []string
+	// name of the option group the option comes from
+	Group string
 
 	Default     string
 	DefaultFlag bool
@@ -62,6 +137,18 @@ type CommandOption struct {
 	Valid ValidateFunc
 }
 
+// Name gives the option as it is written on the command line.
+func (o CommandOption) Name() string {
+	var list []string
+	if o.Short != "" {
+		list = append(list, "-"+o.Short)
+	}
+	if o.Long != "" {
+		list = append(list, "--"+o.Long)
+	}
+	return strings.Join(list, "/")
+}
+
 func (o CommandOption) Validate() error {
 	if o.Flag {
 		return nil
@@ -89,6 +176,22 @@ func (a CommandArg) Validate(arg string) error {
 
 type CommandScript []string
 
+// LineModifier holds the modifiers given at the beginning of a script line.
+type LineModifier struct {
+	// Ignore ignores the error of the line (-)
+	Ignore bool
+	// Silent does not echo the line when tracing (@)
+	Silent bool
+	// Force executes the line even in dry mode (!)
+	Force bool
+	// Capture is the variable set with the output of the line (let name = run)
+	Capture string
+}
+
+func (m LineModifier) IsZero() bool {
+	return !m.Ignore && !m.Silent && !m.Force && m.Capture == ""
+}
+
 func (c CommandScript) Reader() io.Reader {
 	var str bytes.Buffer
 	for i := range c {
@@ -107,18 +210,96 @@ type CommandSettings struct {
 	Alias      []string
 	Short      string
 	Desc       string
+	Icon       string
+	Confirm    string
 	Categories []string
+	Extends    string
+	// forms of the synopsis replacing the one generated from the options and
+	// the arguments
+	Synopsis []string
 
 	Retry   int64
 	WorkDir string
 	Timeout time.Duration
+	// non zero exit codes of the lines of the script that are not failures
+	SuccessCodes []int
+
+	RetryDelay   time.Duration
+	RetryBackoff float64
+	RetryJitter  time.Duration
+
+	Testable bool
+	Examples []string
+	Requires []string
+	Tokens   []string
+	Watch    []string
+	Sources  []string
+	Targets  []string
+	// how the sources are compared with the targets (see freshCommand)
+	Fresh    string
+	Cache    bool
+	Services []string
+	Generate []GeneratedFile
+
+	PathPrepend []string
+	Venv        string
+	Node        string
+	GoFlags     []string
+
+	Position  Position
+	Positions []Position
+
+	Hosts []CommandTarget
+	Sudo  bool
+	// the command is connected to the terminal of maestro (see attach)
+	Interactive bool
+	Lock        string
+	Deps        []CommandDep
+	Options     []CommandOption
+	Args        []CommandArg
+	Schedules   []Schedule
+	Lines       CommandScript
+	Modifiers   []LineModifier
 
-	Hosts     []string
-	Deps      []CommandDep
-	Options   []CommandOption
-	Args      []CommandArg
-	Schedules []Schedule
-	Lines     CommandScript
+	// lines (prefixed by defer) executed after the script whatever its result
+	Finally          CommandScript
+	FinallyModifiers []LineModifier
+
+	Matrix         []MatrixAxis
+	MatrixParallel int64
+
+	Publish    string
+	Artifacts  []string
+	Checksums  bool
+	Provenance bool
+
+	StripAnsi bool
+	Replace   []OutputReplace
+	Extract   string
+
+	// format of the data written by the command on its stdout
+	Output string
+	// names of the values written by the command in the file given by
+	// $MAESTRO_OUTPUT and given to the commands executed after it
+	Outputs []string
+	// format (and schema) of the data read by the command on its stdin
+	Input       string
+	InputSchema string
+
+	// commands (or inline scripts) executed around the command
+	Before  []string
+	After   []string
+	Error   []string
+	Success []string
+
+	// webhooks notified once the command has been executed (see .NOTIFY_URL)
+	NotifyURL []string
+	NotifyOn  string
+
+	// dotenv files loaded in the environment of the command
+	DotEnv []string
+	// variables whose values are masked in the output of the command
+	Sensitive []string
 
 	As map[string]string
 	Ev map[string]string
@@ -143,6 +324,174 @@ func NewCommandSettingsWithLocals(name string, locals *env.Env) (CommandSettings
 	return cmd, nil
 }
 
+// inherit gives to the command the settings of base that it does not set
+// itself. Options are merged, the ones of the command replacing the options of
+// base having the same name. The script and the dependencies of base come
+// before the ones of the command.
+func (s *CommandSettings) inherit(base CommandSettings) {
+	if s.Short == "" {
+		s.Short = base.Short
+	}
+	if s.Desc == "" {
+		s.Desc = base.Desc
+	}
+	if s.Icon == "" {
+		s.Icon = base.Icon
+	}
+	if s.Confirm == "" {
+		s.Confirm = base.Confirm
+	}
+	if len(s.Categories) == 0 {
+		s.Categories = append(s.Categories, base.Categories...)
+	}
+	if s.WorkDir == "" {
+		s.WorkDir = base.WorkDir
+	}
+	if len(s.SuccessCodes) == 0 {
+		s.SuccessCodes = base.SuccessCodes
+	}
+	if len(s.Outputs) == 0 {
+		s.Outputs = base.Outputs
+	}
+	if s.Retry == 0 {
+		s.Retry = base.Retry
+	}
+	if s.Timeout == 0 {
+		s.Timeout = base.Timeout
+	}
+	if s.RetryDelay == 0 {
+		s.RetryDelay = base.RetryDelay
+	}
+	if s.RetryBackoff == 0 {
+		s.RetryBackoff = base.RetryBackoff
+	}
+	if s.RetryJitter == 0 {
+		s.RetryJitter = base.RetryJitter
+	}
+	if s.Venv == "" {
+		s.Venv = base.Venv
+	}
+	if s.Node == "" {
+		s.Node = base.Node
+	}
+	s.Cache = s.Cache || base.Cache
+	s.Testable = s.Testable || base.Testable
+	s.Sudo = s.Sudo || base.Sudo
+	s.Interactive = s.Interactive || base.Interactive
+	if s.Lock == "" {
+		s.Lock = base.Lock
+	}
+
+	inherit := func(list, other []string) []string {
+		if len(list) > 0 {
+			return list
+		}
+		return append(list, other...)
+	}
+	s.Requires = inherit(s.Requires, base.Requires)
+	s.Tokens = inherit(s.Tokens, base.Tokens)
+	s.Watch = inherit(s.Watch, base.Watch)
+	s.Sources = inherit(s.Sources, base.Sources)
+	s.Targets = inherit(s.Targets, base.Targets)
+	if s.Fresh == "" {
+		s.Fresh = base.Fresh
+	}
+	s.Services = inherit(s.Services, base.Services)
+	s.PathPrepend = inherit(s.PathPrepend, base.PathPrepend)
+	s.GoFlags = inherit(s.GoFlags, base.GoFlags)
+	s.Before = inherit(s.Before, base.Before)
+	s.After = inherit(s.After, base.After)
+	s.Error = inherit(s.Error, base.Error)
+	s.Success = inherit(s.Success, base.Success)
+	s.NotifyURL = inherit(s.NotifyURL, base.NotifyURL)
+	if s.NotifyOn == "" {
+		s.NotifyOn = base.NotifyOn
+	}
+
+	if len(s.Hosts) == 0 {
+		s.Hosts = append(s.Hosts, base.Hosts...)
+	}
+	if len(s.Args) == 0 {
+		s.Args = append(s.Args, base.Args...)
+	}
+	if len(s.Matrix) == 0 {
+		s.Matrix = append(s.Matrix, base.Matrix...)
+	}
+	if s.MatrixParallel == 0 {
+		s.MatrixParallel = base.MatrixParallel
+	}
+	if s.Publish == "" {
+		s.Publish = base.Publish
+	}
+	s.Artifacts = inherit(s.Artifacts, base.Artifacts)
+	if s.Input == "" {
+		s.Input, s.InputSchema = base.Input, base.InputSchema
+	}
+	if s.Output == "" {
+		s.Output = base.Output
+	}
+	s.StripAnsi = s.StripAnsi || base.StripAnsi
+	s.Replace = append(append([]OutputReplace{}, base.Replace...), s.Replace...)
+	if s.Extract == "" {
+		s.Extract = base.Extract
+	}
+	s.Checksums = s.Checksums || base.Checksums
+	s.Provenance = s.Provenance || base.Provenance
+	s.DotEnv = append(append([]string{}, base.DotEnv...), s.DotEnv...)
+	if len(s.Generate) == 0 {
+		s.Generate = append(s.Generate, base.Generate...)
+	}
+	var options []CommandOption
+	for _, o := range base.Options {
+		if !s.hasOption(o) {
+			options = append(options, o)
+		}
+	}
+	s.Options = append(options, s.Options...)
+
+	s.Deps = append(append([]CommandDep{}, base.Deps...), s.Deps...)
+	s.Lines = append(append(CommandScript{}, base.Lines...), s.Lines...)
+	s.Modifiers = append(append([]LineModifier{}, base.Modifiers...), s.Modifiers...)
+	s.Finally = append(append(CommandScript{}, base.Finally...), s.Finally...)
+	s.FinallyModifiers = append(append([]LineModifier{}, base.FinallyModifiers...), s.FinallyModifiers...)
+	s.Positions = append(append([]Position{}, base.Positions...), s.Positions...)
+
+	for k, v := range base.Ev {
+		if _, ok := s.Ev[k]; !ok {
+			s.Ev[k] = v
+		}
+	}
+	for k, v := range base.As {
+		if _, ok := s.As[k]; !ok {
+			s.As[k] = v
+		}
+	}
+}
+
+// takesValue tells whether the option given on the command line expects a
+// value given in the next word.
+func (s CommandSettings) takesValue(arg string) bool {
+	if strings.Contains(arg, "=") {
+		return false
+	}
+	arg = strings.TrimLeft(arg, "-")
+	for _, o := range s.Options {
+		if arg != "" && (arg == o.Short || arg == o.Long) {
+			return !o.Flag
+		}
+	}
+	return false
+}
+
+func (s CommandSettings) hasOption(opt CommandOption) bool {
+	for _, o := range s.Options {
+		if (o.Short != "" && o.Short == opt.Short) || (o.Long != "" && o.Long == opt.Long) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s CommandSettings) Command() string {
 	return s.Name
 }
@@ -152,7 +501,9 @@ func (s CommandSettings) About() string {
 }
 
 func (s CommandSettings) Help() (string, error) {
-	return help.Command(s)
+	var str strings.Builder
+	err := help.Text().Command(&str, s.HelpCommand())
+	return str.String(), err
 }
 
 func (s CommandSettings) Tags() []string {
@@ -162,7 +513,20 @@ func (s CommandSettings) Tags() []string {
 	return s.Categories
 }
 
+// Usage gives the synopsis of the command. It is generated from the options and
+// the arguments of the command unless the usage property is set. Each form of
+// the usage property is given on its own line.
 func (s CommandSettings) Usage() string {
+	if len(s.Synopsis) > 0 {
+		var list []string
+		for _, u := range s.Synopsis {
+			if u != s.Name && !strings.HasPrefix(u, s.Name+" ") {
+				u = s.Name + " " + u
+			}
+			list = append(list, u)
+		}
+		return strings.Join(list, "\n")
+	}
 	var str strings.Builder
 	str.WriteString(s.Name)
 	for _, o := range s.Options {
@@ -199,23 +563,45 @@ func (s CommandSettings) Remote() bool {
 }
 
 func (s CommandSettings) Prepare(options ...tish.ShellOption) (Executer, error) {
+	ev, err := s.environ()
+	if err != nil {
+		return nil, err
+	}
+	locals := s.locals.Copy()
+	locals.Define(mstCommand, []string{s.Name})
 	list := []tish.ShellOption{
-		tish.WithEnv(s.locals.Copy()),
-		tish.WithExport(s.Ev),
+		tish.WithEnv(locals),
+		tish.WithExport(ev),
 		tish.WithAlias(s.As),
 	}
+	if s.WorkDir != "" {
+		list = append(list, tish.WithCwd(s.WorkDir))
+	}
 	sh, err := tish.New(append(options, list...)...)
 	if err != nil {
 		return nil, err
 	}
 	cmd := command{
-		name:    s.Command(),
-		retry:   s.Retry,
-		timeout: s.Timeout,
-		shell:   sh,
+		name:     s.Command(),
+		retry:    s.Retry,
+		timeout:  s.Timeout,
+		delay:    s.RetryDelay,
+		backoff:  s.RetryBackoff,
+		jitter:   s.RetryJitter,
+		codes:    s.SuccessCodes,
+		outputs:  s.Outputs,
+		interact: s.Interactive,
+		shell:    sh,
+		locals:   locals,
+		secrets:  new(secrets),
 	}
+	cmd.secrets.Add(s.sensitiveValues(locals, ev)...)
 	cmd.help, _ = s.Help()
 	cmd.script = append(cmd.script, s.Lines...)
+	cmd.mods = append(cmd.mods, s.Modifiers...)
+	cmd.final = append(cmd.final, s.Finally...)
+	cmd.finalMods = append(cmd.finalMods, s.FinallyModifiers...)
+	cmd.generated = append(cmd.generated, s.Generate...)
 	cmd.options = append(cmd.options, s.Options...)
 	cmd.args = append(cmd.args, s.Args...)
 	cmd.deps = append(cmd.deps, s.Deps...)
@@ -223,6 +609,96 @@ func (s CommandSettings) Prepare(options ...tish.ShellOption) (Executer, error)
 	return &cmd, nil
 }
 
+// sensitiveArgs gives the positions in args of the sensitive options and of
+// their values. The position of an option given with its value (-p=value) is
+// also the position of its value. The options are read as the command reads
+// them: until the first argument that is not an option.
+func (s CommandSettings) sensitiveArgs(args []string) [][2]int {
+	options := make(map[string]CommandOption)
+	for _, o := range s.Options {
+		if o.Short != "" {
+			options[o.Short] = o
+		}
+		if o.Long != "" {
+			options[o.Long] = o
+		}
+	}
+	var list [][2]int
+	for i := 0; i < len(args); i++ {
+		str := args[i]
+		if str == "--" || len(str) < 2 || str[0] != '-' {
+			break
+		}
+		name, _, inline := strings.Cut(strings.TrimPrefix(str[1:], "-"), "=")
+		o, ok := options[name]
+		if !ok || o.Flag {
+			continue
+		}
+		pos := [2]int{i, i}
+		if !inline {
+			if i++; i >= len(args) {
+				break
+			}
+			pos[1] = i
+		}
+		if o.Sensitive {
+			list = append(list, pos)
+		}
+	}
+	return list
+}
+
+// maskArgs gives the arguments of the command with the values of its sensitive
+// options replaced by a mask and the values masked.
+func (s CommandSettings) maskArgs(args []string) ([]string, []string) {
+	var (
+		list   = append([]string{}, args...)
+		values []string
+	)
+	for _, p := range s.sensitiveArgs(args) {
+		str := list[p[1]]
+		if p[0] == p[1] {
+			var name string
+			name, str, _ = strings.Cut(str, "=")
+			list[p[1]] = name + "=" + secretMask
+		} else {
+			list[p[1]] = secretMask
+		}
+		values = append(values, str)
+	}
+	return list, values
+}
+
+// unmaskArgs removes the sensitive options masked by maskArgs from args so
+// that their values are asked again.
+func (s CommandSettings) unmaskArgs(args []string) []string {
+	var (
+		list []string
+		last int
+	)
+	for _, p := range s.sensitiveArgs(args) {
+		list = append(list, args[last:p[0]]...)
+		last = p[1] + 1
+	}
+	return append(list, args[last:]...)
+}
+
+// sensitiveValues gives the values of the sensitive variables: the maestro
+// variables and the variables exported to the environment of the command.
+func (s CommandSettings) sensitiveValues(locals *env.Env, ev map[string]string) []string {
+	var list []string
+	for _, n := range s.Sensitive {
+		if vs, err := locals.Resolve(n); err == nil && len(vs) > 0 {
+			list = append(list, strings.Join(vs, " "))
+			list = append(list, vs...)
+		}
+		if v, ok := ev[n]; ok {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 type command struct {
 	name string
 	help string
@@ -230,12 +706,32 @@ type command struct {
 
 	retry   int64
 	timeout time.Duration
+	delay   time.Duration
+	backoff float64
+	jitter  time.Duration
+	codes   []int
+	outputs []string
+
+	interact bool
+	console  *console
+
+	script CommandScript
+	mods   []LineModifier
+	echo   bool
 
-	script  CommandScript
-	args    []CommandArg
-	options []CommandOption
+	final     CommandScript
+	finalMods []LineModifier
 
-	shell *tish.Shell
+	generated []GeneratedFile
+	prompt    *prompter
+	args      []CommandArg
+	options   []CommandOption
+
+	shell   *tish.Shell
+	stdout  io.Writer
+	stderr  io.Writer
+	locals  *env.Env
+	secrets *secrets
 }
 
 func (c *command) Command() string {
@@ -247,11 +743,29 @@ func (c *command) Dependencies() []CommandDep {
 }
 
 func (c *command) SetOut(w io.Writer) {
-	c.shell.SetOut(w)
+	c.stdout = c.secrets.Writer(w)
+	c.shell.SetOut(c.stdout)
 }
 
 func (c *command) SetErr(w io.Writer) {
-	c.shell.SetErr(w)
+	c.stderr = c.secrets.Writer(w)
+	c.shell.SetErr(c.stderr)
+}
+
+func (c *command) SetIn(r io.Reader) {
+	c.shell.SetIn(r)
+}
+
+// flush writes the end of the output of the command kept to mask the secrets
+// written in several parts.
+func (c *command) flush() {
+	flushMask(c.stdout)
+	flushMask(c.stderr)
+}
+
+// Secrets gives the values masked in the output of the command.
+func (c *command) Secrets() []string {
+	return c.secrets.Values()
 }
 
 func (c *command) Register(ctx context.Context, other Executer) {
@@ -264,13 +778,58 @@ func (c *command) Dry(args []string) error {
 	if err != nil {
 		return err
 	}
-	for _, cmd := range c.script {
-		err = c.shell.Dry(cmd, c.name, args)
+	defer c.flush()
+	script, err := c.expandScript()
+	if err != nil {
+		return err
+	}
+	// the lines are written with the interpreter executing them. The lines
+	// with the force modifier are written and then executed
+	for i, cmd := range script {
+		list, err := c.dryLines(cmd, args)
 		if err != nil {
-			break
+			return err
+		}
+		mod := c.modifier(i)
+		for j := range list {
+			list[j].Executed = mod.Force
+			list[j].Capture = mod.Capture
+		}
+		writeDryLines(c.output(), list)
+		if !mod.Force {
+			continue
+		}
+		if err := c.executeLine(context.Background(), cmd, mod, args); err != nil {
+			return err
 		}
 	}
-	return err
+	final, err := c.expandLines(c.final)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range final {
+		list, err := c.dryLines(cmd, args)
+		if err != nil {
+			return err
+		}
+		for j := range list {
+			list[j].Deferred = true
+		}
+		writeDryLines(c.output(), list)
+	}
+	return nil
+}
+
+func (c *command) SetEcho(echo bool) {
+	c.echo = echo
+	c.shell.SetEcho(echo)
+}
+
+func (c *command) modifier(i int) LineModifier {
+	if i < len(c.mods) {
+		return c.mods[i]
+	}
+	return LineModifier{}
 }
 
 func (c *command) Script(args []string) ([]string, error) {
@@ -278,8 +837,12 @@ func (c *command) Script(args []string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	script, err := c.expandScript()
+	if err != nil {
+		return nil, err
+	}
 	var list []string
-	for _, str := range c.script {
+	for _, str := range script {
 		rs, err := c.shell.Expand(str, args)
 		if err != nil {
 			return nil, err
@@ -294,32 +857,320 @@ func (c *command) Execute(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
+	if r := stdinFrom(ctx); r != nil {
+		c.SetIn(r)
+	}
+	defer c.flush()
+	if c.interact {
+		release, err := c.attach()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 	if c.retry <= 0 {
 		c.retry = 1
 	}
+	remove, err := c.generateFiles()
+	if err != nil {
+		return err
+	}
+	defer remove()
+	outputsFrom(ctx).Export(c.shell)
+	file, clean, err := c.createOutputFile()
+	if err != nil {
+		return err
+	}
+	defer clean()
 	if c.timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, c.timeout)
 		defer cancel()
 	}
+	var (
+		delay    = c.delay
+		attempts = attemptsFrom(ctx)
+	)
+	// the commands executed by the script have their own attempts
+	ctx = withAttempts(ctx, nil)
 	for i := int64(0); i < c.retry; i++ {
+		if i > 0 {
+			if e := c.wait(ctx, delay); e != nil {
+				break
+			}
+			if c.backoff > 0 {
+				delay = time.Duration(float64(delay) * c.backoff)
+			}
+		}
+		if attempts != nil {
+			*attempts++
+		}
 		err = c.execute(ctx, args)
 		if err == nil {
 			break
 		}
 	}
+	if err == nil && file != "" {
+		err = outputsFrom(ctx).Read(c.name, file, c.outputs)
+	}
+	if e := c.finalize(args); err == nil {
+		err = e
+	}
 	if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
 		return err
 	}
 	return err
 }
 
+func (c *command) wait(ctx context.Context, delay time.Duration) error {
+	if c.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(c.jitter)))
+	}
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clockFrom(ctx).After(delay):
+		return nil
+	}
+}
+
 func (c *command) execute(ctx context.Context, args []string) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
-	c.shell.Run(ctx, c.script.Reader(), c.name, args)
-	return nil
+	script, err := c.expandScript()
+	if err != nil {
+		return err
+	}
+	err = c.run(ctx, script, args)
+	if code := tish.ExitCode(0); errors.As(err, &code) {
+		err = fmt.Errorf("%s: exit status %w", c.name, err)
+	}
+	if prog, ok := missingProgram(err); ok {
+		err = missingError{
+			Command: c.name,
+			Program: prog,
+			err:     err,
+		}
+	}
+	return err
+}
+
+// run executes the script. Consecutive lines without modifiers are given
+// together to the shell. The lines with modifiers are executed one by one and,
+// when the lines are traced or when the command has success codes, all the
+// lines are executed one by one.
+func (c *command) run(ctx context.Context, script CommandScript, args []string) error {
+	var (
+		tracer = traceFrom(ctx)
+		clock  = clockFrom(ctx)
+		block  CommandScript
+		flush  = func() error {
+			if len(block) == 0 {
+				return nil
+			}
+			defer func() {
+				block = block[:0]
+			}()
+			err := c.shell.Run(ctx, block.Reader(), c.name, args)
+			if e := ctx.Err(); e != nil {
+				err = e
+			}
+			return err
+		}
+	)
+	for i, line := range script {
+		mod := c.modifier(i)
+		if mod.IsZero() && tracer == nil && len(c.codes) == 0 {
+			block = append(block, line)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if mod.Silent {
+			c.shell.SetEcho(false)
+		}
+		start := clock.Now()
+		err := c.executeLine(ctx, line, mod, args)
+		c.shell.SetEcho(c.echo)
+		if tracer != nil && !mod.Silent && c.stderr != nil {
+			tracer.Line(c.stderr, traceLine{
+				Command: c.name,
+				Line:    line,
+				Start:   start,
+				Elapsed: clock.Now().Sub(start),
+				Err:     err,
+			})
+		}
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		if err != nil && !mod.Ignore && !isSuccess(c.codes, err) {
+			return err
+		}
+	}
+	return flush()
+}
+
+// executeLine executes a single line of the script. The output of a line that
+// captures it is not written: it is the value of the variable of the line.
+func (c *command) executeLine(ctx context.Context, line string, mod LineModifier, args []string) error {
+	if mod.Capture == "" {
+		return c.shell.Execute(ctx, line, c.name, args)
+	}
+	var buf bytes.Buffer
+	c.shell.SetOut(&buf)
+	defer c.shell.SetOut(c.output())
+
+	if err := c.shell.Execute(ctx, line, c.name, args); err != nil {
+		return err
+	}
+	value := strings.TrimRight(buf.String(), "\r\n")
+	outputsFrom(ctx).Set(mod.Capture, value)
+	return c.shell.Define(mod.Capture, []string{value})
+}
+
+// output gives the writer of the output of the command.
+func (c *command) output() io.Writer {
+	if c.stdout == nil {
+		c.stdout = c.secrets.Writer(os.Stdout)
+	}
+	return c.stdout
+}
+
+// isSuccess tells whether err is the exit of a program with one of the given
+// success codes.
+func isSuccess(codes []int, err error) bool {
+	if err == nil {
+		return true
+	}
+	code, ok := statusCode(err)
+	if !ok {
+		return false
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// finalizeTimeout is the maximum time given to the deferred lines of a script.
+const finalizeTimeout = time.Minute
+
+// finalize executes the deferred lines of the script. They are executed even
+// when the script has failed or has been cancelled. All the lines are executed
+// and the first error is returned.
+func (c *command) finalize(args []string) error {
+	if len(c.final) == 0 {
+		return nil
+	}
+	script, err := c.expandLines(c.final)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), finalizeTimeout)
+	defer cancel()
+
+	var errs []error
+	for i, line := range script {
+		var mod LineModifier
+		if i < len(c.finalMods) {
+			mod = c.finalMods[i]
+		}
+		if mod.Silent {
+			c.shell.SetEcho(false)
+		}
+		err := c.shell.Execute(ctx, line, c.name, args)
+		c.shell.SetEcho(c.echo)
+		if err != nil && !mod.Ignore {
+			errs = append(errs, err)
+		}
+	}
+	return hasError(errs...)
+}
+
+var builtinPattern = regexp.MustCompile(`^%\(([a-zA-Z_][a-zA-Z0-9_.]*)\)`)
+
+// builtinNamespaces are the prefixes of the built-in variables. Any other
+// %(name) found in the scripts is left as is (eg: python and printf formats).
+var builtinNamespaces = []string{"git.", "maestro.", sysEnv}
+
+func isBuiltinName(name string) bool {
+	for _, p := range builtinNamespaces {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandScript replaces the built-in variables (eg: %(git.branch)) found in
+// the script by their values since the shell does not know them.
+func (c *command) expandScript() (CommandScript, error) {
+	return c.expandLines(c.script)
+}
+
+func (c *command) expandLines(lines CommandScript) (CommandScript, error) {
+	var script CommandScript
+	for _, line := range lines {
+		line, err := expandBuiltins(line, c.resolveBuiltin)
+		if err != nil {
+			return nil, err
+		}
+		script = append(script, line)
+	}
+	return script, nil
+}
+
+func (c *command) resolveBuiltin(name string) ([]string, error) {
+	if strings.HasPrefix(name, sysEnv) {
+		return []string{os.Getenv(strings.TrimPrefix(name, sysEnv))}, nil
+	}
+	return c.locals.Resolve(name)
+}
+
+// expandBuiltins replaces the built-in variables found in line by their
+// values. The strings enclosed in single quotes are left as is as the shell
+// does with its own variables.
+func expandBuiltins(line string, resolve func(string) ([]string, error)) (string, error) {
+	var (
+		buf   strings.Builder
+		quote byte
+	)
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case ch == '\\' && quote != '\'' && i+1 < len(line):
+			buf.WriteString(line[i : i+2])
+			i++
+			continue
+		case ch == '\'' || ch == '"':
+			if quote == 0 {
+				quote = ch
+			} else if quote == ch {
+				quote = 0
+			}
+		case ch == '%' && quote != '\'':
+			m := builtinPattern.FindStringSubmatch(line[i:])
+			if m == nil || !isBuiltinName(m[1]) {
+				break
+			}
+			vs, err := resolve(m[1])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(strings.Join(vs, " "))
+			i += len(m[0]) - 1
+			continue
+		}
+		buf.WriteByte(line[i])
+	}
+	return buf.String(), nil
 }
 
 func (c *command) parseArgs(args []string) ([]string, error) {
@@ -327,6 +1178,9 @@ func (c *command) parseArgs(args []string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := c.askOptions(set); err != nil {
+		return nil, err
+	}
 	define := func(name, value string) error {
 		if name == "" {
 			return nil
@@ -351,22 +1205,31 @@ func (c *command) parseArgs(args []string) ([]string, error) {
 		if err := hasError(e1, e2); err != nil {
 			return nil, err
 		}
+		if o.Sensitive && !o.Flag {
+			c.secrets.Add(o.Target)
+		}
 	}
-	if z := len(c.args); z > 0 && set.NArg() < z {
-		return nil, fmt.Errorf("%s: no enough argument supplied! expected %d, got %d", c.name, z, set.NArg())
+	rest, err := c.askArguments(set.Args())
+	if err != nil {
+		return nil, err
+	}
+	if z := len(c.args); z > 0 && len(rest) < z {
+		return nil, fmt.Errorf("%s: no enough argument supplied! expected %d, got %d", c.name, z, len(rest))
+	}
+	for i, a := range c.args {
+		if err := a.Validate(rest[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Name, err)
+		}
 	}
-	return set.Args(), nil
+	return rest, nil
 }
 
 func (c *command) prepareArgs(args []string) (*flag.FlagSet, error) {
 	var (
-		set  = flag.NewFlagSet(c.name, flag.ExitOnError)
+		set  = flag.NewFlagSet(c.name, flag.ContinueOnError)
 		seen = make(map[string]struct{})
 	)
-	set.Usage = func() {
-		fmt.Fprintln(os.Stdout, strings.TrimSpace(c.help))
-		os.Exit(1)
-	}
+	set.SetOutput(io.Discard)
 	check := func(name string) error {
 		if name == "" {
 			return nil
@@ -379,14 +1242,14 @@ func (c *command) prepareArgs(args []string) (*flag.FlagSet, error) {
 	}
 	attach := func(name, help, value string, target *string) error {
 		err := check(name)
-		if err == nil {
+		if err == nil && name != "" {
 			set.StringVar(target, name, value, help)
 		}
 		return err
 	}
 	attachFlag := func(name, help string, value bool, target *bool) error {
 		err := check(name)
-		if err == nil {
+		if err == nil && name != "" {
 			set.BoolVar(target, name, value, help)
 		}
 		return err
@@ -405,7 +1268,7 @@ func (c *command) prepareArgs(args []string) (*flag.FlagSet, error) {
 		}
 	}
 	if err := set.Parse(args); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", c.name, err)
 	}
 	return set, nil
 }
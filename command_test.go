@@ -0,0 +1,127 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		Input string
+		Want  CommandTarget
+		Addr  string
+		Fail  bool
+	}{
+		{Input: "web1", Want: CommandTarget{Host: "web1", Port: DefaultSSHPort}, Addr: "web1:22"},
+		{Input: "admin@web1", Want: CommandTarget{User: "admin", Host: "web1", Port: DefaultSSHPort}, Addr: "web1:22"},
+		{Input: "admin@10.0.0.1:2222", Want: CommandTarget{User: "admin", Host: "10.0.0.1", Port: 2222}, Addr: "10.0.0.1:2222"},
+		{Input: "deploy@ci@web1", Want: CommandTarget{User: "deploy@ci", Host: "web1", Port: DefaultSSHPort}, Addr: "web1:22"},
+		{Input: "[::1]:2200", Want: CommandTarget{Host: "::1", Port: 2200}, Addr: "[::1]:2200"},
+		{Input: "root@[fe80::1]", Want: CommandTarget{User: "root", Host: "fe80::1", Port: DefaultSSHPort}, Addr: "[fe80::1]:22"},
+		{Input: "::1", Want: CommandTarget{Host: "::1", Port: DefaultSSHPort}, Addr: "[::1]:22"},
+		{Input: "web1:ssh", Fail: true},
+		{Input: "web1:", Fail: true},
+		{Input: "web1:0", Fail: true},
+		{Input: "web1:65536", Fail: true},
+		{Input: "[::1]:abc", Fail: true},
+		{Input: "[::1", Fail: true},
+		{Input: "", Fail: true},
+		{Input: "admin@", Fail: true},
+		{Input: ":2222", Fail: true},
+		{Input: "admin@[]:22", Fail: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTarget(tt.Input)
+		if tt.Fail {
+			if err == nil {
+				t.Errorf("%q: invalid target should have been rejected (%+v)", tt.Input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: fail to parse target: %s", tt.Input, err)
+			continue
+		}
+		if got != tt.Want {
+			t.Errorf("%q: target mismatched: want %+v, got %+v", tt.Input, tt.Want, got)
+		}
+		if addr := got.Addr(); addr != tt.Addr {
+			t.Errorf("%q: address mismatched: want %s, got %s", tt.Input, tt.Addr, addr)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	const file = `
+flaky(
+	retry = 4,
+	retry_delay = 1s,
+	retry_backoff = 2,
+): {
+	exit 1
+}
+jitter(
+	retry = 3,
+	retry_delay = 1s,
+	retry_jitter = 500ms,
+): {
+	exit 1
+}
+`
+	mst := decodeFile(t, file)
+	execute := func(name string, clock *fakeClock) int {
+		t.Helper()
+		cmd, err := mst.Commands.Lookup(name)
+		if err != nil {
+			t.Fatalf("command not found: %s", err)
+		}
+		ex, err := cmd.Prepare()
+		if err != nil {
+			t.Fatalf("fail to prepare command: %s", err)
+		}
+		ex.SetOut(io.Discard)
+		ex.SetErr(io.Discard)
+
+		var (
+			attempts    int
+			ctx, cancel = context.WithCancel(context.Background())
+		)
+		defer cancel()
+		if clock.limit > 0 {
+			clock.blocked = cancel
+		}
+		ctx = withAttempts(withClock(ctx, clock), &attempts)
+		if err := ex.Execute(ctx, nil); err == nil {
+			t.Fatalf("%s: command should have failed", name)
+		}
+		return attempts
+	}
+
+	clock := fakeClock{}
+	if n := execute("flaky", &clock); n != 4 {
+		t.Errorf("attempts mismatched: want 4, got %d", n)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if got := clock.Waits(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("delays mismatched: want %s, got %s", want, got)
+	}
+
+	clock = fakeClock{}
+	if n := execute("jitter", &clock); n != 3 {
+		t.Errorf("attempts mismatched: want 3, got %d", n)
+	}
+	for _, w := range clock.Waits() {
+		if w < time.Second || w >= time.Second+500*time.Millisecond {
+			t.Errorf("delay out of range: %s", w)
+		}
+	}
+
+	// the context is cancelled while the command waits for its third attempt
+	clock = fakeClock{limit: 1}
+	if n := execute("flaky", &clock); n != 2 {
+		t.Errorf("attempts mismatched after cancellation: want 2, got %d", n)
+	}
+}
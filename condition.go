@@ -0,0 +1,327 @@
+package maestro
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/midbel/maestro/internal/env"
+)
+
+const (
+	sysEnv = "env."
+
+	mstOs      = "maestro.os"
+	mstArch    = "maestro.arch"
+	mstCwd     = "maestro.cwd"
+	mstUser    = "maestro.user"
+	mstFile    = "maestro.file"
+	mstCommand = "maestro.command"
+	mstVersion = "maestro.version"
+)
+
+// registerSystem defines the built-in variables describing the system maestro
+// is running on.
+func registerSystem(ev *env.Env) {
+	ev.Define(mstOs, []string{runtime.GOOS})
+	ev.Define(mstArch, []string{runtime.GOARCH})
+	if cwd, err := os.Getwd(); err == nil {
+		ev.Define(mstCwd, []string{cwd})
+	}
+	ev.DefineLazy(mstUser, func() ([]string, error) {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", mstUser, err)
+		}
+		return []string{u.Username}, nil
+	})
+}
+
+// checkDefines checks that the variables defined before the maestro file is
+// decoded (eg: with -D) do not replace the built-in variables.
+func checkDefines(ev *env.Env) error {
+	for _, n := range ev.Names() {
+		if isBuiltinName(n) {
+			return fmt.Errorf("%s: built-in variables are read-only", n)
+		}
+	}
+	return nil
+}
+
+// registerFile defines the built-in variable giving the maestro file being
+// decoded.
+func registerFile(ev *env.Env, file string) {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	ev.Define(mstFile, []string{file})
+}
+
+// evalCondition evaluates the condition of an if. A condition compares
+// operands with == and != and can be combined with &&, || and !. An operand
+// alone is true when it is not empty, 0 or false. Operands are words, quoted
+// strings, variables ($name) and built-ins (%(maestro.os), %(env.NAME)...).
+func evalCondition(str string, locals *env.Env) (bool, error) {
+	c := condition{
+		input:  str,
+		locals: locals,
+	}
+	ok, err := c.or()
+	if err != nil {
+		return false, err
+	}
+	if c.skip(); c.pos < len(c.input) {
+		return false, c.unexpected()
+	}
+	return ok, nil
+}
+
+type condition struct {
+	input  string
+	pos    int
+	locals *env.Env
+}
+
+func (c *condition) or() (bool, error) {
+	left, err := c.and()
+	for err == nil && c.accept("||") {
+		var right bool
+		if right, err = c.and(); err == nil {
+			left = left || right
+		}
+	}
+	return left, err
+}
+
+func (c *condition) and() (bool, error) {
+	left, err := c.unary()
+	for err == nil && c.accept("&&") {
+		var right bool
+		if right, err = c.unary(); err == nil {
+			left = left && right
+		}
+	}
+	return left, err
+}
+
+func (c *condition) unary() (bool, error) {
+	if c.accept("!=") {
+		return false, c.unexpected()
+	}
+	if c.accept("!") {
+		ok, err := c.unary()
+		return !ok, err
+	}
+	if c.accept("(") {
+		ok, err := c.or()
+		if err == nil && !c.accept(")") {
+			err = c.unexpected()
+		}
+		return ok, err
+	}
+	left, err := c.operand()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case c.accept("=="):
+		right, err := c.operand()
+		return left == right, err
+	case c.accept("!="):
+		right, err := c.operand()
+		return left != right, err
+	default:
+		return left != "" && left != "0" && left != kwFalse, nil
+	}
+}
+
+func (c *condition) operand() (string, error) {
+	c.skip()
+	if c.pos >= len(c.input) {
+		return "", fmt.Errorf("%s: missing operand", c.input)
+	}
+	switch ch := c.input[c.pos]; {
+	case ch == squote || ch == dquote:
+		end := strings.IndexByte(c.input[c.pos+1:], ch)
+		if end < 0 {
+			return "", fmt.Errorf("%s: unterminated string", c.input)
+		}
+		str := c.input[c.pos+1 : c.pos+1+end]
+		c.pos += end + 2
+		if ch == dquote {
+			str = os.Expand(str, c.resolve)
+		}
+		return str, nil
+	case ch == dollar:
+		c.pos++
+		enclosed := c.pos < len(c.input) && c.input[c.pos] == lcurly
+		if enclosed {
+			c.pos++
+		}
+		name := c.word(isIdent)
+		if enclosed && !c.accept("}") {
+			return "", c.unexpected()
+		}
+		return c.resolve(name), nil
+	case strings.HasPrefix(c.input[c.pos:], "%("):
+		c.pos += 2
+		name := c.word(func(r rune) bool {
+			return isIdent(r) || r == dot
+		})
+		if !c.accept(")") {
+			return "", c.unexpected()
+		}
+		return c.resolve(name), nil
+	default:
+		str := c.word(func(r rune) bool {
+			return !isBlank(r) && !strings.ContainsRune("=!&|()", r)
+		})
+		if str == "" {
+			return "", c.unexpected()
+		}
+		return str, nil
+	}
+}
+
+// resolve gives the value of a variable. Undefined variables are empty.
+func (c *condition) resolve(name string) string {
+	if strings.HasPrefix(name, sysEnv) {
+		return os.Getenv(strings.TrimPrefix(name, sysEnv))
+	}
+	vs, _ := c.locals.Resolve(name)
+	return strings.Join(vs, " ")
+}
+
+func (c *condition) word(accept func(rune) bool) string {
+	start := c.pos
+	for c.pos < len(c.input) && accept(rune(c.input[c.pos])) {
+		c.pos++
+	}
+	return c.input[start:c.pos]
+}
+
+func (c *condition) accept(op string) bool {
+	c.skip()
+	if !strings.HasPrefix(c.input[c.pos:], op) {
+		return false
+	}
+	c.pos += len(op)
+	return true
+}
+
+func (c *condition) skip() {
+	for c.pos < len(c.input) && isBlank(rune(c.input[c.pos])) {
+		c.pos++
+	}
+}
+
+func (c *condition) unexpected() error {
+	return fmt.Errorf("%s: unexpected character at position %d", c.input, c.pos+1)
+}
+
+// parseLoop gives the name of the variable and the values of a loop header
+// (ident in values...). Variables are expanded to all their values.
+func parseLoop(str string, locals *env.Env) (string, []string, error) {
+	c := condition{
+		input:  str,
+		locals: locals,
+	}
+	ident := c.word(isIdent)
+	if ident == "" {
+		return "", nil, c.unexpected()
+	}
+	c.skip()
+	if c.word(isIdent) != kwIn {
+		return "", nil, fmt.Errorf("%s: %s expected after %s", str, kwIn, ident)
+	}
+	var values []string
+	for c.skip(); c.pos < len(c.input); c.skip() {
+		if c.input[c.pos] == dollar {
+			c.pos++
+			enclosed := c.accept("{")
+			name := c.word(isIdent)
+			if enclosed && !c.accept("}") {
+				return "", nil, c.unexpected()
+			}
+			vs, _ := c.locals.Resolve(name)
+			values = append(values, vs...)
+			continue
+		}
+		v, err := c.operand()
+		if err != nil {
+			return "", nil, err
+		}
+		values = append(values, v)
+	}
+	return ident, values, nil
+}
+
+// expandLoop replaces the references to the loop variable ($ident and
+// ${ident}) by its value. The replacements are returned so that the columns
+// of the expanded body can be given in the body as written.
+func expandLoop(body, ident, value string) (string, []replacement) {
+	var (
+		re   = regexp.MustCompile(`\$\{` + ident + `\}|\$` + ident + `\b`)
+		str  strings.Builder
+		list []replacement
+		last int
+		line = 1
+		col  = 1
+	)
+	for _, ix := range re.FindAllStringIndex(body, -1) {
+		for _, r := range body[last:ix[0]] {
+			if r == nl {
+				line, col = line+1, 1
+			} else {
+				col++
+			}
+		}
+		str.WriteString(body[last:ix[0]])
+		str.WriteString(value)
+		n := utf8.RuneCountInString(value)
+		list = append(list, replacement{
+			Line:  line,
+			Start: col,
+			End:   col + n,
+			Delta: n - (ix[1] - ix[0]),
+		})
+		col += n
+		last = ix[1]
+	}
+	str.WriteString(body[last:])
+	return str.String(), list
+}
+
+// replacement is the range of columns (in runes) of a line taken by the value
+// of a loop variable and the difference of length with its reference.
+type replacement struct {
+	Line  int
+	Start int
+	End   int
+	Delta int
+}
+
+// restore gives the position in the body as written of a position in the
+// expanded body (the first line of the body being at line 1). A position in a
+// value is moved to the reference of the variable.
+func restore(pos Position, offset int, list []replacement) Position {
+	delta := 0
+	for _, r := range list {
+		if r.Line != pos.Line || pos.Column < r.Start {
+			continue
+		}
+		if pos.Column < r.End {
+			delta += pos.Column - r.Start
+			break
+		}
+		delta += r.Delta
+	}
+	pos.Line += offset
+	pos.Column -= delta
+	return pos
+}
@@ -1,12 +1,13 @@
 package maestro
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -21,6 +22,19 @@ type ctreeOption struct {
 	Prefix bool
 	Trace  bool
 	NoDeps bool
+	Format string
+
+	// colorize the prefixes of the lines and the lines written on stderr
+	ColorOut bool
+	ColorErr bool
+
+	// format of the trace (compact, long or json)
+	TraceFormat string
+
+	tap      *tapReport
+	trace    *traceReport
+	report   *runReport
+	progress *progress
 }
 
 type ctree struct {
@@ -48,10 +62,21 @@ func createTree(root executer) (ctree, error) {
 }
 
 func (c *ctree) Execute(ctx context.Context, stdout, stderr io.Writer) error {
-	go io.Copy(stdout, c.stdout)
-	go io.Copy(stderr, c.stderr)
+	var grp errgroup.Group
+	grp.Go(func() error {
+		_, err := io.Copy(stdout, c.stdout)
+		return err
+	})
+	grp.Go(func() error {
+		_, err := io.Copy(stderr, c.stderr)
+		return err
+	})
 
-	return c.root.Execute(ctx, c.Stdout(), c.Stderr())
+	err := c.root.Execute(ctx, c.Stdout(), c.Stderr())
+	c.stdout.CloseWrite()
+	c.stderr.CloseWrite()
+	grp.Wait()
+	return err
 }
 
 func (c *ctree) Stdout() io.Writer {
@@ -75,10 +100,7 @@ type execmain struct {
 
 	ignore bool
 
-	pre     []Executer
-	post    []Executer
-	success []Executer
-	errors  []Executer
+	hooks
 }
 
 func createMain(cmd Executer, args []string, list deplist) execmain {
@@ -90,28 +112,46 @@ func createMain(cmd Executer, args []string, list deplist) execmain {
 }
 
 func (e execmain) Execute(ctx context.Context, stdout, stderr io.Writer) error {
+	stdin := stdinFrom(ctx)
+	if stdin != nil {
+		ctx = withStdin(ctx, nil)
+	}
 	e.executeList(ctx, e.pre, stdout, stderr)
 	defer e.executeList(ctx, e.post, stdout, stderr)
 
 	if err := e.list.Execute(ctx, stdout, stderr); err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	prepare(e.Executer, stdout, stderr)
-	var (
-		next = e.success
-		err  = e.Executer.Execute(ctx, e.args)
-	)
+	err := e.Executer.Execute(withStdin(ctx, stdin), e.args)
 	if e.ignore && err != nil {
 		err = nil
 	}
+	e.complete(ctx, err, stdout, stderr)
+	return err
+}
+
+// hooks are the commands executed around a command: before it, after it
+// whatever its exit status, and after it on success or on failure.
+type hooks struct {
+	pre     []Executer
+	post    []Executer
+	success []Executer
+	errors  []Executer
+}
+
+func (h hooks) complete(ctx context.Context, err error, stdout, stderr io.Writer) error {
+	next := h.success
 	if err != nil {
-		next = e.errors
+		next = h.errors
 	}
-	e.executeList(ctx, next, stdout, stderr)
-	return err
+	return h.executeList(ctx, next, stdout, stderr)
 }
 
-func (e execmain) executeList(ctx context.Context, list []Executer, stdout, stderr io.Writer) error {
+func (h hooks) executeList(ctx context.Context, list []Executer, stdout, stderr io.Writer) error {
 	if len(list) == 0 {
 		return nil
 	}
@@ -125,32 +165,87 @@ func (e execmain) executeList(ctx context.Context, list []Executer, stdout, stde
 	return nil
 }
 
+type keepGoingKey struct{}
+
+// withKeepGoing gives a context where the dependencies are all executed even
+// if some of them fail.
+func withKeepGoing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keepGoingKey{}, true)
+}
+
+func keepGoing(ctx context.Context) bool {
+	ok, _ := ctx.Value(keepGoingKey{}).(bool)
+	return ok
+}
+
+type concurrentKey struct{}
+
+// withConcurrent gives a context where the command runs at the same time as
+// other commands: its lines are prefixed by its name and its progress is not
+// rendered.
+func withConcurrent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, concurrentKey{}, true)
+}
+
+func concurrent(ctx context.Context) bool {
+	ok, _ := ctx.Value(concurrentKey{}).(bool)
+	return ok
+}
+
 type deplist []executer
 
+// Execute runs the dependencies in order. When one of them fails or when ctx
+// is cancelled, the dependencies running in background are cancelled and
+// Execute only returns once all of them are done. With keep going, all the
+// dependencies are executed and their errors are combined.
 func (el deplist) Execute(ctx context.Context, stdout, stderr io.Writer) error {
-	inBackground := func(e executer) bool {
-		b, ok := e.(interface{ Bg() bool })
-		if !ok {
-			return ok
-		}
-		return b.Bg()
+	var (
+		keep      = keepGoing(ctx)
+		pool, sub = createPool(ctx, 0)
+	)
+	if keep {
+		pool.KeepGoing()
 	}
-	grp, sub := errgroup.WithContext(ctx)
 	for i := range el {
-		ex := el[i]
-		if inBackground(ex) {
-			grp.Go(func() error {
+		if sub.Err() != nil {
+			break
+		}
+		var (
+			ex  = el[i]
+			run = func() error {
 				return ex.Execute(sub, stdout, stderr)
-			})
-		} else {
-			err := ex.Execute(sub, stdout, stderr)
-			if err != nil {
-				grp.Wait()
-				return err
 			}
+		)
+		if inBackground(ex) {
+			pool.Go(sub, run)
+			continue
+		}
+		if err := pool.Run(run); err != nil && !keep {
+			break
 		}
 	}
-	return grp.Wait()
+	err := pool.Wait()
+	if keep {
+		return joinErrors(err, ctx.Err())
+	}
+	return hasError(err, ctx.Err())
+}
+
+func inBackground(e executer) bool {
+	b, ok := e.(interface{ Bg() bool })
+	if !ok {
+		return ok
+	}
+	return b.Bg()
+}
+
+func hasBackground(list []executer) bool {
+	for _, e := range list {
+		if inBackground(e) {
+			return true
+		}
+	}
+	return false
 }
 
 type execdep struct {
@@ -159,6 +254,11 @@ type execdep struct {
 
 	list       deplist
 	background bool
+	optional   bool
+	retry      int64
+	timeout    time.Duration
+
+	hooks
 }
 
 func createDep(cmd Executer, args []string, list deplist) execdep {
@@ -170,49 +270,76 @@ func createDep(cmd Executer, args []string, list deplist) execdep {
 }
 
 func (e execdep) Execute(ctx context.Context, stdout, stderr io.Writer) error {
+	// only the command reads the output of the previous command of a pipe
+	stdin := stdinFrom(ctx)
+	if stdin != nil {
+		ctx = withStdin(ctx, nil)
+	}
 	if err := e.list.Execute(ctx, stdout, stderr); err != nil {
+		return e.ignore(ctx, err, stderr)
+	}
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	prepare(e.Executer, stdout, stderr)
-	return e.Executer.Execute(ctx, e.args)
-}
-
-func (e execdep) Bg() bool {
-	return e.background
-}
+	e.executeList(ctx, e.pre, stdout, stderr)
+	defer e.executeList(ctx, e.post, stdout, stderr)
 
-type exectrace struct {
-	inner executer
+	prepare(e.Executer, stdout, stderr)
+	err := e.execute(withStdin(ctx, stdin))
+	e.complete(ctx, err, stdout, stderr)
+	return e.ignore(ctx, err, stderr)
 }
 
-func trace(ex executer) executer {
-	return exectrace{
-		inner: ex,
+// ignore reports the failure of an optional dependency and gives no error
+// instead unless the execution has been cancelled.
+func (e execdep) ignore(ctx context.Context, err error, stderr io.Writer) error {
+	if err == nil || !e.optional || ctx.Err() != nil {
+		return err
 	}
+	fmt.Fprintf(stderr, "optional dependency failed: %s", err)
+	fmt.Fprintln(stderr)
+	return nil
 }
 
-func (e exectrace) Execute(ctx context.Context, stdout, stderr io.Writer) error {
-	var (
-		now     = time.Now()
-		err     = e.inner.Execute(ctx, stdout, stderr)
-		elapsed = time.Since(now)
-	)
-	setPrefix(stderr, "trace")
-	if err != nil {
-		fmt.Fprintln(stderr, "error:", err)
+// execute executes the command of the dependency again until it succeeds or
+// until its number of retries is reached. The timeout applies to all the
+// executions.
+func (e execdep) execute(ctx context.Context) error {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	var err error
+	for i := int64(0); i < e.retry || i == 0; i++ {
+		if err = e.Executer.Execute(ctx, e.args); err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%s: %w", e.Command(), err)
 	}
-	fmt.Fprintf(stderr, "time: %.3fs", elapsed.Seconds())
-	fmt.Fprintln(stderr)
-
 	return err
 }
 
+func (e execdep) Bg() bool {
+	return e.background
+}
+
+// pipe adds the prefix (and the colors) to the lines written by the commands
+// when they are written so that the lines keep the prefix of the command that
+// has written them. Incomplete lines are kept until their end is written or
+// until the pipe is closed.
 type pipe struct {
 	R *os.File
 	W *os.File
 
-	scan   *bufio.Scanner
+	mu     sync.Mutex
+	line   []byte
 	prefix string
+
+	color  bool
+	errors bool
 }
 
 func createPipe() (*pipe, error) {
@@ -221,43 +348,76 @@ func createPipe() (*pipe, error) {
 		err error
 	)
 	p.R, p.W, err = os.Pipe()
-	if err == nil {
-		p.scan = bufio.NewScanner(p.R)
-	}
 	return &p, err
 }
 
 func (p *pipe) SetPrefix(prefix string) {
-	p.prefix = ""
-	if prefix != "" {
-		p.prefix = fmt.Sprintf("[%s] ", prefix)
-	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prefix = formatPrefix(prefix, p.color)
 }
 
 func (p *pipe) Close() error {
 	p.R.Close()
-	return p.W.Close()
+	return p.CloseWrite()
 }
 
-func (p *pipe) Write(b []byte) (int, error) {
-	return p.W.Write(b)
+func (p *pipe) CloseWrite() error {
+	p.mu.Lock()
+	if len(p.line) > 0 {
+		p.W.Write(p.format(p.line))
+		p.line = p.line[:0]
+	}
+	p.mu.Unlock()
+
+	err := p.W.Close()
+	if errors.Is(err, os.ErrClosed) {
+		err = nil
+	}
+	return err
 }
 
-func (p *pipe) Read(b []byte) (int, error) {
-	if !p.scan.Scan() {
-		err := p.scan.Err()
-		if err == nil {
-			err = io.EOF
+func (p *pipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		out []byte
+		str = b
+	)
+	for {
+		x := bytes.IndexByte(str, '\n')
+		if x < 0 {
+			break
 		}
-		return 0, io.EOF
+		if len(p.line) > 0 {
+			out = append(out, p.format(append(p.line, str[:x]...))...)
+			p.line = p.line[:0]
+		} else {
+			out = append(out, p.format(str[:x])...)
+		}
+		str = str[x+1:]
+	}
+	p.line = append(p.line, str...)
+	if len(out) == 0 {
+		return len(b), nil
 	}
-	var n int
-	if p.prefix != "" {
-		n = copy(b, p.prefix)
+	if _, err := p.W.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	return p.R.Read(b)
+}
+
+func (p *pipe) format(line []byte) []byte {
+	str := string(line)
+	if p.color && p.errors {
+		str = paint(colorRed, str)
 	}
-	x := p.scan.Bytes()
-	n += copy(b[n:], append(x, '\n'))
-	return n, p.scan.Err()
+	return []byte(p.prefix + str + "\n")
 }
 
 func prepare(cmd Executer, stdout, stderr io.Writer) {
@@ -0,0 +1,880 @@
+package maestro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testExecuter struct {
+	bg    bool
+	wait  bool
+	err   error
+	done  *int32
+	calls *int32
+}
+
+func (e testExecuter) Execute(ctx context.Context, _, _ io.Writer) error {
+	if e.calls != nil {
+		atomic.AddInt32(e.calls, 1)
+	}
+	if e.wait {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(e.done, 1)
+		return ctx.Err()
+	}
+	return e.err
+}
+
+func (e testExecuter) Bg() bool {
+	return e.bg
+}
+
+func TestDeplistCancelBackground(t *testing.T) {
+	var (
+		done  int32
+		calls int32
+		fail  = errors.New("fail")
+		list  = deplist{
+			testExecuter{bg: true, wait: true, done: &done},
+			testExecuter{err: fail},
+			testExecuter{calls: &calls},
+		}
+	)
+	err := list.Execute(context.Background(), io.Discard, io.Discard)
+	if !errors.Is(err, fail) {
+		t.Fatalf("unexpected error: want %s, got %v", fail, err)
+	}
+	if atomic.LoadInt32(&done) != 1 {
+		t.Errorf("background dependency not awaited")
+	}
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("dependency executed after failure")
+	}
+}
+
+func TestDeplistKeepGoing(t *testing.T) {
+	var (
+		calls int32
+		fail1 = errors.New("fail1")
+		fail2 = errors.New("fail2")
+		list  = deplist{
+			testExecuter{bg: true, err: fail1, calls: &calls},
+			testExecuter{err: fail2, calls: &calls},
+			testExecuter{calls: &calls},
+			deplist{
+				testExecuter{err: fail1, calls: &calls},
+			},
+		}
+	)
+	err := list.Execute(withKeepGoing(context.Background()), io.Discard, io.Discard)
+	if atomic.LoadInt32(&calls) != 4 {
+		t.Errorf("dependencies not executed after failure: %d executed", calls)
+	}
+	errs, ok := err.(failures)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("failures not combined: %v", err)
+	}
+	if !errors.Is(err, fail1) && !errors.Is(err, fail2) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDeplistCancelParent(t *testing.T) {
+	var (
+		done  int32
+		calls int32
+		list  = deplist{
+			testExecuter{bg: true, wait: true, done: &done},
+			testExecuter{calls: &calls},
+		}
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := list.Execute(ctx, io.Discard, io.Discard)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: want %s, got %v", context.Canceled, err)
+	}
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("dependency executed after cancellation")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	list = deplist{
+		testExecuter{bg: true, wait: true, done: &done},
+	}
+	err = list.Execute(ctx, io.Discard, io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: want %s, got %v", context.DeadlineExceeded, err)
+	}
+	if atomic.LoadInt32(&done) != 1 {
+		t.Errorf("background dependency not awaited")
+	}
+}
+
+// decodeFile gives the maestro file decoded from file.
+func decodeFile(t *testing.T, file string) *Maestro {
+	t.Helper()
+	d, err := NewDecoder(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("fail to create decoder: %s", err)
+	}
+	mst, err := d.Decode()
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	return mst
+}
+
+// resolveCommand gives the command with its dependencies. It is closed at the
+// end of the test.
+func resolveCommand(t *testing.T, mst *Maestro, name string, option ctreeOption) executer {
+	t.Helper()
+	cmd, err := mst.setup(context.Background(), name, true)
+	if err != nil {
+		t.Fatalf("fail to setup command: %s", err)
+	}
+	ex, err := mst.resolve(cmd, nil, option)
+	if err != nil {
+		t.Fatalf("fail to resolve command: %s", err)
+	}
+	t.Cleanup(func() {
+		ex.(io.Closer).Close()
+	})
+	return ex
+}
+
+func TestTapFormat(t *testing.T) {
+	const file = `
+lint: {
+	echo linting
+}
+build: lint {
+	echo building
+}
+check(args = file,): {
+	echo $1
+}
+`
+	mst := decodeFile(t, file)
+	tests := []struct {
+		Name string
+		Want []string
+	}{
+		{
+			Name: "build",
+			Want: []string{"TAP version 13", "linting", "ok 1 - lint", "building", "ok 2 - build", "1..2"},
+		},
+		{
+			Name: "check",
+			Want: []string{"TAP version 13", "not ok 1 - check", "# ", "1..1"},
+		},
+	}
+	for _, c := range tests {
+		var (
+			ex  = resolveCommand(t, mst, c.Name, ctreeOption{Format: FormatTap})
+			buf strings.Builder
+		)
+		err := ex.Execute(context.Background(), &buf, io.Discard)
+		if failed := c.Name == "check"; failed != (err != nil) {
+			t.Errorf("%s: unexpected result: %v", c.Name, err)
+		}
+		got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(got) != len(c.Want) {
+			t.Errorf("%s: output mismatched: want %q, got %q", c.Name, c.Want, got)
+			continue
+		}
+		for i := range c.Want {
+			if !strings.HasPrefix(got[i], c.Want[i]) {
+				t.Errorf("%s: line %d mismatched: want %q, got %q", c.Name, i+1, c.Want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestCommandHooks(t *testing.T) {
+	const file = `
+.BEFORE = setup
+.AFTER = teardown
+setup: {
+	echo setup
+}
+teardown: {
+	echo teardown
+}
+clean: {
+	echo clean
+}
+lint(
+	before = "echo lint-before",
+	success = "echo lint-success",
+): {
+	echo linting
+}
+build(
+	before = clean,
+	after = "echo build-after",
+	error = "echo build-error",
+	success = "echo build-success",
+): lint {
+	false
+}
+`
+	var (
+		mst  = decodeFile(t, file)
+		ex   = resolveCommand(t, mst, "build", ctreeOption{})
+		buf  strings.Builder
+		want = []string{"setup", "clean", "lint-before", "linting", "lint-success", "build-error", "build-after", "teardown"}
+	)
+	if err := ex.Execute(context.Background(), &buf, io.Discard); err == nil {
+		t.Fatalf("build should have failed")
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("output mismatched: want %q, got %q", want, got)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	const file = `
+.BEFORE = setup
+setup: {
+	echo setup
+}
+gen: {
+	echo gen
+}
+build(
+	after = "echo build-after",
+): gen {
+	echo build $1
+}
+test: build {
+	echo test
+}
+`
+	var (
+		mst = decodeFile(t, file)
+		ex  = resolveCommand(t, mst, "test", ctreeOption{})
+	)
+	list, err := plan(ex)
+	if err != nil {
+		t.Fatalf("fail to build plan: %s", err)
+	}
+	want := []struct {
+		Command string
+		Role    string
+		Level   int
+	}{
+		{Command: "setup", Role: "before", Level: 0},
+		{Command: "gen", Role: "dependency", Level: 2},
+		{Command: "build", Role: "dependency", Level: 1},
+		{Command: "inline", Role: "after", Level: 1},
+		{Command: "test", Role: "command", Level: 0},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("steps mismatched! want %d, got %d", len(want), len(list))
+	}
+	for i, w := range want {
+		got := list[i]
+		if got.Command != w.Command || got.Role != w.Role || got.Level != w.Level {
+			t.Errorf("step %d mismatched! want %+v, got %+v", i+1, w, got)
+		}
+	}
+	if got := list[4].Lines; len(got) != 1 || got[0] != "echo test" {
+		t.Errorf("lines mismatched! got %q", got)
+	}
+}
+
+func TestPlanMask(t *testing.T) {
+	const file = `
+.SENSITIVE = token
+
+token = s3cr3t
+
+greet(options = (short = p, long = password, sensitive = true)): {
+	echo pass: $password
+	echo token: $token
+}
+`
+	mst := decodeFile(t, file)
+	cmd, err := mst.setup(context.Background(), "greet", true)
+	if err != nil {
+		t.Fatalf("fail to setup command: %s", err)
+	}
+	ex, err := mst.resolve(cmd, []string{"-p", "hunter22"}, ctreeOption{})
+	if err != nil {
+		t.Fatalf("fail to resolve command: %s", err)
+	}
+	defer ex.(io.Closer).Close()
+
+	list, err := plan(ex)
+	if err != nil {
+		t.Fatalf("fail to build plan: %s", err)
+	}
+	var buf strings.Builder
+	printPlan(&buf, list)
+	str := buf.String()
+	if strings.Contains(str, "hunter22") || strings.Contains(str, "s3cr3t") {
+		t.Errorf("secrets printed in plan: %s", str)
+	}
+	if !strings.Contains(str, "greet -p ***") || !strings.Contains(str, "echo pass: ***") {
+		t.Errorf("masked values not printed in plan: %s", str)
+	}
+}
+
+func TestDependencyAttributes(t *testing.T) {
+	const file = `
+lint: {
+	echo lint
+	false
+}
+flaky: {
+	echo flaky
+	false
+}
+build: ?lint, flaky[retry=3] {
+	echo build
+}
+check: ?lint {
+	echo check
+}
+`
+	mst := decodeFile(t, file)
+	tests := []struct {
+		Name string
+		Want []string
+		Fail bool
+	}{
+		{Name: "build", Want: []string{"lint", "flaky", "flaky", "flaky"}, Fail: true},
+		{Name: "check", Want: []string{"lint", "check"}},
+	}
+	for _, tt := range tests {
+		var (
+			ex  = resolveCommand(t, mst, tt.Name, ctreeOption{})
+			buf strings.Builder
+		)
+		err := ex.Execute(context.Background(), &buf, io.Discard)
+		if tt.Fail != (err != nil) {
+			t.Errorf("%s: unexpected result: %v", tt.Name, err)
+		}
+		got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if strings.Join(got, ",") != strings.Join(tt.Want, ",") {
+			t.Errorf("%s: output mismatched: want %q, got %q", tt.Name, tt.Want, got)
+		}
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	const file = `
+version(
+	outputs = tag commit,
+): {
+	echo "tag=v1.2.3" >> $MAESTRO_OUTPUT
+	echo 'commit = "abc def"' >> $MAESTRO_OUTPUT
+}
+build: version {
+	echo build $tag
+}
+publish: version, build {
+	echo publish $tag $commit
+}
+invalid(
+	outputs = tag,
+): {
+	echo "other=1" >> $MAESTRO_OUTPUT
+}
+`
+	var (
+		mst  = decodeFile(t, file)
+		ex   = resolveCommand(t, mst, "publish", ctreeOption{})
+		ctx  = withOutputs(context.Background(), createOutputs())
+		buf  strings.Builder
+		want = []string{"build v1.2.3", "publish v1.2.3 abc def"}
+	)
+	if err := ex.Execute(ctx, &buf, io.Discard); err != nil {
+		t.Fatalf("publish should have succeeded: %s", err)
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("output mismatched: want %q, got %q", want, got)
+	}
+
+	ex = resolveCommand(t, mst, "invalid", ctreeOption{})
+	if err := ex.Execute(ctx, io.Discard, io.Discard); err == nil {
+		t.Errorf("undeclared output should have failed")
+	}
+}
+
+func TestDry(t *testing.T) {
+	const file = `
+.SENSITIVE = token
+token = s3cr3t
+target = bin/app
+lint: {
+	echo lint
+}
+build(
+	options = (short = o, long = output, default = out),
+): {
+	echo building $target with $token to $output
+	lint
+	!echo forced
+	maestro-unknown-program -x
+	defer echo cleanup $target
+}
+`
+	mst := decodeFile(t, file)
+	cmd, err := mst.setup(context.Background(), "build", false)
+	if err != nil {
+		t.Fatalf("fail to setup command: %s", err)
+	}
+	var buf strings.Builder
+	cmd.SetOut(&buf)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Dry([]string{"-o", "dist"}); err != nil {
+		t.Fatalf("dry run should have succeeded: %s", err)
+	}
+	want := []string{
+		"echo building bin/app with *** to dist  # builtin",
+		"lint  # command lint",
+		"echo forced  # builtin, executed",
+		"forced",
+		"maestro-unknown-program -x  # not found",
+		"echo cleanup bin/app  # builtin, deferred",
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("output mismatched: want %q, got %q", want, got)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	const file = `
+git-tag: {
+	echo v1.2.3
+}
+build: {
+	let tag = run git-tag
+	echo build $tag
+	-let fail = run false
+	echo fail $fail
+}
+publish: build {
+	echo publish $tag
+}
+`
+	var (
+		mst  = decodeFile(t, file)
+		ex   = resolveCommand(t, mst, "publish", ctreeOption{})
+		ctx  = withOutputs(context.Background(), createOutputs())
+		buf  strings.Builder
+		want = []string{"build v1.2.3", "fail", "publish v1.2.3"}
+	)
+	if err := ex.Execute(ctx, &buf, io.Discard); err != nil {
+		t.Fatalf("publish should have succeeded: %s", err)
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("output mismatched: want %q, got %q", want, got)
+	}
+}
+
+func TestSuccessCodes(t *testing.T) {
+	const file = `
+warn(success_codes = 3 4): {
+	echo before
+	exit 3
+}
+check(success_codes = 3): {
+	echo check
+	exit 5
+}
+`
+	mst := decodeFile(t, file)
+	tests := []struct {
+		Name string
+		Code int
+	}{
+		{Name: "warn", Code: 0},
+		{Name: "check", Code: 5},
+	}
+	for _, tt := range tests {
+		ex := resolveCommand(t, mst, tt.Name, ctreeOption{})
+		err := ex.Execute(context.Background(), io.Discard, io.Discard)
+		if got := ExitCode(err); got != tt.Code {
+			t.Errorf("%s: exit code mismatched: want %d, got %d (%v)", tt.Name, tt.Code, got, err)
+		}
+	}
+	if got := ExitCode(context.DeadlineExceeded); got != ExitTimeout {
+		t.Errorf("timeout: want exit code %d, got %d", ExitTimeout, got)
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	if runtime.GOOS != "linux" || isTerminal(os.Stdin) {
+		t.Skip("pseudo terminal not allocated")
+	}
+	const file = `
+login(interactive = true): {
+	tty
+	cat /proc/self/stat
+}
+`
+	var (
+		mst = decodeFile(t, file)
+		ex  = resolveCommand(t, mst, "login", ctreeOption{})
+		buf strings.Builder
+	)
+	if err := ex.Execute(context.Background(), &buf, io.Discard); err != nil {
+		t.Fatalf("login should have succeeded: %s", err)
+	}
+	got := buf.String()
+	if !strings.Contains(got, "/dev/pts/") {
+		t.Errorf("login not executed in a pseudo terminal: %q", got)
+	}
+	// the fields following the name of the program: state, ppid, pgrp,
+	// session and tty_nr
+	if x := strings.LastIndexByte(got, ')'); x < 0 {
+		t.Errorf("status of the program not written: %q", got)
+	} else if fields := strings.Fields(got[x+1:]); len(fields) < 5 || fields[4] == "0" {
+		t.Errorf("pseudo terminal not the controlling terminal of the program: %q", got)
+	}
+}
+
+func TestCopyInput(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pseudo terminal not allocated")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("fail to create pipe: %s", err)
+	}
+	defer w.Close()
+	defer r.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+	}()
+
+	var (
+		out  strings.Builder
+		stop = copyInput(&out)
+		done = make(chan struct{})
+	)
+	io.WriteString(w, "yes\n")
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		defer close(done)
+		stop()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("copy of the input not stopped")
+	}
+	if got := out.String(); got != "yes\n" {
+		t.Errorf("input not copied: %q", got)
+	}
+	// the input is read again in blocking mode once the copy is stopped
+	io.WriteString(w, "no\n")
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "no\n" {
+		t.Errorf("input not readable after the copy: %q (%v)", buf, err)
+	}
+}
+
+func TestPipePrefix(t *testing.T) {
+	p, err := createPipe()
+	if err != nil {
+		t.Fatalf("fail to create pipe: %s", err)
+	}
+	defer p.Close()
+
+	var out strings.Builder
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(&out, p)
+	}()
+
+	p.SetPrefix("a")
+	io.WriteString(p, "line 1\nline")
+	p.SetPrefix("b")
+	io.WriteString(p, " 2\nline 3")
+	p.CloseWrite()
+	<-done
+
+	want := "[a] line 1\n[b] line 2\n[b] line 3\n"
+	if got := out.String(); got != want {
+		t.Errorf("lines mismatched: want %q, got %q", want, got)
+	}
+}
+
+func TestInvocations(t *testing.T) {
+	const file = `
+gen: {
+	true
+}
+build(
+	options = (short = o, long = out), (short = r, long = race, flag = true),
+): gen {
+	true
+}
+deploy(
+	args = env,
+): {
+	true
+}
+test: {
+	true
+}
+package: {
+	true
+}
+`
+	mst := decodeFile(t, file)
+	tests := []struct {
+		Args []string
+		Want string
+	}{
+		{Args: []string{"build", "test", "package"}, Want: "build[] test[] package[]"},
+		{Args: []string{"build", "--", "--race", "test"}, Want: "build[-- --race] test[]"},
+		{Args: []string{"build", "gen", "deploy"}, Want: "build[] gen[] deploy[]"},
+		{Args: []string{"build", "-o", "gen", "deploy", "prod"}, Want: "build[-o gen] deploy[prod]"},
+		{Args: []string{"deploy", "gen", "build", "-r", "x"}, Want: "deploy[gen] build[-r x]"},
+		{Args: []string{"build", "--", "-o", "gen"}, Want: "build[-- -o] gen[]"},
+		{Args: []string{"build", "--out=gen", "gen"}, Want: "build[--out=gen] gen[]"},
+		{Args: []string{"deploy", "prod", "gen"}, Want: "deploy[prod] gen[]"},
+		{Args: []string{"gen", "|", "deploy", "prod", "|", "build", "-o", "|"}, Want: "gen[] | deploy[prod] | build[-o |]"},
+		{Args: []string{"deploy", "|", "unknown", "x"}, Want: "deploy[] | unknown[x]"},
+	}
+	for _, tt := range tests {
+		var list []string
+		for _, i := range mst.invocations(tt.Args[0], tt.Args[1:]) {
+			if i.pipe {
+				list = append(list, pipeWord)
+			}
+			list = append(list, fmt.Sprintf("%s%v", i.Command, i.Args))
+		}
+		if got := strings.Join(list, " "); got != tt.Want {
+			t.Errorf("%q: want %s, got %s", tt.Args, tt.Want, got)
+		}
+	}
+}
+
+func TestPipe(t *testing.T) {
+	const file = `
+gen: {
+	echo one
+	echo two
+}
+upper: {
+	tr a-z A-Z
+}
+first: {
+	head -n 1
+}
+endless: {
+	yes
+}
+fail: {
+	false
+}
+etl: gen -> upper {
+	echo end
+}
+broken: gen -> fail -> upper {
+	echo never
+}
+early: endless -> first {
+	echo end
+}
+`
+	mst := decodeFile(t, file)
+	tests := []struct {
+		Name string
+		Want string
+		Fail bool
+	}{
+		{Name: "etl", Want: "ONE\nTWO\nend\n"},
+		{Name: "broken", Fail: true},
+		{Name: "early", Want: "y\nend\n"},
+	}
+	for _, tt := range tests {
+		var (
+			buf strings.Builder
+			ex  = resolveCommand(t, mst, tt.Name, ctreeOption{})
+			err = ex.Execute(context.Background(), &buf, io.Discard)
+		)
+		if tt.Fail {
+			if err == nil {
+				t.Errorf("%s: pipe should have failed", tt.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: pipe should have succeeded: %s", tt.Name, err)
+			continue
+		}
+		if got := buf.String(); got != tt.Want {
+			t.Errorf("%s: output mismatched: want %q, got %q", tt.Name, tt.Want, got)
+		}
+	}
+}
+
+// fakeClock moves its time forward each time After is called: the channels
+// it gives fire at once. Once limit calls have been made (if set), the
+// channels never fire and blocked is called instead.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waits   []time.Duration
+	limit   int
+	blocked func()
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit > 0 && len(c.waits) >= c.limit {
+		if c.blocked != nil {
+			c.blocked()
+		}
+		return nil
+	}
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration{}, c.waits...)
+}
+
+func TestRemotePrefix(t *testing.T) {
+	var (
+		mst  Maestro
+		host = CommandTarget{Host: "web1", Port: 2222}
+		when = time.Date(2022, 3, 8, 14, 5, 9, 0, time.UTC)
+	)
+	mst.Clock = &fakeClock{now: when}
+	tests := []struct {
+		Format string
+		Want   string
+		Fail   bool
+	}{
+		{Format: "", Want: "deploy@web1"},
+		{Format: "{time} {user}@{addr} {command}", Want: "14:05:09 admin@web1:2222 deploy"},
+		{Format: "{cmd}@{host}", Fail: true},
+	}
+	for _, tt := range tests {
+		err := checkPrefixFormat(tt.Format)
+		if tt.Fail {
+			if err == nil {
+				t.Errorf("%q: format should have been rejected", tt.Format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.Format, err)
+			continue
+		}
+		mst.MetaSSH.Prefix = tt.Format
+		if got := mst.remotePrefix("admin", host, "deploy")(); got != tt.Want {
+			t.Errorf("%q: prefix mismatched: want %q, got %q", tt.Format, tt.Want, got)
+		}
+	}
+}
+
+func TestExpandBuiltins(t *testing.T) {
+	t.Setenv("MAESTRO_STAGE", "prod")
+	values := map[string]string{
+		"git.branch":   "main",
+		"maestro.user": "bob",
+		"maestro.os":   "linux",
+	}
+	resolve := func(name string) ([]string, error) {
+		if strings.HasPrefix(name, sysEnv) {
+			return []string{os.Getenv(strings.TrimPrefix(name, sysEnv))}, nil
+		}
+		v, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: undefined", name)
+		}
+		return []string{v}, nil
+	}
+	tests := []struct {
+		Input string
+		Want  string
+	}{
+		{Input: "echo %(git.branch) %(maestro.user)", Want: "echo main bob"},
+		{Input: `echo "%(git.branch)" on %(maestro.os)`, Want: `echo "main" on linux`},
+		{Input: "echo %(os)", Want: "echo %(os)"},
+		{Input: "echo %(env.MAESTRO_STAGE)", Want: "echo prod"},
+		{Input: `python3 -c 'print("%(name)s" % {"name": "x"})'`, Want: `python3 -c 'print("%(name)s" % {"name": "x"})'`},
+		{Input: `echo '%(git.branch)' "it's %(git.branch)"`, Want: `echo '%(git.branch)' "it's main"`},
+		{Input: `printf "%(name)s"`, Want: `printf "%(name)s"`},
+		{Input: `echo \'%(git.branch)\'`, Want: `echo \'main\'`},
+		{Input: "echo 100%", Want: "echo 100%"},
+	}
+	for _, tt := range tests {
+		got, err := expandBuiltins(tt.Input, resolve)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Input, err)
+			continue
+		}
+		if got != tt.Want {
+			t.Errorf("%s: mismatched! want %q, got %q", tt.Input, tt.Want, got)
+		}
+	}
+	if _, err := expandBuiltins("echo %(git.unknown)", resolve); err == nil {
+		t.Errorf("undefined built-in should have failed")
+	}
+}
+
+func TestReadOnlyBuiltins(t *testing.T) {
+	file := filepath.Join(t.TempDir(), DefaultFile)
+	if err := os.WriteFile(file, []byte("show: {\n\techo %(maestro.os)\n}\n"), 0o600); err != nil {
+		t.Fatalf("fail to write file: %s", err)
+	}
+	for _, def := range []string{"maestro.os=plan9", "git.branch=main", "env.HOME=/tmp"} {
+		mst := New()
+		mst.Locals.Set(def)
+		if err := mst.Load(file); err == nil {
+			t.Errorf("%s: built-in variable should not be redefined", def)
+		}
+	}
+	mst := New()
+	mst.Locals.Set("os=plan9")
+	if err := mst.Load(file); err != nil {
+		t.Fatalf("fail to load file: %s", err)
+	}
+	if vs, _ := mst.Locals.Resolve("maestro.os"); len(vs) != 1 || vs[0] != runtime.GOOS {
+		t.Errorf("maestro.os mismatched! want %s, got %q", runtime.GOOS, vs)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,9 +26,25 @@ import (
 const (
 	metaNamespace  = "NAMESPACE"
 	metaWorkDir    = "WORKDIR"
+	metaCacheDir   = "CACHE_DIR"
+	metaLogDir     = "LOGDIR"
+	metaLogMaxSize = "LOG_MAX_SIZE"
+	metaLogMaxAge  = "LOG_MAX_AGE"
+	metaCompose    = "COMPOSE_FILE"
+	metaPackages   = "PACKAGES"
 	metaTrace      = "TRACE"
+	metaTraceLines = "TRACE_LINES"
 	metaAll        = "ALL"
+	metaAllPar     = "ALL_PARALLEL"
+	metaMaxFail    = "MAX_FAILURES"
+	metaNotifyURL  = "NOTIFY_URL"
+	metaNotifyOn   = "NOTIFY_ON"
+	metaEnvFile    = "ENVFILE"
+	metaPublishKey = "PUBLISH_KEY"
+	metaSensitive  = "SENSITIVE"
+	metaTheme      = "THEME"
 	metaDefault    = "DEFAULT"
+	metaNoBuiltins = "NO_BUILTINS"
 	metaBefore     = "BEFORE"
 	metaAfter      = "AFTER"
 	metaError      = "ERROR"
@@ -40,22 +59,91 @@ const (
 	metaPubKey     = "SSH_PUBKEY"
 	metaKnownHosts = "SSH_KNOWN_HOSTS"
 	metaParallel   = "SSH_PARALLEL"
+	metaSudoPass   = "SSH_SUDO_PASSWORD"
+	metaSSHHosts   = "SSH_HOSTS"
+	metaPrefix     = "PREFIX_FORMAT"
 	metaCertFile   = "HTTP_CERT_FILE"
 	metaKeyFile    = "HTTP_CERT_KEY"
+	metaHttpGet    = "HTTP_GET"
+	metaHttpPost   = "HTTP_POST"
+	metaHttpDelete = "HTTP_DELETE"
+	metaHttpPatch  = "HTTP_PATCH"
+	metaHttpPut    = "HTTP_PUT"
+	metaHttpHead   = "HTTP_HEAD"
+	metaHttpToken  = "HTTP_TOKEN"
+	metaHttpTokens = "HTTP_TOKEN_FILE"
+	metaSmtpHost   = "SMTP_HOST"
+	metaSmtpUser   = "SMTP_USER"
+	metaSmtpPass   = "SMTP_PASSWORD"
+	metaSmtpFrom   = "SMTP_FROM"
 )
 
 const (
-	propHelp     = "help"
-	propShort    = "short"
-	propTags     = "tag"
-	propRetry    = "retry"
-	propWorkDir  = "workdir"
-	propTimeout  = "timeout"
-	propHosts    = "hosts"
-	propOpts     = "options"
-	propArg      = "args"
-	propAlias    = "alias"
-	propSchedule = "schedule"
+	themeIcon    = "icon"
+	themeSuccess = "success"
+	themeFailure = "failure"
+	themeColor   = "color"
+)
+
+const (
+	propHelp       = "help"
+	propUsage      = "usage"
+	propShort      = "short"
+	propIcon       = "icon"
+	propConfirm    = "confirm"
+	propTags       = "tag"
+	propRetry      = "retry"
+	propCodes      = "success_codes"
+	propWorkDir    = "workdir"
+	propTimeout    = "timeout"
+	propDelay      = "retry_delay"
+	propBackoff    = "retry_backoff"
+	propJitter     = "retry_jitter"
+	propHosts      = "hosts"
+	propOpts       = "options"
+	propArg        = "args"
+	propAlias      = "alias"
+	propSchedule   = "schedule"
+	propTestable   = "testable"
+	propExample    = "example"
+	propRequires   = "requires"
+	propTokens     = "tokens"
+	propWatch      = "watch"
+	propSources    = "sources"
+	propTargets    = "targets"
+	propFresh      = "fresh"
+	propExtends    = "extends"
+	propSudo       = "sudo"
+	propInteract   = "interactive"
+	propLock       = "lock"
+	propMatrix     = "matrix"
+	propMatrixPar  = "matrix_parallel"
+	propPublish    = "publish"
+	propArtifacts  = "artifacts"
+	propStripAnsi  = "strip_ansi"
+	propReplace    = "replace"
+	propExtract    = "extract"
+	propInput      = "input"
+	propOutput     = "output"
+	propOutputs    = "outputs"
+	propSchema     = "input_schema"
+	propChecksums  = "checksums"
+	propProvenance = "provenance"
+	propPath       = "path_prepend"
+	propCache      = "cache"
+	propServices   = "services"
+	propEnvFile    = "envfile"
+	propWriteEnv   = "write_env"
+	propFlagFile   = "flagfile"
+	propVenv       = "venv"
+	propNode       = "node"
+	propGoFlags    = "goflags"
+	propBefore     = "before"
+	propAfter      = "after"
+	propError      = "error"
+	propSuccess    = "success"
+	propNotifyURL  = "notify_url"
+	propNotifyOn   = "notify_on"
 )
 
 const (
@@ -73,19 +161,23 @@ const (
 )
 
 const (
-	optShort    = "short"
-	optLong     = "long"
-	optRequired = "required"
-	optDefault  = "default"
-	optFlag     = "flag"
-	optHelp     = "help"
-	optValid    = "check"
+	optShort     = "short"
+	optLong      = "long"
+	optRequired  = "required"
+	optDefault   = "default"
+	optFlag      = "flag"
+	optHelp      = "help"
+	optSensitive = "sensitive"
+	optPrompt    = "prompt"
+	optValid     = "check"
 )
 
 type Decoder struct {
 	locals *env.Env
 	env    map[string]string
 	alias  map[string]string
+	hosts  map[string]CommandTarget
+	groups map[string][]CommandOption
 	frames []*frame
 }
 
@@ -105,10 +197,13 @@ func NewDecoderWithEnv(r io.Reader, ev *env.Env) (*Decoder, error) {
 	if ev == nil {
 		ev = env.EmptyEnv()
 	}
+	registerSystem(ev)
 	d := Decoder{
 		locals: ev,
 		env:    make(map[string]string),
 		alias:  make(map[string]string),
+		hosts:  make(map[string]CommandTarget),
+		groups: make(map[string][]CommandOption),
 	}
 	if err := d.push(r); err != nil {
 		return nil, err
@@ -124,32 +219,59 @@ func (d *Decoder) Decode() (*Maestro, error) {
 func (d *Decoder) decode(mst *Maestro) error {
 	d.skipNL()
 	for !d.done() {
-		var err error
-		switch d.curr().Type {
-		case Ident:
-			if d.peek().IsAssign() {
-				err = d.decodeVariable()
-				break
-			}
-			err = d.decodeCommand(mst)
-		case Hidden:
-			err = d.decodeCommand(mst)
-		case Meta:
-			err = d.decodeMeta(mst)
-		case Keyword:
-			err = d.decodeKeyword(mst)
-		case Comment:
-			d.next()
-		default:
-			err = d.unexpected()
-		}
-		if err != nil {
+		if err := d.decodeStatement(mst); err != nil {
 			return err
 		}
 	}
+	if len(mst.MetaExec.EnvFiles) > 0 {
+		// the files of the commands override the files given by the meta
+		for n, c := range mst.Commands {
+			c.DotEnv = append(append([]string{}, mst.MetaExec.EnvFiles...), c.DotEnv...)
+			mst.Commands[n] = c
+		}
+	}
+	for n, c := range mst.Commands {
+		c.Sensitive = mst.MetaExec.Sensitive
+		if c.Icon == "" {
+			c.Icon = mst.MetaExec.Theme.Icon
+		}
+		mst.Commands[n] = c
+	}
 	return nil
 }
 
+func (d *Decoder) decodeStatement(mst *Maestro) error {
+	var err error
+	switch d.curr().Type {
+	case Ident:
+		if d.peek().IsAssign() {
+			err = d.decodeVariable()
+			break
+		}
+		err = d.decodeCommand(mst)
+	case String:
+		// names generated by a loop can contain dashes
+		if !isCommandName(d.curr().Literal) {
+			return d.unexpected()
+		}
+		if p := d.peek(); p.Type != BegList && p.Type != Dependency {
+			return d.unexpected()
+		}
+		err = d.decodeCommand(mst)
+	case Hidden:
+		err = d.decodeCommand(mst)
+	case Meta:
+		err = d.decodeMeta(mst)
+	case Keyword:
+		err = d.decodeKeyword(mst)
+	case Comment:
+		d.next()
+	default:
+		err = d.unexpected()
+	}
+	return err
+}
+
 func (d *Decoder) decodeKeyword(mst *Maestro) error {
 	var err error
 	switch d.curr().Literal {
@@ -161,17 +283,153 @@ func (d *Decoder) decodeKeyword(mst *Maestro) error {
 		err = d.decodeDelete(mst)
 	case kwAlias:
 		err = d.decodeAlias(mst)
+	case kwPreset:
+		err = d.decodePreset(mst)
+	case kwIf:
+		err = d.decodeIf(mst)
+	case kwFor:
+		err = d.decodeFor()
+	case kwPrint, kwEcho:
+		err = d.decodePrint(mst)
+	case kwOptions:
+		err = d.decodeOptionGroup()
 	default:
 		err = d.unexpected()
 	}
 	return err
 }
 
+// decodeIf decodes the block of the first branch whose condition is true. The
+// other branches are skipped without being decoded.
+func (d *Decoder) decodeIf(mst *Maestro) error {
+	var done bool
+	for {
+		d.next()
+		if d.curr().Type != Condition {
+			return d.unexpected()
+		}
+		ok, err := evalCondition(d.curr().Literal, d.locals)
+		if err != nil {
+			return err
+		}
+		d.next()
+		if err := d.decodeBlock(mst, ok && !done); err != nil {
+			return err
+		}
+		done = done || ok
+		if d.curr().Type != Keyword || d.curr().Literal != kwElse {
+			break
+		}
+		d.next()
+		if d.curr().Type == Keyword && d.curr().Literal == kwIf {
+			continue
+		}
+		if d.curr().Type != BegScript {
+			return d.unexpected()
+		}
+		d.next()
+		if err := d.decodeBlock(mst, !done); err != nil {
+			return err
+		}
+		break
+	}
+	if d.done() {
+		return nil
+	}
+	return d.ensureEOL()
+}
+
+// decodeFor decodes the body of the loop once for each of its values. The
+// references to the loop variable are replaced by the value before decoding,
+// so it can be used in the names of the commands.
+func (d *Decoder) decodeFor() error {
+	d.next()
+	if d.curr().Type != Loop {
+		return d.unexpected()
+	}
+	var (
+		line            = d.curr().Line
+		header, body, _ = strings.Cut(d.curr().Literal, string(nl))
+		trimmed         = strings.TrimLeft(body, string(nl))
+	)
+	// the tokens of the body are given at their position in the file
+	line += len(body) - len(trimmed)
+	body = trimmed
+	ident, values, err := parseLoop(header, d.locals)
+	if err != nil {
+		return err
+	}
+	d.next()
+	if !d.done() {
+		if err := d.ensureEOL(); err != nil {
+			return err
+		}
+	}
+	// frames are stacked: the last iteration pushed is the first decoded
+	for i := len(values) - 1; i >= 0; i-- {
+		str, list := expandLoop(body, ident, values[i])
+		f, err := makeFrameAt(strings.NewReader(str), line, list)
+		if err != nil {
+			return err
+		}
+		d.pushFrame(f)
+	}
+	return nil
+}
+
+func (d *Decoder) isCommandName() bool {
+	return d.curr().Type == String && isCommandName(d.curr().Literal)
+}
+
+func isCommandName(str string) bool {
+	for i, r := range str {
+		if !isIdent(r) && (r != minus || i == 0) {
+			return false
+		}
+	}
+	return str != ""
+}
+
+func (d *Decoder) decodeBlock(mst *Maestro, take bool) error {
+	d.skipNL()
+	if !take {
+		for depth := 0; !d.done(); d.next() {
+			switch d.curr().Type {
+			case BegScript, Condition:
+				depth++
+			case EndScript:
+				depth--
+			}
+			if depth < 0 {
+				break
+			}
+		}
+	} else {
+		for !d.done() && d.curr().Type != EndScript {
+			if err := d.decodeStatement(mst); err != nil {
+				return err
+			}
+			d.skipNL()
+		}
+	}
+	if d.curr().Type != EndScript {
+		return d.unexpected()
+	}
+	d.next()
+	return nil
+}
+
 func (d *Decoder) decodeInclude(mst *Maestro) error {
 	type include struct {
 		file     string
 		optional bool
 	}
+	// the frame of the file is popped once its last line is decoded: the
+	// directory and the files being decoded are known before
+	var (
+		dir      = d.dir()
+		decoding = d.decoding()
+	)
 	decode := func() (include, error) {
 		var (
 			str []string
@@ -184,7 +442,7 @@ func (d *Decoder) decodeInclude(mst *Maestro) error {
 			}
 			str = append(str, vs...)
 		}
-		inc.file = strings.Join(str, "")
+		inc.file = normalizePath(strings.Join(str, ""))
 		if d.curr().Type == Optional {
 			inc.optional = true
 			d.next()
@@ -222,24 +480,71 @@ func (d *Decoder) decodeInclude(mst *Maestro) error {
 	default:
 		return d.unexpected()
 	}
+	var files []string
 	for i := range list {
-		file, ok := mst.Includes.Exists(list[i].file)
-		if !ok {
+		found, err := mst.Includes.Lookup(list[i].file, dir)
+		if err != nil {
 			if list[i].optional {
 				continue
 			}
-			return fmt.Errorf("%s: file does not exists in %s", file, mst.Includes)
+			return err
 		}
-		if err := d.decodeFile(file); err != nil {
-			if list[i].optional {
+		for _, f := range found {
+			if abs, _ := filepath.Abs(f); decoding[abs] {
 				continue
 			}
-			return err
+			if err := mst.checkPermissions(f); err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+	}
+	// frames are stacked: the last file pushed is the first decoded
+	for i := len(files) - 1; i >= 0; i-- {
+		if err := d.decodeFile(files[i]); err != nil {
+			return fmt.Errorf("%s: %w", files[i], err)
 		}
 	}
 	return nil
 }
 
+// dir gives the directory of the file being decoded.
+func (d *Decoder) dir() string {
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		if d.frames[i].file != "" {
+			return filepath.Dir(d.frames[i].file)
+		}
+	}
+	return "."
+}
+
+// position gives the position of the current token in the file being decoded.
+func (d *Decoder) position() Position {
+	pos := d.curr().Position
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		if d.frames[i].file != "" {
+			pos.File = d.frames[i].file
+			break
+		}
+	}
+	return pos
+}
+
+// decoding gives the absolute paths of the files being decoded so that a file
+// can not include itself.
+func (d *Decoder) decoding() map[string]bool {
+	files := make(map[string]bool)
+	for _, f := range d.frames {
+		if f.file == "" {
+			continue
+		}
+		if file, err := filepath.Abs(f.file); err == nil {
+			files[file] = true
+		}
+	}
+	return files
+}
+
 func (d *Decoder) decodeFile(file string) error {
 	r, err := os.Open(file)
 	if err != nil {
@@ -278,9 +583,7 @@ func (d *Decoder) decodeExport(msg *Maestro) error {
 	d.next()
 	switch d.curr().Type {
 	case Ident:
-		if err := decode(); err != nil {
-			return err
-		}
+		return decode()
 	case BegList:
 		d.next()
 		if err := d.ensureEOL(); err != nil {
@@ -301,6 +604,59 @@ func (d *Decoder) decodeExport(msg *Maestro) error {
 	return d.ensureEOL()
 }
 
+// decodePrint records the message of a print statement once its variables are
+// expanded. The messages are written when a command is executed.
+func (d *Decoder) decodePrint(mst *Maestro) error {
+	d.next()
+	list, err := d.parseStringList()
+	if err != nil {
+		return err
+	}
+	mst.Messages = append(mst.Messages, strings.Join(list, " "))
+	return d.ensureEOL()
+}
+
+// decodeOptionGroup decodes a named set of options given as
+// "options name = (option), (option)..." that can be attached to commands
+// with their options property.
+func (d *Decoder) decodeOptionGroup() error {
+	d.next()
+	ident := d.curr()
+	if ident.Type != Ident && ident.Type != String {
+		return d.unexpected()
+	}
+	d.next()
+	if d.curr().Type != Assign {
+		return d.unexpected()
+	}
+	d.next()
+	var list []CommandOption
+	for !d.done() {
+		if d.curr().Type != BegList {
+			return d.unexpected()
+		}
+		opt, err := d.decodeOptionObject()
+		if err != nil {
+			return err
+		}
+		opt.Group = ident.Literal
+		if list, err = appendOption(list, opt); err != nil {
+			return fmt.Errorf("%s: %w", ident.Literal, err)
+		}
+		if d.curr().Type != Comma {
+			break
+		}
+		d.next()
+		d.skipComment()
+		d.skipNL()
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("%s: option group without options", ident.Literal)
+	}
+	d.groups[ident.Literal] = list
+	return d.ensureEOL()
+}
+
 func (d *Decoder) decodeDelete(mst *Maestro) error {
 	d.next()
 	for !d.done() && !d.curr().IsEOL() {
@@ -367,6 +723,65 @@ func (d *Decoder) decodeAlias(mst *Maestro) error {
 	}
 }
 
+// decodePreset decodes the presets given as "preset name = command args..."
+// or as a block of such definitions between parenthesis.
+func (d *Decoder) decodePreset(mst *Maestro) error {
+	decode := func() error {
+		var (
+			ident = d.curr()
+			str   []string
+		)
+		if ident.Type != Ident && !(ident.Type == String && isCommandName(ident.Literal)) {
+			return d.unexpected()
+		}
+		d.next()
+		if !d.curr().IsAssign() {
+			return d.unexpected()
+		}
+		d.next()
+		for !d.done() {
+			vs, err := d.decodeValue()
+			if err != nil {
+				return err
+			}
+			str = append(str, vs...)
+			if !d.curr().IsBlank() {
+				break
+			}
+			d.skipBlank()
+		}
+		if len(str) == 0 {
+			return fmt.Errorf("%s: preset without command", ident.Literal)
+		}
+		if err := mst.RegisterPreset(ident.Literal, str[0], str[1:]); err != nil {
+			return err
+		}
+		return d.ensureEOL()
+	}
+	d.next()
+	switch d.curr().Type {
+	case Ident, String:
+		return decode()
+	case BegList:
+		d.next()
+		if err := d.ensureEOL(); err != nil {
+			return err
+		}
+		for !d.done() && d.curr().Type != EndList {
+			if err := decode(); err != nil {
+				return err
+			}
+		}
+		if d.curr().Type != EndList {
+			return d.unexpected()
+		}
+		d.next()
+		return d.ensureEOL()
+	default:
+		return d.unexpected()
+	}
+}
+
 func (d *Decoder) decodeObjectVariable(ident string) error {
 	d.locals = env.EnclosedEnv(d.locals)
 	err := d.decodeObject(d.decodeAssignment)
@@ -411,19 +826,19 @@ func (d *Decoder) decodeObject(decode func() error) error {
 }
 
 func (d *Decoder) decodeAssignment() error {
-	var (
-		ident  = d.curr()
-		assign bool
-	)
+	ident := d.curr()
 	d.next()
 	if !d.curr().IsAssign() {
 		return d.unexpected()
 	}
-	assign = d.curr().Type == Assign
+	kind := d.curr().Type
 	d.next()
 
+	if d.curr().Type == Substitution {
+		return d.decodeSubstitution(ident.Literal, kind)
+	}
 	if d.curr().Type == BegList {
-		if !assign {
+		if kind != Assign {
 			return d.unexpected()
 		}
 		return d.decodeObjectVariable(ident.Literal)
@@ -441,15 +856,79 @@ func (d *Decoder) decodeAssignment() error {
 		}
 		d.skipBlank()
 	}
-	if assign {
+	switch kind {
+	case Assign, AssignImmediate:
+		// values are always expanded when they are decoded
 		d.locals.Define(ident.Literal, str)
-	} else {
+	case AssignDefault:
+		if !d.locals.Defined(ident.Literal) {
+			d.locals.Define(ident.Literal, str)
+		}
+	case AssignShell:
+		vs, err := shellValues(strings.Join(str, " "), d.dir())
+		if err != nil {
+			return fmt.Errorf("%s: %w", ident.Literal, err)
+		}
+		d.locals.Define(ident.Literal, vs)
+	default:
 		xs, _ := d.locals.Resolve(ident.Literal)
 		d.locals.Define(ident.Literal, append(xs, str...))
 	}
 	return nil
 }
 
+// decodeSubstitution defines a variable whose values are given by the output
+// of a script (`script`). The script is executed the first time the variable
+// is resolved and its output is kept for the rest of the run. With :=, the
+// script is executed immediately.
+func (d *Decoder) decodeSubstitution(ident string, kind rune) error {
+	var (
+		script = d.curr().Literal
+		dir    = d.dir()
+	)
+	d.next()
+	if t := d.curr().Type; t != Eol && t != Comment && t != Eof {
+		return d.unexpected()
+	}
+	values := func() ([]string, error) {
+		vs, err := shellValues(script, dir)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", ident, err)
+		}
+		return vs, err
+	}
+	switch kind {
+	case Assign:
+		d.locals.DefineLazy(ident, values)
+	case AssignDefault:
+		if !d.locals.Defined(ident) {
+			d.locals.DefineLazy(ident, values)
+		}
+	case AssignImmediate:
+		vs, err := values()
+		if err != nil {
+			return err
+		}
+		d.locals.Define(ident, vs)
+	default:
+		return fmt.Errorf("%s: command substitution can not be used with this operator", ident)
+	}
+	return nil
+}
+
+// shellValues executes the script with the shell from the given directory.
+// Its output is split on blanks and newlines.
+func shellValues(script, dir string) ([]string, error) {
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", script, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
 func (d *Decoder) decodeVariable() error {
 	if err := d.decodeAssignment(); err != nil {
 		return err
@@ -484,12 +963,24 @@ func (d *Decoder) decodeCommand(mst *Maestro) error {
 	cmd.Ev = copyslice.CopyMap[string, string](d.env)
 	cmd.As = copyslice.CopyMap[string, string](d.alias)
 	cmd.Visible = !hidden
+	cmd.Position = d.position()
+	cmd.WorkDir = mst.MetaExec.WorkDir
 	d.next()
 	if d.curr().Type == BegList {
 		if err := d.decodeCommandProperties(&cmd); err != nil {
 			return err
 		}
 	}
+	if cmd.Extends != "" {
+		base, err := mst.Commands.Lookup(cmd.Extends)
+		if err != nil {
+			return fmt.Errorf("%s: can not extend %w", cmd.Name, err)
+		}
+		if cmd.WorkDir == mst.MetaExec.WorkDir {
+			cmd.WorkDir = ""
+		}
+		cmd.inherit(base)
+	}
 	if d.curr().Type == Dependency {
 		if err := d.decodeCommandDependencies(&cmd); err != nil {
 			return err
@@ -528,17 +1019,34 @@ func (d *Decoder) decodeCommandProperties(cmd *CommandSettings) error {
 			err = fmt.Errorf("%s: unknown command property", curr.Literal)
 		case propShort:
 			cmd.Short, err = d.parseString()
+		case propIcon:
+			cmd.Icon, err = d.parseString()
+		case propConfirm:
+			cmd.Confirm, err = d.parseString()
 		case propHelp:
 			cmd.Desc, err = d.parseString()
+		case propUsage:
+			cmd.Synopsis, err = d.parseStringList()
 		case propTags:
 			cmd.Categories, err = d.parseStringList()
 		case propRetry:
 			cmd.Retry, err = d.parseInt()
+		case propCodes:
+			cmd.SuccessCodes, err = d.parseExitCodes()
 		case propTimeout:
 			cmd.Timeout, err = d.parseDuration()
+		case propDelay:
+			cmd.RetryDelay, err = d.parseDuration()
+		case propBackoff:
+			cmd.RetryBackoff, err = d.parseFloat()
+		case propJitter:
+			cmd.RetryJitter, err = d.parseDuration()
 		case propHosts:
-			cmd.Hosts, err = d.parseStringList()
-			sort.Strings(cmd.Hosts)
+			if d.curr().Type == BegList {
+				cmd.Hosts, err = d.decodeHosts()
+				break
+			}
+			cmd.Hosts, err = d.parseTargets()
 		case propAlias:
 			cmd.Alias, err = d.parseStringList()
 			sort.Strings(cmd.Alias)
@@ -548,9 +1056,212 @@ func (d *Decoder) decodeCommandProperties(cmd *CommandSettings) error {
 			err = d.decodeCommandOptions(cmd)
 		case propSchedule:
 			err = d.decodeCommandSchedule(cmd)
+		case propTestable:
+			cmd.Testable, err = d.parseBool()
+		case propExample:
+			cmd.Examples, err = d.parseStringList()
+		case propRequires:
+			cmd.Requires, err = d.parseStringList()
+		case propTokens:
+			cmd.Tokens, err = d.parseStringList()
+		case propWatch:
+			cmd.Watch, err = d.parseStringList()
+			cmd.Watch = normalizePaths(cmd.Watch)
+		case propSources:
+			cmd.Sources, err = d.parseStringList()
+			cmd.Sources = normalizePaths(cmd.Sources)
+		case propTargets:
+			cmd.Targets, err = d.parseStringList()
+			cmd.Targets = normalizePaths(cmd.Targets)
+		case propFresh:
+			if cmd.Fresh, err = d.parseString(); err == nil {
+				err = checkFresh(cmd.Fresh)
+			}
+		case propVenv:
+			cmd.Venv, err = d.parseString()
+		case propNode:
+			cmd.Node, err = d.parseString()
+		case propGoFlags:
+			cmd.GoFlags, err = d.parseStringList()
+		case propEnvFile:
+			var list []string
+			if list, err = d.parseStringList(); err == nil {
+				for _, f := range list {
+					cmd.DotEnv = append(cmd.DotEnv, normalizePath(f))
+				}
+			}
+		case propWriteEnv:
+			err = d.decodeGeneratedFile(cmd, GenEnv)
+		case propFlagFile:
+			err = d.decodeGeneratedFile(cmd, GenFlag)
+		case propServices:
+			cmd.Services, err = d.parseStringList()
+		case propCache:
+			cmd.Cache, err = d.parseBool()
+		case propPath:
+			cmd.PathPrepend, err = d.parseStringList()
+		case propWorkDir:
+			cmd.WorkDir, err = d.parseString()
+			cmd.WorkDir = normalizePath(cmd.WorkDir)
+		case propExtends:
+			cmd.Extends, err = d.parseString()
+		case propSudo:
+			cmd.Sudo, err = d.parseBool()
+		case propInteract:
+			cmd.Interactive, err = d.parseBool()
+		case propLock:
+			cmd.Lock, err = d.parseString()
+			if b, e := strconv.ParseBool(cmd.Lock); e == nil && !b {
+				cmd.Lock = ""
+			}
+		case propMatrix:
+			cmd.Matrix, err = d.decodeMatrix()
+		case propMatrixPar:
+			cmd.MatrixParallel, err = d.parseInt()
+		case propPublish:
+			cmd.Publish, err = d.parseString()
+		case propArtifacts:
+			cmd.Artifacts, err = d.parseStringList()
+		case propStripAnsi:
+			cmd.StripAnsi, err = d.parseBool()
+		case propReplace:
+			var r OutputReplace
+			if r, err = d.decodeReplace(); err == nil {
+				cmd.Replace = append(cmd.Replace, r)
+			}
+		case propExtract:
+			cmd.Extract, err = d.parseString()
+		case propInput:
+			cmd.Input, err = d.parseString()
+		case propOutput:
+			if cmd.Output, err = d.parseString(); err == nil {
+				err = checkOutput(cmd.Output)
+			}
+		case propOutputs:
+			cmd.Outputs, err = d.parseStringList()
+		case propSchema:
+			cmd.InputSchema, err = d.parseString()
+			cmd.InputSchema = absPath(cmd.InputSchema, d.dir())
+		case propChecksums:
+			cmd.Checksums, err = d.parseBool()
+		case propProvenance:
+			cmd.Provenance, err = d.parseBool()
+		case propBefore:
+			cmd.Before, err = d.parseStringList()
+		case propAfter:
+			cmd.After, err = d.parseStringList()
+		case propError:
+			cmd.Error, err = d.parseStringList()
+		case propSuccess:
+			cmd.Success, err = d.parseStringList()
+		case propNotifyURL:
+			cmd.NotifyURL, err = d.parseStringList()
+		case propNotifyOn:
+			if cmd.NotifyOn, err = d.parseString(); err == nil {
+				err = checkNotifyOn(cmd.NotifyOn)
+			}
+		}
+		return err
+	})
+}
+
+func (d *Decoder) decodeReplace() (OutputReplace, error) {
+	var r OutputReplace
+	list, err := d.parseStringList()
+	if err != nil {
+		return r, err
+	}
+	if len(list) != 2 {
+		return r, fmt.Errorf("%s: pattern and replacement expected", propReplace)
+	}
+	r.Pattern, err = regexp.Compile(list[0])
+	r.With = list[1]
+	return r, err
+}
+
+func (d *Decoder) decodeTheme() (Theme, error) {
+	var theme Theme
+	if d.curr().Type != BegList {
+		return theme, d.unexpected()
+	}
+	err := d.decodeObject(func() error {
+		var (
+			curr = d.curr()
+			err  error
+		)
+		if curr.Type != Ident {
+			return d.unexpected()
+		}
+		d.next()
+		if d.curr().Type != Assign {
+			return d.unexpected()
+		}
+		d.next()
+		switch curr.Literal {
+		case themeIcon:
+			theme.Icon, err = d.parseString()
+		case themeSuccess:
+			theme.Success, err = d.parseString()
+		case themeFailure:
+			theme.Failure, err = d.parseString()
+		case themeColor:
+			theme.Color, err = d.parseBool()
+		default:
+			err = fmt.Errorf("%s: unknown theme property", curr.Literal)
+		}
+		return err
+	})
+	return theme, err
+}
+
+func (d *Decoder) decodeMatrix() ([]MatrixAxis, error) {
+	if d.curr().Type != BegList {
+		return nil, d.unexpected()
+	}
+	var list []MatrixAxis
+	err := d.decodeObject(func() error {
+		curr := d.curr()
+		if curr.Type != Ident {
+			return d.unexpected()
+		}
+		d.next()
+		if d.curr().Type != Assign {
+			return d.unexpected()
+		}
+		d.next()
+		values, err := d.parseStringList()
+		if err != nil {
+			return err
 		}
-		return err
+		if len(values) == 0 {
+			return fmt.Errorf("%s: no values given to matrix variable", curr.Literal)
+		}
+		for _, a := range list {
+			if a.Name == curr.Literal {
+				return fmt.Errorf("%s: matrix variable already defined", curr.Literal)
+			}
+		}
+		list = append(list, MatrixAxis{Name: curr.Literal, Values: values})
+		return nil
 	})
+	return list, err
+}
+
+func (d *Decoder) decodeGeneratedFile(cmd *CommandSettings, kind string) error {
+	list, err := d.parseStringList()
+	if err != nil {
+		return err
+	}
+	if len(list) < 2 {
+		return fmt.Errorf("%s: file and at least one name expected", kind)
+	}
+	gen := GeneratedFile{
+		Kind:  kind,
+		File:  normalizePath(list[0]),
+		Names: list[1:],
+	}
+	cmd.Generate = append(cmd.Generate, gen)
+	return nil
 }
 
 func (d *Decoder) decodeCommandSchedule(cmd *CommandSettings) error {
@@ -683,7 +1394,7 @@ func (d *Decoder) decodeCommandArguments() ([]CommandArg, error) {
 		d.skipBlank()
 		if d.curr().Type == BegList {
 			d.next()
-			list, err := d.decodeValidationRules(EndList)
+			list, _, err := d.decodeValidationRules(EndList)
 			if err != nil {
 				return nil, err
 			}
@@ -733,27 +1444,53 @@ func (d *Decoder) decodeOptionObject() (CommandOption, error) {
 			opt.Flag, err = d.parseBool()
 		case optHelp:
 			opt.Help, err = d.parseString()
+		case optSensitive:
+			opt.Sensitive, err = d.parseBool()
+		case optPrompt:
+			opt.Prompt, err = d.parseString()
 		case optValid:
-			opt.Valid, err = d.decodeBasicValidateOption()
+			opt.Valid, opt.Choices, err = d.decodeBasicValidateOption()
 		}
 		return err
 	})
 }
 
+// decodeCommandOptions decodes the options of a command. The options are given
+// inline or with the name of an option group. Options coming from a group can
+// not have the same short or long name as another option of the command.
 func (d *Decoder) decodeCommandOptions(cmd *CommandSettings) error {
 	var done bool
 	for !d.done() && !done {
-		if t := d.curr().Type; t != BegList {
-			if t == Ident || t == String {
-				return nil
+		var (
+			list []CommandOption
+			err  error
+		)
+		switch t := d.curr().Type; {
+		case t == BegList:
+			var opt CommandOption
+			if opt, err = d.decodeOptionObject(); err == nil {
+				list = append(list, opt)
 			}
+		case (t == Ident || t == String) && !d.peek().IsAssign():
+			group, ok := d.groups[d.curr().Literal]
+			if !ok {
+				return fmt.Errorf("%s: option group not defined", d.curr().Literal)
+			}
+			list = append(list, group...)
+			d.next()
+		case t == Ident || t == String:
+			return nil
+		default:
 			return d.unexpected()
 		}
-		opt, err := d.decodeOptionObject()
 		if err != nil {
 			return err
 		}
-		cmd.Options = append(cmd.Options, opt)
+		for _, o := range list {
+			if cmd.Options, err = appendOption(cmd.Options, o); err != nil {
+				return fmt.Errorf("%s: %w", cmd.Name, err)
+			}
+		}
 		switch d.curr().Type {
 		case Comma:
 			d.next()
@@ -773,12 +1510,34 @@ func (d *Decoder) decodeCommandOptions(cmd *CommandSettings) error {
 	return nil
 }
 
+// appendOption adds opt to the list of options. An error is returned when an
+// option of a group has the same short or long name as another option.
+func appendOption(list []CommandOption, opt CommandOption) ([]CommandOption, error) {
+	for _, o := range list {
+		if o.Group == "" && opt.Group == "" {
+			continue
+		}
+		if (o.Short != "" && o.Short == opt.Short) || (o.Long != "" && o.Long == opt.Long) {
+			return nil, fmt.Errorf("option %s collides with option %s", describeOption(opt), describeOption(o))
+		}
+	}
+	return append(list, opt), nil
+}
+
+func describeOption(o CommandOption) string {
+	str := o.Name()
+	if o.Group != "" {
+		str = fmt.Sprintf("%s (group %s)", str, o.Group)
+	}
+	return str
+}
+
 func (d *Decoder) decodeSpecialValidateOption(rule string) (ValidateFunc, error) {
 	if d.curr().Type != BegList {
 		return nil, d.unexpected()
 	}
 	d.next()
-	list, err := d.decodeValidationRules(EndList)
+	list, _, err := d.decodeValidationRules(EndList)
 	if err != nil {
 		return nil, err
 	}
@@ -797,26 +1556,31 @@ func (d *Decoder) decodeSpecialValidateOption(rule string) (ValidateFunc, error)
 	return fn, nil
 }
 
-func (d *Decoder) decodeBasicValidateOption() (ValidateFunc, error) {
-	list, err := d.decodeValidationRules(Comma)
+func (d *Decoder) decodeBasicValidateOption() (ValidateFunc, []string, error) {
+	list, choices, err := d.decodeValidationRules(Comma)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	switch len(list) {
 	case 0:
-		return nil, fmt.Errorf("%s is given but rules are supplied", optValid)
+		return nil, nil, fmt.Errorf("%s is given but rules are supplied", optValid)
 	case 1:
-		return list[0], nil
+		return list[0], choices, nil
 	default:
-		return validateAll(list...), nil
+		return validateAll(list...), choices, nil
 	}
 }
 
-func (d *Decoder) decodeValidationRules(until rune) ([]ValidateFunc, error) {
-	var list []ValidateFunc
+// decodeValidationRules gives the list of rules found until the given token
+// and the values accepted by the oneof rule if any.
+func (d *Decoder) decodeValidationRules(until rune) ([]ValidateFunc, []string, error) {
+	var (
+		list    []ValidateFunc
+		choices []string
+	)
 	for !d.done() && d.curr().Type != until {
 		if d.curr().Type != Ident {
-			return nil, d.unexpected()
+			return nil, nil, d.unexpected()
 		}
 		var (
 			rule = d.curr().Literal
@@ -827,7 +1591,7 @@ func (d *Decoder) decodeValidationRules(until rune) ([]ValidateFunc, error) {
 		if rule == validNot || rule == validSome || rule == validAll {
 			fn, err := d.decodeSpecialValidateOption(rule)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			list = append(list, fn)
 			continue
@@ -841,32 +1605,35 @@ func (d *Decoder) decodeValidationRules(until rune) ([]ValidateFunc, error) {
 				case curr.IsVariable():
 					vs, err := d.locals.Resolve(curr.Literal)
 					if err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 					args = append(args, vs...)
 				default:
-					return nil, d.unexpected()
+					return nil, nil, d.unexpected()
 				}
 				d.next()
 				d.skipBlank()
 			}
 			if d.curr().Type != EndList {
-				return nil, d.unexpected()
+				return nil, nil, d.unexpected()
 			}
 			d.next()
 			d.skipBlank()
 		}
+		if rule == validOneOf {
+			choices = append(choices, args...)
+		}
 		fn, err := getValidateFunc(rule, args)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		list = append(list, fn)
 	}
 	if d.curr().Type != until {
-		return nil, d.unexpected()
+		return nil, nil, d.unexpected()
 	}
 	d.next()
-	return list, nil
+	return list, choices, nil
 }
 
 func (d *Decoder) decodeCommandDependencies(cmd *CommandSettings) error {
@@ -875,85 +1642,160 @@ func (d *Decoder) decodeCommandDependencies(cmd *CommandSettings) error {
 		if d.curr().Type == BegScript {
 			break
 		}
-		var optional, mandatory, space bool
-		for d.curr().Type != Ident {
-			switch d.curr().Type {
-			case Mandatory:
-				mandatory = true
-			case Optional:
-				optional = true
-			default:
-				return d.unexpected()
-			}
+		dep, err := d.decodeDependency()
+		if err != nil {
+			return err
+		}
+		for d.curr().Type == Pipe {
 			d.next()
+			next, err := d.decodeDependency()
+			if err != nil {
+				return err
+			}
+			// the pipe runs in background as a whole
+			dep.Bg, next.Bg = dep.Bg || next.Bg, false
+			dep.Pipe = append(dep.Pipe, next)
 		}
+		cmd.Deps = append(cmd.Deps, dep)
 		switch d.curr().Type {
-		case Resolution:
-			space = true
+		case Comma:
 			d.next()
-		case Ident:
+		case BegScript:
 		default:
 			return d.unexpected()
 		}
-		dep := CommandDep{
-			Name:      d.curr().Literal,
-			Optional:  optional,
-			Mandatory: mandatory,
+	}
+	if d.curr().Type != BegScript {
+		return d.unexpected()
+	}
+	return nil
+}
+
+// decodeDependency decodes one dependency of a command with its modifiers,
+// its arguments and its attributes.
+func (d *Decoder) decodeDependency() (CommandDep, error) {
+	var (
+		dep                                    CommandDep
+		optional, mandatory, background, space bool
+	)
+	for d.curr().Type != Ident && !d.isCommandName() {
+		switch d.curr().Type {
+		case Mandatory:
+			mandatory = true
+		case Optional:
+			optional = true
+		case Background:
+			background = true
+		default:
+			return dep, d.unexpected()
+		}
+		d.next()
+	}
+	switch d.curr().Type {
+	case Resolution:
+		space = true
+		d.next()
+	case Ident:
+	default:
+		if !d.isCommandName() {
+			return dep, d.unexpected()
+		}
+	}
+	dep = CommandDep{
+		Name:      d.curr().Literal,
+		Bg:        background,
+		Optional:  optional,
+		Mandatory: mandatory,
+	}
+	d.next()
+	if d.curr().Type == Resolution {
+		if space {
+			return dep, d.unexpected()
 		}
 		d.next()
-		if d.curr().Type == Resolution {
-			if space {
-				return d.unexpected()
-			}
-			d.next()
-			if d.curr().Type != Ident {
-				return d.unexpected()
-			}
-			dep.Space = dep.Name
-			dep.Name = d.curr().Literal
-			d.next()
+		if d.curr().Type != Ident {
+			return dep, d.unexpected()
 		}
-		if d.curr().Type == BegList {
-			d.next()
-			for !d.done() && d.curr().Type != EndList {
-				switch curr := d.curr(); {
-				case curr.IsPrimitive():
-					dep.Args = append(dep.Args, curr.Literal)
-				case curr.IsVariable():
-					vs, err := d.locals.Resolve(curr.Literal)
-					if err != nil {
-						return err
-					}
-					dep.Args = append(dep.Args, vs...)
-				default:
-					return d.unexpected()
-				}
-				d.next()
-				if d.curr().Type == Comma {
-					d.next()
+		dep.Space = dep.Name
+		dep.Name = d.curr().Literal
+		d.next()
+	}
+	if d.curr().Type == BegList {
+		d.next()
+		for !d.done() && d.curr().Type != EndList {
+			switch curr := d.curr(); {
+			case curr.IsPrimitive():
+				dep.Args = append(dep.Args, curr.Literal)
+			case curr.IsVariable():
+				vs, err := d.locals.Resolve(curr.Literal)
+				if err != nil {
+					return dep, err
 				}
-			}
-			if d.curr().Type != EndList {
-				return d.unexpected()
+				dep.Args = append(dep.Args, vs...)
+			default:
+				return dep, d.unexpected()
 			}
 			d.next()
+			if d.curr().Type == Comma {
+				d.next()
+			}
 		}
-		if d.curr().Type == Background {
-			dep.Bg = true
-			d.next()
+		if d.curr().Type != EndList {
+			return dep, d.unexpected()
 		}
-		cmd.Deps = append(cmd.Deps, dep)
+		d.next()
+	}
+	if d.curr().Type == BegAttr {
+		if err := d.decodeDependencyAttributes(&dep); err != nil {
+			return dep, err
+		}
+	}
+	if d.curr().Type == Background {
+		dep.Bg = true
+		d.next()
+	}
+	return dep, nil
+}
+
+// decodeDependencyAttributes decodes the settings given between brackets after
+// the name of a dependency (eg: test[timeout=2m, retry=3]).
+func (d *Decoder) decodeDependencyAttributes(dep *CommandDep) error {
+	d.next()
+	for !d.done() && d.curr().Type != EndAttr {
+		curr := d.curr()
+		if curr.Type != Ident {
+			return d.unexpected()
+		}
+		d.next()
+		if d.curr().Type != Assign {
+			return d.unexpected()
+		}
+		d.next()
+		var err error
+		switch curr.Literal {
+		case propTimeout:
+			dep.Timeout, err = d.parseDuration()
+		case propRetry:
+			dep.Retry, err = d.parseInt()
+		default:
+			err = fmt.Errorf("%s: unknown dependency attribute", curr.Literal)
+		}
+		if err != nil {
+			return err
+		}
+		d.skipBlank()
 		switch d.curr().Type {
 		case Comma:
 			d.next()
-		case BegScript:
+		case EndAttr:
 		default:
 			return d.unexpected()
 		}
 	}
-	if d.curr().Type != BegScript {
+	if d.curr().Type != EndAttr {
 		return d.unexpected()
 	}
+	d.next()
 	return nil
 }
 
@@ -988,12 +1830,23 @@ func (d *Decoder) decodeCommandScripts(cmd *CommandSettings, mst *Maestro) error
 		case Comment:
 			d.next()
 		default:
+			pos := d.position()
 			line, err1 := d.decodeScriptLine()
 			if err1 != nil {
 				err = err1
 				break
 			}
+			if rest, ok := parseDefer(line); ok {
+				mod, rest := parseModifiers(rest)
+				cmd.Finally = append(cmd.Finally, rest)
+				cmd.FinallyModifiers = append(cmd.FinallyModifiers, mod)
+				break
+			}
+			mod, line := parseModifiers(line)
+			mod.Capture, line = parseCapture(line)
 			cmd.Lines = append(cmd.Lines, line)
+			cmd.Modifiers = append(cmd.Modifiers, mod)
+			cmd.Positions = append(cmd.Positions, pos)
 		}
 		if err != nil {
 			return err
@@ -1006,6 +1859,57 @@ func (d *Decoder) decodeCommandScripts(cmd *CommandSettings, mst *Maestro) error
 	return d.ensureEOL()
 }
 
+const scriptDefer = "defer"
+
+// parseDefer reports whether a script line starts with the defer prefix and
+// gives the line without it.
+func parseDefer(line string) (string, bool) {
+	rest := strings.TrimPrefix(line, scriptDefer)
+	if rest == line || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return line, false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+var capturePattern = regexp.MustCompile(`^let\s+([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*run\s+(.+)$`)
+
+// parseCapture reports whether a script line captures the output of a command
+// in a variable (let name = run command) and gives the name of the variable
+// and the command.
+func parseCapture(line string) (string, string) {
+	m := capturePattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", line
+	}
+	return m[1], strings.TrimSpace(m[2])
+}
+
+// parseModifiers extracts the modifiers (-, @, !) at the beginning of a script
+// line. They should be immediately followed by the command so that the shell
+// negation (! cmd) is left untouched.
+func parseModifiers(line string) (LineModifier, string) {
+	var (
+		mod LineModifier
+		i   int
+	)
+	for ; i < len(line); i++ {
+		switch line[i] {
+		case minus:
+			mod.Ignore = true
+		case arobase:
+			mod.Silent = true
+		case bang:
+			mod.Force = true
+		default:
+			if line[i] == ' ' || line[i] == '\t' {
+				return LineModifier{}, line
+			}
+			return mod, line[i:]
+		}
+	}
+	return LineModifier{}, line
+}
+
 func (d *Decoder) decodeScriptLine() (string, error) {
 	if d.curr().Type != Script {
 		return "", d.unexpected()
@@ -1029,12 +1933,76 @@ func (d *Decoder) decodeMeta(mst *Maestro) error {
 		mst.MetaExec.Namespace, err = d.parseString()
 	case metaWorkDir:
 		mst.MetaExec.WorkDir, err = d.parseString()
+		mst.MetaExec.WorkDir = normalizePath(mst.MetaExec.WorkDir)
+	case metaCacheDir:
+		mst.MetaExec.CacheDir, err = d.parseString()
+		mst.MetaExec.CacheDir = normalizePath(mst.MetaExec.CacheDir)
+	case metaLogDir:
+		mst.MetaExec.LogDir, err = d.parseString()
+		mst.MetaExec.LogDir = normalizePath(mst.MetaExec.LogDir)
+	case metaLogMaxSize:
+		mst.MetaExec.LogMaxSize, err = d.parseInt()
+	case metaLogMaxAge:
+		mst.MetaExec.LogMaxAge, err = d.parseDuration()
+	case metaCompose:
+		mst.MetaExec.ComposeFile, err = d.parseString()
+		mst.MetaExec.ComposeFile = normalizePath(mst.MetaExec.ComposeFile)
+	case metaPackages:
+		var list []string
+		if list, err = d.parseStringList(); err != nil {
+			break
+		}
+		if mst.MetaExec.Packages == nil {
+			mst.MetaExec.Packages = make(map[string]string)
+		}
+		for _, str := range list {
+			prog, pkg, err := parsePackage(str)
+			if err != nil {
+				return err
+			}
+			mst.MetaExec.Packages[prog] = pkg
+		}
 	case metaTrace:
 		mst.MetaExec.Trace, err = d.parseBool()
+	case metaTraceLines:
+		mst.MetaExec.TraceLines, err = d.parseBool()
 	case metaAll:
 		mst.MetaExec.All, err = d.parseStringList()
+	case metaAllPar:
+		mst.MetaExec.AllParallel, err = d.parseInt()
+	case metaMaxFail:
+		mst.MetaExec.MaxFailures, err = d.parseInt()
+	case metaNotifyURL:
+		mst.MetaExec.NotifyURL, err = d.parseStringList()
+	case metaNotifyOn:
+		if mst.MetaExec.NotifyOn, err = d.parseString(); err == nil {
+			err = checkNotifyOn(mst.MetaExec.NotifyOn)
+		}
+	case metaEnvFile:
+		var list []string
+		list, err = d.parseStringList()
+		mst.MetaExec.EnvFiles = append(mst.MetaExec.EnvFiles, normalizePaths(list)...)
+	case metaTheme:
+		mst.MetaExec.Theme, err = d.decodeTheme()
+	case metaSensitive:
+		var list []string
+		list, err = d.parseStringList()
+		mst.MetaExec.Sensitive = append(mst.MetaExec.Sensitive, list...)
+	case metaPublishKey:
+		mst.MetaExec.SignKey, err = d.parseSignerSSH()
 	case metaDefault:
 		mst.MetaExec.Default, err = d.parseString()
+	case metaNoBuiltins:
+		var list []string
+		if list, err = d.parseStringList(); err != nil {
+			break
+		}
+		for _, str := range list {
+			if err := checkNoBuiltin(str); err != nil {
+				return err
+			}
+		}
+		mst.MetaExec.NoBuiltins = append(mst.MetaExec.NoBuiltins, list...)
 	case metaBefore:
 		mst.MetaExec.Before, err = d.parseStringList()
 	case metaAfter:
@@ -1049,6 +2017,7 @@ func (d *Decoder) decodeMeta(mst *Maestro) error {
 		mst.MetaAbout.Email, err = d.parseString()
 	case metaVersion:
 		mst.MetaAbout.Version, err = d.parseString()
+		d.locals.Define(mstVersion, []string{mst.MetaAbout.Version})
 	case metaUsage:
 		mst.MetaAbout.Usage, err = d.parseString()
 	case metaHelp:
@@ -1063,10 +2032,46 @@ func (d *Decoder) decodeMeta(mst *Maestro) error {
 		mst.MetaSSH.Hosts, err = d.parseKnownHosts()
 	case metaParallel:
 		mst.MetaSSH.Parallel, err = d.parseInt()
+	case metaSudoPass:
+		mst.MetaSSH.Sudo, err = d.parseString()
+	case metaSSHHosts:
+		_, err = d.decodeHosts()
+	case metaPrefix:
+		if mst.MetaSSH.Prefix, err = d.parseString(); err == nil {
+			err = checkPrefixFormat(mst.MetaSSH.Prefix)
+		}
 	case metaCertFile:
 		mst.MetaHttp.CertFile, err = d.parseString()
 	case metaKeyFile:
 		mst.MetaHttp.KeyFile, err = d.parseString()
+	case metaHttpGet:
+		mst.MetaHttp.Get, err = d.parseStringList()
+	case metaHttpPost:
+		mst.MetaHttp.Post, err = d.parseStringList()
+	case metaHttpDelete:
+		mst.MetaHttp.Delete, err = d.parseStringList()
+	case metaHttpPatch:
+		mst.MetaHttp.Patch, err = d.parseStringList()
+	case metaHttpPut:
+		mst.MetaHttp.Put, err = d.parseStringList()
+	case metaHttpHead:
+		mst.MetaHttp.Head, err = d.parseStringList()
+	case metaHttpToken:
+		var list []string
+		list, err = d.parseStringList()
+		mst.MetaHttp.Tokens = append(mst.MetaHttp.Tokens, list...)
+	case metaHttpTokens:
+		var list []string
+		list, err = d.parseTokenFile()
+		mst.MetaHttp.Tokens = append(mst.MetaHttp.Tokens, list...)
+	case metaSmtpHost:
+		mst.MetaSMTP.Host, err = d.parseString()
+	case metaSmtpUser:
+		mst.MetaSMTP.User, err = d.parseString()
+	case metaSmtpPass:
+		mst.MetaSMTP.Pass, err = d.parseString()
+	case metaSmtpFrom:
+		mst.MetaSMTP.From, err = d.parseString()
 	default:
 		return fmt.Errorf("%s: unknown/unsupported meta", meta)
 	}
@@ -1099,7 +2104,7 @@ func (d *Decoder) ensureEOL() error {
 func (d *Decoder) decodeQuote() (string, error) {
 	d.next()
 	var str []string
-	for !d.done() && d.curr().Type != Quote {
+	for !d.done() && d.curr().Type != Quote && !d.curr().IsInvalid() {
 		if d.curr().IsVariable() {
 			vs, err := d.locals.Resolve(d.curr().Literal)
 			if err != nil {
@@ -1169,6 +2174,84 @@ func (d *Decoder) parseStringList() ([]string, error) {
 	return str, nil
 }
 
+func (d *Decoder) parseTargets() ([]CommandTarget, error) {
+	list, err := d.parseStringList()
+	if err != nil {
+		return nil, err
+	}
+	var hosts []CommandTarget
+	for _, str := range list {
+		t, err := d.resolveTarget(str)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, t)
+	}
+	sortTargets(hosts)
+	return hosts, nil
+}
+
+// decodeHosts decodes the aliases of the remote servers (alias = [user@]host[:port]).
+// The aliases can be used by the commands decoded after them in place of the
+// servers. The servers declared are given.
+func (d *Decoder) decodeHosts() ([]CommandTarget, error) {
+	if d.curr().Type != BegList {
+		return nil, d.unexpected()
+	}
+	var hosts []CommandTarget
+	err := d.decodeObject(func() error {
+		curr := d.curr()
+		if curr.Type != Ident && !(curr.Type == String && isCommandName(curr.Literal)) {
+			return d.unexpected()
+		}
+		d.next()
+		if d.curr().Type != Assign {
+			return d.unexpected()
+		}
+		d.next()
+		str, err := d.parseString()
+		if err != nil {
+			return err
+		}
+		t, err := d.resolveTarget(str)
+		if err != nil {
+			return fmt.Errorf("%s: %w", curr.Literal, err)
+		}
+		d.hosts[curr.Literal] = t
+		hosts = append(hosts, t)
+		return nil
+	})
+	sortTargets(hosts)
+	return hosts, err
+}
+
+// resolveTarget gives the remote server of str. An alias is replaced by the
+// server it was declared with. The user and the port given with the alias
+// replace the ones of the declaration (eg: root@web1:2200).
+func (d *Decoder) resolveTarget(str string) (CommandTarget, error) {
+	t, err := parseTarget(str)
+	if err != nil {
+		return t, err
+	}
+	a, ok := d.hosts[t.Host]
+	if !ok {
+		return t, nil
+	}
+	if t.User != "" {
+		a.User = t.User
+	}
+	if hasPort(str) {
+		a.Port = t.Port
+	}
+	return a, nil
+}
+
+func sortTargets(hosts []CommandTarget) {
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].String() < hosts[j].String()
+	})
+}
+
 func (d *Decoder) parseString() (string, error) {
 	if d.curr().Type == Eol || d.curr().Type == Comment {
 		return "", nil
@@ -1223,12 +2306,32 @@ func (d *Decoder) parseKnownHosts() ([]hostEntry, error) {
 	return list, nil
 }
 
+func (d *Decoder) parseTokenFile() ([]string, error) {
+	file, err := d.parseString()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := os.ReadFile(normalizePath(file))
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, str := range strings.Split(string(buf), "\n") {
+		str = strings.TrimSpace(str)
+		if str == "" || strings.HasPrefix(str, "#") {
+			continue
+		}
+		list = append(list, str)
+	}
+	return list, nil
+}
+
 func (d *Decoder) parseSignerSSH() (ssh.Signer, error) {
 	file, err := d.parseString()
 	if err != nil {
 		return nil, err
 	}
-	buf, err := os.ReadFile(file)
+	buf, err := os.ReadFile(normalizePath(file))
 	if err != nil {
 		return nil, err
 	}
@@ -1251,6 +2354,31 @@ func (d *Decoder) parseInt() (int64, error) {
 	return strconv.ParseInt(str, 0, 64)
 }
 
+// parseExitCodes parses a list of exit codes (between 0 and 255).
+func (d *Decoder) parseExitCodes() ([]int, error) {
+	list, err := d.parseStringList()
+	if err != nil {
+		return nil, err
+	}
+	var codes []int
+	for _, str := range list {
+		c, err := strconv.Atoi(str)
+		if err != nil || c < 0 || c > 255 {
+			return nil, fmt.Errorf("%s: invalid exit code", str)
+		}
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+func (d *Decoder) parseFloat() (float64, error) {
+	str, err := d.parseString()
+	if err != nil || str == "" {
+		return 0, err
+	}
+	return strconv.ParseFloat(str, 64)
+}
+
 func (d *Decoder) parseDuration() (time.Duration, error) {
 	str, err := d.parseString()
 	if err != nil || str == "" {
@@ -1302,7 +2430,11 @@ func (d *Decoder) done() bool {
 }
 
 func (d *Decoder) unexpected() error {
-	return unexpected(d.curr(), d.CurrentLine())
+	curr := d.curr()
+	if z := len(d.frames); z > 0 && curr.IsInvalid() {
+		return unexpected(curr, d.frames[z-1].scan.LineAt(curr.Line))
+	}
+	return unexpected(curr, d.CurrentLine())
 }
 
 func (d *Decoder) undefined() error {
@@ -1314,9 +2446,16 @@ func (d *Decoder) push(r io.Reader) error {
 	if err != nil {
 		return err
 	}
+	d.pushFrame(f)
+	return nil
+}
+
+func (d *Decoder) pushFrame(f *frame) {
+	if f.done() && len(d.frames) > 0 {
+		return
+	}
 	d.frames = append(d.frames, f)
 	d.locals = env.EnclosedEnv(d.locals)
-	return nil
 }
 
 func (d *Decoder) pop() error {
@@ -1360,18 +2499,34 @@ var (
 )
 
 type frame struct {
+	file string
 	curr Token
 	peek Token
 	scan *Scanner
+
+	// line of the input before the first line of the frame and the values
+	// given to the loop variable when the frame is the body of a loop: the
+	// tokens are given at their position in the input
+	offset int
+	values []replacement
 }
 
 func makeFrame(r io.Reader) (*frame, error) {
+	return makeFrameAt(r, 0, nil)
+}
+
+func makeFrameAt(r io.Reader, offset int, values []replacement) (*frame, error) {
 	s, err := Scan(r)
 	if err != nil {
 		return nil, err
 	}
 	f := frame{
-		scan: s,
+		scan:   s,
+		offset: offset,
+		values: values,
+	}
+	if n, ok := r.(interface{ Name() string }); ok {
+		f.file = n.Name()
 	}
 	f.next()
 	f.next()
@@ -1394,6 +2549,9 @@ func (f *frame) Line() string {
 func (f *frame) next() {
 	f.curr = f.peek
 	f.peek = f.scan.Scan()
+	if f.offset > 0 || len(f.values) > 0 {
+		f.peek.Position = restore(f.peek.Position, f.offset, f.values)
+	}
 }
 
 func (f *frame) done() bool {
@@ -1404,16 +2562,36 @@ type UnexpectedError struct {
 	Line     string
 	Invalid  Token
 	Expected []string
+	// Reason of an invalid token (eg: unterminated string)
+	Reason string
 }
 
 func unexpected(token Token, line string) error {
 	return UnexpectedError{
 		Line:    line,
 		Invalid: token,
+		Reason:  token.reason,
+	}
+}
+
+// Message describes the error without its position.
+func (e UnexpectedError) Message() string {
+	if strings.HasPrefix(e.Reason, "unterminated") {
+		return fmt.Sprintf("%s starting at line %d", e.Reason, e.Invalid.Line)
+	}
+	if e.Reason != "" {
+		return fmt.Sprintf("%s at line %d", e.Reason, e.Invalid.Line)
 	}
+	if e.Invalid.IsInvalid() {
+		return "unexpected character found"
+	}
+	return e.Invalid.String()
 }
 
 func (e UnexpectedError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%s (column %d)", e.Message(), e.Invalid.Column)
+	}
 	str := e.Invalid.Literal
 	if str == "" {
 		str = e.Invalid.String()
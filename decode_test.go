@@ -1,16 +1,44 @@
 package maestro_test
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/midbel/maestro"
+	"github.com/midbel/maestro/internal/env"
 )
 
 func TestDecode(t *testing.T) {
 	t.Run("file", testDecodeFile)
 	t.Run("end-of-line", testDecodeEndOfLine)
+	t.Run("export", testDecodeExport)
+	t.Run("condition", testDecodeCondition)
+	t.Run("loop", testDecodeLoop)
+	t.Run("extends", testDecodeExtends)
+	t.Run("matrix", testDecodeMatrix)
+	t.Run("assignment", testDecodeAssignment)
+	t.Run("defer", testDecodeDefer)
+	t.Run("preset", testDecodePreset)
+	t.Run("unterminated", testDecodeUnterminated)
+	t.Run("unicode", testDecodeUnicode)
+	t.Run("crlf", testDecodeCRLF)
+	t.Run("print", testDecodePrint)
+	t.Run("hosts", testDecodeHosts)
+	t.Run("option-groups", testDecodeOptionGroups)
+	t.Run("usage", testDecodeUsage)
+	t.Run("permissions", testDecodePermissions)
+	t.Run("include", testDecodeInclude)
+	t.Run("dependencies", testDecodeDependencies)
+	t.Run("capture", testDecodeCapture)
+	t.Run("builtins", testDecodeBuiltins)
+	t.Run("envfile", testDecodeEnvFile)
 }
 
 func testDecodeFile(t *testing.T) {
@@ -65,3 +93,1072 @@ func testDecodeEndOfLine(t *testing.T) {
 		t.Fatalf("fail to decode multiline object: %s", err)
 	}
 }
+
+const exports = `
+export FOO = foo
+export (
+	BAR = bar
+)
+action: {
+	echo $FOO $BAR
+}
+`
+
+func testDecodeExport(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(exports))
+	if err != nil {
+		t.Fatalf("fail to decode exports: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("action")
+	if err != nil {
+		t.Fatalf("action command not decoded: %s", err)
+	}
+	if cmd.Ev["FOO"] != "foo" || cmd.Ev["BAR"] != "bar" {
+		t.Fatalf("exported variables mismatched! got %v", cmd.Ev)
+	}
+}
+
+const conditions = `
+mode = debug
+if %(maestro.os) == plan9 {
+	action: {
+		echo plan9
+	}
+} else if $mode == debug && !$undefined {
+	export MODE = debug
+	action: {
+		echo debug
+	}
+} else {
+	action: {
+		echo other
+	}
+}
+`
+
+func testDecodeCondition(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(conditions))
+	if err != nil {
+		t.Fatalf("fail to decode conditions: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("action")
+	if err != nil {
+		t.Fatalf("action command not decoded: %s", err)
+	}
+	if len(cmd.Lines) != 1 || cmd.Lines[0] != "echo debug" {
+		t.Fatalf("wrong branch decoded! got %v", cmd.Lines)
+	}
+	if cmd.Ev["MODE"] != "debug" {
+		t.Fatalf("exported variables mismatched! got %v", cmd.Ev)
+	}
+}
+
+const loops = `
+targets = linux windows
+for target in $targets {
+	build-${target}(short = "build for $target"): {
+		echo $target
+	}
+}
+all: build-linux, build-windows {
+	echo all
+}
+`
+
+func testDecodeLoop(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(loops))
+	if err != nil {
+		t.Fatalf("fail to decode loops: %s", err)
+	}
+	for _, n := range []string{"linux", "windows"} {
+		cmd, err := mst.Commands.Lookup("build-" + n)
+		if err != nil {
+			t.Fatalf("build-%s command not generated: %s", n, err)
+		}
+		if cmd.Short != "build for "+n || cmd.Lines[0] != "echo "+n {
+			t.Fatalf("loop variable not expanded! got %s/%v", cmd.Short, cmd.Lines)
+		}
+	}
+	cmd, err := mst.Commands.Lookup("all")
+	if err != nil {
+		t.Fatalf("all command not decoded: %s", err)
+	}
+	if len(cmd.Deps) != 2 {
+		t.Fatalf("dependencies mismatched! got %v", cmd.Deps)
+	}
+	testDecodeLoopPosition(t)
+}
+
+const invalidLoop = `
+targets = linux windows
+for target in $targets {
+
+	build-${target}(short = "build for $target"): {
+		echo $target
+	}
+	test-${target}(short = $target $target): {
+		echo $target
+	}
+}
+`
+
+func testDecodeLoopPosition(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(loops))
+	if err != nil {
+		t.Fatalf("fail to decode loops: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("build-windows")
+	if err != nil {
+		t.Fatalf("build-windows command not generated: %s", err)
+	}
+	if cmd.Position.Line != 4 {
+		t.Errorf("command position mismatched! want line 4, got %d", cmd.Position.Line)
+	}
+
+	_, err = maestro.Decode(strings.NewReader(invalidLoop))
+	var e maestro.UnexpectedError
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected error expected, got %v", err)
+	}
+	// the blank after the first $target in the file and not the one after
+	// the first linux in the expanded line
+	if e.Invalid.Line != 8 || e.Invalid.Column != 32 {
+		t.Errorf("error position mismatched! want 8:32, got %d:%d", e.Invalid.Line, e.Invalid.Column)
+	}
+}
+
+const extends = `
+%base(
+	short = "base command",
+	hosts = localhost,
+	options = (
+		short = "v",
+		long  = "verbose",
+		flag  = true,
+	), (
+		short   = "n",
+		long    = "name",
+		default = base,
+	),
+): {
+	echo base
+}
+child(
+	extends = base,
+	options = (
+		short   = "n",
+		long    = "name",
+		default = child,
+	),
+): {
+	echo child
+}
+`
+
+func testDecodeExtends(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(extends))
+	if err != nil {
+		t.Fatalf("fail to decode extends: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("child")
+	if err != nil {
+		t.Fatalf("child command not decoded: %s", err)
+	}
+	if cmd.Short != "base command" || len(cmd.Hosts) != 1 {
+		t.Fatalf("properties not inherited! got %s/%v", cmd.Short, cmd.Hosts)
+	}
+	if len(cmd.Options) != 2 || cmd.Options[1].Default != "child" {
+		t.Fatalf("options not merged! got %v", cmd.Options)
+	}
+	if len(cmd.Lines) != 2 || cmd.Lines[0] != "echo base" || cmd.Lines[1] != "echo child" {
+		t.Fatalf("script not appended! got %v", cmd.Lines)
+	}
+	if !cmd.Visible {
+		t.Fatalf("child command should be visible")
+	}
+}
+
+const matrix = `
+build(
+	matrix = (
+		GOOS   = linux darwin,
+		GOARCH = amd64 arm64 386,
+	),
+	matrix_parallel = 2,
+): {
+	echo $GOOS/$GOARCH
+}
+`
+
+func testDecodeMatrix(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(matrix))
+	if err != nil {
+		t.Fatalf("fail to decode matrix: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("build")
+	if err != nil {
+		t.Fatalf("build command not decoded: %s", err)
+	}
+	if len(cmd.Matrix) != 2 || cmd.MatrixParallel != 2 {
+		t.Fatalf("matrix mismatched! got %v (%d)", cmd.Matrix, cmd.MatrixParallel)
+	}
+	if a := cmd.Matrix[1]; a.Name != "GOARCH" || len(a.Values) != 3 {
+		t.Fatalf("matrix variable mismatched! got %v", a)
+	}
+}
+
+const assignments = `
+FOO ?= foo
+BAR ?= bar
+NOW := $BAR-now
+OUT != echo out
+LAZY = ` + "`echo lazy`" + `
+NEVER = ` + "`exit 1`" + `
+action(short = "$FOO $BAR $NOW $OUT $LAZY"): {
+	echo $FOO
+}
+`
+
+func testDecodeAssignment(t *testing.T) {
+	ev := env.EmptyEnv()
+	ev.Define("BAR", []string{"cli"})
+	d, err := maestro.NewDecoderWithEnv(strings.NewReader(assignments), ev)
+	if err != nil {
+		t.Fatalf("fail to create decoder: %s", err)
+	}
+	mst, err := d.Decode()
+	if err != nil {
+		t.Fatalf("fail to decode assignments: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("action")
+	if err != nil {
+		t.Fatalf("action command not decoded: %s", err)
+	}
+	if want := "foo cli cli-now out lazy"; cmd.Short != want {
+		t.Fatalf("variables mismatched! want %q, got %q", want, cmd.Short)
+	}
+}
+
+const deferred = `
+build: {
+	mkdir -p tmp
+	defer @rm -rf tmp
+	deferred
+	go build
+}
+`
+
+func testDecodeDefer(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(deferred))
+	if err != nil {
+		t.Fatalf("fail to decode defer: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("build")
+	if err != nil {
+		t.Fatalf("build command not decoded: %s", err)
+	}
+	if len(cmd.Lines) != 3 || cmd.Lines[1] != "deferred" {
+		t.Fatalf("script mismatched! got %v", cmd.Lines)
+	}
+	if len(cmd.Finally) != 1 || cmd.Finally[0] != "rm -rf tmp" || !cmd.FinallyModifiers[0].Silent {
+		t.Fatalf("deferred lines mismatched! got %v", cmd.Finally)
+	}
+}
+
+const presets = `
+preset deploy-prod = deploy --env prod eu
+preset (
+	deploy-dev = deploy -e dev
+)
+
+deploy(
+	options = (
+		short = e,
+		long = env,
+	)
+): {
+	echo $env $@
+}
+`
+
+func testDecodePreset(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(presets))
+	if err != nil {
+		t.Fatalf("fail to decode presets: %s", err)
+	}
+	if len(mst.Presets) != 2 {
+		t.Fatalf("presets mismatched! want 2, got %d", len(mst.Presets))
+	}
+	p := mst.Presets["deploy-prod"]
+	if p.Command != "deploy" || strings.Join(p.Args, " ") != "--env prod eu" {
+		t.Fatalf("preset mismatched! got %s", p)
+	}
+}
+
+func testDecodeUnterminated(t *testing.T) {
+	data := []struct {
+		Input  string
+		Reason string
+		Line   int
+	}{
+		{
+			Input:  "var = \"foobar\n",
+			Reason: "unterminated string",
+			Line:   1,
+		},
+		{
+			Input:  "var = foo\nother = 'foobar\n",
+			Reason: "unterminated string",
+			Line:   2,
+		},
+		{
+			Input:  "var = ${foobar\n",
+			Reason: "unterminated variable",
+			Line:   1,
+		},
+		{
+			Input:  "var = %(os\n",
+			Reason: "unterminated variable",
+			Line:   1,
+		},
+		{
+			Input:  "var = foo\ncmd: {\n\techo $var\n",
+			Reason: "unterminated script",
+			Line:   2,
+		},
+		{
+			Input:  "var = <<EOF\nfoobar\n",
+			Reason: "unterminated heredoc",
+			Line:   1,
+		},
+	}
+	for _, d := range data {
+		_, err := maestro.Decode(strings.NewReader(d.Input))
+		u, ok := err.(maestro.UnexpectedError)
+		if !ok {
+			t.Errorf("%q: expected unexpected error, got %v", d.Input, err)
+			continue
+		}
+		if u.Reason != d.Reason || u.Invalid.Line != d.Line {
+			t.Errorf("%q: want %s at line %d, got %s at line %d", d.Input, d.Reason, d.Line, u.Reason, u.Invalid.Line)
+		}
+	}
+}
+
+const multibytes = "\xef\xbb\xbf" + `
+café = brûlée
+# commentaire: été, 日本語
+bâtir(short = "construit $café ü"): {
+	echo "日本語 ñ" # straße
+}
+`
+
+func testDecodeUnicode(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(multibytes))
+	if err != nil {
+		t.Fatalf("fail to decode unicode: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("bâtir")
+	if err != nil {
+		t.Fatalf("bâtir command not decoded: %s", err)
+	}
+	if want := "construit brûlée ü"; cmd.Short != want {
+		t.Fatalf("short mismatched! want %q, got %q", want, cmd.Short)
+	}
+	if len(cmd.Lines) != 1 || cmd.Lines[0] != `echo "日本語 ñ" # straße` {
+		t.Fatalf("script mismatched! got %v", cmd.Lines)
+	}
+
+	maestro.UnicodeIdent = false
+	defer func() {
+		maestro.UnicodeIdent = true
+	}()
+	if _, err := maestro.Decode(strings.NewReader(multibytes)); err == nil {
+		t.Fatalf("unicode identifiers decoded with UnicodeIdent disabled")
+	}
+
+	_, err = maestro.Decode(strings.NewReader("var = foo\nother = \xff\n"))
+	if u, ok := err.(maestro.UnexpectedError); !ok || u.Invalid.Line != 2 {
+		t.Fatalf("invalid utf-8 not reported at line 2: %v", err)
+	}
+}
+
+const crlf = `
+name = "foo bar" # comment
+doc = <<DOC
+line 1
+line 2
+DOC
+main(
+	short = "$name",
+	help = <<HELP
+first line
+second line
+HELP
+): {
+	# a comment
+	echo "$name" \
+	  end
+	echo 'single quoted'
+}
+`
+
+func testDecodeCRLF(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample.mf")
+	if err != nil {
+		t.Fatalf("fail to read sample file: %s", err)
+	}
+	for _, str := range []string{crlf, multiline, deferred, string(sample)} {
+		want, err := maestro.Decode(strings.NewReader(str))
+		if err != nil {
+			t.Fatalf("fail to decode file: %s", err)
+		}
+		str = strings.ReplaceAll(str, "\n", "\r\n")
+		got, err := maestro.Decode(strings.NewReader(str))
+		if err != nil {
+			t.Fatalf("fail to decode file with CRLF: %s", err)
+		}
+		if len(got.Commands) != len(want.Commands) {
+			t.Fatalf("commands mismatched! want %d, got %d", len(want.Commands), len(got.Commands))
+		}
+		for n, w := range want.Commands {
+			g, err := got.Commands.Lookup(n)
+			if err != nil {
+				t.Fatalf("%s: command not decoded with CRLF", n)
+			}
+			x := fmt.Sprint(g.Short, g.Desc, g.Lines, g.Finally)
+			if strings.Contains(x, "\r") {
+				t.Errorf("%s: carriage return found in %q", n, x)
+			}
+			if y := fmt.Sprint(w.Short, w.Desc, w.Lines, w.Finally); x != y {
+				t.Errorf("%s: command mismatched! want %q, got %q", n, y, x)
+			}
+		}
+	}
+}
+
+const prints = `
+env  = prod
+echo = foo
+alias print = echo
+
+print "deploying to $env"
+echo
+if $env == prod {
+	print "careful:" ${env}  environment # comment
+} else {
+	print not shown
+}
+echo(short = "$echo"): {
+	echo from script
+}
+print: {
+	print from script
+}
+`
+
+func testDecodePrint(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(prints))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	want := []string{"deploying to prod", "", "careful: prod environment"}
+	if fmt.Sprint(mst.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages mismatched! want %q, got %q", want, mst.Messages)
+	}
+	for _, n := range []string{"echo", "print"} {
+		if _, err := mst.Commands.Lookup(n); err != nil {
+			t.Errorf("%s: command not decoded", n)
+		}
+	}
+	cmd, _ := mst.Commands.Lookup("echo")
+	if cmd.Short != "foo" {
+		t.Errorf("variable echo not decoded! got %q", cmd.Short)
+	}
+}
+
+const hosts = `
+.SSH_HOSTS = (
+	web1 = "10.0.0.1:2222",
+	web2 = deploy@10.0.0.2,
+)
+
+deploy(hosts = web1 "root@web2:2200" db.example.org): {
+	true
+}
+
+backup(
+	hosts = (db1 = "[::1]", db2 = "admin@db2.example.org:2022"),
+): {
+	true
+}
+
+restore(hosts = db2 "web1:22"): {
+	true
+}
+`
+
+func testDecodeHosts(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(hosts))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	tests := []struct {
+		Command string
+		Hosts   []string
+	}{
+		{
+			Command: "deploy",
+			Hosts:   []string{"10.0.0.1:2222", "db.example.org:22", "root@10.0.0.2:2200"},
+		},
+		{
+			Command: "backup",
+			Hosts:   []string{"[::1]:22", "admin@db2.example.org:2022"},
+		},
+		{
+			Command: "restore",
+			Hosts:   []string{"10.0.0.1:22", "admin@db2.example.org:2022"},
+		},
+	}
+	for _, tt := range tests {
+		cmd, err := mst.Commands.Lookup(tt.Command)
+		if err != nil {
+			t.Fatalf("%s: command not decoded", tt.Command)
+		}
+		var got []string
+		for _, h := range cmd.Hosts {
+			got = append(got, h.String())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.Hosts) {
+			t.Errorf("%s: hosts mismatched! want %q, got %q", tt.Command, tt.Hosts, got)
+		}
+	}
+	_, err = maestro.Decode(strings.NewReader(".SSH_HOSTS = (web = \"host:ssh\")\n"))
+	if err == nil || !strings.Contains(err.Error(), "host:ssh") {
+		t.Errorf("invalid port not reported with its host: %v", err)
+	}
+}
+
+const optionGroups = `
+options aws = (
+	short = r,
+	long = region,
+	default = eu-west-1,
+), (long = profile, help = "aws profile")
+
+deploy(
+	options = aws, (short = f, long = force, flag = true),
+	short = "deploy the stack",
+): {
+	true
+}
+
+destroy(options = (long = yes, flag = true), aws): {
+	true
+}
+`
+
+func testDecodeOptionGroups(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(optionGroups))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	tests := []struct {
+		Command string
+		Options []string
+	}{
+		{
+			Command: "deploy",
+			Options: []string{"aws:-r/--region", "aws:--profile", ":-f/--force"},
+		},
+		{
+			Command: "destroy",
+			Options: []string{":--yes", "aws:-r/--region", "aws:--profile"},
+		},
+	}
+	for _, tt := range tests {
+		cmd, err := mst.Commands.Lookup(tt.Command)
+		if err != nil {
+			t.Fatalf("%s: command not decoded", tt.Command)
+		}
+		var got []string
+		for _, o := range cmd.Options {
+			got = append(got, o.Group+":"+o.Name())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.Options) {
+			t.Errorf("%s: options mismatched! want %q, got %q", tt.Command, tt.Options, got)
+		}
+	}
+	cmd, _ := mst.Commands.Lookup("deploy")
+	if cmd.Short != "deploy the stack" {
+		t.Errorf("property after options not decoded! got %q", cmd.Short)
+	}
+	help, _ := cmd.Help()
+	if !strings.Contains(help, "Options:\n\n  -f, --force") || !strings.Contains(help, "aws options:\n\n  -r, --region") {
+		t.Errorf("option groups not shown in help: %s", help)
+	}
+
+	invalid := []string{
+		optionGroups + "clash(options = aws, (long = region)): {\n\ttrue\n}\n",
+		"options dup = (short = r), (short = r)\n",
+		"build(options = unknown): {\n\ttrue\n}\n",
+	}
+	for _, str := range invalid {
+		if _, err := maestro.Decode(strings.NewReader(str)); err == nil {
+			t.Errorf("decoding should have failed for %q", str)
+		}
+	}
+}
+
+const usages = `
+service(
+	usage = "start|stop <service>" "service status",
+	options = (short = v, long = verbose, flag = true),
+): {
+	true
+}
+`
+
+func testDecodeUsage(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(usages))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("service")
+	if err != nil {
+		t.Fatalf("service: command not decoded")
+	}
+	want := "service start|stop <service>\nservice status"
+	if got := cmd.Usage(); got != want {
+		t.Errorf("usage mismatched! want %q, got %q", want, got)
+	}
+	help, _ := cmd.Help()
+	if !strings.Contains(help, "usage: service start|stop <service>\n       service status") || !strings.Contains(help, "-v, --verbose") {
+		t.Errorf("usage not shown in help: %s", help)
+	}
+}
+
+func testDecodePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions of the files not supported")
+	}
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "maestro.mf")
+		inc  = filepath.Join(dir, "common.mf")
+	)
+	if err := os.WriteFile(file, []byte("include \"common.mf\"\n"), 0o644); err != nil {
+		t.Fatalf("fail to write file: %s", err)
+	}
+	if err := os.WriteFile(inc, []byte("build: {\n\ttrue\n}\n"), 0o644); err != nil {
+		t.Fatalf("fail to write file: %s", err)
+	}
+	tests := []struct {
+		File  string
+		Mode  os.FileMode
+		Check string
+		Fail  bool
+	}{
+		{File: file, Mode: 0o644, Check: maestro.PermRefuse, Fail: false},
+		{File: file, Mode: 0o666, Check: maestro.PermRefuse, Fail: true},
+		{File: file, Mode: 0o664, Check: maestro.PermRefuse, Fail: true},
+		{File: inc, Mode: 0o666, Check: maestro.PermRefuse, Fail: true},
+		{File: file, Mode: 0o666, Check: "", Fail: false},
+	}
+	for _, tt := range tests {
+		os.Chmod(file, 0o644)
+		os.Chmod(inc, 0o644)
+		if err := os.Chmod(tt.File, tt.Mode); err != nil {
+			t.Fatalf("fail to change mode: %s", err)
+		}
+		mst := maestro.New()
+		mst.CheckPerms = tt.Check
+		err := mst.Load(file)
+		if tt.Fail && err == nil {
+			t.Errorf("%s (%s): file should have been refused", filepath.Base(tt.File), tt.Mode)
+		}
+		if !tt.Fail && err != nil {
+			t.Errorf("%s (%s): unexpected error: %s", filepath.Base(tt.File), tt.Mode, err)
+		}
+	}
+}
+
+func testDecodeInclude(t *testing.T) {
+	tests := []struct {
+		File string
+		Want []string
+	}{
+		{File: "testdata/include/maestro.mf", Want: []string{"build", "fmt", "lint", "test"}},
+		{File: "testdata/include/self.mf", Want: []string{"build", "fmt", "lint", "test"}},
+		{File: "testdata/include/lib/fmt.mf", Want: []string{"fmt"}},
+	}
+	for _, tt := range tests {
+		mst := maestro.New()
+		if err := mst.Load(tt.File); err != nil {
+			t.Errorf("%s: fail to load file: %s", tt.File, err)
+			continue
+		}
+		var got []string
+		for n := range mst.Commands {
+			got = append(got, n)
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(tt.Want, " ") {
+			t.Errorf("%s: commands mismatched: want %q, got %q", tt.File, tt.Want, got)
+		}
+	}
+	for _, str := range []string{"include \"missing/*.mf\"\n", "include \"testdata/fuzz\"\n"} {
+		if _, err := maestro.Decode(strings.NewReader(str)); err == nil {
+			t.Errorf("including should have failed for %q", str)
+		}
+	}
+	if _, err := maestro.Decode(strings.NewReader("include \"missing/*.mf\"?\n")); err != nil {
+		t.Errorf("optional include should not fail: %s", err)
+	}
+}
+
+// generateFile gives a maestro file with count commands. Each command has
+// properties, options, dependencies and a script.
+func generateFile(count int) string {
+	var str strings.Builder
+	str.WriteString(".VERSION = 1.0.0\n")
+	str.WriteString("prefix = mst\n")
+	str.WriteString("tags = build test release\n\n")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&str, "cmd-%d(\n", i)
+		fmt.Fprintf(&str, "\tshort = \"command %d of the file\",\n", i)
+		str.WriteString("\ttag = $tags,\n")
+		str.WriteString("\toptions = (\n\t\tshort = o,\n\t\tlong = output,\n\t\tdefault = ${prefix}-out,\n\t),\n")
+		str.WriteString("\tretry = 3,\n")
+		str.WriteString(")")
+		if i > 0 {
+			fmt.Fprintf(&str, ": cmd-%d", i-1)
+		}
+		str.WriteString(" {\n")
+		fmt.Fprintf(&str, "\techo \"running %d\" $output\n", i)
+		str.WriteString("\tgo build -o $output ./...\n")
+		str.WriteString("}\n\n")
+	}
+	return str.String()
+}
+
+func BenchmarkScan(b *testing.B) {
+	input := generateFile(1000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s, err := maestro.Scan(strings.NewReader(input))
+		if err != nil {
+			b.Fatalf("fail to create scanner: %s", err)
+		}
+		for tok := s.Scan(); tok.Type != maestro.Eof; tok = s.Scan() {
+			if tok.Type == maestro.Invalid {
+				b.Fatalf("invalid token at %s", tok.Position)
+			}
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	input := generateFile(1000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := maestro.Decode(strings.NewReader(input)); err != nil {
+			b.Fatalf("fail to decode file: %s", err)
+		}
+	}
+}
+
+func BenchmarkDecodeIncludes(b *testing.B) {
+	var (
+		dir   = b.TempDir()
+		depth = 20
+		file  string
+	)
+	for i := depth; i >= 0; i-- {
+		content := generateFile(50)
+		if file != "" {
+			content = fmt.Sprintf("include %s\n\n%s", file, content)
+		}
+		content = strings.ReplaceAll(content, "cmd-", fmt.Sprintf("cmd%d-", i))
+		file = filepath.Join(dir, fmt.Sprintf("file%d.mf", i))
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			b.Fatalf("fail to write %s: %s", file, err)
+		}
+	}
+	input := fmt.Sprintf("include %s\n", file)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := maestro.Decode(strings.NewReader(input)); err != nil {
+			b.Fatalf("fail to decode includes: %s", err)
+		}
+	}
+}
+
+func BenchmarkDecodeValue(b *testing.B) {
+	var str strings.Builder
+	str.WriteString("var = ")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&str, "part%d-${sep}", i)
+	}
+	input := "sep = _\n" + str.String() + "\n"
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := maestro.Decode(strings.NewReader(input)); err != nil {
+			b.Fatalf("fail to decode value: %s", err)
+		}
+	}
+}
+
+// fuzzSeeds gives the inputs used as the corpus of the fuzz targets.
+func fuzzSeeds(f *testing.F) {
+	b, err := os.ReadFile("testdata/sample.mf")
+	if err != nil {
+		f.Fatalf("fail to read sample file: %s", err)
+	}
+	f.Add(string(b))
+	f.Add(multiline)
+	f.Add(generateFile(3))
+	f.Add("var = \"unterminated")
+	f.Add("cmd: {\n\techo 'unterminated\n")
+	f.Add("var = ${unterminated")
+	f.Add("cmd: {\n\tcat <<EOF\nfoo\n")
+	f.Add("if %(os == linux {\n")
+}
+
+// unsafeInput tells whether decoding input could execute a command (command
+// substitution and shell assignment).
+func unsafeInput(input string) bool {
+	return strings.Contains(input, "`") || strings.Contains(input, "!=")
+}
+
+func FuzzScan(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		s, err := maestro.Scan(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("fail to create scanner: %s", err)
+		}
+		// each token consumes at least one character except the last one
+		for i := 0; i <= len(input)+1; i++ {
+			switch tok := s.Scan(); tok.Type {
+			case maestro.Eof, maestro.Invalid:
+				return
+			default:
+			}
+		}
+		t.Fatalf("scanner does not reach the end of input")
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		if unsafeInput(input) {
+			t.Skip("input executes commands")
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			maestro.Decode(strings.NewReader(input))
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("decoder does not terminate")
+		}
+	})
+}
+
+const dependencies = `
+build: ?lint, &serve(8080), test[timeout=2m, retry=3], *check(-v)[retry = 2] &, fetch(api)->go-transform -> load[retry=2]& {
+	true
+}
+`
+
+func testDecodeDependencies(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(dependencies))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("build")
+	if err != nil {
+		t.Fatalf("build: command not decoded")
+	}
+	want := []maestro.CommandDep{
+		{Name: "lint", Optional: true},
+		{Name: "serve", Args: []string{"8080"}, Bg: true},
+		{Name: "test", Timeout: 2 * time.Minute, Retry: 3},
+		{Name: "check", Args: []string{"-v"}, Mandatory: true, Bg: true, Retry: 2},
+		{
+			Name: "fetch",
+			Args: []string{"api"},
+			Bg:   true,
+			Pipe: []maestro.CommandDep{
+				{Name: "go-transform"},
+				{Name: "load", Retry: 2},
+			},
+		},
+	}
+	if len(cmd.Deps) != len(want) {
+		t.Fatalf("dependencies mismatched! want %d, got %d", len(want), len(cmd.Deps))
+	}
+	for i, w := range want {
+		got := cmd.Deps[i]
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", w) {
+			t.Errorf("dependency mismatched! want %+v, got %+v", w, got)
+		}
+	}
+
+	invalid := []string{
+		"build: test[timeout=2m {\n\ttrue\n}\n",
+		"build: test[delay=1s] {\n\ttrue\n}\n",
+		"build: test[retry] {\n\ttrue\n}\n",
+		"build: fetch -> {\n\ttrue\n}\n",
+	}
+	for _, str := range invalid {
+		if _, err := maestro.Decode(strings.NewReader(str)); err == nil {
+			t.Errorf("decoding should have failed for %q", str)
+		}
+	}
+}
+
+const captures = `
+build: {
+	let tag = run git describe --tags
+	-let  commit=run   git rev-parse HEAD
+	let x=1
+	echo $tag
+}
+`
+
+func testDecodeCapture(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(captures))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	cmd, err := mst.Commands.Lookup("build")
+	if err != nil {
+		t.Fatalf("build: command not decoded")
+	}
+	tests := []struct {
+		Line    string
+		Capture string
+		Ignore  bool
+	}{
+		{Line: "git describe --tags", Capture: "tag"},
+		{Line: "git rev-parse HEAD", Capture: "commit", Ignore: true},
+		{Line: "let x=1"},
+		{Line: "echo $tag"},
+	}
+	if len(cmd.Lines) != len(tests) {
+		t.Fatalf("lines mismatched! want %d, got %d", len(tests), len(cmd.Lines))
+	}
+	for i, tt := range tests {
+		mod := cmd.Modifiers[i]
+		if cmd.Lines[i] != tt.Line || mod.Capture != tt.Capture || mod.Ignore != tt.Ignore {
+			t.Errorf("line %d mismatched! want %+v, got %q (%+v)", i+1, tt, cmd.Lines[i], mod)
+		}
+	}
+}
+
+const builtins = `
+.NO_BUILTINS = version
+
+version: {
+	echo 1.0.0
+}
+
+all: {
+	true
+}
+`
+
+func testDecodeBuiltins(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(builtins))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	tests := []struct {
+		Name     string
+		Builtin  bool
+		Shadowed bool
+	}{
+		{Name: "version", Builtin: false, Shadowed: false},
+		{Name: "all", Builtin: true, Shadowed: true},
+		{Name: "help", Builtin: true, Shadowed: false},
+		{Name: "build", Builtin: false, Shadowed: false},
+	}
+	for _, tt := range tests {
+		if got := mst.Builtin(tt.Name); got != tt.Builtin {
+			t.Errorf("%s: builtin mismatched! want %t, got %t", tt.Name, tt.Builtin, got)
+		}
+		if got := mst.Shadowed(tt.Name); got != tt.Shadowed {
+			t.Errorf("%s: shadowed mismatched! want %t, got %t", tt.Name, tt.Shadowed, got)
+		}
+	}
+
+	invalid := []string{
+		".NO_BUILTINS = build\n",
+		".NO_BUILTINS = run\n",
+		".NO_BUILTINS = import\n",
+	}
+	for _, str := range invalid {
+		if _, err := maestro.Decode(strings.NewReader(str)); err == nil {
+			t.Errorf("decoding should have failed for %q", str)
+		}
+	}
+}
+
+const envFiles = `
+.ENVFILE = ".env.default"
+
+build(
+	envfile = ".env" ".env.local",
+	envfile = ci.env,
+): {
+	true
+}
+
+test(
+	write_env = "test.env" VAR,
+): {
+	true
+}
+`
+
+func testDecodeEnvFile(t *testing.T) {
+	mst, err := maestro.Decode(strings.NewReader(envFiles))
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	tests := []struct {
+		Command  string
+		DotEnv   []string
+		Generate []string
+	}{
+		{Command: "build", DotEnv: []string{".env.default", ".env", ".env.local", "ci.env"}},
+		{Command: "test", DotEnv: []string{".env.default"}, Generate: []string{"test.env"}},
+	}
+	for _, tt := range tests {
+		cmd, err := mst.Commands.Lookup(tt.Command)
+		if err != nil {
+			t.Fatalf("%s: command not decoded", tt.Command)
+		}
+		if fmt.Sprint(cmd.DotEnv) != fmt.Sprint(tt.DotEnv) {
+			t.Errorf("%s: dotenv files mismatched! want %q, got %q", tt.Command, tt.DotEnv, cmd.DotEnv)
+		}
+		var got []string
+		for _, g := range cmd.Generate {
+			got = append(got, g.File)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.Generate) {
+			t.Errorf("%s: generated files mismatched! want %q, got %q", tt.Command, tt.Generate, got)
+		}
+	}
+	if _, err := maestro.Decode(strings.NewReader("test(write_env = test.env): {\n\ttrue\n}\n")); err == nil {
+		t.Errorf("write_env without names should have failed")
+	}
+}
@@ -0,0 +1,116 @@
+package maestro
+
+import (
+	"flag"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/midbel/maestro/help"
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+// Doc writes the documentation of the maestro file or of the given commands
+// in the format selected with --format (text, markdown, json).
+func (m *Maestro) Doc(args []string) error {
+	var (
+		set    = flag.NewFlagSet(CmdDoc, flag.ExitOnError)
+		format = set.String("format", help.FormatMarkdown, "format of the documentation")
+		file   = set.String("o", "", "write documentation to file")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	r, err := help.Lookup(*format)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = stdio.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if set.NArg() == 0 {
+		return r.File(w, m.HelpFile())
+	}
+	doc := m.HelpFile()
+	doc.Commands = doc.Commands[:0]
+	for _, name := range set.Args() {
+		cmd, err := m.Commands.Lookup(name)
+		if err != nil {
+			return m.suggest(err, name)
+		}
+		doc.Commands = append(doc.Commands, cmd.HelpCommand())
+	}
+	if len(doc.Commands) == 1 {
+		return r.Command(w, doc.Commands[0])
+	}
+	return r.File(w, doc)
+}
+
+// HelpFile gives the documentation of the visible commands of the maestro
+// file to be used with a help.Renderer.
+func (m *Maestro) HelpFile() help.File {
+	doc := help.File{
+		File:     m.Name(),
+		Help:     m.Help,
+		Usage:    m.Usage,
+		Version:  m.Version,
+		Presets:  m.helpPresets(),
+		Commands: []help.Command{},
+	}
+	for _, c := range m.Commands {
+		if c.Blocked() {
+			continue
+		}
+		doc.Commands = append(doc.Commands, c.HelpCommand())
+	}
+	sort.Slice(doc.Commands, func(i, j int) bool {
+		return doc.Commands[i].Name < doc.Commands[j].Name
+	})
+	return doc
+}
+
+// HelpCommand gives the documentation of the command to be used with a
+// help.Renderer.
+func (s CommandSettings) HelpCommand() help.Command {
+	doc := help.Command{
+		Name:     s.Name,
+		Short:    s.Short,
+		Icon:     s.Icon,
+		Desc:     s.Desc,
+		Usage:    s.Usage(),
+		Options:  []help.Option{},
+		Args:     []string{},
+		Examples: s.Examples,
+		Alias:    append([]string{}, s.Alias...),
+		Tags:     s.Tags(),
+		Hosts:    []string{},
+	}
+	for _, o := range s.Options {
+		opt := help.Option{
+			Short:    o.Short,
+			Long:     o.Long,
+			Help:     o.Help,
+			Default:  o.Default,
+			Required: o.Required,
+			Flag:     o.Flag,
+			Group:    o.Group,
+		}
+		if o.Flag && o.DefaultFlag {
+			opt.Default = "true"
+		}
+		doc.Options = append(doc.Options, opt)
+	}
+	for _, a := range s.Args {
+		doc.Args = append(doc.Args, a.Name)
+	}
+	for _, h := range s.Hosts {
+		doc.Hosts = append(doc.Hosts, h.String())
+	}
+	return doc
+}
@@ -0,0 +1,86 @@
+package maestro
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/midbel/maestro/internal/copyslice"
+)
+
+// loadDotEnv adds to ev the variables of the dotenv files. The files are read
+// in order so that a variable of a file overrides the same variable defined
+// by the maestro file or by the previous files. Missing files are ignored.
+func loadDotEnv(ev map[string]string, files []string) (map[string]string, error) {
+	ev = copyslice.CopyMap[string, string](ev)
+	for _, f := range files {
+		if err := readDotEnv(f, ev); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+	}
+	return ev, nil
+}
+
+// readDotEnv reads the KEY=VALUE pairs of a dotenv file. Lines can start with
+// export. Values can be quoted: variables ($NAME or ${NAME}) and escape
+// sequences are only expanded in double quoted and unquoted values.
+func readDotEnv(file string, ev map[string]string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	lookup := func(key string) string {
+		if v, ok := ev[key]; ok {
+			return v
+		}
+		return os.Getenv(key)
+	}
+	scan := bufio.NewScanner(r)
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return fmt.Errorf("%s:%d: KEY=VALUE expected", file, n)
+		}
+		value, err := dotEnvValue(strings.TrimSpace(value), lookup)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		ev[key] = value
+	}
+	return scan.Err()
+}
+
+func dotEnvValue(str string, lookup func(string) string) (string, error) {
+	if str == "" {
+		return str, nil
+	}
+	switch q := str[0]; q {
+	case squote, dquote:
+		end := strings.LastIndexByte(str, q)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		str = str[1:end]
+		if q == squote {
+			return str, nil
+		}
+		str = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(str)
+	default:
+		if x := strings.Index(str, " #"); x >= 0 {
+			str = strings.TrimSpace(str[:x])
+		}
+	}
+	return os.Expand(str, lookup), nil
+}
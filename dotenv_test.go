@@ -0,0 +1,38 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		base  = filepath.Join(dir, ".env")
+		local = filepath.Join(dir, ".env.local")
+	)
+	writeTestFile(t, base, "NAME=base\nMODE=debug\n")
+	writeTestFile(t, local, "export MODE=release\n")
+
+	file := fmt.Sprintf(`
+show(
+	envfile = %q %q %q,
+): {
+	echo $NAME $MODE
+}
+`, base, filepath.Join(dir, "missing.env"), local)
+	mst := decodeFile(t, file)
+
+	var buf strings.Builder
+	ex := resolveCommand(t, mst, "show", ctreeOption{})
+	if err := ex.Execute(context.Background(), &buf, io.Discard); err != nil {
+		t.Fatalf("show should have succeeded: %s", err)
+	}
+	if want := "base release\n"; buf.String() != want {
+		t.Errorf("variables mismatched: want %q, got %q", want, buf.String())
+	}
+}
@@ -0,0 +1,144 @@
+package maestro
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+const driftDir = "drift"
+
+// snapshot is the environment of the last run of a command. The values of
+// the environment variables of the command are not recorded: only their
+// digests are so that the secrets they can contain are not written.
+type snapshot struct {
+	When   time.Time         `json:"when"`
+	Tools  map[string]string `json:"tools"`
+	System map[string]string `json:"system"`
+	Env    map[string]string `json:"env"`
+}
+
+func takeSnapshot(cmd CommandSettings, when time.Time) snapshot {
+	snap := snapshot{
+		When:   when,
+		Tools:  make(map[string]string),
+		System: make(map[string]string),
+		Env:    make(map[string]string),
+	}
+	for _, r := range cmd.Requires {
+		snap.Tools[r] = toolVersion(r)
+	}
+	for k, v := range cmd.Ev {
+		sum := sha256.Sum256([]byte(v))
+		snap.Env[k] = hex.EncodeToString(sum[:])
+	}
+	snap.System["PATH"] = os.Getenv("PATH")
+	snap.System["GOOS"] = runtime.GOOS
+	snap.System["GOARCH"] = runtime.GOARCH
+	return snap
+}
+
+func (s snapshot) Diff(other snapshot) []string {
+	var (
+		list []string
+		diff = func(kind string, old, curr map[string]string, values bool) {
+			for k, v := range curr {
+				o, ok := old[k]
+				switch {
+				case !ok && values:
+					list = append(list, fmt.Sprintf("%s %s: added (%s)", kind, k, v))
+				case !ok:
+					list = append(list, fmt.Sprintf("%s %s: added", kind, k))
+				case o != v && values:
+					list = append(list, fmt.Sprintf("%s %s: %s -> %s", kind, k, o, v))
+				case o != v:
+					list = append(list, fmt.Sprintf("%s %s: changed", kind, k))
+				}
+			}
+			for k := range old {
+				if _, ok := curr[k]; !ok {
+					list = append(list, fmt.Sprintf("%s %s: removed", kind, k))
+				}
+			}
+		}
+	)
+	diff("tool", other.Tools, s.Tools, true)
+	diff("system", other.System, s.System, true)
+	diff("env", other.Env, s.Env, false)
+	sort.Strings(list)
+	return list
+}
+
+func checkDrift(dir string, cmd CommandSettings, when time.Time, w io.Writer) error {
+	var (
+		file = filepath.Join(dir, driftDir, cmd.Name+".json")
+		curr = takeSnapshot(cmd, when)
+	)
+	prev, err := readSnapshot(file)
+	if err == nil {
+		for _, d := range curr.Diff(prev) {
+			fmt.Fprintf(w, "drift: %s: %s (last run: %s)", cmd.Name, d, prev.When.Format(time.RFC3339))
+			fmt.Fprintln(w)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return writeSnapshot(file, curr)
+}
+
+func readSnapshot(file string) (snapshot, error) {
+	var snap snapshot
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return snap, err
+	}
+	return snap, json.Unmarshal(buf, &snap)
+}
+
+func writeSnapshot(file string, snap snapshot) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf, 0644)
+}
+
+func toolVersion(name string) string {
+	for _, arg := range []string{"--version", "version"} {
+		var out bytes.Buffer
+		cmd := exec.Command(name, arg)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			continue
+		}
+		scan := bufio.NewScanner(&out)
+		if scan.Scan() {
+			return scan.Text()
+		}
+	}
+	return "unknown"
+}
+
+func checkRequires(cmd CommandSettings) error {
+	for _, r := range cmd.Requires {
+		if _, err := exec.LookPath(r); err != nil {
+			return fmt.Errorf("%s: required program %s not found", cmd.Name, r)
+		}
+	}
+	return nil
+}
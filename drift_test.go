@@ -0,0 +1,43 @@
+package maestro
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrift(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		cmd = CommandSettings{
+			Name: "deploy",
+			Ev:   map[string]string{"TOKEN": "abc123", "STAGE": "prod"},
+		}
+		buf  strings.Builder
+		when = time.Date(2022, 3, 8, 14, 5, 9, 0, time.UTC)
+	)
+	if err := checkDrift(dir, cmd, when, &buf); err != nil {
+		t.Fatalf("fail to check drift: %s", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("unexpected drift on first run: %s", buf.String())
+	}
+	cmd.Ev = map[string]string{"TOKEN": "def456", "REGION": "eu"}
+	if err := checkDrift(dir, cmd, when.Add(time.Hour), &buf); err != nil {
+		t.Fatalf("fail to check drift: %s", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"env TOKEN: changed", "env REGION: added", "env STAGE: removed", "last run: 2022-03-08T14:05:09Z"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q not reported: %s", want, out)
+		}
+	}
+	file, _ := os.ReadFile(filepath.Join(dir, driftDir, "deploy.json"))
+	for _, v := range []string{"abc123", "def456", "eu"} {
+		if strings.Contains(out, v) || strings.Contains(string(file), `"`+v+`"`) {
+			t.Errorf("value %s of the environment written", v)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// shellBuiltins are the builtins of the shell: they are executed by the shell
+// itself before looking for a command or a program with the same name.
+var shellBuiltins = map[string]struct{}{
+	"alias":    {},
+	"builtin":  {},
+	"builtins": {},
+	"cd":       {},
+	"command":  {},
+	"dirs":     {},
+	"echo":     {},
+	"enable":   {},
+	"env":      {},
+	"exit":     {},
+	"export":   {},
+	"false":    {},
+	"help":     {},
+	"popd":     {},
+	"pushd":    {},
+	"pwd":      {},
+	"readonly": {},
+	"seq":      {},
+	"set":      {},
+	"true":     {},
+	"type":     {},
+	"unalias":  {},
+	"wait":     {},
+}
+
+// dryLine is a command of a line of a script as it would be executed: its
+// words are expanded (variables, options and environment) and the interpreter
+// is what executes it (a builtin of the shell, a command of the maestro file
+// or the path of a program).
+type dryLine struct {
+	Line        string
+	Interpreter string
+	Executed    bool
+	Deferred    bool
+	Capture     string
+}
+
+func (d dryLine) String() string {
+	var (
+		str  strings.Builder
+		info = []string{d.Interpreter}
+	)
+	if d.Executed {
+		info = append(info, "executed")
+	}
+	if d.Deferred {
+		info = append(info, "deferred")
+	}
+	if d.Capture != "" {
+		info = append(info, fmt.Sprintf("captured in %s", d.Capture))
+	}
+	str.WriteString(d.Line)
+	str.WriteString("  # ")
+	str.WriteString(strings.Join(info, ", "))
+	return str.String()
+}
+
+// dryLines expands a line of the script into the commands it executes.
+func (c *command) dryLines(line string, args []string) ([]dryLine, error) {
+	var buf bytes.Buffer
+	c.shell.SetOut(&buf)
+	defer c.shell.SetOut(c.output())
+
+	if err := c.shell.Dry(line, c.name, args); err != nil {
+		return nil, err
+	}
+	var list []dryLine
+	for _, str := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		words := strings.Fields(str)
+		if len(words) == 0 {
+			continue
+		}
+		list = append(list, dryLine{
+			Line:        str,
+			Interpreter: c.interpreter(words[0]),
+		})
+	}
+	return list, nil
+}
+
+// interpreter gives what executes the program of a line, looking for it in the
+// same order as the shell does.
+func (c *command) interpreter(name string) string {
+	if _, ok := shellBuiltins[name]; ok {
+		return "builtin"
+	}
+	if cmd, err := c.shell.Find(context.Background(), name); err == nil {
+		if p, ok := cmd.(*pathCommand); ok {
+			return p.Path
+		}
+		return fmt.Sprintf("command %s", cmd.Command())
+	}
+	if file, err := exec.LookPath(name); err == nil {
+		return file
+	}
+	return "not found"
+}
+
+func writeDryLines(w io.Writer, list []dryLine) {
+	for _, d := range list {
+		fmt.Fprintln(w, d.String())
+	}
+}
@@ -0,0 +1,271 @@
+package maestro
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+var varPattern = regexp.MustCompile(`\$\{?([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+// shellSpecials are the variables set by the shell itself.
+var shellSpecials = []string{"HOME", "SECONDS", "PWD", "OLDPWD", "PID", "PPID", "RANDOM", "SHELL"}
+
+// Export writes a POSIX shell script equivalent to the execution of the given
+// command and its dependencies so that it can be run without maestro.
+func (m *Maestro) Export(args []string) error {
+	var (
+		set  = flag.NewFlagSet(CmdExport, flag.ExitOnError)
+		file = set.String("o", "", "write script to file")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	name := set.Arg(0)
+	if name == "" {
+		name = m.MetaExec.Default
+	}
+	var rest []string
+	if set.NArg() > 1 {
+		rest = set.Args()[1:]
+	}
+	var w io.Writer = stdio.Stdout
+	if *file != "" {
+		f, err := os.OpenFile(*file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	ex := exporter{
+		Maestro: m,
+		Writer:  bufio.NewWriter(w),
+		seen:    make(map[string]struct{}),
+	}
+	fmt.Fprintln(ex, "#!/bin/sh")
+	fmt.Fprintf(ex, "# generated by maestro from %s", filepath.Base(m.File))
+	fmt.Fprintln(ex)
+	fmt.Fprintln(ex, "set -e")
+	if err := ex.export(name, rest, ""); err != nil {
+		return err
+	}
+	return ex.Flush()
+}
+
+type exporter struct {
+	*Maestro
+	*bufio.Writer
+
+	seen map[string]struct{}
+	bg   bool
+}
+
+func (e *exporter) export(name string, args []string, suffix string) error {
+	cmd, err := e.Commands.Lookup(name)
+	if err != nil {
+		return e.suggest(err, name)
+	}
+	if err := e.exportDeps(cmd); err != nil {
+		return err
+	}
+	if suffix == "" {
+		e.wait()
+	}
+	return e.exportCommand(cmd, args, suffix)
+}
+
+func (e *exporter) exportDeps(cmd CommandSettings) error {
+	if e.NoDeps {
+		return nil
+	}
+	for _, d := range cmd.Deps {
+		if len(d.Pipe) > 0 {
+			if err := e.exportPipe(d); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := e.seen[d.Key()]; ok && !d.Mandatory {
+			continue
+		}
+		e.seen[d.Key()] = struct{}{}
+		var suffix string
+		switch {
+		case d.Bg:
+			suffix, e.bg = " &", true
+		case d.Optional:
+			suffix = " || true"
+		}
+		if err := e.export(d.Key(), d.Args, suffix); err != nil {
+			if d.Optional {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// exportPipe writes the dependencies of the commands of a pipe then the
+// commands themselves separated by a |.
+func (e *exporter) exportPipe(dep CommandDep) error {
+	var (
+		list []CommandSettings
+		args [][]string
+	)
+	for _, d := range append([]CommandDep{dep}, dep.Pipe...) {
+		e.seen[d.Key()] = struct{}{}
+		cmd, err := e.Commands.Lookup(d.Key())
+		if err != nil {
+			if d.Optional {
+				continue
+			}
+			return e.suggest(err, d.Key())
+		}
+		if err := e.exportDeps(cmd); err != nil {
+			return err
+		}
+		list = append(list, cmd)
+		args = append(args, d.Args)
+	}
+	if !dep.Bg {
+		e.wait()
+	}
+	for i, cmd := range list {
+		suffix := " |"
+		if i == len(list)-1 {
+			suffix = ""
+			if dep.Bg {
+				suffix, e.bg = " &", true
+			}
+		}
+		if err := e.exportCommand(cmd, args[i], suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait writes a wait when commands have been started in background.
+func (e *exporter) wait() {
+	if !e.bg {
+		return
+	}
+	fmt.Fprintln(e)
+	fmt.Fprintln(e, "wait")
+	e.bg = false
+}
+
+func (e *exporter) exportCommand(cmd CommandSettings, args []string, suffix string) error {
+	ev, err := cmd.environ()
+	if err != nil {
+		return err
+	}
+	x, err := cmd.Prepare()
+	if err != nil {
+		return err
+	}
+	c, ok := x.(*command)
+	if !ok {
+		return fmt.Errorf("%s: command can not be exported", cmd.Command())
+	}
+	fmt.Fprintln(e)
+	fmt.Fprintf(e, "# %s", cmd.Command())
+	fmt.Fprintln(e)
+	fmt.Fprintln(e, "(")
+	if err := c.export(e, args, cmd.WorkDir, ev); err != nil {
+		return err
+	}
+	fmt.Fprintf(e, ")%s", suffix)
+	fmt.Fprintln(e)
+	return nil
+}
+
+// export writes the body of the command: the variables used by its script
+// are written with the values they have once the arguments are parsed.
+func (c *command) export(w io.Writer, args []string, dir string, ev map[string]string) error {
+	args, err := c.parseArgs(args)
+	if err != nil {
+		return err
+	}
+	script, err := c.expandScript()
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		fmt.Fprintf(w, "\tcd %s", shellQuote(dir))
+		fmt.Fprintln(w)
+	}
+	var keys []string
+	for k := range ev {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "\texport %s=%s", k, shellQuote(ev[k]))
+		fmt.Fprintln(w)
+	}
+	seen := make(map[string]struct{})
+	for _, line := range script {
+		for _, m := range varPattern.FindAllStringSubmatch(line, -1) {
+			if _, ok := seen[m[1]]; ok {
+				continue
+			}
+			seen[m[1]] = struct{}{}
+			if _, ok := ev[m[1]]; ok || isSpecial(m[1]) {
+				continue
+			}
+			vs, err := c.shell.Resolve(m[1])
+			if err != nil || len(vs) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\t%s=%s", m[1], shellQuote(strings.Join(vs, " ")))
+			fmt.Fprintln(w)
+		}
+	}
+	if len(args) > 0 {
+		var list []string
+		for _, a := range args {
+			list = append(list, shellQuote(a))
+		}
+		fmt.Fprintf(w, "\tset -- %s", strings.Join(list, " "))
+		fmt.Fprintln(w)
+	}
+	for i, line := range script {
+		mod := c.modifier(i)
+		if mod.Capture != "" {
+			line = fmt.Sprintf("%s=$(%s)", mod.Capture, line)
+		}
+		if mod.Ignore {
+			line = fmt.Sprintf("{ %s; } || true", line)
+		}
+		fmt.Fprintf(w, "\t%s", line)
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+func shellQuote(str string) string {
+	if str != "" && !strings.ContainsAny(str, " \t\n\"'$\\#&|;<>()*?[]{}~`!") {
+		return str
+	}
+	return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
+}
+
+func isSpecial(ident string) bool {
+	for _, s := range shellSpecials {
+		if s == ident {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07]*\x07`)
+
+// OutputReplace replaces the text matching a pattern in the output of a
+// command.
+type OutputReplace struct {
+	Pattern *regexp.Regexp
+	With    string
+}
+
+// filterCommand transforms the output of a command: escape sequences are
+// removed, patterns are replaced line by line and the value(s) of a JSON
+// document are extracted from its standard output.
+type filterCommand struct {
+	Executer
+
+	ansi    bool
+	replace []OutputReplace
+	extract string
+
+	stdout io.Writer
+	stderr io.Writer
+	out    *lineWriter
+	err    *lineWriter
+	doc    *bytes.Buffer
+}
+
+func (m *Maestro) filter(ex Executer, cmd CommandSettings) Executer {
+	return &filterCommand{
+		Executer: ex,
+		ansi:     cmd.StripAnsi,
+		replace:  cmd.Replace,
+		extract:  cmd.Extract,
+		stdout:   io.Discard,
+		stderr:   io.Discard,
+	}
+}
+
+func (c *filterCommand) SetOut(w io.Writer) {
+	c.stdout = w
+	if c.extract != "" {
+		c.doc = new(bytes.Buffer)
+		w = c.doc
+	}
+	c.out = c.lines(w)
+	c.Executer.SetOut(c.out)
+}
+
+func (c *filterCommand) SetErr(w io.Writer) {
+	c.stderr = w
+	c.err = c.lines(w)
+	c.Executer.SetErr(c.err)
+}
+
+func (c *filterCommand) Execute(ctx context.Context, args []string) error {
+	err := c.Executer.Execute(ctx, args)
+	c.flush()
+	if c.doc == nil || err != nil {
+		return err
+	}
+	defer c.doc.Reset()
+	list, err := extractJson(c.doc.Bytes(), c.extract)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Command(), err)
+	}
+	for _, str := range list {
+		fmt.Fprintln(c.stdout, str)
+	}
+	return nil
+}
+
+func (c *filterCommand) flush() {
+	if c.out != nil {
+		c.out.Flush()
+	}
+	if c.err != nil {
+		c.err.Flush()
+	}
+}
+
+func (c *filterCommand) lines(w io.Writer) *lineWriter {
+	return &lineWriter{
+		w:    w,
+		line: c.filterLine,
+	}
+}
+
+func (c *filterCommand) filterLine(str string) string {
+	if c.ansi {
+		str = ansiPattern.ReplaceAllString(str, "")
+	}
+	for _, r := range c.replace {
+		str = r.Pattern.ReplaceAllString(str, r.With)
+	}
+	return str
+}
+
+// lineWriter gives each complete line written to the line function before
+// writing it to w.
+type lineWriter struct {
+	w    io.Writer
+	buf  bytes.Buffer
+	line func(string) string
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	for {
+		x := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if x < 0 {
+			break
+		}
+		line := w.buf.Next(x + 1)
+		if _, err := io.WriteString(w.w, w.line(string(line[:x]))+"\n"); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *lineWriter) Flush() {
+	if w.buf.Len() == 0 {
+		return
+	}
+	io.WriteString(w.w, w.line(w.buf.String()))
+	w.buf.Reset()
+}
+
+// extractJson gives the values selected by a path from a JSON document. The
+// path is a sequence of fields (.name), indexes ([0]) and iterations ([]) in
+// the style of jq. Strings are given as is and the other values are encoded
+// as JSON.
+func extractJson(doc []byte, path string) ([]string, error) {
+	var value interface{}
+	if err := json.Unmarshal(doc, &value); err != nil {
+		return nil, fmt.Errorf("output is not a valid json document: %w", err)
+	}
+	values := []interface{}{value}
+	for rest := strings.TrimPrefix(path, "."); rest != ""; {
+		var (
+			step string
+			err  error
+		)
+		step, rest = nextStep(rest)
+		if values, err = selectStep(values, step); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	var list []string
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			list = append(list, str)
+			continue
+		}
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, string(buf))
+	}
+	return list, nil
+}
+
+func nextStep(path string) (string, string) {
+	if strings.HasPrefix(path, "[") {
+		x := strings.IndexByte(path, ']')
+		if x < 0 {
+			return path, ""
+		}
+		return path[:x+1], strings.TrimPrefix(path[x+1:], ".")
+	}
+	x := strings.IndexAny(path, ".[")
+	if x < 0 {
+		return path, ""
+	}
+	return path[:x], strings.TrimPrefix(path[x:], ".")
+}
+
+func selectStep(values []interface{}, step string) ([]interface{}, error) {
+	var list []interface{}
+	for _, v := range values {
+		switch {
+		case step == "[]":
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("can not iterate over %s", typeOf(v))
+			}
+			list = append(list, arr...)
+		case strings.HasPrefix(step, "["):
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("can not index %s", typeOf(v))
+			}
+			x, err := strconv.Atoi(strings.Trim(step, "[]"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid index", step)
+			}
+			if x < 0 {
+				x += len(arr)
+			}
+			if x < 0 || x >= len(arr) {
+				list = append(list, nil)
+				continue
+			}
+			list = append(list, arr[x])
+		default:
+			obj, ok := v.(map[string]interface{})
+			if !ok && v != nil {
+				return nil, fmt.Errorf("can not get %s of %s", step, typeOf(v))
+			}
+			list = append(list, obj[step])
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,31 @@
+package maestro
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes the exclusive lock of f. Without wait, it gives errLocked
+// when the lock is held by another handle. The lock is released by unlockFile
+// or when f is closed, even if the process is killed.
+func lockFile(f *os.File, wait bool) error {
+	var (
+		flags uint32 = windows.LOCKFILE_EXCLUSIVE_LOCK
+		ol    windows.Overlapped
+	)
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	var ol windows.Overlapped
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
@@ -0,0 +1,135 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	FreshTime = "mtime"
+	FreshHash = "hash"
+)
+
+func checkFresh(mode string) error {
+	switch mode {
+	case "", FreshTime, FreshHash:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported fresh mode (use %s or %s)", mode, FreshTime, FreshHash)
+	}
+}
+
+// freshCommand skips the execution of a command when its targets are newer
+// than its sources. Without targets or in hash mode, the content of the
+// sources is compared with the one recorded by the last successful run (and
+// the targets only have to exist).
+type freshCommand struct {
+	Executer
+
+	dir     string
+	ign     *ignoreList
+	mode    string
+	sources []string
+	targets []string
+	stderr  io.Writer
+}
+
+func (m *Maestro) fresh(ex Executer, cmd CommandSettings) (Executer, error) {
+	ign, err := loadIgnore(filepath.Dir(m.File))
+	if err != nil {
+		return nil, err
+	}
+	work := cmd.workDir()
+	fc := freshCommand{
+		Executer: ex,
+		dir:      m.stateDir(),
+		ign:      ign,
+		mode:     cmd.Fresh,
+		sources:  workGlobs(cmd.Sources, work),
+		targets:  workGlobs(cmd.Targets, work),
+		stderr:   io.Discard,
+	}
+	return &fc, nil
+}
+
+func (c *freshCommand) SetErr(w io.Writer) {
+	c.stderr = w
+	c.Executer.SetErr(w)
+}
+
+func (c *freshCommand) Execute(ctx context.Context, args []string) error {
+	ok, set, err := c.upToDate()
+	if err != nil {
+		return err
+	}
+	if ok {
+		fmt.Fprintf(c.stderr, "%s: nothing to do", c.Command())
+		fmt.Fprintln(c.stderr)
+		return nil
+	}
+	if err := c.Executer.Execute(ctx, args); err != nil {
+		return err
+	}
+	if set == nil {
+		return nil
+	}
+	return writeStamps(c.dir, c.Command(), set)
+}
+
+func (c *freshCommand) upToDate() (bool, stamps, error) {
+	sources, err := expandGlobs(c.sources, c.ign)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(c.targets) == 0 || c.mode == FreshHash {
+		changed, set, err := sourcesChanged(c.dir, c.Command(), sources)
+		if err != nil || changed || len(c.targets) == 0 {
+			return !changed && err == nil, set, err
+		}
+		oldest, err := oldestTarget(c.targets)
+		return !oldest.IsZero() && err == nil, set, err
+	}
+	oldest, err := oldestTarget(c.targets)
+	if err != nil || oldest.IsZero() {
+		return false, nil, err
+	}
+	for _, f := range sources {
+		i, err := os.Stat(f)
+		if err != nil {
+			return false, nil, err
+		}
+		if i.ModTime().After(oldest) {
+			return false, nil, nil
+		}
+	}
+	return true, nil, nil
+}
+
+// oldestTarget gives the modification time of the oldest target. A zero time
+// is returned if one of the targets does not exist yet.
+func oldestTarget(patterns []string) (time.Time, error) {
+	var oldest time.Time
+	for _, p := range patterns {
+		files, err := filepath.Glob(p)
+		if err != nil {
+			return oldest, fmt.Errorf("%s: %w", p, err)
+		}
+		if len(files) == 0 {
+			return time.Time{}, nil
+		}
+		for _, f := range files {
+			i, err := os.Stat(f)
+			if err != nil {
+				return time.Time{}, nil
+			}
+			if oldest.IsZero() || i.ModTime().Before(oldest) {
+				oldest = i.ModTime()
+			}
+		}
+	}
+	return oldest, nil
+}
@@ -0,0 +1,112 @@
+package maestro
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	GenEnv  = "env"
+	GenFlag = "flag"
+)
+
+// GeneratedFile describes a file written with the values of some options
+// and/or variables before the script of a command is executed. The file is
+// removed once the command is done.
+type GeneratedFile struct {
+	Kind  string
+	File  string
+	Names []string
+}
+
+func (g GeneratedFile) Format(resolve func(string) ([]string, error)) (string, error) {
+	var str strings.Builder
+	for _, n := range g.Names {
+		vs, err := resolve(n)
+		if err != nil {
+			return "", err
+		}
+		value := strings.Join(vs, " ")
+		if strings.ContainsAny(value, " \t\n\"'$\\#") {
+			value = strconv.Quote(value)
+		}
+		switch g.Kind {
+		case GenEnv:
+			fmt.Fprintf(&str, "%s=%s", strings.ToUpper(strings.ReplaceAll(n, "-", "_")), value)
+		case GenFlag:
+			fmt.Fprintf(&str, "--%s=%s", n, value)
+		default:
+			return "", fmt.Errorf("%s: unsupported kind of file", g.Kind)
+		}
+		str.WriteString("\n")
+	}
+	return str.String(), nil
+}
+
+// generateFiles writes the files generated for the command. A file that
+// already exists is never overwritten: it could be a file of the user that
+// would be removed once the command is done.
+func (c *command) generateFiles() (func(), error) {
+	var files []string
+	remove := func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}
+	for _, g := range c.generated {
+		str, err := g.Format(c.resolveGenerated)
+		if err != nil {
+			remove()
+			return nil, fmt.Errorf("%s: %w", g.File, err)
+		}
+		file := g.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(c.shell.Cwd(), file)
+		}
+		if err := writeGenerated(file, str); err != nil {
+			remove()
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return remove, nil
+}
+
+// resolveGenerated gives the values of an option or a variable written in a
+// generated file. Unlike the shell, an undefined name is an error.
+func (c *command) resolveGenerated(name string) ([]string, error) {
+	vs, err := c.shell.Resolve(name)
+	if err != nil || len(vs) > 0 {
+		return vs, err
+	}
+	for _, o := range c.options {
+		if o.Short == name || o.Long == name {
+			return vs, nil
+		}
+	}
+	if c.locals.Defined(name) {
+		return vs, nil
+	}
+	return nil, fmt.Errorf("%s: undefined option or variable", name)
+}
+
+func writeGenerated(file, content string) error {
+	w, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("%s: file already exists (it is not replaced by the generated file)", file)
+		}
+		return err
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		w.Close()
+		os.Remove(file)
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,76 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := fmt.Sprintf(`
+VAR = value
+EMPTY =
+generate(
+	workdir = %[1]q,
+	write_env = app.env VAR EMPTY,
+	flagfile = app.flags VAR,
+): {
+	cat app.env app.flags
+}
+existing(
+	workdir = %[1]q,
+	write_env = ".env" VAR,
+): {
+	echo should not be executed
+}
+undefined(
+	workdir = %[1]q,
+	write_env = other.env VAR UNDEFINED,
+): {
+	echo should not be executed
+}
+`, dir)
+	mst := decodeFile(t, file)
+
+	var buf strings.Builder
+	ex := resolveCommand(t, mst, "generate", ctreeOption{})
+	if err := ex.Execute(context.Background(), &buf, io.Discard); err != nil {
+		t.Fatalf("generate should have succeeded: %s", err)
+	}
+	if want := "VAR=value\nEMPTY=\n--VAR=value\n"; buf.String() != want {
+		t.Errorf("generated files mismatched: want %q, got %q", want, buf.String())
+	}
+	for _, f := range []string{"app.env", "app.flags"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err == nil {
+			t.Errorf("%s: generated file not removed", f)
+		}
+	}
+
+	env := filepath.Join(dir, ".env")
+	writeTestFile(t, env, "SECRET=keep me\n")
+	buf.Reset()
+	ex = resolveCommand(t, mst, "existing", ctreeOption{})
+	if err := ex.Execute(context.Background(), &buf, io.Discard); err == nil {
+		t.Errorf("generating over an existing file should have failed")
+	}
+	if content, err := os.ReadFile(env); err != nil || string(content) != "SECRET=keep me\n" {
+		t.Errorf("existing file modified: %q (%v)", content, err)
+	}
+
+	ex = resolveCommand(t, mst, "undefined", ctreeOption{})
+	err := ex.Execute(context.Background(), &buf, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "UNDEFINED") {
+		t.Errorf("undefined name should have been rejected: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.env")); err == nil {
+		t.Errorf("file generated with an undefined name")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("script executed despite the errors: %q", buf.String())
+	}
+}
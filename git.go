@@ -0,0 +1,75 @@
+package maestro
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/midbel/maestro/internal/env"
+)
+
+const (
+	gitBranch = "git.branch"
+	gitCommit = "git.commit"
+	gitDirty  = "git.dirty"
+	gitTag    = "git.tag"
+)
+
+// registerGit defines the git built-in variables. Their values are computed
+// the first time they are used.
+func registerGit(ev *env.Env, dir string) {
+	repo := func(name string) error {
+		if _, err := runGit(dir, "rev-parse", "--git-dir"); err != nil {
+			return fmt.Errorf("%s: %s is not in a git repository", name, dir)
+		}
+		return nil
+	}
+	value := func(name string, args ...string) func() ([]string, error) {
+		return func() ([]string, error) {
+			if err := repo(name); err != nil {
+				return nil, err
+			}
+			str, err := runGit(dir, args...)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			return []string{str}, nil
+		}
+	}
+	ev.DefineLazy(gitBranch, value(gitBranch, "rev-parse", "--abbrev-ref", "HEAD"))
+	ev.DefineLazy(gitCommit, value(gitCommit, "rev-parse", "HEAD"))
+	ev.DefineLazy(gitDirty, func() ([]string, error) {
+		if err := repo(gitDirty); err != nil {
+			return nil, err
+		}
+		str, err := runGit(dir, "status", "--porcelain")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", gitDirty, err)
+		}
+		return []string{strconv.FormatBool(str != "")}, nil
+	})
+	ev.DefineLazy(gitTag, func() ([]string, error) {
+		if err := repo(gitTag); err != nil {
+			return nil, err
+		}
+		// no tag is not an error: the variable is then empty
+		str, _ := runGit(dir, "describe", "--tags", "--abbrev=0")
+		return []string{str}, nil
+	})
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	var (
+		out bytes.Buffer
+		cmd = exec.Command("git", args...)
+	)
+	cmd.Dir = dir
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(out.String()))
+	}
+	return strings.TrimSpace(out.String()), nil
+}
@@ -3,15 +3,16 @@ module github.com/midbel/maestro
 go 1.18
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/midbel/distance v0.1.0
 	github.com/midbel/shlex v0.1.0
 	github.com/midbel/textwrap v0.1.2
 	github.com/midbel/tish v0.1.1
 	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	golang.org/x/sys v0.0.0-20220908164124-27713097b956
+	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/midbel/rw v0.3.0 // indirect
-	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
-)
+require github.com/midbel/rw v0.3.0 // indirect
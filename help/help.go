@@ -0,0 +1,141 @@
+// Package help renders the documentation of a maestro file and of its
+// commands.
+package help
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+	FormatJson     = "json"
+)
+
+type Option struct {
+	Short    string `json:"short,omitempty"`
+	Long     string `json:"long,omitempty"`
+	Help     string `json:"help,omitempty"`
+	Default  string `json:"default,omitempty"`
+	Required bool   `json:"required"`
+	Flag     bool   `json:"flag"`
+	Group    string `json:"group,omitempty"`
+}
+
+// OptionGroup is a set of options shown under the same heading.
+type OptionGroup struct {
+	Name    string
+	Options []Option
+}
+
+// Command is the documentation of a command. It is also the JSON document
+// giving the commands of a maestro file (eg: maestro ls --format json).
+type Command struct {
+	Name     string   `json:"name"`
+	Short    string   `json:"short,omitempty"`
+	Icon     string   `json:"icon,omitempty"`
+	Desc     string   `json:"desc,omitempty"`
+	Usage    string   `json:"usage"`
+	Options  []Option `json:"options"`
+	Args     []string `json:"args"`
+	Examples []string `json:"examples,omitempty"`
+	Alias    []string `json:"aliases"`
+	Tags     []string `json:"tags"`
+	Hosts    []string `json:"hosts"`
+}
+
+// Groups gives the options of the command grouped by the option group they
+// come from. The options specific to the command come first in a group
+// without name.
+func (c Command) Groups() []OptionGroup {
+	var (
+		list []OptionGroup
+		seen = make(map[string]int)
+	)
+	for _, o := range c.Options {
+		x, ok := seen[o.Group]
+		if !ok {
+			x = len(list)
+			seen[o.Group] = x
+			list = append(list, OptionGroup{Name: o.Group})
+		}
+		list[x].Options = append(list[x].Options, o)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name == "" && list[j].Name != ""
+	})
+	return list
+}
+
+type Preset struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+type File struct {
+	File     string    `json:"file"`
+	Help     string    `json:"help,omitempty"`
+	Usage    string    `json:"usage,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Commands []Command `json:"commands"`
+	Presets  []Preset  `json:"presets,omitempty"`
+}
+
+// Tags gives the commands of the file grouped by their tags.
+func (f File) Tags() map[string][]Command {
+	set := make(map[string][]Command)
+	for _, c := range f.Commands {
+		for _, t := range c.Tags {
+			set[t] = append(set[t], c)
+		}
+	}
+	for _, cs := range set {
+		sort.Slice(cs, func(i, j int) bool {
+			return cs[i].Name < cs[j].Name
+		})
+	}
+	return set
+}
+
+// Renderer writes the help of a file or of a single command in a given format.
+type Renderer interface {
+	File(io.Writer, File) error
+	Command(io.Writer, Command) error
+}
+
+var renderers = struct {
+	sync.RWMutex
+	set map[string]Renderer
+}{
+	set: map[string]Renderer{
+		FormatText:     Text(),
+		FormatMarkdown: Markdown(),
+		FormatJson:     Json(),
+	},
+}
+
+// Lookup gives the renderer registered for the given format. Text is used
+// when format is empty.
+func Lookup(format string) (Renderer, error) {
+	if format == "" {
+		format = FormatText
+	}
+	renderers.RLock()
+	defer renderers.RUnlock()
+	r, ok := renderers.set[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported help format", format)
+	}
+	return r, nil
+}
+
+// Register makes a renderer available under the given format.
+func Register(format string, r Renderer) {
+	renderers.Lock()
+	defer renderers.Unlock()
+	renderers.set[strings.ToLower(format)] = r
+}
@@ -0,0 +1,272 @@
+package help
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var sample = Command{
+	Name:  "deploy",
+	Short: "deploy the application",
+	Desc:  "deploy copies the binaries on the servers | restarts them",
+	Usage: "deploy [-e env] [--dry-run] <version>",
+	Options: []Option{
+		{Short: "e", Long: "env", Help: "environment", Default: "dev", Required: true},
+		{Long: "dry-run", Help: "print | do not execute", Flag: true, Group: "common"},
+		{Short: "v", Long: "verbose", Flag: true, Group: "common"},
+	},
+	Args:     []string{"version"},
+	Examples: []string{"-e prod v1.2.0"},
+	Alias:    []string{"ship"},
+	Tags:     []string{"ops"},
+	Hosts:    []string{"web1:22"},
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		Format string
+		Want   Renderer
+		Fail   bool
+	}{
+		{Format: "", Want: renderers.set[FormatText]},
+		{Format: "text", Want: renderers.set[FormatText]},
+		{Format: "Markdown", Want: renderers.set[FormatMarkdown]},
+		{Format: "JSON", Want: renderers.set[FormatJson]},
+		{Format: "yaml", Fail: true},
+	}
+	for _, tt := range tests {
+		r, err := Lookup(tt.Format)
+		if tt.Fail {
+			if err == nil {
+				t.Errorf("%q: unknown format should have been rejected", tt.Format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: fail to lookup renderer: %s", tt.Format, err)
+			continue
+		}
+		if fmt.Sprintf("%T", r) != fmt.Sprintf("%T", tt.Want) {
+			t.Errorf("%q: renderer mismatched: want %T, got %T", tt.Format, tt.Want, r)
+		}
+	}
+}
+
+type nameRenderer struct{}
+
+func (nameRenderer) File(w io.Writer, f File) error {
+	_, err := io.WriteString(w, f.File)
+	return err
+}
+
+func (nameRenderer) Command(w io.Writer, c Command) error {
+	_, err := io.WriteString(w, c.Name)
+	return err
+}
+
+func TestRegister(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			Register(fmt.Sprintf("Name%d", i), nameRenderer{})
+		}(i)
+		go func() {
+			defer wg.Done()
+			Lookup(FormatText)
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		renderers.Lock()
+		defer renderers.Unlock()
+		for i := 0; i < 8; i++ {
+			delete(renderers.set, fmt.Sprintf("name%d", i))
+		}
+	}()
+
+	r, err := Lookup("NAME3")
+	if err != nil {
+		t.Fatalf("registered renderer not found: %s", err)
+	}
+	var str strings.Builder
+	if err := r.Command(&str, sample); err != nil || str.String() != "deploy" {
+		t.Errorf("unexpected output of renderer: %q (%v)", str.String(), err)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	cmd := Command{
+		Options: []Option{
+			{Long: "dry-run", Group: "common"},
+			{Long: "env"},
+			{Long: "region", Group: "cloud"},
+			{Long: "verbose", Group: "common"},
+			{Long: "tag"},
+		},
+	}
+	var got []string
+	for _, g := range cmd.Groups() {
+		var names []string
+		for _, o := range g.Options {
+			names = append(names, o.Long)
+		}
+		got = append(got, fmt.Sprintf("%s=%s", g.Name, strings.Join(names, ",")))
+	}
+	want := []string{"=env,tag", "common=dry-run,verbose", "cloud=region"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("groups mismatched: want %q, got %q", want, got)
+	}
+}
+
+func TestTags(t *testing.T) {
+	f := File{
+		Commands: []Command{
+			{Name: "test", Tags: []string{"dev", "ci"}},
+			{Name: "build", Tags: []string{"dev"}},
+			{Name: "deploy", Tags: []string{"ops"}},
+		},
+	}
+	set := f.Tags()
+	want := map[string]string{
+		"ci":  "test",
+		"dev": "build,test",
+		"ops": "deploy",
+	}
+	if len(set) != len(want) {
+		t.Errorf("tags mismatched: want %d, got %d", len(want), len(set))
+	}
+	for tag, names := range want {
+		var got []string
+		for _, c := range set[tag] {
+			got = append(got, c.Name)
+		}
+		if strings.Join(got, ",") != names {
+			t.Errorf("%s: commands mismatched: want %s, got %q", tag, names, got)
+		}
+	}
+}
+
+func TestRenderCommand(t *testing.T) {
+	tests := []struct {
+		Format string
+		Want   string
+	}{
+		{
+			Format: FormatText,
+			Want: `deploy: deploy the application
+
+deploy copies the binaries on the servers | restarts them
+
+Options:
+
+  -e, --env  environment
+
+common options:
+
+  --dry-run  print | do not execute
+  -v, --verbose
+
+usage: deploy [-e env] [--dry-run] <version>
+examples:
+  deploy -e prod v1.2.0
+alias: ship
+tags:  ops
+hosts: web1:22
+`,
+		},
+		{
+			Format: FormatMarkdown,
+			Want: "# deploy\n\n" +
+				"deploy the application\n\n" +
+				"deploy copies the binaries on the servers | restarts them\n\n" +
+				"```\ndeploy [-e env] [--dry-run] <version>\n```\n\n" +
+				"| option | default | required | help |\n" +
+				"|--------|---------|----------|------|\n" +
+				"| `-e`, `--env` | dev | yes | environment |\n\n" +
+				"common options:\n\n" +
+				"| option | default | required | help |\n" +
+				"|--------|---------|----------|------|\n" +
+				"| `--dry-run` |  |  | print \\| do not execute |\n" +
+				"| `-v`, `--verbose` |  |  |  |\n\n" +
+				"examples:\n\n```\ndeploy -e prod v1.2.0\n```\n\n" +
+				"* **alias**: ship\n" +
+				"* **tags**: ops\n" +
+				"* **hosts**: web1:22\n",
+		},
+	}
+	for _, tt := range tests {
+		r, err := Lookup(tt.Format)
+		if err != nil {
+			t.Fatalf("%s: fail to lookup renderer: %s", tt.Format, err)
+		}
+		var str strings.Builder
+		if err := r.Command(&str, sample); err != nil {
+			t.Errorf("%s: fail to render command: %s", tt.Format, err)
+			continue
+		}
+		if got := str.String(); got != tt.Want {
+			t.Errorf("%s: output mismatched:\nwant:\n%s\ngot:\n%s", tt.Format, tt.Want, got)
+		}
+	}
+}
+
+func TestRenderJson(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Json().Command(&buf, Command{Name: "build", Usage: "build"}); err != nil {
+		t.Fatalf("fail to render command: %s", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid json document: %s", err)
+	}
+	for _, k := range []string{"name", "usage", "options", "args", "aliases", "tags", "hosts"} {
+		if _, ok := doc[k]; !ok {
+			t.Errorf("%s: field missing", k)
+		}
+	}
+	for _, k := range []string{"short", "desc", "icon", "examples"} {
+		if _, ok := doc[k]; ok {
+			t.Errorf("%s: empty field given", k)
+		}
+	}
+
+	buf.Reset()
+	f := File{File: "maestro.mf", Commands: []Command{sample}}
+	if err := Json().File(&buf, f); err != nil {
+		t.Fatalf("fail to render file: %s", err)
+	}
+	var got File
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid json document: %s", err)
+	}
+	if got.File != f.File || len(got.Commands) != 1 || got.Commands[0].Name != sample.Name || len(got.Commands[0].Options) != len(sample.Options) {
+		t.Errorf("file mismatched: want %+v, got %+v", f, got)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		Input string
+		Want  string
+	}{
+		{Input: "", Want: ""},
+		{Input: "build the program", Want: "build the program"},
+		{Input: "build the program\n", Want: "build the program"},
+		{
+			Input: "a much longer text that should be wrapped on multiple lines because it is longer than seventy characters",
+			Want:  "a much longer text that should be wrapped on multiple lines because it \nis longer than seventy characters",
+		},
+	}
+	for _, tt := range tests {
+		if got := wrap(tt.Input); got != tt.Want {
+			t.Errorf("%q: text mismatched: want %q, got %q", tt.Input, tt.Want, got)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package help
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type jsonRenderer struct{}
+
+// Json gives a renderer writing the help as an indented JSON document.
+func Json() Renderer {
+	return jsonRenderer{}
+}
+
+func (_ jsonRenderer) File(w io.Writer, f File) error {
+	return encode(w, f)
+}
+
+func (_ jsonRenderer) Command(w io.Writer, c Command) error {
+	return encode(w, c)
+}
+
+func encode(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
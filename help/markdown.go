@@ -0,0 +1,162 @@
+package help
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type markdownRenderer struct{}
+
+// Markdown gives a renderer suitable to publish the documentation of a maestro
+// file.
+func Markdown() Renderer {
+	return markdownRenderer{}
+}
+
+func (r markdownRenderer) File(w io.Writer, f File) error {
+	ws := bufio.NewWriter(w)
+	fmt.Fprintf(ws, "# %s", f.File)
+	if f.Version != "" {
+		fmt.Fprintf(ws, " (%s)", f.Version)
+	}
+	fmt.Fprintln(ws)
+	if f.Help != "" {
+		fmt.Fprintln(ws)
+		fmt.Fprintln(ws, f.Help)
+	}
+	if f.Usage != "" {
+		fmt.Fprintln(ws)
+		fmt.Fprintf(ws, "usage: `%s`", f.Usage)
+		fmt.Fprintln(ws)
+	}
+	var (
+		set  = f.Tags()
+		tags []string
+	)
+	for t := range set {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	if len(tags) > 0 {
+		fmt.Fprintln(ws)
+		fmt.Fprintln(ws, "## Commands")
+		fmt.Fprintln(ws)
+	}
+	for _, t := range tags {
+		var links []string
+		for _, c := range set[t] {
+			links = append(links, fmt.Sprintf("[%s](#%s)", c.Name, anchor(c.Name)))
+		}
+		fmt.Fprintf(ws, "* **%s**: %s", t, strings.Join(links, ", "))
+		fmt.Fprintln(ws)
+	}
+	if len(f.Presets) > 0 {
+		fmt.Fprintln(ws)
+		fmt.Fprintln(ws, "## Presets")
+		fmt.Fprintln(ws)
+	}
+	for _, p := range f.Presets {
+		fmt.Fprintf(ws, "* **%s**: `%s`", p.Name, p.Command)
+		fmt.Fprintln(ws)
+	}
+	cs := append([]Command{}, f.Commands...)
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].Name < cs[j].Name
+	})
+	for _, c := range cs {
+		fmt.Fprintln(ws)
+		r.command(ws, c, "###")
+	}
+	return ws.Flush()
+}
+
+func (r markdownRenderer) Command(w io.Writer, c Command) error {
+	ws := bufio.NewWriter(w)
+	r.command(ws, c, "#")
+	return ws.Flush()
+}
+
+func (r markdownRenderer) command(w io.Writer, c Command, level string) {
+	fmt.Fprint(w, level, " ")
+	if c.Icon != "" {
+		fmt.Fprint(w, c.Icon, " ")
+	}
+	fmt.Fprintln(w, c.Name)
+	if c.Short != "" {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, c.Short)
+	}
+	if c.Desc != "" {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, c.Desc)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "```")
+	fmt.Fprintln(w, c.Usage)
+	fmt.Fprintln(w, "```")
+	for _, g := range c.Groups() {
+		if g.Name != "" {
+			fmt.Fprintln(w)
+			fmt.Fprintf(w, "%s options:", g.Name)
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "| option | default | required | help |")
+		fmt.Fprintln(w, "|--------|---------|----------|------|")
+		for _, o := range g.Options {
+			var names []string
+			if o.Short != "" {
+				names = append(names, "`-"+o.Short+"`")
+			}
+			if o.Long != "" {
+				names = append(names, "`--"+o.Long+"`")
+			}
+			var required string
+			if o.Required {
+				required = "yes"
+			}
+			fmt.Fprintf(w, "| %s | %s | %s | %s |", strings.Join(names, ", "), escapeCell(o.Default), required, escapeCell(o.Help))
+			fmt.Fprintln(w)
+		}
+	}
+	if len(c.Examples) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "examples:")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "```")
+		for _, e := range c.Examples {
+			fmt.Fprintf(w, "%s %s", c.Name, e)
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, "```")
+	}
+	var props [][2]string
+	if len(c.Alias) > 0 {
+		props = append(props, [2]string{"alias", strings.Join(c.Alias, ", ")})
+	}
+	if len(c.Tags) > 0 {
+		props = append(props, [2]string{"tags", strings.Join(c.Tags, ", ")})
+	}
+	if len(c.Hosts) > 0 {
+		props = append(props, [2]string{"hosts", strings.Join(c.Hosts, ", ")})
+	}
+	if len(props) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, p := range props {
+		fmt.Fprintf(w, "* **%s**: %s", p[0], p[1])
+		fmt.Fprintln(w)
+	}
+}
+
+func anchor(str string) string {
+	return strings.ToLower(strings.ReplaceAll(str, " ", "-"))
+}
+
+func escapeCell(str string) string {
+	str = strings.ReplaceAll(str, "|", `\|`)
+	return strings.ReplaceAll(str, "\n", " ")
+}
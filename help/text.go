@@ -0,0 +1,119 @@
+package help
+
+import (
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/midbel/textwrap"
+)
+
+const helptext = `
+{{if .Help -}}
+{{wrap .Help}}
+{{- end}}
+
+Available commands:
+{{range $k, $cs := .Tags}}
+{{$k}}:
+{{repeat "-" $k}}-
+{{- range $cs}}
+  - {{with .Icon}}{{.}} {{end}}{{printf "%-20s %s" .Name .Short -}}
+{{end -}}
+{{end}}
+{{- with .Presets}}
+
+Available presets:
+{{- range .}}
+  - {{printf "%-20s %s" .Name .Command}}
+{{- end}}
+{{- end}}
+
+{{wrap (printf "use \"maestro -f %s help <command>\" for more information on the available command(s)" .File)}}
+`
+
+const cmdhelp = `
+{{with .Icon}}{{.}} {{end}}{{.Name}}{{if .Short }}: {{.Short}}{{end}}
+
+{{if .Desc -}}{{wrap .Desc}}
+{{end}}
+
+{{- range .Groups}}
+{{if .Name}}{{.Name}} options:{{else}}Options:{{end}}
+{{range .Options}}
+  {{if .Short}}-{{.Short}}{{end}}{{if and .Long .Short}}, {{end}}{{if .Long}}--{{.Long}}{{end}}{{if .Help}}  {{.Help}}{{end}}
+{{- end}}
+{{end}}
+usage: {{indent .Usage 7}}
+{{with .Examples}}examples:
+{{range .}}  {{$.Name}} {{.}}
+{{end}}{{end -}}
+{{if .Alias}}alias: {{join .Alias ", "}}
+{{end -}}
+{{if .Tags}}tags:  {{join .Tags ", "}}
+{{end -}}
+{{with .Hosts}}hosts: {{join . ", "}}
+{{end -}}
+`
+
+type textRenderer struct {
+	file *template.Template
+	cmd  *template.Template
+}
+
+// Text gives the renderer used by maestro to print help on a terminal.
+func Text() Renderer {
+	return textRenderer{
+		file: template.Must(template.New("file").Funcs(funcmap).Parse(helptext)),
+		cmd:  template.Must(template.New("command").Funcs(funcmap).Parse(cmdhelp)),
+	}
+}
+
+func (r textRenderer) File(w io.Writer, f File) error {
+	return render(w, r.file, f)
+}
+
+func (r textRenderer) Command(w io.Writer, c Command) error {
+	return render(w, r.cmd, c)
+}
+
+func render(w io.Writer, t *template.Template, ctx interface{}) error {
+	var str strings.Builder
+	if err := t.Execute(&str, ctx); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, strings.TrimSpace(str.String())+"\n")
+	return err
+}
+
+var funcmap = template.FuncMap{
+	"repeat": repeat,
+	"wrap":   wrap,
+	"join":   strings.Join,
+	"indent": indent,
+}
+
+// wrap breaks str in lines of textwrap.DefaultLength characters. textwrap
+// repeats the last word of a text that does not end with a newline: one is
+// added before and removed after wrapping the text.
+func wrap(str string) string {
+	return strings.TrimSuffix(textwrap.Wrap(str+"\n"), "\n")
+}
+
+// indent aligns the lines of str after the first one on the given column.
+func indent(str string, n int) string {
+	return strings.ReplaceAll(str, "\n", "\n"+strings.Repeat(" ", n))
+}
+
+func repeat(char string, value interface{}) string {
+	var n int
+	switch v := value.(type) {
+	case string:
+		n = len(v)
+	case int:
+		n = v
+	default:
+		return ""
+	}
+	return strings.Repeat(char, n)
+}
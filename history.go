@@ -0,0 +1,280 @@
+package maestro
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+const (
+	historyFile = "history.json"
+	historySize = 500
+)
+
+// invocation is a command executed from the command line with its arguments
+// and the options of maestro changing how it is executed.
+type invocation struct {
+	When     time.Time     `json:"when"`
+	File     string        `json:"file"`
+	Command  string        `json:"command"`
+	Args     []string      `json:"args,omitempty"`
+	Remote   bool          `json:"remote,omitempty"`
+	NoDeps   bool          `json:"nodeps,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+
+	// the command reads the output of the previous command
+	pipe bool
+}
+
+func (i invocation) Failed() bool {
+	return i.Error != ""
+}
+
+func (i invocation) Status() string {
+	if i.Failed() {
+		return i.Error
+	}
+	return "ok"
+}
+
+func (i invocation) String() string {
+	list := []string{"maestro"}
+	if i.Remote {
+		list = append(list, "-r")
+	}
+	if i.NoDeps {
+		list = append(list, "-k")
+	}
+	list = append(list, i.Command)
+	for _, a := range i.Args {
+		list = append(list, shellQuote(a))
+	}
+	return strings.Join(list, " ")
+}
+
+// historyFile gives the file of the history shared by all the maestro files
+// of the user: $XDG_STATE_HOME/maestro/history.json (with ~/.local/state when
+// XDG_STATE_HOME is not set).
+func (m *Maestro) historyFile() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(m.stateDir(), historyFile)
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "maestro", historyFile)
+}
+
+// historyKey gives the value used to find the invocations of the commands of
+// the current maestro file in the history.
+func (m *Maestro) historyKey() string {
+	file, err := filepath.Abs(m.File)
+	if err != nil {
+		return m.File
+	}
+	return file
+}
+
+// History prints the most recent invocations of the commands of the maestro
+// file (or of all the files with -a).
+func (m *Maestro) History(args []string) error {
+	var (
+		set   = flag.NewFlagSet(CmdHistory, flag.ExitOnError)
+		all   = set.Bool("a", false, "show the invocations of all maestro files")
+		count = set.Int("n", 20, "number of invocations to show")
+		fail  = set.Bool("failed", false, "only show the failed invocations")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	list, err := readHistory(m.historyFile())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var (
+		key = m.historyKey()
+		tw  = tabwriter.NewWriter(stdio.Stdout, 12, 2, 2, ' ', 0)
+	)
+	for i := len(list) - 1; i >= 0 && *count > 0; i-- {
+		x := list[i]
+		if (!*all && x.File != key) || (*fail && !x.Failed()) {
+			continue
+		}
+		*count--
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s", x.When.Format("2006-01-02 15:04:05"), humanDuration(x.Duration), x, x.Status())
+		if *all {
+			fmt.Fprintf(tw, "\t%s", x.File)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// Last prints the most recent invocation (of the given command).
+func (m *Maestro) Last(args []string) error {
+	set := flag.NewFlagSet(CmdLast, flag.ExitOnError)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	i, err := m.lastInvocation(set.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdio.Stdout, i)
+	fmt.Fprintf(stdio.Stdout, "  at %s: %s", i.When.Format("2006-01-02 15:04:05"), i.Status())
+	fmt.Fprintln(stdio.Stdout)
+	return nil
+}
+
+// Rerun executes again the most recent (failed) invocation (of the given
+// command) with the same arguments.
+func (m *Maestro) Rerun(args []string) error {
+	var (
+		set  = flag.NewFlagSet(CmdRerun, flag.ExitOnError)
+		fail = set.Bool("failed", false, "execute again the last failed invocation")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	i, err := m.lastInvocation(set.Arg(0), *fail)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdio.Stderr, "rerun: %s", i)
+	fmt.Fprintln(stdio.Stderr)
+	m.Remote = i.Remote
+	m.NoDeps = i.NoDeps
+	// the values of the sensitive options are not recorded: they are asked
+	// again or given by their defaults
+	if cmd, err := m.Commands.Lookup(i.Command); err == nil {
+		i.Args = cmd.unmaskArgs(i.Args)
+	}
+	return m.Execute(i.Command, i.Args)
+}
+
+func (m *Maestro) lastInvocation(name string, failed bool) (invocation, error) {
+	list, err := readHistory(m.historyFile())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return invocation{}, err
+	}
+	if name != "" {
+		cmd, err := m.Commands.Lookup(name)
+		if err != nil {
+			return invocation{}, m.suggest(err, name)
+		}
+		name = cmd.Name
+	}
+	key := m.historyKey()
+	for i := len(list) - 1; i >= 0; i-- {
+		x := list[i]
+		if x.File != key || (failed && !x.Failed()) {
+			continue
+		}
+		if name == "" || x.Command == name {
+			return x, nil
+		}
+	}
+	what := "invocation"
+	if failed {
+		what = "failed invocation"
+	}
+	if name != "" {
+		return invocation{}, fmt.Errorf("%s: no %s found in history", name, what)
+	}
+	return invocation{}, fmt.Errorf("no %s found in history", what)
+}
+
+// remember records the invocation of a command in the history. The history
+// only keeps the last historySize invocations. The values of the sensitive
+// options are masked. Failing to update the history does not make the
+// execution of the command fail.
+func (m *Maestro) remember(name string, args []string, when time.Time, err error) {
+	cmd, e := m.Commands.Lookup(name)
+	if e != nil {
+		return
+	}
+	args, values := cmd.maskArgs(args)
+	i := invocation{
+		When:     when,
+		File:     m.historyKey(),
+		Command:  cmd.Name,
+		Args:     args,
+		Remote:   m.Remote,
+		NoDeps:   m.NoDeps,
+		Duration: m.clock().Now().Sub(when),
+	}
+	if err != nil {
+		i.Error = redact(err.Error(), values...)
+	}
+	updateHistory(m.historyFile(), i)
+}
+
+func readHistory(file string) ([]invocation, error) {
+	var list []invocation
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return list, json.Unmarshal(buf, &list)
+}
+
+// updateHistory adds i to the history. The history is updated by one process
+// at a time holding the lock of the history and it is replaced at once so that
+// it is never read partially written. A history that can not be read is left
+// as is.
+func updateHistory(file string, i invocation) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(file+lockExt, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock, true); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	list, err := readHistory(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	list = append(list, i)
+	if len(list) > historySize {
+		list = list[len(list)-historySize:]
+	}
+	return writeHistory(file, list)
+}
+
+func writeHistory(file string, list []invocation) error {
+	buf, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the temporary file is only readable by the user
+	f, err := os.CreateTemp(filepath.Dir(file), historyFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
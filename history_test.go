@@ -0,0 +1,109 @@
+package maestro
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistoryMaskArgs(t *testing.T) {
+	const file = `
+greet(
+	options = (short = p, long = pass, sensitive = true),
+		(short = n, long = name),
+		(short = v, flag = true),
+): {
+	echo $name
+}
+`
+	mst := decodeFile(t, file)
+	cmd, err := mst.Commands.Lookup("greet")
+	if err != nil {
+		t.Fatalf("greet not decoded: %s", err)
+	}
+	tests := []struct {
+		Args   []string
+		Masked []string
+		Rerun  []string
+	}{
+		{
+			Args:   []string{"-p", "hunter22", "-n", "bob"},
+			Masked: []string{"-p", "***", "-n", "bob"},
+			Rerun:  []string{"-n", "bob"},
+		},
+		{
+			Args:   []string{"-v", "--pass=hunter22", "arg"},
+			Masked: []string{"-v", "--pass=***", "arg"},
+			Rerun:  []string{"-v", "arg"},
+		},
+		{
+			Args:   []string{"arg", "-p", "hunter22"},
+			Masked: []string{"arg", "-p", "hunter22"},
+			Rerun:  []string{"arg", "-p", "hunter22"},
+		},
+		{
+			Args:   []string{"-n", "-p", "--", "-p", "x"},
+			Masked: []string{"-n", "-p", "--", "-p", "x"},
+			Rerun:  []string{"-n", "-p", "--", "-p", "x"},
+		},
+	}
+	for _, tt := range tests {
+		got, _ := cmd.maskArgs(tt.Args)
+		if strings.Join(got, " ") != strings.Join(tt.Masked, " ") {
+			t.Errorf("%q: masked arguments mismatched! want %q, got %q", tt.Args, tt.Masked, got)
+		}
+		if got = cmd.unmaskArgs(got); strings.Join(got, " ") != strings.Join(tt.Rerun, " ") {
+			t.Errorf("%q: arguments of rerun mismatched! want %q, got %q", tt.Args, tt.Rerun, got)
+		}
+	}
+
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+	mst.remember("greet", []string{"-p", "hunter22"}, time.Now(), errors.New("bad password hunter22"))
+
+	hist := mst.historyFile()
+	buf, err := os.ReadFile(hist)
+	if err != nil {
+		t.Fatalf("history not written: %s", err)
+	}
+	if strings.Contains(string(buf), "hunter22") {
+		t.Errorf("sensitive value written in history: %s", buf)
+	}
+	if fi, err := os.Stat(hist); err == nil && fi.Mode().Perm()&0o077 != 0 {
+		t.Errorf("history readable by other users: %s", fi.Mode())
+	}
+}
+
+func TestHistoryConcurrent(t *testing.T) {
+	var (
+		file = filepath.Join(t.TempDir(), "maestro", historyFile)
+		wg   sync.WaitGroup
+		size = 20
+	)
+	for i := 0; i < size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := updateHistory(file, invocation{Command: "build"}); err != nil {
+				t.Errorf("fail to update history: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	list, err := readHistory(file)
+	if err != nil {
+		t.Fatalf("fail to read history: %s", err)
+	}
+	if len(list) != size {
+		t.Errorf("invocations lost! want %d, got %d", size, len(list))
+	}
+
+	os.WriteFile(file, []byte("{corrupted"), 0o600)
+	if err := updateHistory(file, invocation{Command: "build"}); err == nil {
+		t.Errorf("corrupted history should not be replaced")
+	}
+}
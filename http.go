@@ -1,13 +1,17 @@
 package maestro
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
@@ -19,14 +23,36 @@ const (
 	httpHdrExit   = "Maestro-Exit"
 	httpHdrPrefix = "Maestro-Prefix"
 
-	httpHdrContent = "Content-Type"
-	httpHdrTrailer = "Trailer"
+	httpHdrContent  = "Content-Type"
+	httpHdrTrailer  = "Trailer"
+	httpHdrAllow    = "Allow"
+	httpHdrAccept   = "Accept"
+	httpHdrCache    = "Cache-Control"
+	httpHdrLocation = "Location"
+	httpHdrAuth     = "Authorization"
+	httpHdrAuthWant = "WWW-Authenticate"
 )
 
-func setupRoutes(m *Maestro) {
-	http.Handle("/help", serveRequest(ServeHelp(m)))
-	http.Handle("/version", serveRequest(ServeVersion(m)))
-	http.Handle("/", serveRequest(ServeExecute(m)))
+// maxRequestBody is the size limit of the body of the requests given as input
+// to the commands.
+const maxRequestBody = 10 << 20
+
+const (
+	mimeEventStream = "text/event-stream"
+
+	eventOut  = "out"
+	eventErr  = "err"
+	eventExit = "exit"
+)
+
+func setupRoutes(m *Maestro, queue *jobQueue) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/jobs/", serveRequest(ServeJobs(m, queue)))
+	mux.Handle("/help", serveRequest(serveAuth(ServeHelp)(m)))
+	mux.Handle("/commands", serveRequest(serveAuth(ServeCommands)(m)))
+	mux.Handle("/version", serveRequest(serveAuth(ServeVersion)(m)))
+	mux.Handle("/", serveRequest(ServeExecute(m)))
+	return mux
 }
 
 func ServeExecute(mst *Maestro) http.Handler {
@@ -35,26 +61,66 @@ func ServeExecute(mst *Maestro) http.Handler {
 			name   = path.Base(r.URL.Path)
 			option = getOption(r)
 		)
-		if name == "" {
+		if name == "" || name == "/" {
 			name = mst.MetaExec.Default
 		}
+		if !checkAuth(w, r, mst, name) {
+			return
+		}
+		if code, allow := checkMethod(mst, name, r.Method); code != 0 {
+			if len(allow) > 0 {
+				w.Header().Set(httpHdrAllow, strings.Join(allow, ", "))
+			}
+			w.WriteHeader(code)
+			return
+		}
+		if acceptStream(r) {
+			serveStream(w, r, name, option, mst)
+			return
+		}
+		output := FormatText
+		if cmd, err := mst.Commands.Lookup(name); err == nil && cmd.Output != "" {
+			output = cmd.Output
+		}
+		format, ok := negotiateFormat(r, output)
+		if !ok {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set(httpHdrContent, mimeOf(format))
 		w.Header().Set(httpHdrTrailer, httpHdrExit)
 		var (
-			err  = executeCommand(r.Context(), w, name, option, mst)
+			stdout io.Writer = w
+			stderr io.Writer = w
+			buf    bytes.Buffer
+		)
+		if output == FormatJson {
+			// messages written on stderr would make the document invalid
+			stderr = io.Discard
+		}
+		if format != output {
+			stdout = &buf
+		}
+		var (
+			err  = executeCommand(r.Context(), http.MaxBytesReader(w, r.Body, maxRequestBody), stdout, stderr, name, option, mst)
 			code int
 		)
 		switch {
-		case errors.Is(err, errNotFound):
+		case errors.Is(err, errNotFound), errors.Is(err, errInput):
 			code = http.StatusBadRequest
 		case errors.Is(err, errResolve):
 			code = http.StatusInternalServerError
 		default:
 		}
 		if code >= http.StatusBadRequest {
+			w.Header().Set(httpHdrContent, mimeText)
 			w.WriteHeader(code)
 			io.WriteString(w, err.Error())
 			return
 		}
+		if stdout == &buf {
+			convertOutput(w, buf.Bytes(), output, format)
+		}
 		exit := "ok"
 		if err != nil {
 			exit = err.Error()
@@ -81,12 +147,171 @@ func ServeVersion(mst *Maestro) http.Handler {
 
 func serveRequest(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set(httpHdrContent, "text/plain")
+		w.Header().Set(httpHdrContent, mimeText)
 		h.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(fn)
 }
 
+func checkAuth(w http.ResponseWriter, r *http.Request, mst *Maestro, name string) bool {
+	var tokens []string
+	if name != "" {
+		cmd, err := mst.Commands.Lookup(name)
+		if err == nil {
+			tokens = cmd.Tokens
+		}
+	}
+	if mst.MetaHttp.Authorized(requestToken(r), tokens) {
+		return true
+	}
+	w.Header().Set(httpHdrAuthWant, `Bearer realm="maestro"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+// serveAuth rejects the requests without one of the tokens given by
+// .HTTP_TOKEN (or the tokens of the command given with the command parameter).
+func serveAuth(create func(*Maestro) http.Handler) func(*Maestro) http.Handler {
+	return func(mst *Maestro) http.Handler {
+		h := create(mst)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !checkAuth(w, r, mst, r.URL.Query().Get("command")) {
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func requestToken(r *http.Request) string {
+	if _, pass, ok := r.BasicAuth(); ok {
+		return pass
+	}
+	str := r.Header.Get(httpHdrAuth)
+	if kind, token, ok := strings.Cut(str, " "); ok && strings.EqualFold(kind, "bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+func acceptStream(r *http.Request) bool {
+	if r.URL.Query().Get("stream") == "sse" {
+		return true
+	}
+	for _, a := range strings.Split(r.Header.Get(httpHdrAccept), ",") {
+		a, _, _ = strings.Cut(a, ";")
+		if strings.TrimSpace(a) == mimeEventStream {
+			return true
+		}
+	}
+	return false
+}
+
+func serveStream(w http.ResponseWriter, r *http.Request, name string, option ctreeOption, mst *Maestro) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set(httpHdrContent, mimeEventStream)
+	w.Header().Set(httpHdrCache, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	var (
+		mu     sync.Mutex
+		stdout = createEventWriter(w, &mu, eventOut)
+		stderr = createEventWriter(w, &mu, eventErr)
+		err    = executeCommand(r.Context(), http.MaxBytesReader(w, r.Body, maxRequestBody), stdout, stderr, name, option, mst)
+	)
+	stdout.Flush()
+	stderr.Flush()
+
+	exit := struct {
+		Code  int    `json:"code"`
+		Error string `json:"error,omitempty"`
+	}{
+		Code: exitCode(err),
+	}
+	if err != nil {
+		exit.Error = err.Error()
+	}
+	buf, _ := json.Marshal(exit)
+	writeEvent(w, &mu, eventExit, string(buf))
+}
+
+type eventWriter struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	event string
+	buf   bytes.Buffer
+}
+
+func createEventWriter(w io.Writer, mu *sync.Mutex, event string) *eventWriter {
+	return &eventWriter{
+		w:     w,
+		mu:    mu,
+		event: event,
+	}
+}
+
+func (w *eventWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	for {
+		x := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if x < 0 {
+			break
+		}
+		line := w.buf.Next(x + 1)
+		writeEvent(w.w, w.mu, w.event, string(line[:x]))
+	}
+	return len(b), nil
+}
+
+func (w *eventWriter) Flush() {
+	if w.buf.Len() == 0 {
+		return
+	}
+	writeEvent(w.w, w.mu, w.event, w.buf.String())
+	w.buf.Reset()
+}
+
+func writeEvent(w io.Writer, mu *sync.Mutex, event, data string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, str := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", str)
+	}
+	io.WriteString(w, "\n")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func checkMethod(mst *Maestro, name, method string) (int, []string) {
+	if !mst.MetaHttp.Restricted() {
+		return 0, nil
+	}
+	allow := mst.MetaHttp.Methods(name)
+	if len(allow) == 0 {
+		if cmd, err := mst.Commands.Lookup(name); err == nil {
+			allow = mst.MetaHttp.Methods(cmd.Name)
+		}
+	}
+	if len(allow) == 0 {
+		return http.StatusNotFound, nil
+	}
+	for i := range allow {
+		if allow[i] == method {
+			return 0, nil
+		}
+	}
+	return http.StatusMethodNotAllowed, allow
+}
+
 func getOption(r *http.Request) ctreeOption {
 	return ctreeOption{
 		NoDeps: parseBool(r.Header.Get(httpHdrNoDeps)),
@@ -107,7 +332,11 @@ var (
 	errExecute  = errors.New("execution fail")
 )
 
-func executeCommand(ctx context.Context, w io.Writer, name string, option ctreeOption, mst *Maestro) error {
+func executeCommand(ctx context.Context, body io.Reader, stdout, stderr io.Writer, name string, option ctreeOption, mst *Maestro) error {
+	if err := mst.confirm(name); err != nil {
+		return err
+	}
+	ctx = withInput(ctx, body)
 	x, err := mst.setup(ctx, name, true)
 	if err != nil {
 		return err
@@ -119,9 +348,12 @@ func executeCommand(ctx context.Context, w io.Writer, name string, option ctreeO
 	if c, ok := ex.(io.Closer); ok {
 		defer c.Close()
 	}
-	err = ex.Execute(ctx, w, w)
+	err = ex.Execute(ctx, stdout, stderr)
+	if errors.Is(err, errInput) {
+		return err
+	}
 	if err != nil {
-		err = fmt.Errorf("%w %s: %s", errExecute, name, err)
+		err = fmt.Errorf("%s %s: %w", errExecute, name, err)
 	}
 	return err
 }
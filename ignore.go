@@ -0,0 +1,184 @@
+package maestro
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var ignoreFiles = []string{".gitignore", ".maestroignore"}
+
+type ignoreRule struct {
+	re     *regexp.Regexp
+	negate bool
+	dir    bool
+}
+
+// ignoreList holds the rules found in the ignore files of a directory. Rules
+// follow the syntax of .gitignore: later rules take precedence over earlier
+// ones and a rule starting with ! re-includes a previously excluded path.
+type ignoreList struct {
+	base  string
+	rules []ignoreRule
+}
+
+// loadIgnore reads the ignore files of dir (the directory of the maestro file).
+// Unlike git, the ignore files of its sub directories are not read: their
+// rules should be moved to the ignore files of dir.
+func loadIgnore(dir string) (*ignoreList, error) {
+	ign := ignoreList{
+		base: dir,
+	}
+	for _, f := range ignoreFiles {
+		if err := ign.load(filepath.Join(dir, f)); err != nil {
+			return nil, err
+		}
+	}
+	return &ign, nil
+}
+
+func (i *ignoreList) load(file string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	defer r.Close()
+
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		line := strings.TrimRight(scan.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseIgnoreRule(line)
+		if err != nil {
+			return err
+		}
+		i.rules = append(i.rules, rule)
+	}
+	return scan.Err()
+}
+
+// Ignored reports whether the given file should be skipped. Every parent
+// directory of the file is checked too so that a rule like node_modules/
+// excludes everything below it.
+func (i *ignoreList) Ignored(file string) bool {
+	if i == nil || len(i.rules) == 0 {
+		return false
+	}
+	rel := file
+	if r, err := filepath.Rel(i.base, file); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	var (
+		parts = strings.Split(filepath.ToSlash(filepath.Clean(rel)), "/")
+		curr  string
+	)
+	for j := range parts {
+		curr = strings.Join(parts[:j+1], "/")
+		if i.match(curr, j < len(parts)-1 || isDir(file)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *ignoreList) match(file string, dir bool) bool {
+	var ignored bool
+	for _, r := range i.rules {
+		if r.dir && !dir {
+			continue
+		}
+		if r.re.MatchString(file) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+func parseIgnoreRule(line string) (ignoreRule, error) {
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dir = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var str strings.Builder
+	if !anchored {
+		str.WriteString("(^|/)")
+	} else {
+		str.WriteString("^")
+	}
+	for j := 0; j < len(line); j++ {
+		switch c := line[j]; c {
+		case '*':
+			if j+1 < len(line) && line[j+1] == '*' {
+				j++
+				if j+1 < len(line) && line[j+1] == '/' {
+					j++
+					str.WriteString("(.*/)?")
+				} else {
+					str.WriteString(".*")
+				}
+			} else {
+				str.WriteString("[^/]*")
+			}
+		case '?':
+			str.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(line[j:], ']')
+			if end < 0 {
+				str.WriteString(regexp.QuoteMeta(string(c)))
+				break
+			}
+			class := line[j+1 : j+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			str.WriteString("[" + class + "]")
+			j += end
+		case '\\':
+			if j+1 < len(line) {
+				j++
+				str.WriteString(regexp.QuoteMeta(line[j : j+1]))
+			}
+		default:
+			str.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	str.WriteString("$")
+
+	re, err := regexp.Compile(str.String())
+	if err != nil {
+		return rule, err
+	}
+	rule.re = re
+	return rule, nil
+}
+
+// compileGlob gives a rule matching the files selected by the pattern. A
+// pattern without a slash matches the files with this name in any directory.
+func compileGlob(pattern string) (ignoreRule, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	if strings.Contains(pattern, "/") {
+		pattern = "/" + pattern
+	}
+	return parseIgnoreRule(pattern)
+}
+
+func isDir(file string) bool {
+	i, err := os.Stat(file)
+	return err == nil && i.IsDir()
+}
@@ -0,0 +1,137 @@
+package maestro
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		Rule   string
+		File   string
+		Want   bool
+		Negate bool
+		Dir    bool
+	}{
+		// unanchored: matches the name in any directory
+		{Rule: "*.log", File: "app.log", Want: true},
+		{Rule: "*.log", File: "var/log/app.log", Want: true},
+		{Rule: "*.log", File: "app.log.gz"},
+		{Rule: "build", File: "cmd/build", Want: true},
+		{Rule: "build", File: "rebuild"},
+		{Rule: "a?c", File: "x/abc", Want: true},
+		{Rule: "a?c", File: "a/c"},
+		{Rule: "[ab].txt", File: "b.txt", Want: true},
+		{Rule: "[!ab].txt", File: "b.txt"},
+		{Rule: "[!ab].txt", File: "c.txt", Want: true},
+		{Rule: `\#notes`, File: "#notes", Want: true},
+		// anchored: a slash at the beginning or in the middle
+		{Rule: "/build", File: "build", Want: true},
+		{Rule: "/build", File: "cmd/build"},
+		{Rule: "doc/*.md", File: "doc/README.md", Want: true},
+		{Rule: "doc/*.md", File: "src/doc/README.md"},
+		{Rule: "doc/*.md", File: "doc/api/README.md"},
+		// double asterisks
+		{Rule: "**/vendor", File: "vendor", Want: true},
+		{Rule: "**/vendor", File: "lib/go/vendor", Want: true},
+		{Rule: "doc/**/*.md", File: "doc/README.md", Want: true},
+		{Rule: "doc/**/*.md", File: "doc/api/v1/README.md", Want: true},
+		{Rule: "doc/**", File: "doc/api/README.md", Want: true},
+		{Rule: "doc/**", File: "doc"},
+		// negation and directories only
+		{Rule: "!keep.log", File: "var/keep.log", Want: true, Negate: true},
+		{Rule: "node_modules/", File: "web/node_modules", Want: true, Dir: true},
+		{Rule: "/out/", File: "out", Want: true, Dir: true},
+		{Rule: "/out/", File: "src/out", Dir: true},
+	}
+	for _, tt := range tests {
+		rule, err := parseIgnoreRule(tt.Rule)
+		if err != nil {
+			t.Errorf("%s: fail to parse rule: %s", tt.Rule, err)
+			continue
+		}
+		if rule.negate != tt.Negate || rule.dir != tt.Dir {
+			t.Errorf("%s: flags mismatched: want negate %t/dir %t, got %t/%t", tt.Rule, tt.Negate, tt.Dir, rule.negate, rule.dir)
+		}
+		if got := rule.re.MatchString(tt.File); got != tt.Want {
+			t.Errorf("%s: %s: match mismatched: want %t, got %t", tt.Rule, tt.File, tt.Want, got)
+		}
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"app.log", "keep.log", "logs/keep.log", "build/app", "src/build", "node_modules/lib/index.js"} {
+		writeTestFile(t, filepath.Join(dir, f), f)
+	}
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "*.log\n!keep.log\nbuild/\n")
+	writeTestFile(t, filepath.Join(dir, ".maestroignore"), "# dependencies\nnode_modules\n\nlogs/\n")
+	// the ignore files of the sub directories are not read
+	writeTestFile(t, filepath.Join(dir, "src", ".gitignore"), "*\n")
+
+	ign, err := loadIgnore(dir)
+	if err != nil {
+		t.Fatalf("fail to load ignore files: %s", err)
+	}
+	tests := []struct {
+		File string
+		Want bool
+	}{
+		{File: "app.log", Want: true},
+		{File: "keep.log"},
+		{File: "logs/keep.log", Want: true},
+		{File: "build", Want: true},
+		{File: "build/app", Want: true},
+		{File: "src/build"},
+		{File: "src/main.go"},
+		{File: "node_modules/lib/index.js", Want: true},
+		{File: "main.go"},
+	}
+	for _, tt := range tests {
+		if got := ign.Ignored(filepath.Join(dir, tt.File)); got != tt.Want {
+			t.Errorf("%s: ignored mismatched: want %t, got %t", tt.File, tt.Want, got)
+		}
+	}
+	var empty *ignoreList
+	if empty.Ignored(filepath.Join(dir, "app.log")) {
+		t.Errorf("nil list should not ignore files")
+	}
+}
+
+func TestWalkGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"main.go", "cmd/app/main.go", "cmd/app/app.txt", "internal/x/y/z.go", "gen/api.go"} {
+		writeTestFile(t, filepath.Join(dir, f), f)
+	}
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "gen/\n")
+	ign, err := loadIgnore(dir)
+	if err != nil {
+		t.Fatalf("fail to load ignore files: %s", err)
+	}
+	tests := []struct {
+		Pattern string
+		Want    []string
+	}{
+		{Pattern: "**/*.go", Want: []string{"cmd/app/main.go", "internal/x/y/z.go", "main.go"}},
+		{Pattern: "cmd/**", Want: []string{"cmd/app/app.txt", "cmd/app/main.go"}},
+		{Pattern: "internal/**/z.go", Want: []string{"internal/x/y/z.go"}},
+		{Pattern: "**/app/*.txt", Want: []string{"cmd/app/app.txt"}},
+		{Pattern: "**/*.rs"},
+	}
+	for _, tt := range tests {
+		list, err := walkGlob(filepath.Join(dir, tt.Pattern), ign)
+		if err != nil {
+			t.Errorf("%s: fail to walk: %s", tt.Pattern, err)
+			continue
+		}
+		var got []string
+		for _, f := range list {
+			rel, _ := filepath.Rel(dir, f)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.Want, " ") {
+			t.Errorf("%s: want %q, got %q", tt.Pattern, tt.Want, got)
+		}
+	}
+}
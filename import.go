@@ -0,0 +1,257 @@
+package maestro
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	ImportMakefile = "makefile"
+	ImportNpm      = "npm"
+	ImportTaskfile = "taskfile"
+)
+
+// importer converts the content of a file from another task runner.
+type importer struct {
+	file  string
+	parse func(io.Reader) (*importFile, error)
+}
+
+var importers = map[string]importer{
+	ImportMakefile: {file: "Makefile", parse: parseMakefile},
+	ImportNpm:      {file: "package.json", parse: parsePackageJson},
+	ImportTaskfile: {file: "Taskfile.yml", parse: parseTaskfile},
+}
+
+// Import converts the tasks found in a file of another tool (given with
+// --from) and writes them as a maestro file.
+func (m *Maestro) Import(file string, args []string) error {
+	var (
+		set       = flag.NewFlagSet(CmdImport, flag.ExitOnError)
+		from      = set.String("from", ImportMakefile, "format of the file to import")
+		out       = set.String("o", file, "write maestro file to file (- for stdout)")
+		overwrite = set.Bool("w", false, "overwrite the maestro file if it already exists")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	imp, ok := importers[strings.ToLower(*from)]
+	if !ok {
+		var list []string
+		for k := range importers {
+			list = append(list, k)
+		}
+		sort.Strings(list)
+		return fmt.Errorf("%s: unsupported format (supported: %s)", *from, strings.Join(list, ", "))
+	}
+	src := set.Arg(0)
+	if src == "" {
+		src = imp.file
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	mf, err := imp.parse(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	mf.Source = filepath.Base(src)
+
+	if *out == "-" {
+		return mf.Write(os.Stdout)
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !*overwrite {
+		flags |= os.O_EXCL
+	}
+	w, err := os.OpenFile(*out, flags, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("%s: file already exists (use -w to overwrite it)", *out)
+		}
+		return err
+	}
+	if err := mf.Write(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+type importVar struct {
+	Name   string
+	Values []string
+	Append bool
+}
+
+type importCommand struct {
+	Name    string
+	Help    string
+	WorkDir string
+	Paths   []string
+	Deps    []string
+	Sources []string
+	Targets []string
+	Script  []string
+}
+
+// importFile holds what could be converted from the imported file. Skipped
+// keeps the lines that have no equivalent in maestro so that they can be
+// reported to the user.
+type importFile struct {
+	Source   string
+	Default  string
+	All      []string
+	Vars     []importVar
+	Env      []importVar
+	Commands []importCommand
+	Skipped  []string
+}
+
+func (f *importFile) Write(w io.Writer) error {
+	ws := bufio.NewWriter(w)
+	fmt.Fprintf(ws, "# generated by maestro from %s", f.Source)
+	fmt.Fprintln(ws)
+	if len(f.Skipped) > 0 {
+		fmt.Fprintln(ws, "#")
+		fmt.Fprintln(ws, "# the following lines could not be converted:")
+		for _, s := range f.Skipped {
+			fmt.Fprintf(ws, "#   %s", s)
+			fmt.Fprintln(ws)
+		}
+	}
+	if f.Default != "" || len(f.All) > 0 {
+		fmt.Fprintln(ws)
+	}
+	if f.Default != "" {
+		fmt.Fprintf(ws, ".DEFAULT = %s", f.Default)
+		fmt.Fprintln(ws)
+	}
+	if len(f.All) > 0 {
+		fmt.Fprintf(ws, ".ALL = %s", strings.Join(f.All, " "))
+		fmt.Fprintln(ws)
+	}
+	if len(f.Vars) > 0 || len(f.Env) > 0 {
+		fmt.Fprintln(ws)
+	}
+	for _, v := range f.Vars {
+		op := "="
+		if v.Append {
+			op = "+="
+		}
+		fmt.Fprintf(ws, "%s %s %s", v.Name, op, quoteValues(v.Values))
+		fmt.Fprintln(ws)
+	}
+	for _, v := range f.Env {
+		fmt.Fprintf(ws, "export %s = %s", v.Name, quoteValue(strings.Join(v.Values, " ")))
+		fmt.Fprintln(ws)
+	}
+	for _, c := range f.Commands {
+		fmt.Fprintln(ws)
+		f.writeCommand(ws, c)
+	}
+	return ws.Flush()
+}
+
+func (f *importFile) writeCommand(w io.Writer, c importCommand) {
+	var props [][2]string
+	if c.Help != "" {
+		props = append(props, [2]string{propShort, "'" + strings.ReplaceAll(c.Help, "'", "") + "'"})
+	}
+	if c.WorkDir != "" {
+		props = append(props, [2]string{propWorkDir, quoteValue(c.WorkDir)})
+	}
+	if len(c.Paths) > 0 {
+		props = append(props, [2]string{propPath, quoteValues(c.Paths)})
+	}
+	if len(c.Sources) > 0 {
+		props = append(props, [2]string{propSources, quoteValues(c.Sources)})
+	}
+	if len(c.Targets) > 0 {
+		props = append(props, [2]string{propTargets, quoteValues(c.Targets)})
+	}
+	io.WriteString(w, c.Name)
+	if len(props) > 0 {
+		var size int
+		for _, p := range props {
+			if len(p[0]) > size {
+				size = len(p[0])
+			}
+		}
+		fmt.Fprintln(w, "(")
+		for _, p := range props {
+			fmt.Fprintf(w, "\t%-*s = %s,", size, p[0], p[1])
+			fmt.Fprintln(w)
+		}
+		io.WriteString(w, ")")
+	}
+	io.WriteString(w, ":")
+	if len(c.Deps) > 0 {
+		fmt.Fprintf(w, " %s", strings.Join(c.Deps, ", "))
+	}
+	if len(c.Script) == 0 {
+		fmt.Fprintln(w, " {}")
+		return
+	}
+	fmt.Fprintln(w, " {")
+	for _, line := range c.Script {
+		fmt.Fprintf(w, "\t%s", line)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func quoteValues(values []string) string {
+	var list []string
+	for _, v := range values {
+		list = append(list, quoteValue(v))
+	}
+	return strings.Join(list, " ")
+}
+
+// quoteValue quotes a value that the maestro scanner would not read as a
+// single literal. Values referencing variables are kept as is to be expanded.
+func quoteValue(str string) string {
+	if str == "" {
+		return "''"
+	}
+	if strings.Contains(str, "$") {
+		return str
+	}
+	if strings.ContainsAny(str, " \t=.,:;()'\"#+*?<>|&!{}[]") {
+		return "'" + strings.ReplaceAll(str, "'", "") + "'"
+	}
+	return str
+}
+
+// importName gives a valid maestro identifier for the given name.
+func importName(str string) string {
+	var b strings.Builder
+	for _, r := range str {
+		if !isIdent(r) {
+			r = underscore
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// commandName gives a name for an imported command that does not hide one of
+// the sub-commands of maestro.
+func commandName(str string) string {
+	str = importName(str)
+	if isSubcommand(str) {
+		return str + "_"
+	}
+	return str
+}
@@ -0,0 +1,60 @@
+package maestro
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the tests")
+
+// TestImport converts the files of testdata/import and compares the maestro
+// files generated with the golden files (the source file with a .mf
+// extension). Run the tests with -update to write the golden files again.
+func TestImport(t *testing.T) {
+	for _, name := range []string{ImportMakefile, ImportNpm, ImportTaskfile} {
+		imp := importers[name]
+		t.Run(name, func(t *testing.T) {
+			var (
+				file   = filepath.Join("testdata", "import", imp.file)
+				golden = file + ".mf"
+			)
+			r, err := os.Open(file)
+			if err != nil {
+				t.Fatalf("fail to open file: %s", err)
+			}
+			defer r.Close()
+			mf, err := imp.parse(r)
+			if err != nil {
+				t.Fatalf("fail to import file: %s", err)
+			}
+			mf.Source = imp.file
+
+			var got bytes.Buffer
+			if err := mf.Write(&got); err != nil {
+				t.Fatalf("fail to write maestro file: %s", err)
+			}
+			if *updateGolden {
+				if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+					t.Fatalf("fail to update golden file: %s", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("fail to read golden file: %s", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("maestro file mismatched:\nwant:\n%s\ngot:\n%s", want, got.Bytes())
+			}
+			d, err := NewDecoder(bytes.NewReader(got.Bytes()))
+			if err != nil {
+				t.Fatalf("fail to create decoder: %s", err)
+			}
+			if _, err := d.Decode(); err != nil {
+				t.Errorf("generated file can not be decoded: %s", err)
+			}
+		})
+	}
+}
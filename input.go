@@ -0,0 +1,247 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	InputJson = "json"
+	InputCsv  = "csv"
+)
+
+var errInput = errors.New("invalid input")
+
+type inputKey struct{}
+
+// withInput gives the reader used as the input of the commands executed with
+// ctx instead of the standard input (eg: the body of a request in serve
+// mode).
+func withInput(ctx context.Context, r io.Reader) context.Context {
+	return context.WithValue(ctx, inputKey{}, r)
+}
+
+func inputFrom(ctx context.Context) io.Reader {
+	if r, ok := ctx.Value(inputKey{}).(io.Reader); ok {
+		return r
+	}
+	return os.Stdin
+}
+
+// inputCommand reads and validates the input of a command before giving it to
+// its script.
+type inputCommand struct {
+	Executer
+
+	in     interface{ SetIn(io.Reader) }
+	format string
+	schema string
+}
+
+func (m *Maestro) input(ex Executer, cmd CommandSettings) (Executer, error) {
+	switch cmd.Input {
+	case InputJson, InputCsv:
+	default:
+		return nil, fmt.Errorf("%s: %s: unsupported input format", cmd.Command(), cmd.Input)
+	}
+	in, ok := ex.(interface{ SetIn(io.Reader) })
+	if !ok {
+		return nil, fmt.Errorf("%s: input can not be given to command", cmd.Command())
+	}
+	return &inputCommand{
+		Executer: ex,
+		in:       in,
+		format:   cmd.Input,
+		schema:   cmd.InputSchema,
+	}, nil
+}
+
+// Execute reads the input of the command from the previous command of a pipe
+// or else from the input given by ctx.
+func (c *inputCommand) Execute(ctx context.Context, args []string) error {
+	in := stdinFrom(ctx)
+	if in == nil {
+		in = inputFrom(ctx)
+	}
+	buf, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", c.Command(), errInput, err)
+	}
+	if err := c.validate(buf); err != nil {
+		return fmt.Errorf("%s: %w", c.Command(), err)
+	}
+	c.in.SetIn(bytes.NewReader(buf))
+	return c.Executer.Execute(withStdin(ctx, nil), args)
+}
+
+func (c *inputCommand) validate(buf []byte) error {
+	var (
+		doc   interface{}
+		loose bool
+		err   error
+	)
+	switch c.format {
+	case InputJson:
+		err = json.Unmarshal(buf, &doc)
+	case InputCsv:
+		doc, err = parseCsv(buf)
+		loose = true
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInput, err)
+	}
+	if c.schema == "" {
+		return nil
+	}
+	var sch schema
+	if buf, err = os.ReadFile(c.schema); err == nil {
+		err = json.Unmarshal(buf, &sch)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: invalid schema: %w", c.schema, err)
+	}
+	list := sch.Validate("$", doc, loose)
+	if len(list) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w:\n  %s", errInput, strings.Join(list, "\n  "))
+}
+
+// parseCsv gives the records of a csv document as objects whose keys are the
+// names of the columns given by the first line.
+func parseCsv(buf []byte) ([]interface{}, error) {
+	rs := csv.NewReader(bytes.NewReader(buf))
+	head, err := rs.Read()
+	if err != nil {
+		return nil, err
+	}
+	list := []interface{}{}
+	for {
+		row, err := rs.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[string]interface{})
+		for i, h := range head {
+			obj[h] = row[i]
+		}
+		list = append(list, obj)
+	}
+	return list, nil
+}
+
+// schema is the subset of JSON schema used to validate the input of the
+// commands: type, enum, required, properties, additionalProperties and
+// items.
+type schema struct {
+	Type       string             `json:"type"`
+	Enum       []interface{}      `json:"enum"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+	Additional *bool              `json:"additionalProperties"`
+}
+
+// Validate gives the errors found in value. In loose mode, strings are
+// accepted for numbers and booleans if they can be converted (eg: csv).
+func (s *schema) Validate(where string, value interface{}, loose bool) []string {
+	if s == nil {
+		return nil
+	}
+	if s.Type != "" && !s.is(value, loose) {
+		return []string{fmt.Sprintf("%s: %s expected, got %s", where, s.Type, typeOf(value))}
+	}
+	if len(s.Enum) > 0 && !s.oneOf(value) {
+		return []string{fmt.Sprintf("%s: value not allowed", where)}
+	}
+	var list []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, r := range s.Required {
+			if _, ok := v[r]; !ok {
+				list = append(list, fmt.Sprintf("%s: %s: required property missing", where, r))
+			}
+		}
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p, ok := s.Properties[k]
+			if !ok && s.Additional != nil && !*s.Additional {
+				list = append(list, fmt.Sprintf("%s: %s: property not allowed", where, k))
+				continue
+			}
+			list = append(list, p.Validate(where+"."+k, v[k], loose)...)
+		}
+	case []interface{}:
+		for i := range v {
+			list = append(list, s.Items.Validate(fmt.Sprintf("%s[%d]", where, i), v[i], loose)...)
+		}
+	}
+	return list
+}
+
+func (s *schema) is(value interface{}, loose bool) bool {
+	if str, ok := value.(string); ok && loose {
+		switch s.Type {
+		case "number":
+			_, err := strconv.ParseFloat(str, 64)
+			return err == nil
+		case "integer":
+			_, err := strconv.ParseInt(str, 10, 64)
+			return err == nil
+		case "boolean":
+			_, err := strconv.ParseBool(str)
+			return err == nil
+		}
+	}
+	switch t := typeOf(value); s.Type {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	default:
+		return t == s.Type
+	}
+}
+
+func (s *schema) oneOf(value interface{}) bool {
+	for _, e := range s.Enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,134 @@
+package maestro
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	const sch = `{
+	"type": "object",
+	"required": ["name", "replicas"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string"},
+		"replicas": {"type": "integer"},
+		"ratio": {"type": "number"},
+		"debug": {"type": "boolean"},
+		"env": {"type": "string", "enum": ["dev", "prod"]},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	}
+}`
+	var s schema
+	if err := json.Unmarshal([]byte(sch), &s); err != nil {
+		t.Fatalf("fail to decode schema: %s", err)
+	}
+	tests := []struct {
+		Doc   string
+		Loose bool
+		Want  []string
+	}{
+		{Doc: `{"name": "web", "replicas": 2, "ratio": 0.5, "debug": true, "env": "dev", "tags": ["a", "b"]}`},
+		{Doc: `{"name": "web"}`, Want: []string{"$: replicas: required property missing"}},
+		{Doc: `{"name": "web", "replicas": 1.5}`, Want: []string{"$.replicas: integer expected, got number"}},
+		{Doc: `{"name": 1, "replicas": 1, "env": "test"}`, Want: []string{"$.env: value not allowed", "$.name: string expected, got number"}},
+		{Doc: `{"name": "web", "replicas": 1, "tags": ["a", 2, null]}`, Want: []string{"$.tags[1]: string expected, got number", "$.tags[2]: string expected, got null"}},
+		{Doc: `{"name": "web", "replicas": 1, "port": 80}`, Want: []string{"$: port: property not allowed"}},
+		{Doc: `["web"]`, Want: []string{"$: object expected, got array"}},
+		{Doc: `{"name": "web", "replicas": "2"}`, Want: []string{"$.replicas: integer expected, got string"}},
+		{Doc: `{"name": "web", "replicas": "2", "ratio": "0.5", "debug": "true"}`, Loose: true},
+		{Doc: `{"name": "web", "replicas": "two", "debug": "maybe"}`, Loose: true, Want: []string{"$.debug: boolean expected, got string", "$.replicas: integer expected, got string"}},
+	}
+	for _, tt := range tests {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(tt.Doc), &doc); err != nil {
+			t.Fatalf("%s: fail to decode document: %s", tt.Doc, err)
+		}
+		got := s.Validate("$", doc, tt.Loose)
+		if strings.Join(got, "|") != strings.Join(tt.Want, "|") {
+			t.Errorf("%s: errors mismatched: want %q, got %q", tt.Doc, tt.Want, got)
+		}
+	}
+	var empty *schema
+	if list := empty.Validate("$", "anything", false); len(list) != 0 {
+		t.Errorf("nil schema should accept any value: %q", list)
+	}
+}
+
+func TestParseCsv(t *testing.T) {
+	tests := []struct {
+		Doc  string
+		Want string
+		Fail bool
+	}{
+		{Doc: "name,replicas\nweb,2\n\"db, main\",1\n", Want: `[{"name":"web","replicas":"2"},{"name":"db, main","replicas":"1"}]`},
+		{Doc: "name,replicas\n", Want: `[]`},
+		{Doc: "", Fail: true},
+		{Doc: "name,replicas\nweb\n", Fail: true},
+		{Doc: "name\n\"web\n", Fail: true},
+	}
+	for _, tt := range tests {
+		list, err := parseCsv([]byte(tt.Doc))
+		if tt.Fail {
+			if err == nil {
+				t.Errorf("%q: invalid document should have been rejected", tt.Doc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: fail to parse document: %s", tt.Doc, err)
+			continue
+		}
+		got, _ := json.Marshal(list)
+		if string(got) != tt.Want {
+			t.Errorf("%q: records mismatched: want %s, got %s", tt.Doc, tt.Want, got)
+		}
+	}
+}
+
+func TestServeInput(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "schema.json")
+	)
+	if err := os.WriteFile(file, []byte(`{"type": "object", "required": ["name"]}`), 0o644); err != nil {
+		t.Fatalf("fail to write schema: %s", err)
+	}
+	mst := decodeFile(t, `
+deploy(
+	input        = json,
+	input_schema = "`+file+`",
+): {
+	cat
+}
+`)
+	tests := []struct {
+		Body string
+		Code int
+	}{
+		{Body: `{"name": "web"}`, Code: http.StatusOK},
+		{Body: `{"replicas": 1}`, Code: http.StatusBadRequest},
+		{Body: `{"name": `, Code: http.StatusBadRequest},
+		{Body: `{"name": "` + strings.Repeat("x", maxRequestBody) + `"}`, Code: http.StatusBadRequest},
+	}
+	h := ServeExecute(mst)
+	for _, tt := range tests {
+		var (
+			req = httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader([]byte(tt.Body)))
+			rec = httptest.NewRecorder()
+		)
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.Code {
+			t.Errorf("%.20s: status code mismatched: want %d, got %d", tt.Body, tt.Code, rec.Code)
+		}
+		if tt.Code == http.StatusOK && strings.TrimSpace(rec.Body.String()) != tt.Body {
+			t.Errorf("input not given to the command: %q", rec.Body.String())
+		}
+	}
+}
@@ -14,6 +14,9 @@ func CopyMap[T comparable, V any](arr map[T]V) map[T]V {
 	return ret
 }
 
+// CopyValues gives the product of arr with values: each list of arr is
+// repeated for each value with the value appended to it. The lists of arr
+// are reused when values has a single value.
 func CopyValues[T any](arr [][]T, values []T) [][]T {
 	if len(arr) == 0 {
 		for i := range values {
@@ -21,6 +24,12 @@ func CopyValues[T any](arr [][]T, values []T) [][]T {
 		}
 		return arr
 	}
+	if len(values) == 1 {
+		for i := range arr {
+			arr[i] = append(arr[i], values[0])
+		}
+		return arr
+	}
 	var (
 		old  = copyValues(arr)
 		list [][]T
@@ -2,7 +2,9 @@ package env
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type Values map[string][]string
@@ -10,6 +12,23 @@ type Values map[string][]string
 type Env struct {
 	parent *Env
 	locals Values
+	lazy   map[string]*lazyValue
+}
+
+// lazyValue computes the values of a variable on its first resolution. The
+// result is kept for the following resolutions.
+type lazyValue struct {
+	once sync.Once
+	get  func() ([]string, error)
+	vs   []string
+	err  error
+}
+
+func (v *lazyValue) Values() ([]string, error) {
+	v.once.Do(func() {
+		v.vs, v.err = v.get()
+	})
+	return v.vs, v.err
 }
 
 func EmptyEnv() *Env {
@@ -36,6 +55,45 @@ func (e *Env) Set(str string) error {
 	return nil
 }
 
+// String gives the variables defined in the env (not in its parents) as
+// NAME=VALUE pairs. With Set, it makes Env usable as a flag.Value (eg: -D).
+func (e *Env) String() string {
+	if e == nil {
+		return ""
+	}
+	var list []string
+	for k, vs := range e.locals {
+		list = append(list, fmt.Sprintf("%s=%s", k, strings.Join(vs, " ")))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// Names gives the names of the variables defined in the env (not in its
+// parents).
+func (e *Env) Names() []string {
+	var list []string
+	for k := range e.locals {
+		list = append(list, k)
+	}
+	for k := range e.lazy {
+		if _, ok := e.locals[k]; !ok {
+			list = append(list, k)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (e *Env) DefineLazy(key string, get func() ([]string, error)) error {
+	if e.lazy == nil {
+		e.lazy = make(map[string]*lazyValue)
+	}
+	delete(e.locals, key)
+	e.lazy[key] = &lazyValue{get: get}
+	return nil
+}
+
 func (e *Env) Define(key string, vs []string) error {
 	e.locals[key] = append(e.locals[key][:0], vs...)
 	return nil
@@ -43,17 +101,33 @@ func (e *Env) Define(key string, vs []string) error {
 
 func (e *Env) Delete(key string) error {
 	delete(e.locals, key)
+	delete(e.lazy, key)
 	return nil
 }
 
 func (e *Env) Resolve(key string) ([]string, error) {
 	vs, ok := e.locals[key]
+	if v, lazy := e.lazy[key]; !ok && lazy {
+		return v.Values()
+	}
 	if !ok && e.parent != nil {
 		return e.parent.Resolve(key)
 	}
 	return vs, nil
 }
 
+// Defined reports whether key is defined in the env or in one of its parents
+// even if it has no values.
+func (e *Env) Defined(key string) bool {
+	if _, ok := e.locals[key]; ok {
+		return true
+	}
+	if _, ok := e.lazy[key]; ok {
+		return true
+	}
+	return e.parent != nil && e.parent.Defined(key)
+}
+
 func (e *Env) Unwrap() *Env {
 	if e.parent == nil {
 		return e
@@ -65,6 +139,12 @@ func (e *Env) Copy() *Env {
 	x := Env{
 		locals: copyLocals(e.locals),
 	}
+	if len(e.lazy) > 0 {
+		x.lazy = make(map[string]*lazyValue)
+		for k, v := range e.lazy {
+			x.lazy[k] = v
+		}
+	}
 	if e.parent != nil {
 		x.parent = e.parent.Copy()
 	}
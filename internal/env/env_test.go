@@ -1,6 +1,8 @@
 package env_test
 
 import (
+	"flag"
+	"io"
 	"testing"
 
 	"github.com/midbel/maestro/internal/env"
@@ -30,3 +32,65 @@ func TestEnv(t *testing.T) {
 		t.Fatalf("empty values expected! got %v", values)
 	}
 }
+
+func TestEnvLazy(t *testing.T) {
+	var (
+		e     = env.EmptyEnv()
+		count int
+	)
+	e.DefineLazy("lazy", func() ([]string, error) {
+		count++
+		return []string{"lazy"}, nil
+	})
+	if count != 0 {
+		t.Fatalf("lazy value computed before being resolved")
+	}
+	c := env.EnclosedEnv(e.Copy())
+	for i := 0; i < 2; i++ {
+		values, err := c.Resolve("lazy")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(values) != 1 || values[0] != "lazy" {
+			t.Fatalf("values mismatched! got %v", values)
+		}
+	}
+	e.Resolve("lazy")
+	if count != 1 {
+		t.Fatalf("lazy value should be computed once! got %d", count)
+	}
+	e.Define("lazy", []string{"other"})
+	values, _ := e.Resolve("lazy")
+	if len(values) != 1 || values[0] != "other" {
+		t.Fatalf("values mismatched! got %v", values)
+	}
+}
+
+func TestEnvFlag(t *testing.T) {
+	var (
+		e   = env.EmptyEnv()
+		set = flag.NewFlagSet("maestro", flag.ContinueOnError)
+	)
+	set.SetOutput(io.Discard)
+	set.Var(e, "D", "define variables")
+	if err := set.Parse([]string{"-D", "foo=bar", "-D", "empty", "-D", "var=a=b"}); err != nil {
+		t.Fatalf("fail to parse arguments: %s", err)
+	}
+	tests := map[string]string{
+		"foo": "bar",
+		"var": "a=b",
+	}
+	for k, want := range tests {
+		values, _ := e.Resolve(k)
+		if len(values) != 1 || values[0] != want {
+			t.Errorf("%s: values mismatched! want %s, got %v", k, want, values)
+		}
+	}
+	if !e.Defined("empty") {
+		t.Errorf("empty: variable not defined")
+	}
+	want := "empty=, foo=bar, var=a=b"
+	if got := e.String(); got != want {
+		t.Errorf("string mismatched! want %q, got %q", want, got)
+	}
+}
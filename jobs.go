@@ -0,0 +1,393 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+const mimeJson = "application/json"
+
+const (
+	// jobRetention is the time during which a finished job (and its log) can
+	// still be queried
+	jobRetention = time.Hour
+	// maxFinishedJob is the number of finished jobs kept by the queue: the
+	// oldest ones are removed first
+	maxFinishedJob = 100
+)
+
+var errJobNotFound = errors.New("job not found")
+
+type Job struct {
+	ID      string    `json:"id"`
+	Command string    `json:"command"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Exit    int       `json:"exit"`
+	Created time.Time `json:"created"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+
+	log    *jobLog
+	cancel context.CancelFunc
+}
+
+func (j *Job) finished() bool {
+	switch j.Status {
+	case JobDone, JobFailed, JobCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+type jobLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (j *jobLog) Write(b []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.buf.Write(b)
+}
+
+func (j *jobLog) Bytes() []byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]byte(nil), j.buf.Bytes()...)
+}
+
+// jobQueue executes the jobs with a pool: the jobs wait in the pending state
+// for a free worker. The jobs of the commands sharing the same lock are
+// executed one after the other instead of failing to acquire the lock held by
+// the job running.
+type jobQueue struct {
+	mst  *Maestro
+	pool *pool
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func createQueue(mst *Maestro, limit int) *jobQueue {
+	if limit <= 0 {
+		limit = maxParallelJob
+	}
+	pool, _ := createPool(context.Background(), limit)
+	pool.KeepGoing()
+	return &jobQueue{
+		mst:  mst,
+		pool: pool,
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Reload replaces the maestro file used to execute the jobs enqueued from now
+// on. The jobs already enqueued are executed with the previous one.
+func (q *jobQueue) Reload(mst *Maestro) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.mst = mst
+}
+
+func (q *jobQueue) Enqueue(name string, option ctreeOption) (Job, error) {
+	q.mu.Lock()
+	mst := q.mst
+	q.mu.Unlock()
+	cmd, err := mst.Commands.Lookup(name)
+	if err != nil {
+		return Job{}, fmt.Errorf("%w: %s", errNotFound, name)
+	}
+	id, err := jobID()
+	if err != nil {
+		return Job{}, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := Job{
+		ID:      id,
+		Command: name,
+		Status:  JobPending,
+		Created: mst.clock().Now(),
+		log:     new(jobLog),
+		cancel:  cancel,
+	}
+
+	q.mu.Lock()
+	q.prune(j.Created)
+	q.jobs[j.ID] = &j
+	q.mu.Unlock()
+
+	group := cmd.lockName()
+	if group != "" {
+		q.pool.Limit(group, 1)
+	}
+	go q.run(ctx, mst, &j, group, option)
+	return q.Get(j.ID)
+}
+
+func (q *jobQueue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", errJobNotFound, id)
+	}
+	return q.copy(j), nil
+}
+
+func (q *jobQueue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var list []Job
+	for _, j := range q.jobs {
+		list = append(list, q.copy(j))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+func (q *jobQueue) Log(id string) ([]byte, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errJobNotFound, id)
+	}
+	return j.log.Bytes(), nil
+}
+
+func (q *jobQueue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", errJobNotFound, id)
+	}
+	j.cancel()
+	return q.Get(id)
+}
+
+// Close cancels the jobs pending and running.
+func (q *jobQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		j.cancel()
+	}
+	q.pool.Cancel()
+	return nil
+}
+
+func (q *jobQueue) run(ctx context.Context, mst *Maestro, j *Job, group string, option ctreeOption) {
+	r := startRun(j.ID, j.Command, j.cancel, j.log)
+	err := q.pool.GoGroup(ctx, group, func() error {
+		defer r.Close()
+		q.update(j, func(j *Job) {
+			j.Status = JobRunning
+			j.Start = mst.clock().Now()
+		})
+		err := executeCommand(ctx, http.NoBody, j.log, j.log, j.Command, option, mst)
+		if err == nil {
+			err = ctx.Err()
+		}
+		q.finish(j, mst.clock().Now(), err)
+		// the error is given by the job: the pool keeps no trace of it
+		return nil
+	})
+	if err != nil {
+		r.Close()
+		q.finish(j, mst.clock().Now(), err)
+	}
+}
+
+func (q *jobQueue) finish(j *Job, end time.Time, err error) {
+	q.update(j, func(j *Job) {
+		j.End = end
+		j.Exit = exitCode(err)
+		switch {
+		case err == nil:
+			j.Status = JobDone
+		case errors.Is(err, context.Canceled):
+			j.Status = JobCancelled
+		default:
+			j.Status = JobFailed
+			j.Error = err.Error()
+		}
+	})
+}
+
+// prune removes the jobs finished for longer than jobRetention and the oldest
+// finished jobs beyond maxFinishedJob. It should be called with the lock held.
+func (q *jobQueue) prune(now time.Time) {
+	var done []*Job
+	for id, j := range q.jobs {
+		if !j.finished() {
+			continue
+		}
+		if now.Sub(j.End) >= jobRetention {
+			delete(q.jobs, id)
+			continue
+		}
+		done = append(done, j)
+	}
+	if len(done) <= maxFinishedJob {
+		return
+	}
+	sort.Slice(done, func(i, j int) bool {
+		return done[i].End.Before(done[j].End)
+	})
+	for _, j := range done[:len(done)-maxFinishedJob] {
+		delete(q.jobs, j.ID)
+	}
+}
+
+func (q *jobQueue) update(j *Job, fn func(*Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(j)
+}
+
+func (q *jobQueue) copy(j *Job) Job {
+	return Job{
+		ID:      j.ID,
+		Command: j.Command,
+		Status:  j.Status,
+		Error:   j.Error,
+		Exit:    j.Exit,
+		Created: j.Created,
+		Start:   j.Start,
+		End:     j.End,
+	}
+}
+
+func ServeJobs(mst *Maestro, queue *jobQueue) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var (
+			rest       = strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+			id, sub, _ = strings.Cut(rest, "/")
+		)
+		switch {
+		case r.Method == http.MethodPost:
+		case id == "":
+			if !checkAuth(w, r, mst, "") {
+				return
+			}
+		default:
+			// the jobs are protected by the tokens of their command and the
+			// unknown jobs by the tokens of .HTTP_TOKEN
+			j, err := queue.Get(id)
+			if !checkAuth(w, r, mst, j.Command) {
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		}
+		switch {
+		case r.Method == http.MethodPost && id != "" && sub == "":
+			enqueueJob(w, r, mst, queue, id)
+		case r.Method == http.MethodGet && id == "":
+			writeJson(w, http.StatusOK, authorizedJobs(r, mst, queue.List()))
+		case r.Method == http.MethodGet && sub == "":
+			j, err := queue.Get(id)
+			writeJob(w, http.StatusOK, j, err)
+		case r.Method == http.MethodGet && sub == "log":
+			buf, err := queue.Log(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.Write(buf)
+		case r.Method == http.MethodDelete && sub == "":
+			j, err := queue.Cancel(id)
+			writeJob(w, http.StatusOK, j, err)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// authorizedJobs gives the jobs of the commands that the token of the request
+// allows to execute.
+func authorizedJobs(r *http.Request, mst *Maestro, list []Job) []Job {
+	var (
+		token = requestToken(r)
+		jobs  = []Job{}
+	)
+	for _, j := range list {
+		var tokens []string
+		if cmd, err := mst.Commands.Lookup(j.Command); err == nil {
+			tokens = cmd.Tokens
+		}
+		if mst.MetaHttp.Authorized(token, tokens) {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+func enqueueJob(w http.ResponseWriter, r *http.Request, mst *Maestro, queue *jobQueue, name string) {
+	if !checkAuth(w, r, mst, name) {
+		return
+	}
+	if code, allow := checkMethod(mst, name, r.Method); code != 0 {
+		if len(allow) > 0 {
+			w.Header().Set(httpHdrAllow, strings.Join(allow, ", "))
+		}
+		w.WriteHeader(code)
+		return
+	}
+	j, err := queue.Enqueue(name, getOption(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set(httpHdrLocation, path.Join("/jobs", j.ID))
+	writeJson(w, http.StatusAccepted, j)
+}
+
+func writeJob(w http.ResponseWriter, code int, j Job, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJson(w, code, j)
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set(httpHdrContent, mimeJson)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func jobID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
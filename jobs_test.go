@@ -0,0 +1,265 @@
+package maestro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const jobsFile = `
+.HTTP_TOKEN = admin
+
+deploy(
+	tokens = secret,
+): {
+	true
+}
+
+build: {
+	true
+}
+`
+
+func TestJobsAuth(t *testing.T) {
+	d, err := NewDecoder(strings.NewReader(jobsFile))
+	if err != nil {
+		t.Fatalf("fail to create decoder: %s", err)
+	}
+	mst, err := d.Decode()
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	queue := createQueue(mst, 1)
+
+	var (
+		jobs = ServeJobs(mst, queue)
+		help = serveAuth(ServeHelp)(mst)
+	)
+	serve := func(h http.Handler, method, url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set(httpHdrAuth, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(jobs, http.MethodPost, "/jobs/deploy", "secret")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("enqueue: unexpected status code %d", rec.Code)
+	}
+	var j Job
+	if err := json.NewDecoder(rec.Body).Decode(&j); err != nil {
+		t.Fatalf("fail to decode job: %s", err)
+	}
+	tests := []struct {
+		Method string
+		URL    string
+		Token  string
+		Code   int
+	}{
+		{Method: http.MethodGet, URL: "/jobs/" + j.ID, Token: "admin", Code: http.StatusUnauthorized},
+		{Method: http.MethodGet, URL: "/jobs/" + j.ID + "/log", Token: "admin", Code: http.StatusUnauthorized},
+		{Method: http.MethodDelete, URL: "/jobs/" + j.ID, Token: "", Code: http.StatusUnauthorized},
+		{Method: http.MethodGet, URL: "/jobs/" + j.ID, Token: "secret", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/jobs/" + j.ID + "/log", Token: "secret", Code: http.StatusOK},
+		{Method: http.MethodGet, URL: "/jobs/unknown", Token: "admin", Code: http.StatusNotFound},
+		{Method: http.MethodGet, URL: "/jobs/unknown", Token: "", Code: http.StatusUnauthorized},
+		{Method: http.MethodDelete, URL: "/jobs/unknown", Token: "secret", Code: http.StatusUnauthorized},
+		{Method: http.MethodGet, URL: "/jobs", Token: "", Code: http.StatusUnauthorized},
+	}
+	for _, c := range tests {
+		rec := serve(jobs, c.Method, c.URL, c.Token)
+		if rec.Code != c.Code {
+			t.Errorf("%s %s: status code mismatched: want %d, got %d", c.Method, c.URL, c.Code, rec.Code)
+		}
+	}
+
+	rec = serve(jobs, http.MethodGet, "/jobs", "admin")
+	var list []Job
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("fail to decode jobs: %s", err)
+	}
+	if len(list) != 0 {
+		t.Errorf("jobs of deploy listed without its token")
+	}
+
+	if rec := serve(help, http.MethodGet, "/help", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("help: unexpected status code %d", rec.Code)
+	}
+	if rec := serve(help, http.MethodGet, "/help?command=deploy", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("help: unexpected status code %d", rec.Code)
+	}
+}
+
+func TestClose(t *testing.T) {
+	const file = `
+sleep: {
+	sleep 10
+}
+`
+	d, err := NewDecoder(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("fail to create decoder: %s", err)
+	}
+	mst, err := d.Decode()
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	var (
+		serve = make(chan error, 1)
+		exec  = make(chan error, 1)
+	)
+	go func() {
+		serve <- mst.ListenAndServe([]string{"-a", "127.0.0.1:0"})
+	}()
+	go func() {
+		exec <- mst.Execute("sleep", nil)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := mst.Close(); err != nil {
+		t.Errorf("fail to close: %s", err)
+	}
+	for _, c := range []struct {
+		Name string
+		Done chan error
+		Err  error
+	}{
+		{Name: "serve", Done: serve},
+		{Name: "execute", Done: exec, Err: context.Canceled},
+	} {
+		select {
+		case err := <-c.Done:
+			if c.Err == nil && err != nil || c.Err != nil && !errors.Is(err, c.Err) {
+				t.Errorf("%s: unexpected error: %v", c.Name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: not stopped after close", c.Name)
+		}
+	}
+	if err := mst.Close(); err != nil {
+		t.Errorf("closing twice should not fail: %s", err)
+	}
+}
+
+func TestJobsExit(t *testing.T) {
+	const file = `
+fail: {
+	exit 3
+}
+`
+	d, err := NewDecoder(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("fail to create decoder: %s", err)
+	}
+	mst, err := d.Decode()
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	queue := createQueue(mst, 1)
+	j, err := queue.Enqueue("fail", ctreeOption{})
+	if err != nil {
+		t.Fatalf("fail to enqueue job: %s", err)
+	}
+	for limit := time.Now().Add(5 * time.Second); !j.finished(); {
+		if time.Now().After(limit) {
+			t.Fatalf("job not finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+		j, _ = queue.Get(j.ID)
+	}
+	if j.Status != JobFailed || j.Exit != 3 {
+		t.Errorf("unexpected result: status %s, exit %d", j.Status, j.Exit)
+	}
+}
+
+func TestJobsPrune(t *testing.T) {
+	var (
+		queue = createQueue(nil, 1)
+		now   = time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	)
+	add := func(id, status string, end time.Time) {
+		queue.jobs[id] = &Job{ID: id, Status: status, End: end}
+	}
+	add("running", JobRunning, time.Time{})
+	add("pending", JobPending, time.Time{})
+	add("expired", JobDone, now.Add(-jobRetention))
+	for i := 0; i < maxFinishedJob+5; i++ {
+		add(fmt.Sprintf("done-%03d", i), JobFailed, now.Add(-time.Duration(maxFinishedJob+5-i)*time.Second))
+	}
+	queue.prune(now)
+
+	if len(queue.jobs) != maxFinishedJob+2 {
+		t.Errorf("jobs mismatched: want %d, got %d", maxFinishedJob+2, len(queue.jobs))
+	}
+	for _, id := range []string{"running", "pending", "done-005", fmt.Sprintf("done-%03d", maxFinishedJob+4)} {
+		if _, ok := queue.jobs[id]; !ok {
+			t.Errorf("%s: job removed", id)
+		}
+	}
+	for _, id := range []string{"expired", "done-000", "done-004"} {
+		if _, ok := queue.jobs[id]; ok {
+			t.Errorf("%s: job not removed", id)
+		}
+	}
+}
+
+func TestJobsLock(t *testing.T) {
+	const file = `
+deploy(
+	lock = true,
+): {
+	sleep 0.2
+}
+`
+	mst := decodeFile(t, file)
+	mst.File = filepath.Join(t.TempDir(), "maestro.mf")
+
+	var (
+		queue = createQueue(mst, 4)
+		ids   []string
+	)
+	for i := 0; i < 2; i++ {
+		j, err := queue.Enqueue("deploy", ctreeOption{})
+		if err != nil {
+			t.Fatalf("fail to enqueue job: %s", err)
+		}
+		ids = append(ids, j.ID)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var pending int
+	for _, id := range ids {
+		if j, _ := queue.Get(id); j.Status == JobPending {
+			pending++
+		}
+	}
+	if pending != 1 {
+		t.Errorf("one job should wait for the other one: %d pending", pending)
+	}
+	var jobs []Job
+	for _, id := range ids {
+		j, _ := queue.Get(id)
+		for limit := time.Now().Add(5 * time.Second); !j.finished(); {
+			if time.Now().After(limit) {
+				t.Fatalf("job not finished")
+			}
+			time.Sleep(10 * time.Millisecond)
+			j, _ = queue.Get(id)
+		}
+		if j.Status != JobDone {
+			t.Errorf("job should have succeeded: %s (%s)", j.Status, j.Error)
+		}
+		jobs = append(jobs, j)
+	}
+	if jobs[1].Start.Before(jobs[0].End) && jobs[0].Start.Before(jobs[1].End) {
+		t.Errorf("jobs executed at the same time")
+	}
+}
@@ -0,0 +1,58 @@
+package maestro
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// killChildren kills all the descendants of the current process found in
+// /proc, including the ones started in their own session (interactive
+// commands).
+func killChildren() {
+	var (
+		tree  = processTree()
+		queue = []int{os.Getpid()}
+	)
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, c := range tree[pid] {
+			if proc, err := os.FindProcess(c); err == nil {
+				proc.Kill()
+			}
+			queue = append(queue, c)
+		}
+	}
+}
+
+// processTree gives the pid of the children of each process found in /proc.
+func processTree() map[int][]int {
+	files, _ := filepath.Glob("/proc/[0-9]*/stat")
+	tree := make(map[int][]int)
+	for _, f := range files {
+		buf, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		// the name of the process is between parenthesis and can contain
+		// spaces: the fields are read after the closing one
+		str := string(buf)
+		x := strings.LastIndexByte(str, ')')
+		if x < 0 {
+			continue
+		}
+		fields := strings.Fields(str[x+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(filepath.Base(filepath.Dir(f)))
+		ppid, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		tree[ppid] = append(tree[ppid], pid)
+	}
+	return tree
+}
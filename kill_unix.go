@@ -0,0 +1,22 @@
+//go:build !linux && !windows
+
+package maestro
+
+import (
+	"os"
+	"syscall"
+)
+
+// killChildren kills the process group of maestro when maestro leads it (eg:
+// when it is started by an interactive shell). Its children are in this group
+// unless they start their own: maestro is then killed with them and exits
+// with the status of SIGKILL (the same as ExitForced). Nothing is killed when
+// maestro does not lead its process group since the group includes the
+// processes that have started maestro.
+func killChildren() {
+	pid := os.Getpid()
+	if syscall.Getpgrp() != pid {
+		return
+	}
+	syscall.Kill(-pid, syscall.SIGKILL)
+}
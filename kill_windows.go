@@ -0,0 +1,6 @@
+package maestro
+
+// killChildren does nothing: the descendants of maestro can not be found. The
+// processes started by the scripts are however killed when the commands are
+// cancelled by the first interrupt.
+func killChildren() {}
@@ -0,0 +1,223 @@
+package maestro
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const shellcheck = "shellcheck"
+
+type LintMessage struct {
+	File    string
+	Line    int
+	Command string
+	Message string
+}
+
+func (m LintMessage) String() string {
+	if m.Line <= 0 {
+		return fmt.Sprintf("%s: %s: %s", m.File, m.Command, m.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", m.File, m.Line, m.Command, m.Message)
+}
+
+type lintFunc func(CommandSettings) []LintMessage
+
+type linter struct {
+	file     string
+	commands Registry
+	builtin  func(string) bool
+	external bool
+}
+
+func (l linter) Lint() []LintMessage {
+	var list []LintMessage
+	for _, c := range l.commands {
+		for _, fn := range l.checks() {
+			list = append(list, fn(c)...)
+		}
+	}
+	for i := range list {
+		if list[i].File == "" {
+			list[i].File = l.file
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].File != list[j].File {
+			return list[i].File < list[j].File
+		}
+		if list[i].Line == list[j].Line {
+			return list[i].Command < list[j].Command
+		}
+		return list[i].Line < list[j].Line
+	})
+	return list
+}
+
+// commandMessage gives a message reported at the position of the command.
+func commandMessage(cmd CommandSettings, str string) LintMessage {
+	return LintMessage{
+		File:    cmd.Position.File,
+		Line:    cmd.Position.Line,
+		Command: cmd.Name,
+		Message: str,
+	}
+}
+
+// lineMessage gives a message reported at the position of the line of the
+// script of the command (line 0 in the file of the command if it is unknown).
+func lineMessage(cmd CommandSettings, line int, str string) LintMessage {
+	msg := commandMessage(cmd, str)
+	if line >= 0 && line < len(cmd.Positions) {
+		msg.File, msg.Line = cmd.Positions[line].File, cmd.Positions[line].Line
+	} else {
+		msg.Line = 0
+	}
+	return msg
+}
+
+func (l linter) checks() []lintFunc {
+	list := []lintFunc{
+		l.lintDependencies,
+		l.lintBuiltins,
+		lintEmptyScript,
+		lintBackticks,
+		lintPositionals,
+		lintUnsafeRemove,
+	}
+	if l.external {
+		if _, err := exec.LookPath(shellcheck); err == nil {
+			list = append(list, lintShellcheck)
+		}
+	}
+	return list
+}
+
+func (l linter) lintDependencies(cmd CommandSettings) []LintMessage {
+	var list []LintMessage
+	var deps []CommandDep
+	for _, d := range cmd.Deps {
+		deps = append(append(deps, d), d.Pipe...)
+	}
+	for _, d := range deps {
+		if _, err := l.commands.Lookup(d.Key()); err == nil || d.Optional {
+			continue
+		}
+		list = append(list, commandMessage(cmd, fmt.Sprintf("dependency %s is not defined", d.Key())))
+	}
+	return list
+}
+
+func (l linter) lintBuiltins(cmd CommandSettings) []LintMessage {
+	if l.builtin == nil {
+		return nil
+	}
+	var list []LintMessage
+	for _, n := range append([]string{cmd.Name}, cmd.Alias...) {
+		if !l.builtin(n) {
+			continue
+		}
+		what := "command"
+		if n != cmd.Name {
+			what = "alias " + n
+		}
+		list = append(list, commandMessage(cmd, fmt.Sprintf("%s hidden by the sub-command of maestro (use run %s or .NO_BUILTINS)", what, n)))
+	}
+	return list
+}
+
+func lintEmptyScript(cmd CommandSettings) []LintMessage {
+	if len(cmd.Lines) > 0 {
+		return nil
+	}
+	return []LintMessage{commandMessage(cmd, "command has an empty script")}
+}
+
+func lintBackticks(cmd CommandSettings) []LintMessage {
+	return lintLines(cmd, func(line string) string {
+		if strings.Count(line, "`") < 2 {
+			return ""
+		}
+		return "use $(...) instead of backticks for command substitution"
+	})
+}
+
+var positional = regexp.MustCompile(`\$\{?([1-9][0-9]*)`)
+
+func lintPositionals(cmd CommandSettings) []LintMessage {
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+	return lintLines(cmd, func(line string) string {
+		for _, m := range positional.FindAllStringSubmatch(line, -1) {
+			n, _ := strconv.Atoi(m[1])
+			if n > len(cmd.Args) {
+				return fmt.Sprintf("$%d is used but command only declares %d argument(s)", n, len(cmd.Args))
+			}
+		}
+		return ""
+	})
+}
+
+var unsafeRemove = regexp.MustCompile(`\brm\s+(-[a-zA-Z]*[rR][a-zA-Z]*\s+)+\$\{?[a-zA-Z_][a-zA-Z0-9_]*\}?/`)
+
+func lintUnsafeRemove(cmd CommandSettings) []LintMessage {
+	return lintLines(cmd, func(line string) string {
+		if !unsafeRemove.MatchString(line) {
+			return ""
+		}
+		return "recursive rm with an unchecked variable prefix can remove unexpected files if the variable is empty"
+	})
+}
+
+var shellcheckLine = regexp.MustCompile(`^-:(\d+):\d+: (.*)$`)
+
+func lintShellcheck(cmd CommandSettings) []LintMessage {
+	if len(cmd.Lines) == 0 {
+		return nil
+	}
+	var (
+		out bytes.Buffer
+		exe = exec.Command(shellcheck, "-s", "bash", "-f", "gcc", "-")
+	)
+	exe.Stdin = io.MultiReader(strings.NewReader("#!/bin/bash\n"), cmd.Lines.Reader())
+	exe.Stdout = &out
+	exe.Run()
+	return parseShellcheck(cmd, &out)
+}
+
+// parseShellcheck gives the messages of shellcheck (in gcc format) at the
+// position of the lines of the script of the command.
+func parseShellcheck(cmd CommandSettings, r io.Reader) []LintMessage {
+	var (
+		list []LintMessage
+		scan = bufio.NewScanner(r)
+	)
+	for scan.Scan() {
+		parts := shellcheckLine.FindStringSubmatch(scan.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		n, _ := strconv.Atoi(parts[1])
+		// first line is the shebang added above
+		list = append(list, lineMessage(cmd, n-2, parts[2]))
+	}
+	return list
+}
+
+func lintLines(cmd CommandSettings, check func(string) string) []LintMessage {
+	var list []LintMessage
+	for i, line := range cmd.Lines {
+		if str := check(line); str != "" {
+			list = append(list, lineMessage(cmd, i, str))
+		}
+	}
+	return list
+}
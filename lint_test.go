@@ -0,0 +1,71 @@
+package maestro
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseShellcheck(t *testing.T) {
+	cmd := CommandSettings{
+		Name: "child",
+		Lines: CommandScript{
+			"echo $1",
+			"cd $dir",
+			"echo `date`",
+		},
+		Position: Position{Line: 3, File: "maestro.mf"},
+		Positions: []Position{
+			{Line: 2, File: "base.mf"},
+			{Line: 3, File: "base.mf"},
+			{Line: 4, File: "maestro.mf"},
+		},
+	}
+	out := `-:2:6: warning: $1 is unset [SC2154]
+-:3:4: warning: Use 'cd ... || exit' in case cd fails. [SC2164]
+not a message
+-:4:6: note: Use $(...) notation instead of legacy backticks. [SC2006]
+-:9:1: error: unexpected line [SC1000]
+`
+	want := []LintMessage{
+		{File: "base.mf", Line: 2, Command: "child", Message: "warning: $1 is unset [SC2154]"},
+		{File: "base.mf", Line: 3, Command: "child", Message: "warning: Use 'cd ... || exit' in case cd fails. [SC2164]"},
+		{File: "maestro.mf", Line: 4, Command: "child", Message: "note: Use $(...) notation instead of legacy backticks. [SC2006]"},
+		{File: "maestro.mf", Line: 0, Command: "child", Message: "error: unexpected line [SC1000]"},
+	}
+	got := parseShellcheck(cmd, strings.NewReader(out))
+	if len(got) != len(want) {
+		t.Fatalf("messages mismatched: want %d, got %d (%v)", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d: message mismatched: want %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestLintInclude(t *testing.T) {
+	mst := New()
+	if err := mst.Load("testdata/lint/maestro.mf"); err != nil {
+		t.Fatalf("fail to load file: %s", err)
+	}
+	lint := linter{
+		file:     mst.File,
+		commands: mst.Commands,
+		builtin:  func(string) bool { return false },
+	}
+	want := []string{
+		"base.mf:3: base: use $(...) instead of backticks for command substitution",
+		"base.mf:3: child: use $(...) instead of backticks for command substitution",
+		"maestro.mf:3: child: dependency missing is not defined",
+		"maestro.mf:4: child: use $(...) instead of backticks for command substitution",
+	}
+	var got []string
+	for _, msg := range lint.Lint() {
+		msg.File = filepath.Base(msg.File)
+		got = append(got, msg.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("messages mismatched:\nwant: %q\ngot:  %q", want, got)
+	}
+}
@@ -0,0 +1,52 @@
+package maestro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+func (m *Maestro) ListCommands() error {
+	return m.listCommands(stdio.Stdout, m.Format)
+}
+
+func (m *Maestro) listCommands(w io.Writer, format string) error {
+	list := m.HelpFile().Commands
+	switch format {
+	case FormatJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case "", FormatText:
+		var icons bool
+		for _, c := range list {
+			icons = icons || c.Icon != ""
+		}
+		tw := tabwriter.NewWriter(w, 12, 2, 2, ' ', 0)
+		for _, c := range list {
+			if icons {
+				fmt.Fprintf(tw, "%s\t", c.Icon)
+			}
+			fmt.Fprintf(tw, "%s\t%s", c.Name, c.Short)
+			fmt.Fprintln(tw)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("%s: unsupported output format", format)
+	}
+}
+
+func ServeCommands(mst *Maestro) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJson(w, http.StatusOK, mst.HelpFile().Commands)
+	}
+	return http.HandlerFunc(fn)
+}
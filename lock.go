@@ -0,0 +1,125 @@
+package maestro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	lockDir   = "locks"
+	lockExt   = ".lock"
+	lockDelay = 100 * time.Millisecond
+)
+
+var errLocked = errors.New("locked")
+
+// lockCommand prevents two instances of maestro from executing the command at
+// the same time on the same machine. The lock is the advisory lock of a file
+// of the state directory: it is released by the system when the process
+// holding it stops, even if it is killed. The file contains the pid of the
+// process holding the lock and is never removed so that all the processes
+// lock the same file.
+type lockCommand struct {
+	Executer
+
+	file    string
+	timeout time.Duration
+}
+
+func (m *Maestro) lock(ex Executer, cmd CommandSettings) Executer {
+	return &lockCommand{
+		Executer: ex,
+		file:     filepath.Join(m.stateDir(), lockDir, cmd.lockName()+lockExt),
+		timeout:  m.LockTimeout,
+	}
+}
+
+// lockName gives the name of the lock of the command (empty without lock).
+func (s CommandSettings) lockName() string {
+	if b, err := strconv.ParseBool(s.Lock); err == nil && b {
+		return s.Command()
+	}
+	return s.Lock
+}
+
+func (c *lockCommand) Execute(ctx context.Context, args []string) error {
+	f, err := c.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Command(), err)
+	}
+	defer f.Close()
+	defer unlockFile(f)
+	return c.Executer.Execute(ctx, args)
+}
+
+// acquire waits at most timeout for the lock to be released by the process
+// holding it.
+func (c *lockCommand) acquire(ctx context.Context) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(c.file), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(c.file, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		timer = time.NewTimer(c.timeout)
+		tick  = time.NewTicker(lockDelay)
+	)
+	defer timer.Stop()
+	defer tick.Stop()
+	for {
+		err := c.tryLock(f)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, errLocked) {
+			f.Close()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-timer.C:
+			f.Close()
+			if pid := c.holder(); pid > 0 {
+				return nil, fmt.Errorf("locked by process %d (%s)", pid, c.file)
+			}
+			return nil, fmt.Errorf("%w (%s)", errLocked, c.file)
+		case <-tick.C:
+		}
+	}
+}
+
+// tryLock takes the lock of f and writes the pid of the process in it.
+func (c *lockCommand) tryLock(f *os.File) error {
+	if err := lockFile(f, false); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		unlockFile(f)
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		unlockFile(f)
+		return err
+	}
+	return nil
+}
+
+// holder gives the pid of the process holding the lock.
+func (c *lockCommand) holder() int {
+	buf, err := os.ReadFile(c.file)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf)))
+	return pid
+}
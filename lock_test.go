@@ -0,0 +1,54 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockCommand(t *testing.T) {
+	mst := decodeFile(t, "build: {\n\ttrue\n}\n")
+	cmd, err := mst.Commands.Lookup("build")
+	if err != nil {
+		t.Fatalf("build not decoded: %s", err)
+	}
+	ex, err := cmd.Prepare()
+	if err != nil {
+		t.Fatalf("fail to prepare build: %s", err)
+	}
+	var (
+		file = filepath.Join(t.TempDir(), "locks", "build.lock")
+		lock = lockCommand{Executer: ex, file: file}
+		ctx  = context.Background()
+	)
+	f, err := lock.acquire(ctx)
+	if err != nil {
+		t.Fatalf("fail to acquire lock: %s", err)
+	}
+	buf, _ := os.ReadFile(file)
+	if want := fmt.Sprint(os.Getpid()); strings.TrimSpace(string(buf)) != want {
+		t.Errorf("pid mismatched! want %s, got %q", want, buf)
+	}
+
+	other := lockCommand{Executer: ex, file: file, timeout: 3 * lockDelay}
+	err = other.Execute(ctx, nil)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("locked by process %d", os.Getpid())) {
+		t.Fatalf("build should have been locked: %v", err)
+	}
+	go func() {
+		time.Sleep(lockDelay)
+		unlockFile(f)
+		f.Close()
+	}()
+	other.timeout = time.Second
+	if err := other.Execute(ctx, nil); err != nil {
+		t.Fatalf("build should have been executed once the lock released: %s", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("lock file should be kept: %s", err)
+	}
+}
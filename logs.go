@@ -0,0 +1,239 @@
+package maestro
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+const (
+	logExt        = ".log"
+	logTimeFormat = "20060102T150405.000"
+
+	// defaults of the rotation of the log files of a command
+	DefaultLogMaxSize = 10 << 20
+	DefaultLogMaxAge  = 7 * 24 * time.Hour
+)
+
+func (m *Maestro) logDir() string {
+	if m.Options.LogDir != "" {
+		return m.Options.LogDir
+	}
+	return m.MetaExec.LogDir
+}
+
+// Logs prints the content of the last log file of a command or, with -l, the
+// list of its log files.
+func (m *Maestro) Logs(args []string) error {
+	var (
+		set  = flag.NewFlagSet(CmdLogs, flag.ExitOnError)
+		list = set.Bool("l", false, "list the log files of the command")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	dir := m.logDir()
+	if dir == "" {
+		return fmt.Errorf("logs: log directory not configured (.LOGDIR or --log-dir)")
+	}
+	name := set.Arg(0)
+	if name == "" {
+		name = m.MetaExec.Default
+	}
+	cmd, err := m.Commands.Lookup(name)
+	if err != nil {
+		return m.suggest(err, name)
+	}
+	files, err := logFiles(filepath.Join(dir, cmd.Command()))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s: no log files found", name)
+	}
+	if *list {
+		tw := tabwriter.NewWriter(stdio.Stdout, 12, 2, 2, ' ', 0)
+		for i := len(files) - 1; i >= 0; i-- {
+			f := files[i]
+			fmt.Fprintf(tw, "%s\t%s\t%d", f.When.Format(time.RFC3339), f.Path, f.Size)
+			fmt.Fprintln(tw)
+		}
+		return tw.Flush()
+	}
+	r, err := os.Open(files[len(files)-1].Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(stdio.Stdout, r)
+	return err
+}
+
+func (m *Maestro) logs(ex Executer, cmd CommandSettings) Executer {
+	rotate := logRotation{
+		MaxSize: m.MetaExec.LogMaxSize,
+		MaxAge:  m.MetaExec.LogMaxAge,
+	}
+	if rotate.MaxSize <= 0 {
+		rotate.MaxSize = DefaultLogMaxSize
+	}
+	if rotate.MaxAge <= 0 {
+		rotate.MaxAge = DefaultLogMaxAge
+	}
+	return &logCommand{
+		Executer: ex,
+		dir:      filepath.Join(m.logDir(), cmd.Command()),
+		rotate:   rotate,
+		stdout:   io.Discard,
+		stderr:   io.Discard,
+	}
+}
+
+// logCommand writes the output of a command into a new file of the log
+// directory of the command each time it is executed. The older files are
+// removed once the command is done.
+type logCommand struct {
+	Executer
+	dir    string
+	rotate logRotation
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *logCommand) SetOut(w io.Writer) {
+	c.stdout = w
+	c.Executer.SetOut(w)
+}
+
+func (c *logCommand) SetErr(w io.Writer) {
+	c.stderr = w
+	c.Executer.SetErr(w)
+}
+
+func (c *logCommand) Execute(ctx context.Context, args []string) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	now := clockFrom(ctx).Now()
+	file := filepath.Join(c.dir, now.Format(logTimeFormat)+logExt)
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	out := stdio.Lock(f)
+	c.Executer.SetOut(teeWriter(c.stdout, out))
+	c.Executer.SetErr(teeWriter(c.stderr, out))
+	err1 := c.Executer.Execute(ctx, args)
+
+	c.Executer.SetOut(c.stdout)
+	c.Executer.SetErr(c.stderr)
+	err2 := f.Close()
+	err3 := c.rotate.Rotate(c.dir, clockFrom(ctx).Now())
+	return hasError(err1, err2, err3)
+}
+
+type logWriter struct {
+	io.Writer
+	file io.Writer
+}
+
+func teeWriter(w, file io.Writer) io.Writer {
+	return &logWriter{
+		Writer: w,
+		file:   file,
+	}
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	w.file.Write(b)
+	return w.Writer.Write(b)
+}
+
+func (w *logWriter) SetPrefix(prefix string) {
+	setPrefix(w.Writer, prefix)
+}
+
+// logRotation removes the log files older than MaxAge and then the oldest
+// files until the total size of the files is lower than MaxSize. The last
+// log file is always kept.
+type logRotation struct {
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+func (r logRotation) Rotate(dir string, now time.Time) error {
+	files, err := logFiles(dir)
+	if err != nil || len(files) <= 1 {
+		return err
+	}
+	var (
+		last  = len(files) - 1
+		limit = now.Add(-r.MaxAge)
+		total int64
+	)
+	for _, f := range files {
+		total += f.Size
+	}
+	for i := 0; i < last; i++ {
+		f := files[i]
+		if !f.When.Before(limit) && total <= r.MaxSize {
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil {
+			return err
+		}
+		total -= f.Size
+	}
+	return nil
+}
+
+type logFile struct {
+	Path string
+	When time.Time
+	Size int64
+}
+
+// logFiles gives the log files found in dir from the oldest to the newest.
+func logFiles(dir string) ([]logFile, error) {
+	es, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	var list []logFile
+	for _, e := range es {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != logExt {
+			continue
+		}
+		when, err := time.ParseInLocation(logTimeFormat, strings.TrimSuffix(name, logExt), time.Local)
+		if err != nil {
+			continue
+		}
+		i, err := e.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, logFile{
+			Path: filepath.Join(dir, name),
+			When: when,
+			Size: i.Size(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].When.Before(list[j].When)
+	})
+	return list, nil
+}
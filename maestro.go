@@ -3,26 +3,32 @@ package maestro
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/midbel/distance"
+	"github.com/midbel/maestro/help"
 	"github.com/midbel/maestro/internal/env"
-	"github.com/midbel/maestro/internal/help"
 	"github.com/midbel/maestro/internal/stdio"
+	"github.com/midbel/shlex"
 	"github.com/midbel/tish"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/sync/semaphore"
 )
 
 const (
@@ -34,27 +40,157 @@ const (
 	CmdServe    = "serve"
 	CmdGraph    = "graph"
 	CmdSchedule = "schedule"
+	CmdSelfTest = "selftest"
+	CmdLint     = "lint"
+	CmdWatch    = "watch"
+	CmdAffected = "affected"
+	CmdExport   = "export"
+	CmdImport   = "import"
+	CmdValidate = "validate"
+	CmdDoc      = "doc"
+	CmdEnv      = "env"
+	CmdStatus   = "status"
+	CmdCancel   = "cancel"
+	CmdRerun    = "rerun"
+	CmdLast     = "last"
+	CmdLogs     = "logs"
+	CmdHistory  = "history"
+	CmdRun      = "run"
 )
 
+var builtins = []string{
+	CmdHelp,
+	CmdVersion,
+	CmdAll,
+	CmdDefault,
+	CmdListen,
+	CmdServe,
+	CmdGraph,
+	CmdSchedule,
+	CmdSelfTest,
+	CmdLint,
+	CmdWatch,
+	CmdAffected,
+	CmdExport,
+	CmdImport,
+	CmdValidate,
+	CmdDoc,
+	CmdEnv,
+	CmdStatus,
+	CmdCancel,
+	CmdRerun,
+	CmdLast,
+	CmdLogs,
+	CmdHistory,
+	CmdRun,
+}
+
+// checkNoBuiltin checks that name is a sub-command of maestro that can be
+// disabled. run can not be as it is the way to execute the commands hidden by
+// the sub-commands and import is executed before the file is loaded.
+func checkNoBuiltin(name string) error {
+	switch {
+	case name == CmdRun || name == CmdImport:
+		return fmt.Errorf("%s: sub-command can not be disabled", name)
+	case !isSubcommand(name):
+		return fmt.Errorf("%s: not a sub-command of maestro", name)
+	default:
+		return nil
+	}
+}
+
+func isSubcommand(name string) bool {
+	for _, b := range builtins {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
 const (
 	DefaultFile     = "maestro.mf"
 	DefaultVersion  = "0.1.0"
 	DefaultHttpAddr = ":9090"
+	DefaultStateDir = ".maestro"
 )
 
+// Options are the settings of maestro given on the command line (or by the
+// program embedding maestro). They are given as is to the new version of
+// maestro created when the file is loaded again (see reload).
+type Options struct {
+	Includes Dirs
+
+	Remote     bool
+	NoDeps     bool
+	WithPrefix bool
+	Format     string
+	Drift      bool
+	Force      bool
+	NoInput    bool
+	Yes        bool
+
+	// print the plan of the execution and ask for its confirmation before
+	// executing it
+	ConfirmPlan bool
+
+	// execute all the commands (of .ALL, the dependencies, the hosts) even if
+	// some of them fail and report all the failures at the end
+	KeepGoing bool
+	// number of commands of .ALL executed at the same time. It takes
+	// precedence over .ALL_PARALLEL
+	Parallel int64
+
+	// colorize the output of the commands (auto, always, never)
+	Color string
+	// do not render the progress of the commands running concurrently
+	NoProgress bool
+	// format of the trace lines (compact, long, json)
+	TraceFormat string
+	// file (and format) where the report of the execution is written
+	Report       string
+	ReportFormat string
+
+	// directory of the log files of the commands (replace .LOGDIR)
+	LogDir string
+
+	// time to wait for the lock of a command held by another process
+	LockTimeout time.Duration
+
+	// warn about (or refuse) the maestro files that can be modified by other
+	// users (warn, refuse)
+	CheckPerms string
+
+	// Renderer formats the output of the help sub-command (help.Text when nil)
+	Renderer help.Renderer
+	// Clock gives the time of the executions (the system clock when nil)
+	Clock Clock
+}
+
 type Maestro struct {
 	MetaExec
 	MetaAbout
 	MetaSSH
 	MetaHttp
+	MetaSMTP
+
+	Options
 
-	Includes Dirs
 	Locals   *env.Env
 	Commands Registry
-
-	Remote     bool
-	NoDeps     bool
-	WithPrefix bool
+	Presets  map[string]Preset
+	// messages of the print statements written when a command is executed
+	Messages []string
+
+	// variables defined before the maestro file is loaded (see reload)
+	defines *env.Env
+	// commands (and dependencies) already executed by the previous commands
+	// given on the command line (see ExecuteCommands)
+	executed map[string]struct{}
+	// outputs of the commands shared by the commands given on the command line
+	outputs *outputs
+	// resources released by Close
+	closers *closers
 }
 
 func New() *Maestro {
@@ -70,20 +206,53 @@ func New() *Maestro {
 		MetaAbout: about,
 		MetaHttp:  mhttp,
 		Commands:  make(Registry),
+		closers:   createClosers(),
 	}
 }
 
+// Close releases the resources still in use by maestro: the servers of serve
+// are shut down, the watchers are stopped and the commands still running are
+// cancelled. Closing maestro twice has no effect.
+func (m *Maestro) Close() error {
+	return m.closers.Close()
+}
+
+// onClose registers a resource released by Close. The returned function has
+// to be called once the resource has been released by its owner.
+func (m *Maestro) onClose(c io.Closer) func() {
+	return m.closers.Add(c)
+}
+
 func (m *Maestro) Name() string {
 	return strings.TrimSuffix(filepath.Base(m.File), filepath.Ext(m.File))
 }
 
+func (m *Maestro) interactive() bool {
+	return !m.NoInput && isTerminal(os.Stdin)
+}
+
+func (m *Maestro) stateDir() string {
+	return filepath.Join(filepath.Dir(m.File), DefaultStateDir)
+}
+
 func (m *Maestro) Load(file string) error {
+	if err := m.checkPermissions(file); err != nil {
+		return err
+	}
 	r, err := os.Open(file)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
+	if m.defines == nil {
+		if err := checkDefines(m.Locals); err != nil {
+			return err
+		}
+		m.defines = m.Locals.Copy()
+	}
+	registerGit(m.Locals, filepath.Dir(file))
+	registerFile(m.Locals, file)
 	d, err := NewDecoderWithEnv(r, m.Locals)
 	if err != nil {
 		return err
@@ -91,6 +260,9 @@ func (m *Maestro) Load(file string) error {
 	if err := d.decode(m); err != nil {
 		return err
 	}
+	if err := m.checkPresets(); err != nil {
+		return err
+	}
 	m.MetaAbout.File = file
 	return nil
 }
@@ -108,15 +280,51 @@ func (m *Maestro) ListenAndServe(args []string) error {
 	var (
 		set  = flag.NewFlagSet(CmdServe, flag.ExitOnError)
 		addr = set.String("a", m.MetaHttp.Addr, "listening address")
+		file = set.String("l", "", "file where the messages of the server are written")
 	)
 	if err := set.Parse(args); err != nil {
 		return err
 	}
-	setupRoutes(m)
+	logger := stdio.Stderr
+	if *file != "" {
+		f, err := openServerLog(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		logger = f
+	}
+	// the jobs never read the terminal of maestro. The settings of m are not
+	// modified since m can still execute commands while it serves.
+	srv := *m
+	srv.NoInput = true
+	var (
+		queue   = createQueue(&srv, maxParallelJob)
+		handler = createReloader(setupRoutes(&srv, queue))
+	)
+	stop := m.reloadOnHangup(logger, func(x *Maestro) {
+		x.NoInput = true
+		queue.Reload(x)
+		handler.Reload(setupRoutes(x, queue))
+	})
+	defer stop()
+
 	server := http.Server{
-		Addr: *addr,
+		Addr:     *addr,
+		Handler:  handler,
+		ErrorLog: log.New(logger, "", log.LstdFlags),
+	}
+	defer m.onClose(queue)()
+	defer m.onClose(closerFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}))()
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
 	}
-	return server.ListenAndServe()
+	return err
 }
 
 func (m *Maestro) Graph(name string) error {
@@ -156,7 +364,18 @@ func (m *Maestro) Schedule(args []string) error {
 
 func (m *Maestro) schedule(args []string, stdout, stderr io.Writer) error {
 	sort.Strings(args)
-	grp, ctx := errgroup.WithContext(interruptContext())
+	parent, stop := interruptContext()
+	defer stop()
+	parent, cancel := context.WithCancel(withClock(parent, m.clock()))
+	defer cancel()
+	defer m.onClose(closerFunc(func() error {
+		cancel()
+		return nil
+	}))()
+	var (
+		brk      = createBreaker(m.MetaExec.MaxFailures, cancel)
+		grp, ctx = errgroup.WithContext(parent)
+	)
 	for _, c := range m.Commands {
 		var (
 			x = sort.SearchStrings(args, c.Name)
@@ -167,15 +386,20 @@ func (m *Maestro) schedule(args []string, stdout, stderr io.Writer) error {
 		}
 		for i := range c.Schedules {
 			var (
-				c = scheduleContext(c, m.WithPrefix, m.Trace)
+				c = scheduleContext(c, m.WithPrefix, m.Trace, brk)
 				e = c.Schedules[i]
 			)
+			c.notifier = m.notifier(c.Name, e.Notify...)
 			grp.Go(func() error {
 				return e.Run(ctx, m.Commands.Copy(), c, stdout, stderr)
 			})
 		}
 	}
-	return grp.Wait()
+	err := grp.Wait()
+	if e := brk.Err(); e != nil {
+		err = e
+	}
+	return err
 }
 
 func (m *Maestro) scheduleList(args []string, limit int) error {
@@ -188,9 +412,10 @@ func (m *Maestro) scheduleList(args []string, limit int) error {
 }
 
 func (m *Maestro) showScheduleShort(args []string) {
-	now := time.Now()
+	now := m.clock().Now()
 	for _, c := range m.getCommandByNames(args) {
 		for _, s := range c.Schedules {
+			s.Sched.SetClock(m.clock())
 			var wait time.Duration
 			for wait <= 0 {
 				next := s.Sched.Next()
@@ -205,6 +430,7 @@ func (m *Maestro) showScheduleShort(args []string) {
 func (m *Maestro) showScheduleLong(args []string, limit int) {
 	for _, c := range m.getCommandByNames(args) {
 		for _, s := range c.Schedules {
+			s.Sched.SetClock(m.clock())
 			fmt.Fprintln(stdio.Stdout, "*", c.Command())
 			prefix := "next"
 			for i := 0; i < limit; i++ {
@@ -237,7 +463,9 @@ func (m *Maestro) getCommandByNames(names []string) []CommandSettings {
 }
 
 func (m *Maestro) Dry(name string, args []string) error {
-	cmd, err := m.setup(interruptContext(), name, true)
+	ctx, stop := interruptContext()
+	defer stop()
+	cmd, err := m.setup(ctx, name, true)
 	if err != nil {
 		return err
 	}
@@ -246,6 +474,154 @@ func (m *Maestro) Dry(name string, args []string) error {
 	return cmd.Dry(args)
 }
 
+func (m *Maestro) Lint(args []string) error {
+	var (
+		set      = flag.NewFlagSet(CmdLint, flag.ExitOnError)
+		internal = set.Bool("i", false, "only run internal checks (skip shellcheck)")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	lint := linter{
+		file:     m.File,
+		commands: m.Commands,
+		builtin:  m.Builtin,
+		external: !*internal,
+	}
+	list := lint.Lint()
+	for _, msg := range list {
+		fmt.Fprintln(stdio.Stdout, msg)
+	}
+	if len(list) > 0 {
+		return fmt.Errorf("lint: %d problem(s) found", len(list))
+	}
+	return nil
+}
+
+// Validate checks that the given arguments would be accepted by a command
+// without executing it and prints the values of its options and arguments.
+func (m *Maestro) Validate(args []string) error {
+	var (
+		set   = flag.NewFlagSet(CmdValidate, flag.ExitOnError)
+		quiet = set.Bool("q", false, "only report errors")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	name := set.Arg(0)
+	if name == "" {
+		name = m.MetaExec.Default
+	}
+	var rest []string
+	if set.NArg() > 1 {
+		rest = set.Args()[1:]
+	}
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	name, rest = m.expand(name, rest)
+	cmd, err := m.Commands.Lookup(name)
+	if err != nil {
+		return m.suggest(err, name)
+	}
+	x, err := cmd.Prepare()
+	if err != nil {
+		return err
+	}
+	c, ok := x.(*command)
+	if !ok {
+		return fmt.Errorf("%s: command can not be validated", name)
+	}
+	rest, err = c.parseArgs(rest)
+	if err != nil {
+		return fmt.Errorf("%s: invalid arguments: %w", name, err)
+	}
+	if *quiet {
+		return nil
+	}
+	var values [][2]string
+	for _, o := range c.options {
+		var str string
+		switch {
+		case o.Flag:
+			str = strconv.FormatBool(o.TargetFlag)
+		case o.Sensitive && o.Target != "":
+			str = secretMask
+		default:
+			str = o.Target
+		}
+		values = append(values, [2]string{o.Name(), str})
+	}
+	for i, a := range rest {
+		key := fmt.Sprintf("$%d", i+1)
+		if i < len(c.args) {
+			key = fmt.Sprintf("<%s>", c.args[i].Name)
+		}
+		values = append(values, [2]string{key, a})
+	}
+	var size int
+	for _, v := range values {
+		if len(v[0]) > size {
+			size = len(v[0])
+		}
+	}
+	fmt.Fprintf(stdio.Stdout, "%s: ok", name)
+	fmt.Fprintln(stdio.Stdout)
+	for _, v := range values {
+		fmt.Fprintf(stdio.Stdout, "  %-*s = %s", size, v[0], v[1])
+		fmt.Fprintln(stdio.Stdout)
+	}
+	return nil
+}
+
+func (m *Maestro) SelfTest(args []string) error {
+	var (
+		report tapReport
+		failed int
+	)
+	list := m.getCommandByNames(args)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	fmt.Fprintln(stdio.Stdout, "TAP version 13")
+	for _, c := range list {
+		if !c.Testable && len(c.Examples) == 0 {
+			continue
+		}
+		examples := c.Examples
+		if len(examples) == 0 {
+			examples = append(examples, "")
+		}
+		for _, e := range examples {
+			err := m.selftest(c.Name, e)
+			if err != nil {
+				failed++
+			}
+			name := strings.TrimSpace(fmt.Sprintf("%s %s", c.Name, e))
+			report.Report(stdio.Stdout, name, err)
+		}
+	}
+	report.Plan(stdio.Stdout)
+	if failed > 0 {
+		return fmt.Errorf("selftest: %d/%d example(s) failed", failed, report.count)
+	}
+	return nil
+}
+
+func (m *Maestro) selftest(name, example string) error {
+	args, err := shlex.Split(strings.NewReader(example))
+	if err != nil {
+		return err
+	}
+	cmd, err := m.setup(context.Background(), name, false)
+	if err != nil {
+		return err
+	}
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	return cmd.Dry(args)
+}
+
 func (m *Maestro) ExecuteDefault(args []string) error {
 	if m.MetaExec.Default == "" {
 		return fmt.Errorf("default command not defined")
@@ -257,14 +633,107 @@ func (m *Maestro) ExecuteAll(args []string) error {
 	if len(m.MetaExec.All) == 0 {
 		return fmt.Errorf("all command not defined")
 	}
+	limit := m.Options.Parallel
+	if limit <= 0 {
+		limit = m.MetaExec.AllParallel
+	}
+	if limit > 1 {
+		return m.executeAllParallel(args, int(limit))
+	}
+	if m.KeepGoing {
+		var errs []error
+		for _, n := range m.MetaExec.All {
+			if err := m.execute(n, args, stdio.Stdout, stdio.Stderr); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return joinErrors(errs...)
+	}
+	if m.MetaExec.MaxFailures <= 0 {
+		for _, n := range m.MetaExec.All {
+			if err := m.execute(n, args, stdio.Stdout, stdio.Stderr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var (
+		brk    = createBreaker(m.MetaExec.MaxFailures, nil)
+		failed []string
+	)
 	for _, n := range m.MetaExec.All {
-		if err := m.execute(n, args, stdio.Stdout, stdio.Stderr); err != nil {
-			return err
+		err := m.execute(n, args, stdio.Stdout, stdio.Stderr)
+		if err == nil {
+			continue
 		}
+		fmt.Fprintln(stdio.Stderr, err)
+		failed = append(failed, n)
+		if brk.Fail() {
+			return brk.Err()
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: command(s) failed", strings.Join(failed, ", "))
 	}
 	return nil
 }
 
+// executeAllParallel runs the commands of .ALL with at most limit of them at
+// the same time. The lines written by the commands are prefixed by their name.
+// The first failure cancels the commands still running unless the execution
+// keeps going after a failure or .MAX_FAILURES is not reached yet.
+func (m *Maestro) executeAllParallel(args []string, limit int) error {
+	if m.ConfirmPlan {
+		return fmt.Errorf("the plan can not be confirmed when the commands are executed in parallel")
+	}
+	for _, n := range m.MetaExec.All {
+		if err := m.confirm(n); err != nil {
+			return err
+		}
+	}
+	parent, stop := interruptContext()
+	defer stop()
+
+	var (
+		pool, ctx = createPool(withConcurrent(parent), limit)
+		brk       = createBreaker(m.MetaExec.MaxFailures, nil)
+		stdout    = stdio.Lock(stdio.Stdout)
+		stderr    = stdio.Lock(stdio.Stderr)
+		mu        sync.Mutex
+		errs      []error
+	)
+	for _, n := range m.MetaExec.All {
+		name := n
+		err := pool.Go(ctx, func() error {
+			sub, r := startRunContext(ctx, name, stderr)
+			defer r.Close()
+			defer m.onClose(r)()
+			err := m.executeContext(sub, name, args, stdout, stderr)
+			if err == nil {
+				return nil
+			}
+			if m.KeepGoing || (m.MetaExec.MaxFailures > 0 && !brk.Fail()) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			break
+		}
+	}
+	err := pool.Wait()
+	if e := brk.Err(); e != nil {
+		err = e
+	}
+	if len(errs) == 0 {
+		return err
+	}
+	return joinErrors(append(errs, err)...)
+}
+
 func (m *Maestro) ExecuteHelp(name string) error {
 	return m.executeHelp(name, stdio.Stdout)
 }
@@ -275,60 +744,373 @@ func (m *Maestro) ExecuteVersion() error {
 
 func (m *Maestro) Execute(name string, args []string) error {
 	if name == "" && m.MetaExec.Default == "" {
-		return m.ExecuteHelp(name)
+		if !m.interactive() || !isTerminal(os.Stdout) {
+			return m.ExecuteHelp(name)
+		}
+		cmd, err := m.pick()
+		if err != nil {
+			if errors.Is(err, errCancel) {
+				err = nil
+			}
+			return err
+		}
+		name = cmd
+	}
+	name, args = m.expand(name, args)
+	if err := checkColor(m.Color); err != nil {
+		return err
 	}
 	if hasHelp(args) {
 		return m.ExecuteHelp(name)
 	}
+	for _, msg := range m.Messages {
+		fmt.Fprintln(stdio.Stderr, msg)
+	}
 	if m.MetaExec.Dry {
 		return m.Dry(name, args)
 	}
+	var (
+		now    = m.clock().Now()
+		stdout = stdio.Stdout
+		stderr = stdio.Stderr
+		notif  = m.notifier(name)
+		err    error
+	)
+	if notif != nil {
+		stdout, stderr = notif.Writer(stdout), notif.Writer(stderr)
+	}
 	if m.Remote {
-		return m.executeRemote(name, args, stdio.Stdout, stdio.Stderr)
+		err = m.executeRemote(name, args, stdout, stderr)
+	} else {
+		err = m.execute(name, args, stdout, stderr)
 	}
-	return m.execute(name, args, stdio.Stdout, stdio.Stderr)
+	m.remember(name, args, now, err)
+	m.notify(notif, name, args, now, err, stdio.Stderr)
+	return err
+}
+
+// Builtin tells whether name is a sub-command of maestro not disabled by
+// .NO_BUILTINS.
+func (m *Maestro) Builtin(name string) bool {
+	if !isSubcommand(name) {
+		return false
+	}
+	for _, n := range m.MetaExec.NoBuiltins {
+		if n == name {
+			return false
+		}
+	}
+	return true
+}
+
+// Shadowed tells whether name is a command hidden by a sub-command of
+// maestro. The command can only be executed with run.
+func (m *Maestro) Shadowed(name string) bool {
+	if !m.Builtin(name) {
+		return false
+	}
+	_, err := m.Commands.Lookup(name)
+	return err == nil
+}
+
+// Run executes the commands given as arguments even if they have the name of
+// a sub-command of maestro.
+func (m *Maestro) Run(args []string) error {
+	var name string
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	return m.ExecuteCommands(name, args)
+}
+
+// ExecuteCommands executes the commands given on the command line one after
+// the other. The dependencies shared by the commands are executed only once
+// and a command without arguments already executed as a dependency of a
+// previous command is not executed again. The execution stops at the first
+// failure unless it keeps going.
+func (m *Maestro) ExecuteCommands(name string, args []string) error {
+	list := m.invocations(name, args)
+	if len(list) == 1 {
+		return m.Execute(name, args)
+	}
+	m.executed = make(map[string]struct{})
+	m.outputs = createOutputs()
+	defer func() {
+		m.executed, m.outputs = nil, nil
+	}()
+	var errs []error
+	for j := 0; j < len(list); j++ {
+		if k := pipeEnd(list, j); k > j+1 {
+			err := m.executePipe(list[j:k])
+			if j = k - 1; err == nil {
+				continue
+			}
+			if !m.KeepGoing {
+				return err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		i := list[j]
+		cmd, err := m.Commands.Lookup(i.Command)
+		if _, ok := m.executed[cmd.Name]; ok && err == nil && len(i.Args) == 0 {
+			continue
+		}
+		if err = m.Execute(i.Command, i.Args); err == nil {
+			if cmd.Name != "" {
+				m.executed[cmd.Name] = struct{}{}
+			}
+			continue
+		}
+		if !m.KeepGoing {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return joinErrors(errs...)
+}
+
+// pipeEnd gives the index following the last command of the pipe starting at
+// the given index.
+func pipeEnd(list []invocation, from int) int {
+	end := from + 1
+	for end < len(list) && list[end].pipe {
+		end++
+	}
+	return end
+}
+
+// executePipe executes the commands given on the command line separated by a
+// "|" at the same time, each command reading the output of the previous one.
+func (m *Maestro) executePipe(list []invocation) error {
+	var names []string
+	for _, i := range list {
+		if i.Command == "" {
+			return fmt.Errorf("command missing after %s", pipeWord)
+		}
+		names = append(names, i.Command)
+	}
+	if m.Remote || m.MetaExec.Dry || m.ConfirmPlan {
+		return fmt.Errorf("%s: commands can only be piped when they are executed locally", strings.Join(names, " | "))
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	ctx, r := startRunContext(ctx, strings.Join(names, " | "), stdio.Stderr)
+	defer r.Close()
+	defer m.onClose(r)()
+
+	ctx = withClock(ctx, m.clock())
+	if m.KeepGoing {
+		ctx = withKeepGoing(ctx)
+	}
+	ctx = withOutputs(ctx, m.outputs)
+
+	var (
+		pipe   execpipe
+		option = ctreeOption{
+			NoDeps:   m.NoDeps,
+			Ignore:   m.Ignore,
+			ColorErr: m.colorize(os.Stderr),
+		}
+	)
+	for j, i := range list {
+		name, args := m.expand(i.Command, i.Args)
+		if err := m.confirm(name); err != nil {
+			return err
+		}
+		cmd, err := m.setup(ctx, name, true)
+		if err != nil {
+			return err
+		}
+		ex, err := m.resolve(cmd, args, option)
+		if err != nil {
+			return err
+		}
+		if c, ok := ex.(io.Closer); ok {
+			defer c.Close()
+		}
+		pipe.list = append(pipe.list, ex)
+		list[j].Command, list[j].Args = name, args
+	}
+	var (
+		now = m.clock().Now()
+		err = pipe.Execute(ctx, stdio.Stdout, stdio.Stderr)
+	)
+	for _, i := range list {
+		m.remember(i.Command, i.Args, now, err)
+	}
+	return err
+}
+
+// pipeWord separates the commands given on the command line whose output is
+// the input of the next command.
+const pipeWord = "|"
+
+// invocations splits the words given on the command line into the commands to
+// execute. A word that is the name of a command (or of a preset) starts a new
+// command unless it is the value of an option of the current command or the
+// current command still expects some of the arguments it declares. The words
+// after "--" are never options. The word following a "|" always starts a new
+// command that reads the output of the previous one.
+func (m *Maestro) invocations(name string, args []string) []invocation {
+	var (
+		list  = []invocation{{Command: name}}
+		cmd   CommandSettings
+		pos   int
+		value bool
+		rest  bool
+		pipe  bool
+	)
+	cmd, _ = m.Commands.Lookup(name)
+	for _, a := range args {
+		curr := &list[len(list)-1]
+		switch {
+		case value:
+			value = false
+		case a == pipeWord:
+			pipe = true
+			continue
+		case pipe:
+			list = append(list, invocation{Command: a, pipe: true})
+			cmd, _ = m.Commands.Lookup(a)
+			pos, rest, pipe = 0, false, false
+			continue
+		case a == "--" && !rest:
+			rest = true
+		case strings.HasPrefix(a, "-") && !rest:
+			value = cmd.takesValue(a)
+		case m.isInvocable(a) && pos >= len(cmd.Args):
+			list = append(list, invocation{Command: a})
+			cmd, _ = m.Commands.Lookup(a)
+			pos, rest = 0, false
+			continue
+		default:
+			pos++
+		}
+		curr.Args = append(curr.Args, a)
+	}
+	if pipe {
+		list = append(list, invocation{pipe: true})
+	}
+	return list
+}
+
+func (m *Maestro) isInvocable(name string) bool {
+	if _, err := m.Commands.Lookup(name); err == nil {
+		return true
+	}
+	_, ok := m.Presets[name]
+	return ok
 }
 
 func (m *Maestro) execute(name string, args []string, stdout, stderr io.Writer) error {
-	ctx := interruptContext()
+	if err := m.confirm(name); err != nil {
+		return err
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	ctx, r := startRunContext(ctx, name, stderr)
+	defer r.Close()
+	defer m.onClose(r)()
+	return m.executeContext(ctx, name, args, stdout, stderr)
+}
+
+func (m *Maestro) executeContext(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	ctx = withClock(ctx, m.clock())
+	if m.KeepGoing {
+		ctx = withKeepGoing(ctx)
+	}
+	out := m.outputs
+	if out == nil {
+		out = createOutputs()
+	}
+	ctx = withOutputs(ctx, out)
 	cmd, err := m.setup(ctx, name, true)
 	if err != nil {
 		return err
 	}
+	if m.Drift {
+		settings, err := m.Commands.Lookup(name)
+		if err != nil {
+			return err
+		}
+		if err := checkDrift(m.stateDir(), settings, clockFrom(ctx).Now(), stderr); err != nil {
+			return err
+		}
+	}
 	option := ctreeOption{
-		Trace:  m.Trace,
+		Trace:  m.Trace || m.TraceLines,
 		NoDeps: m.NoDeps,
-		Prefix: m.WithPrefix,
+		Prefix: m.WithPrefix || concurrent(ctx),
 		Ignore: m.Ignore,
+		Format: m.Format,
+
+		ColorOut:    m.colorize(os.Stdout),
+		ColorErr:    m.colorize(os.Stderr),
+		TraceFormat: m.TraceFormat,
+	}
+	if m.progress() && !concurrent(ctx) {
+		option.progress = createProgress(os.Stderr, m.clock(), m.Theme)
+	}
+	if m.TraceLines {
+		if err := checkTraceFormat(option.TraceFormat); err != nil {
+			return err
+		}
+		option.trace = createTraceReport(option.TraceFormat, m.Theme)
+		option.trace.lines = true
+	}
+	if m.Report != "" {
+		if err := checkReportFormat(m.ReportFormat); err != nil {
+			return err
+		}
+		settings, err := m.Commands.Lookup(name)
+		if err != nil {
+			return err
+		}
+		option.report = createRunReport(settings, args, m.clock())
+	}
+	ex, err := m.resolve(cmd, args, option)
+	if err != nil {
+		return err
+	}
+	if c, ok := ex.(io.Closer); ok {
+		defer c.Close()
+	}
+	if m.ConfirmPlan {
+		if err := m.confirmPlan(ex, stderr); err != nil {
+			return err
+		}
+	}
+	err = ex.Execute(ctx, stdout, stderr)
+	if option.trace != nil {
+		option.trace.Summary(stderr)
 	}
-	ex, err := m.resolve(cmd, args, option)
-	if err != nil {
-		return err
+	if option.report != nil {
+		if e := option.report.Finish(m.Report, m.ReportFormat, err); e != nil {
+			fmt.Fprintf(stderr, "fail to write report: %s", e)
+			fmt.Fprintln(stderr)
+		}
 	}
-	if c, ok := ex.(io.Closer); ok {
-		defer c.Close()
+	for _, h := range m.remedy(err) {
+		fmt.Fprintln(stderr, h)
 	}
-	return ex.Execute(ctx, stdout, stderr)
+	return err
 }
 
 func (m *Maestro) executeHelp(name string, w io.Writer) error {
-	var (
-		help string
-		err  error
-	)
-	if name != "" {
-		cmd, err := m.Commands.Lookup(name)
-		if err != nil {
-			return err
-		}
-		help, err = cmd.Help()
-	} else {
-		help, err = m.help()
+	r := m.Renderer
+	if r == nil {
+		r = help.Text()
 	}
-	if err == nil {
-		fmt.Fprintln(w, strings.TrimSpace(help))
+	if name == "" {
+		return r.File(w, m.HelpFile())
 	}
-	return err
+	name, _ = m.expand(name, nil)
+	cmd, err := m.Commands.Lookup(name)
+	if err != nil {
+		return err
+	}
+	return r.Command(w, cmd.HelpCommand())
 }
 
 func (m *Maestro) executeVersion(w io.Writer) error {
@@ -342,6 +1124,13 @@ func (m *Maestro) executeRemote(name string, args []string, stdout, stderr io.Wr
 	if err != nil {
 		return err
 	}
+	if err := m.confirm(name); err != nil {
+		return err
+	}
+	// the working directory and the PATH refer to the local system
+	var paths []string
+	cmd.WorkDir, cmd.Venv, cmd.Node = "", "", ""
+	cmd.PathPrepend, paths = nil, cmd.PathPrepend
 	ex, err := cmd.Prepare()
 	if err != nil {
 		return err
@@ -350,64 +1139,301 @@ func (m *Maestro) executeRemote(name string, args []string, stdout, stderr io.Wr
 	if err != nil {
 		return err
 	}
-	if m.MetaSSH.Parallel <= 0 {
-		n := len(cmd.Hosts)
-		m.MetaSSH.Parallel = int64(n)
+	if len(paths) > 0 {
+		path := fmt.Sprintf("export PATH=\"%s:$PATH\"", strings.Join(paths, ":"))
+		scripts = append([]string{path}, scripts...)
+	}
+	if m.ConfirmPlan {
+		var hosts []string
+		for _, h := range cmd.Hosts {
+			hosts = append(hosts, h.String())
+		}
+		lines, args := maskPlan(ex, scripts, args)
+		step := planStep{
+			Command: cmd.Name,
+			Args:    args,
+			Role:    "on " + strings.Join(hosts, ", "),
+			Lines:   lines,
+		}
+		printPlan(stderr, []planStep{step})
+		if err := m.confirmed("plan"); err != nil {
+			return err
+		}
 	}
 	var (
-		parent   = interruptContext()
-		grp, ctx = errgroup.WithContext(parent)
-		sema     = semaphore.NewWeighted(m.MetaSSH.Parallel)
-		seen     = make(map[string]struct{})
-		pout, _  = createPipe()
-		perr, _  = createPipe()
-		sshout   = stdio.Lock(pout)
-		ssherr   = stdio.Lock(perr)
+		password string
+		values   []string
 	)
+	if s, ok := ex.(interface{ Secrets() []string }); ok {
+		values = s.Secrets()
+	}
+	if cmd.Sudo {
+		if password, err = m.MetaSSH.SudoPassword(); err != nil {
+			return err
+		}
+		for i := range scripts {
+			scripts[i] = sudoScript(scripts[i], password != "")
+		}
+		values = append(values, password)
+	}
+	limit := int(m.MetaSSH.Parallel)
+	if limit <= 0 {
+		limit = len(cmd.Hosts)
+	}
+	if cmd.Interactive {
+		// the hosts share the terminal of maestro
+		limit = 1
+	}
+	parent, stop := interruptContext()
+	defer stop()
+	parent, r := startRunContext(parent, name, stderr)
+	defer r.Close()
+	defer m.onClose(r)()
 
-	go io.Copy(stdout, pout)
-	go io.Copy(stderr, perr)
-
+	var report *runReport
+	if m.Report != "" {
+		if err := checkReportFormat(m.ReportFormat); err != nil {
+			return err
+		}
+		report = createRunReport(cmd, args, m.clock())
+	}
+	pout, err := createPipe()
+	if err != nil {
+		return err
+	}
+	perr, err := createPipe()
+	if err != nil {
+		return err
+	}
+	var (
+		sshout    = stdio.Lock(pout)
+		ssherr    = stdio.Lock(perr)
+		wg        sync.WaitGroup
+		seen      = make(map[string]struct{})
+		pool, ctx = createPool(parent, limit)
+		progress  *progress
+	)
+	if m.KeepGoing {
+		pool.KeepGoing()
+	}
+	if len(cmd.Hosts) > 1 && m.progress() && !cmd.Interactive {
+		progress = createProgress(os.Stderr, m.clock(), m.Theme)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		w := mask(stdout, values...)
+		io.Copy(w, pout)
+		flushMask(w)
+	}()
+	go func() {
+		defer wg.Done()
+		w := mask(stderr, values...)
+		io.Copy(w, perr)
+		flushMask(w)
+	}()
 	for _, h := range cmd.Hosts {
-		if _, ok := seen[h]; ok {
+		if _, ok := seen[h.String()]; ok {
 			continue
 		}
-		seen[h] = struct{}{}
-		if err := sema.Acquire(parent, 1); err != nil {
+		seen[h.String()] = struct{}{}
+		host := h
+		run := func(sshout, ssherr io.Writer) error {
+			if report == nil {
+				return m.executeHost(ctx, ex, host, scripts, password, cmd.SuccessCodes, cmd.Interactive, sshout, ssherr)
+			}
+			var (
+				entry = reportEntry{
+					Command: cmd.Name,
+					Host:    host.String(),
+					Start:   m.clock().Now(),
+				}
+				stdout = countWriter{Writer: sshout}
+				stderr = countWriter{Writer: ssherr}
+				err    = m.executeHost(ctx, ex, host, scripts, password, cmd.SuccessCodes, cmd.Interactive, &stdout, &stderr)
+			)
+			entry.finish(m.clock().Now(), err)
+			entry.Stdout, entry.Stderr = stdout.Count(), stderr.Count()
+			report.Add(entry)
 			return err
 		}
-		host := h
-		grp.Go(func() error {
-			defer sema.Release(1)
-			return m.executeHost(ctx, ex, host, scripts, sshout, ssherr)
+		err = pool.Go(ctx, func() error {
+			var err error
+			if progress == nil {
+				err = run(sshout, ssherr)
+			} else {
+				err = progress.Run(host.String(), sshout, ssherr, run)
+			}
+			if err != nil && m.KeepGoing {
+				err = fmt.Errorf("%s: %w", host, err)
+			}
+			return err
 		})
+		if err != nil {
+			break
+		}
+	}
+	if e := pool.Wait(); e != nil {
+		err = e
+	}
+	if err != nil && len(values) > 0 {
+		var set secrets
+		set.Add(values...)
+		err = errors.New(string(set.Redact([]byte(err.Error()))))
+	}
+	pout.CloseWrite()
+	perr.CloseWrite()
+	wg.Wait()
+	pout.Close()
+	perr.Close()
+	if report != nil {
+		if e := report.Finish(m.Report, m.ReportFormat, err); e != nil {
+			fmt.Fprintf(stderr, "fail to write report: %s", e)
+			fmt.Fprintln(stderr)
+		}
+	}
+	return err
+}
+
+// DefaultPrefixFormat is the format of the prefix of the lines written by the
+// commands executed on remote hosts when .PREFIX_FORMAT is not set.
+const DefaultPrefixFormat = "{command}@{host}"
+
+// prefixFields are the placeholders replaced in .PREFIX_FORMAT.
+var prefixFields = []string{"command", "host", "addr", "user", "time"}
+
+var prefixPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+func checkPrefixFormat(format string) error {
+	for _, m := range prefixPattern.FindAllStringSubmatch(format, -1) {
+		var ok bool
+		for _, f := range prefixFields {
+			if ok = m[1] == f; ok {
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: unknown field in prefix format (use %s)", m[0], strings.Join(prefixFields, ", "))
+		}
+	}
+	return nil
+}
+
+// remotePrefix gives the prefix of the lines written by a command executed on
+// a remote host. The placeholders of the format are replaced by the name of
+// the command, the name of the host, its address (host:port), the user and,
+// for each line, the time the line is written.
+func (m *Maestro) remotePrefix(user string, host CommandTarget, name string) func() string {
+	format := m.MetaSSH.Prefix
+	if format == "" {
+		format = DefaultPrefixFormat
+	}
+	replace := strings.NewReplacer(
+		"{command}", name,
+		"{host}", host.Host,
+		"{addr}", host.Addr(),
+		"{user}", user,
+	)
+	format = replace.Replace(format)
+	if !strings.Contains(format, "{time}") {
+		return func() string {
+			return format
+		}
+	}
+	return func() string {
+		return strings.ReplaceAll(format, "{time}", m.clock().Now().Format("15:04:05"))
 	}
-	sema.Acquire(parent, m.MetaSSH.Parallel)
-	return grp.Wait()
 }
 
-func (m *Maestro) executeHost(ctx context.Context, cmd Executer, addr string, scripts []string, stdout, stderr io.Writer) error {
+// executeHost executes the scripts of cmd on host. The lines written by the
+// scripts are prefixed unless the command is interactive: the scripts are then
+// executed in a pseudo terminal reading the standard input of maestro and
+// their output is written as is.
+func (m *Maestro) executeHost(ctx context.Context, cmd Executer, host CommandTarget, scripts []string, password string, codes []int, interactive bool, stdout, stderr io.Writer) error {
+	user := host.User
+	if user == "" {
+		user = m.MetaSSH.User
+	}
+	var prefix func() string
+	if m.WithPrefix {
+		prefix = m.remotePrefix(user, host, cmd.Command())
+	}
 	var (
-		prefix = fmt.Sprintf("%s;%s;%s", m.MetaSSH.User, addr, cmd.Command())
-		exec   = func(sess *ssh.Session, line string) error {
-			setPrefix(stdout, prefix)
-			setPrefix(stderr, prefix)
+		lout = &lineWriter{
+			w:    stdout,
+			line: prefixLine(prefix, host.Addr(), m.colorize(os.Stdout), false),
+		}
+		lerr = &lineWriter{
+			w:    stderr,
+			line: prefixLine(prefix, host.Addr(), m.colorize(os.Stderr), true),
+		}
+	)
+	defer lout.Flush()
+	defer lerr.Flush()
+	if !interactive {
+		stdout, stderr = lout, lerr
+	}
 
+	var (
+		exec = func(sess *ssh.Session, line string) error {
 			defer sess.Close()
 			sess.Stdout = stdout
 			sess.Stderr = stderr
+			var stdin io.Reader
+			if password != "" {
+				stdin = strings.NewReader(password + "\n")
+			}
+			if interactive {
+				restore, err := requestPty(sess)
+				if err != nil {
+					return err
+				}
+				defer restore()
+				// the standard input of maestro is forwarded only while the
+				// session runs: the next sessions and commands get what is
+				// typed after
+				in, err := sess.StdinPipe()
+				if err != nil {
+					return err
+				}
+				if stdin != nil {
+					if _, err := io.Copy(in, stdin); err != nil {
+						return err
+					}
+				}
+				stop := copyInput(in)
+				defer stop()
+			} else {
+				sess.Stdin = stdin
+			}
 
-			return sess.Run(line)
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-ctx.Done():
+					sess.Signal(ssh.SIGTERM)
+					sess.Close()
+				case <-done:
+				}
+			}()
+			if err := sess.Run(line); err != nil {
+				if e := ctx.Err(); e != nil {
+					err = e
+				}
+				return err
+			}
+			return nil
 		}
 	)
 	config := ssh.ClientConfig{
-		User:            m.MetaSSH.User,
+		User:            user,
 		Auth:            m.MetaSSH.AuthMethod(),
 		HostKeyCallback: m.CheckHostKey,
 	}
-	client, err := ssh.Dial("tcp", addr, &config)
+	client, err := ssh.Dial("tcp", host.Addr(), &config)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %w", host, err)
 	}
 	defer client.Close()
 	for i := range scripts {
@@ -420,43 +1446,13 @@ func (m *Maestro) executeHost(ctx context.Context, cmd Executer, addr string, sc
 		if err != nil {
 			return err
 		}
-		if err := exec(sess, scripts[i]); err != nil {
+		if err := exec(sess, scripts[i]); err != nil && !isSuccess(codes, err) {
 			return err
 		}
 	}
 	return nil
 }
 
-func (m *Maestro) help() (string, error) {
-	h := struct {
-		File     string
-		Help     string
-		Usage    string
-		Version  string
-		Commands map[string][]CommandSettings
-	}{
-		Version:  m.Version,
-		File:     m.Name(),
-		Usage:    m.Usage,
-		Help:     m.Help,
-		Commands: make(map[string][]CommandSettings),
-	}
-	for _, c := range m.Commands {
-		if c.Blocked() {
-			continue
-		}
-		for _, t := range c.Tags() {
-			h.Commands[t] = append(h.Commands[t], c)
-		}
-	}
-	for _, cs := range h.Commands {
-		sort.Slice(cs, func(i, j int) bool {
-			return cs[i].Command() < cs[j].Command()
-		})
-	}
-	return help.Maestro(h)
-}
-
 func (m *Maestro) canExecute(cmd CommandSettings) error {
 	if cmd.Blocked() {
 		return fmt.Errorf("%s: command can not be called", cmd.Command())
@@ -472,6 +1468,18 @@ func (m *Maestro) resolve(cmd Executer, args []string, option ctreeOption) (exec
 		list deplist
 		err  error
 	)
+	if err := checkFormat(option.Format); err != nil {
+		return nil, err
+	}
+	if option.Format == FormatTap {
+		option.tap = new(tapReport)
+	}
+	if option.Trace && option.trace == nil {
+		if err := checkTraceFormat(option.TraceFormat); err != nil {
+			return nil, err
+		}
+		option.trace = createTraceReport(option.TraceFormat, m.Theme)
+	}
 	if !option.NoDeps {
 		list, err = m.resolveDependencies(cmd, option)
 		if err != nil {
@@ -479,16 +1487,32 @@ func (m *Maestro) resolve(cmd Executer, args []string, option ctreeOption) (exec
 		}
 	}
 
+	if option.report != nil {
+		cmd = reportExecuter(cmd, option.report, false)
+	}
 	root := createMain(cmd, args, list)
 	root.ignore = option.Ignore
-	root.pre, err = m.resolveList(m.Before)
-	root.post, err = m.resolveList(m.After)
-	root.errors, err = m.resolveList(m.Error)
-	root.success, err = m.resolveList(m.Success)
+	global, err := m.resolveHooks(m.MetaExec.Before, m.MetaExec.After, m.MetaExec.Error, m.MetaExec.Success)
+	if err != nil {
+		return nil, err
+	}
+	if root.hooks, err = m.resolveCommandHooks(cmd.Command()); err != nil {
+		return nil, err
+	}
+	root.pre = append(global.pre, root.pre...)
+	root.post = append(root.post, global.post...)
+	root.errors = append(root.errors, global.errors...)
+	root.success = append(root.success, global.success...)
 
 	var ex executer = root
-	if option.Trace {
-		ex = trace(ex)
+	if option.tap != nil {
+		ex = tap(ex, option.tap)
+	}
+	if option.trace != nil {
+		ex = trace(ex, cmd.Command(), 0, option.trace)
+	}
+	if option.tap != nil {
+		ex = tapPlan(ex, option.tap)
 	}
 
 	tree, err := createTree(ex)
@@ -496,13 +1520,46 @@ func (m *Maestro) resolve(cmd Executer, args []string, option ctreeOption) (exec
 		return nil, err
 	}
 	tree.prefix = option.Prefix
+	tree.stdout.color = option.ColorOut
+	tree.stderr.color = option.ColorErr
+	tree.stderr.errors = true
 	return &tree, nil
 }
 
+// resolveCommandHooks gives the hooks set with the before, after, error and
+// success properties of the command.
+func (m *Maestro) resolveCommandHooks(name string) (hooks, error) {
+	cmd, err := m.Commands.Lookup(name)
+	if err != nil {
+		return hooks{}, nil
+	}
+	return m.resolveHooks(cmd.Before, cmd.After, cmd.Error, cmd.Success)
+}
+
+func (m *Maestro) resolveHooks(before, after, errs, success []string) (hooks, error) {
+	var (
+		h  hooks
+		e1 error
+		e2 error
+		e3 error
+		e4 error
+	)
+	h.pre, e1 = m.resolveList(before)
+	h.post, e2 = m.resolveList(after)
+	h.errors, e3 = m.resolveList(errs)
+	h.success, e4 = m.resolveList(success)
+	return h, hasError(e1, e2, e3, e4)
+}
+
+// resolveList prepares the commands of a hook. An entry that is not the name
+// of a command is an inline script.
 func (m *Maestro) resolveList(names []string) ([]Executer, error) {
 	var list []Executer
 	for _, n := range names {
 		x, err := m.Commands.Prepare(n)
+		if err != nil && !isCommandName(n) {
+			x, err = m.prepareInline(n)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -511,43 +1568,122 @@ func (m *Maestro) resolveList(names []string) ([]Executer, error) {
 	return list, nil
 }
 
+func (m *Maestro) prepareInline(script string) (Executer, error) {
+	cmd, err := NewCommandSettingsWithLocals("inline", m.Locals)
+	if err != nil {
+		return nil, err
+	}
+	cmd.WorkDir = m.MetaExec.WorkDir
+	mod, line := parseModifiers(script)
+	cmd.Lines = append(cmd.Lines, line)
+	cmd.Modifiers = append(cmd.Modifiers, mod)
+	return cmd.Prepare()
+}
+
 func (m *Maestro) resolveDependencies(cmd Executer, option ctreeOption) (deplist, error) {
 	var (
-		traverse func(Executer) (deplist, error)
-		seen     = make(map[string]struct{})
+		traverse func(Executer, int) (deplist, error)
+		seen     = m.executed
 		empty    = struct{}{}
 	)
+	if seen == nil {
+		seen = make(map[string]struct{})
+	}
 
-	traverse = func(cmd Executer) (deplist, error) {
-		var set []executer
-		for _, d := range cmd.Dependencies() {
-			if _, ok := seen[d.Key()]; ok && !d.Mandatory {
-				continue
+	// dependency gives the executer of a dependency. It is nil when an
+	// optional dependency is not defined.
+	dependency := func(d CommandDep, level int) (executer, string, error) {
+		c, err := m.setup(context.Background(), d.Key(), false)
+		if err != nil {
+			if d.Optional && !d.Mandatory {
+				return nil, "", nil
 			}
+			return nil, "", err
+		}
+		list, err := traverse(c, level+1)
+		if err != nil {
+			return nil, "", err
+		}
+		if option.report != nil {
+			c = reportExecuter(c, option.report, true)
+		}
+		ed := createDep(c, d.Args, list)
+		ed.background = d.Bg
+		ed.optional = d.Optional && !d.Mandatory
+		ed.retry, ed.timeout = d.Retry, d.Timeout
+		if ed.hooks, err = m.resolveCommandHooks(d.Key()); err != nil {
+			return nil, "", err
+		}
+
+		var ex executer = ed
+		if option.tap != nil {
+			ex = tap(ex, option.tap)
+		}
+		if option.trace != nil {
+			ex = trace(ex, c.Command(), level+1, option.trace)
+		}
+		return ex, c.Command(), nil
+	}
+	// pipe gives the executer of a dependency whose output is given to other
+	// commands. The commands of a pipe are always executed.
+	pipe := func(d CommandDep, level int) (executer, string, error) {
+		var (
+			ep    = execpipe{background: d.Bg}
+			names []string
+		)
+		d.Bg = false
+		for _, d := range append([]CommandDep{d}, d.Pipe...) {
 			seen[d.Key()] = empty
-			c, err := m.setup(context.Background(), d.Key(), false)
+			ex, name, err := dependency(d, level)
 			if err != nil {
-				if d.Optional && !d.Mandatory {
+				return nil, "", err
+			}
+			if ex == nil {
+				continue
+			}
+			ep.list = append(ep.list, ex)
+			names = append(names, name)
+		}
+		return ep, strings.Join(names, " -> "), nil
+	}
+
+	traverse = func(cmd Executer, level int) (deplist, error) {
+		var (
+			set   []executer
+			names []string
+		)
+		for _, d := range cmd.Dependencies() {
+			var (
+				ex   executer
+				name string
+				err  error
+			)
+			if len(d.Pipe) > 0 {
+				ex, name, err = pipe(d, level)
+			} else {
+				if _, ok := seen[d.Key()]; ok && !d.Mandatory {
 					continue
 				}
-				return nil, err
+				seen[d.Key()] = empty
+				ex, name, err = dependency(d, level)
 			}
-			list, err := traverse(c)
 			if err != nil {
 				return nil, err
 			}
-			ed := createDep(c, d.Args, list)
-			ed.background = d.Bg
-
-			var ex executer = ed
-			if option.Trace {
-				ex = trace(ex)
+			if ex == nil {
+				continue
 			}
 			set = append(set, ex)
+			names = append(names, name)
+		}
+		if option.progress != nil && hasBackground(set) {
+			for i := range set {
+				set[i] = withProgress(set[i], names[i], option.progress)
+			}
 		}
 		return deplist(set), nil
 	}
-	return traverse(cmd)
+	return traverse(cmd, 0)
 }
 
 func (m *Maestro) setup(ctx context.Context, name string, can bool) (Executer, error) {
@@ -558,10 +1694,58 @@ func (m *Maestro) setup(ctx context.Context, name string, can bool) (Executer, e
 	if err := m.canExecute(cmd); can && err != nil {
 		return nil, err
 	}
-	ex, err := cmd.Prepare(tish.WithFinder(makeFinder(m.Namespace, m.Commands)))
+	if err := checkRequires(cmd); can && err != nil {
+		return nil, err
+	}
+	find := makeFinder(m.Namespace, m.Commands).forCommand(cmd)
+	prepare := cmd.Prepare
+	if len(cmd.Matrix) > 0 {
+		prepare = cmd.PrepareMatrix
+	}
+	ex, err := prepare(tish.WithFinder(find))
 	if err != nil {
 		return nil, err
 	}
+	if e, ok := ex.(interface{ SetEcho(bool) }); ok && m.Trace && !m.TraceLines {
+		e.SetEcho(true)
+	}
+	if p, ok := ex.(interface{ SetPrompt(*prompter) }); ok && can && m.interactive() {
+		p.SetPrompt(createPrompter(os.Stdin, os.Stderr))
+	}
+	if c, ok := ex.(interface{ SetConsole(*console) }); ok && find.Console != nil {
+		c.SetConsole(find.Console)
+	}
+	if cmd.Input != "" {
+		if ex, err = m.input(ex, cmd); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.StripAnsi || len(cmd.Replace) > 0 || cmd.Extract != "" {
+		ex = m.filter(ex, cmd)
+	}
+	if len(cmd.Services) > 0 {
+		ex = m.services(ex, cmd)
+	}
+	if cmd.Publish != "" && len(cmd.Artifacts) > 0 {
+		ex = m.publish(ex, cmd)
+	}
+	if cmd.Cache && !m.Force {
+		ex, err = m.cache(ex, cmd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cmd.Sources) > 0 && !m.Force {
+		if ex, err = m.fresh(ex, cmd); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.Lock != "" {
+		ex = m.lock(ex, cmd)
+	}
+	if m.logDir() != "" {
+		ex = m.logs(ex, cmd)
+	}
 	return ex, nil
 }
 
@@ -571,7 +1755,10 @@ func (m *Maestro) suggest(err error, name string) error {
 		all = append(all, c.Command())
 		all = append(all, c.Alias...)
 	}
-	all = append(all, CmdHelp, CmdVersion, CmdAll, CmdDefault, CmdServe, CmdGraph, CmdSchedule)
+	for n := range m.Presets {
+		all = append(all, n)
+	}
+	all = append(all, builtins...)
 	return Suggest(err, name, all)
 }
 
@@ -582,9 +1769,22 @@ func (m *Maestro) traverseGraph(name string, level int) ([]string, error) {
 	}
 
 	fmt.Fprintf(stdio.Stdout, "%s- %s", strings.Repeat(" ", level*2), name)
+	if len(cmd.Hosts) > 0 {
+		var hosts []string
+		for _, h := range cmd.Hosts {
+			hosts = append(hosts, h.String())
+		}
+		fmt.Fprintf(stdio.Stdout, " @ %s", strings.Join(hosts, ", "))
+	}
 	fmt.Fprintln(stdio.Stdout)
-	var list []string
+	var (
+		list []string
+		deps []CommandDep
+	)
 	for _, d := range cmd.Deps {
+		deps = append(append(deps, d), d.Pipe...)
+	}
+	for _, d := range deps {
 		others, err := m.traverseGraph(d.Name, level+1)
 		if err != nil {
 			return nil, err
@@ -596,19 +1796,43 @@ func (m *Maestro) traverseGraph(name string, level int) ([]string, error) {
 }
 
 type MetaExec struct {
-	WorkDir   string
-	Namespace string
-	Dry       bool
-	Ignore    bool
+	WorkDir     string
+	CacheDir    string
+	LogDir      string
+	LogMaxSize  int64
+	LogMaxAge   time.Duration
+	ComposeFile string
+	Namespace   string
+	Packages    map[string]string
+	Dry         bool
+	Ignore      bool
 
 	Trace bool
-
-	All     []string
-	Default string
-	Before  []string
-	After   []string
-	Error   []string
-	Success []string
+	// trace each line of the scripts and write a summary of the slowest
+	// commands at the end of the run
+	TraceLines bool
+	Theme      Theme
+	// key used to sign the checksums and provenance of published artifacts
+	SignKey ssh.Signer
+
+	All         []string
+	AllParallel int64
+	MaxFailures int64
+	EnvFiles    []string
+	Sensitive   []string
+	Default     string
+	Before      []string
+	After       []string
+	Error       []string
+	Success     []string
+	// sub-commands of maestro disabled so that the commands having the same
+	// name can be executed without run
+	NoBuiltins []string
+
+	// webhooks notified once a command has been executed and the result of
+	// the command triggering the notifications (error, success or always)
+	NotifyURL []string
+	NotifyOn  string
 }
 
 type MetaAbout struct {
@@ -626,6 +1850,20 @@ type MetaSSH struct {
 	Pass     string
 	Key      ssh.Signer
 	Hosts    []hostEntry
+	// reference to the secret giving the password of sudo (see readSecret)
+	Sudo string
+	// format of the prefix of the lines written by the commands executed on
+	// the remote hosts (see remotePrefix)
+	Prefix string
+}
+
+// SudoPassword gives the password given to sudo when executing the scripts of
+// privileged commands. The password is empty when it is not configured.
+func (m MetaSSH) SudoPassword() (string, error) {
+	if m.Sudo == "" {
+		return "", nil
+	}
+	return readSecret(m.Sudo)
 }
 
 func (m MetaSSH) AuthMethod() []ssh.AuthMethod {
@@ -656,11 +1894,73 @@ func (m MetaSSH) CheckHostKey(host string, addr net.Addr, key ssh.PublicKey) err
 	return fmt.Errorf("%s unknown host (%s)", host, addr)
 }
 
+type MetaSMTP struct {
+	// address of the server (host[:port])
+	Host string
+	User string
+	// reference to the secret giving the password of the user (see readSecret)
+	Pass string
+	From string
+}
+
 type MetaHttp struct {
 	CertFile string
 	KeyFile  string
 	Addr     string
 	Base     string
+	Tokens   []string
+
+	// list of commands that can be executed for each HTTP method. If at least
+	// one method is configured, commands not listed won't be available for
+	// execution
+	Get    []string
+	Post   []string
+	Delete []string
+	Patch  []string
+	Put    []string
+	Head   []string
+}
+
+func (m MetaHttp) Authorized(token string, cmd []string) bool {
+	list := cmd
+	if len(list) == 0 {
+		list = m.Tokens
+	}
+	if len(list) == 0 {
+		return true
+	}
+	for i := range list {
+		if subtle.ConstantTimeCompare([]byte(list[i]), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (m MetaHttp) Restricted() bool {
+	n := len(m.Get) + len(m.Post) + len(m.Delete) + len(m.Patch) + len(m.Put) + len(m.Head)
+	return n > 0
+}
+
+func (m MetaHttp) Methods(name string) []string {
+	var (
+		list  []string
+		check = func(method string, names []string) {
+			for i := range names {
+				if names[i] == name {
+					list = append(list, method)
+					break
+				}
+			}
+		}
+	)
+	check(http.MethodGet, m.Get)
+	check(http.MethodPost, m.Post)
+	check(http.MethodDelete, m.Delete)
+	check(http.MethodPatch, m.Patch)
+	check(http.MethodPut, m.Put)
+	check(http.MethodHead, m.Head)
+	return list
 }
 
 type Registry map[string]CommandSettings
@@ -709,24 +2009,53 @@ func (r Registry) Lookup(name string) (CommandSettings, error) {
 type commandFinder struct {
 	Space    string
 	Commands Registry
+
+	Dir   string
+	Paths []string
+	// pseudo terminal of an interactive command given to the programs it
+	// starts (see attach)
+	Console *console
 }
 
-func makeFinder(ns string, set Registry) tish.CommandFinder {
+func makeFinder(ns string, set Registry) *commandFinder {
 	return &commandFinder{
 		Space:    ns,
 		Commands: set,
 	}
 }
 
+func (c *commandFinder) forCommand(cmd CommandSettings) *commandFinder {
+	f := *c
+	f.Dir = cmd.WorkDir
+	f.Paths, _ = cmd.searchPaths()
+	if cmd.Interactive && f.Console == nil && !attachTerminal() {
+		f.Console = new(console)
+	}
+	return &f
+}
+
 func (c *commandFinder) Find(ctx context.Context, name string) (tish.Command, error) {
 	cmd, ok := c.Commands[name]
 	if !ok {
 		cmd, ok = c.findByName(name)
-		if !ok {
+	}
+	if !ok {
+		file, found := lookPath(c.Paths, name)
+		if !found && c.Console != nil {
+			// the programs of the PATH are started by maestro instead of the
+			// shell to give them the pseudo terminal
+			if f, err := exec.LookPath(name); err == nil {
+				file, found = f, true
+			}
+		}
+		if !found {
 			return nil, fmt.Errorf("%s: command not found", name)
 		}
+		p := makePathCommand(ctx, name, file, c.Dir)
+		p.console = c.Console
+		return p, nil
 	}
-	x, err := cmd.Prepare(tish.WithFinder(c))
+	x, err := cmd.Prepare(tish.WithFinder(c.forCommand(cmd)))
 	if err != nil {
 		return nil, err
 	}
@@ -795,6 +2124,76 @@ func hasHelp(args []string) bool {
 	return as[i] == "-h" || as[i] == "-help" || as[i] == "--help"
 }
 
+const (
+	// ExitTimeout is the exit code of maestro when a command has not completed
+	// before its timeout.
+	ExitTimeout = 124
+	// ExitInterrupt is the exit code of maestro when the commands have been
+	// cancelled after an interrupt.
+	ExitInterrupt = 130
+)
+
+// ExitCode gives the exit code of maestro for the error returned by the
+// execution of the commands: the exit code of the command that has failed (of
+// the first one when several commands have failed), ExitTimeout or
+// ExitInterrupt when the command has been cancelled and 1 otherwise.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupt
+	}
+	if code := exitCode(err); code > 0 && code <= 255 {
+		return code
+	}
+	return 1
+}
+
+// failures are the errors of the commands that have failed when the execution
+// continues after a failure (see KeepGoing).
+type failures []error
+
+func (f failures) Error() string {
+	var str strings.Builder
+	fmt.Fprintf(&str, "%d command(s) failed:", len(f))
+	for _, e := range f {
+		str.WriteString("\n  - ")
+		str.WriteString(e.Error())
+	}
+	return str.String()
+}
+
+// Unwrap gives the error of the first command that has failed: its exit code
+// is the one of maestro.
+func (f failures) Unwrap() error {
+	return f[0]
+}
+
+// joinErrors combines the non nil errors. A single error is given as is.
+func joinErrors(errs ...error) error {
+	var list failures
+	for _, e := range errs {
+		switch e := e.(type) {
+		case nil:
+		case failures:
+			list = append(list, e...)
+		default:
+			list = append(list, e)
+		}
+	}
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		return list[0]
+	default:
+		return list
+	}
+}
+
 func hasError(errs ...error) error {
 	for _, e := range errs {
 		if e != nil {
@@ -811,15 +2210,3 @@ func cleanFilename(str string) string {
 	}
 	return str
 }
-
-func interruptContext() context.Context {
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		sig := make(chan os.Signal, 1)
-		defer close(sig)
-		signal.Notify(sig, os.Kill, os.Interrupt)
-		<-sig
-		cancel()
-	}()
-	return ctx
-}
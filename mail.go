@@ -0,0 +1,93 @@
+package maestro
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSMTPPort = 25
+
+	// number of lines of output given in the emails
+	mailLines = 500
+)
+
+// mailer sends the notifications by email with the server configured with
+// the .SMTP_* metas.
+type mailer struct {
+	addr string
+	host string
+	user string
+	pass string
+	from string
+
+	clock Clock
+}
+
+// mailer gives the mailer configured by the maestro file. It is nil when
+// .SMTP_HOST is not set.
+func (m *Maestro) mailer() *mailer {
+	if m.MetaSMTP.Host == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(m.MetaSMTP.Host)
+	if err != nil {
+		host, port = m.MetaSMTP.Host, fmt.Sprint(DefaultSMTPPort)
+	}
+	x := mailer{
+		addr:  net.JoinHostPort(host, port),
+		host:  host,
+		user:  m.MetaSMTP.User,
+		pass:  m.MetaSMTP.Pass,
+		from:  m.MetaSMTP.From,
+		clock: m.clock(),
+	}
+	if x.from == "" {
+		x.from = x.user
+	}
+	if !strings.Contains(x.from, "@") {
+		name, _ := os.Hostname()
+		x.from = fmt.Sprintf("maestro@%s", name)
+	}
+	return &x
+}
+
+// Send sends the mail to the given addresses. The server is authenticated
+// with the user and its password when the user is set.
+func (m *mailer) Send(to []string, subject, body string) error {
+	var auth smtp.Auth
+	if m.user != "" {
+		pass, err := readSecret(m.pass)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.user, pass, m.host)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", m.clock.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(m.addr, auth, m.from, to, buf.Bytes())
+}
+
+// mailAddresses gives the addresses of a list separated by blanks or commas.
+func mailAddresses(str ...string) []string {
+	var list []string
+	for _, s := range str {
+		list = append(list, strings.FieldsFunc(s, func(r rune) bool {
+			return r == ',' || r == ';' || r == ' ' || r == '\t'
+		})...)
+	}
+	return list
+}
@@ -0,0 +1,278 @@
+package maestro
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	makeAssign = regexp.MustCompile(`^(?:(export|override)\s+)?([A-Za-z_][A-Za-z0-9_.-]*)\s*(\?=|::=|:=|\+=|!=|=)\s*(.*)$`)
+	makeTarget = regexp.MustCompile(`^([^:=#]+?)\s*::?\s*([^=]*)$`)
+	makeRef    = regexp.MustCompile(`\$[({]([^(){}]+)[)}]`)
+)
+
+var makeDirectives = []string{"define", "ifeq", "ifneq", "ifdef", "ifndef", "else", "endif", "include", "-include", "sinclude", "vpath", "unexport"}
+
+type makeRule struct {
+	targets []string
+	prereqs []string
+	orders  []string
+	help    string
+	recipe  []string
+}
+
+// parseMakefile converts the variables and the explicit rules of a Makefile.
+// Pattern rules, conditionals and multi-line variables have no equivalent and
+// are reported as skipped.
+func parseMakefile(r io.Reader) (*importFile, error) {
+	var (
+		mf     importFile
+		rules  []*makeRule
+		curr   *makeRule
+		phony  = make(map[string]struct{})
+		help   []string
+		define bool
+		depth  int
+	)
+	lines, err := readMakefile(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if define {
+			if strings.TrimSpace(line) == "endef" {
+				define = false
+			}
+			continue
+		}
+		if depth > 0 && !strings.HasPrefix(line, "\t") {
+			trimmed := strings.TrimSpace(line)
+			switch word := makeDirective(trimmed); word {
+			case "ifeq", "ifneq", "ifdef", "ifndef":
+				depth++
+			case "endif":
+				depth--
+			}
+			mf.Skipped = append(mf.Skipped, trimmed)
+			continue
+		}
+		if strings.HasPrefix(line, "\t") && curr != nil {
+			if str := strings.TrimSpace(line); str != "" && !strings.HasPrefix(str, "#") {
+				curr.recipe = append(curr.recipe, str)
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			help = help[:0]
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			help = append(help, strings.TrimSpace(strings.TrimLeft(trimmed, "#")))
+			continue
+		}
+		curr = nil
+		if word := makeDirective(trimmed); word != "" {
+			switch word {
+			case "define":
+				define = true
+			case "ifeq", "ifneq", "ifdef", "ifndef":
+				depth++
+			}
+			mf.Skipped = append(mf.Skipped, trimmed)
+			continue
+		}
+		if m := makeAssign.FindStringSubmatch(trimmed); m != nil {
+			if m[3] == "!=" || strings.Contains(m[4], "$(shell") {
+				mf.Skipped = append(mf.Skipped, trimmed)
+				continue
+			}
+			v := importVar{
+				Name:   importName(m[2]),
+				Values: strings.Fields(convertMakeRefs(m[4])),
+				Append: m[3] == "+=",
+			}
+			if m[1] == "export" && !v.Append {
+				// exported variables are given to the recipes in their environment
+				mf.Env = append(mf.Env, v)
+				continue
+			}
+			mf.Vars = append(mf.Vars, v)
+			continue
+		}
+		m := makeTarget.FindStringSubmatch(trimmed)
+		if m == nil {
+			mf.Skipped = append(mf.Skipped, trimmed)
+			continue
+		}
+		var (
+			targets = strings.Fields(m[1])
+			prereqs = m[2]
+			inline  string
+		)
+		if x := strings.Index(prereqs, ";"); x >= 0 {
+			prereqs, inline = prereqs[:x], strings.TrimSpace(prereqs[x+1:])
+		}
+		if len(targets) == 1 && targets[0] == ".PHONY" {
+			for _, t := range strings.Fields(prereqs) {
+				phony[t] = struct{}{}
+			}
+			continue
+		}
+		curr = &makeRule{
+			targets: targets,
+			help:    strings.Join(help, " "),
+		}
+		help = help[:0]
+		if inline != "" {
+			curr.recipe = append(curr.recipe, inline)
+		}
+		if strings.HasPrefix(targets[0], ".") || strings.Contains(m[1], "%") {
+			// the recipe of the rule is dropped with it
+			mf.Skipped = append(mf.Skipped, trimmed)
+			continue
+		}
+		normal, orders, _ := strings.Cut(prereqs, "|")
+		curr.prereqs = strings.Fields(normal)
+		curr.orders = strings.Fields(orders)
+		rules = append(rules, curr)
+	}
+
+	known := make(map[string]struct{})
+	for _, r := range rules {
+		for _, t := range r.targets {
+			known[t] = struct{}{}
+		}
+	}
+	for _, r := range rules {
+		for _, t := range r.targets {
+			if mf.Default == "" {
+				mf.Default = commandName(t)
+			}
+			cmd := importCommand{
+				Name: commandName(t),
+				Help: r.help,
+			}
+			for _, p := range append(r.prereqs, r.orders...) {
+				if _, ok := known[p]; ok {
+					cmd.Deps = append(cmd.Deps, commandName(p))
+				} else {
+					cmd.Sources = append(cmd.Sources, convertMakeRefs(p))
+				}
+			}
+			if _, ok := phony[t]; !ok && len(r.recipe) > 0 {
+				cmd.Targets = append(cmd.Targets, convertMakeRefs(t))
+			}
+			for _, line := range r.recipe {
+				cmd.Script = append(cmd.Script, convertRecipe(line, t, r.prereqs))
+			}
+			if t == CmdAll && len(cmd.Script) == 0 && len(cmd.Sources) == 0 {
+				mf.All = cmd.Deps
+			}
+			mf.Commands = append(mf.Commands, cmd)
+		}
+	}
+	return &mf, nil
+}
+
+// readMakefile gives the logical lines of the Makefile: lines ending with a
+// backslash are joined with the following one by a single blank.
+func readMakefile(r io.Reader) ([]string, error) {
+	var (
+		scan  = bufio.NewScanner(r)
+		lines []string
+		curr  strings.Builder
+	)
+	for scan.Scan() {
+		line := strings.TrimSuffix(scan.Text(), "\r")
+		if curr.Len() > 0 {
+			line = strings.TrimLeft(line, " \t")
+		}
+		if strings.HasSuffix(line, "\\") {
+			curr.WriteString(strings.TrimRight(strings.TrimSuffix(line, "\\"), " \t"))
+			curr.WriteString(" ")
+			continue
+		}
+		curr.WriteString(line)
+		lines = append(lines, curr.String())
+		curr.Reset()
+	}
+	if curr.Len() > 0 {
+		lines = append(lines, curr.String())
+	}
+	return lines, scan.Err()
+}
+
+// convertRecipe rewrites a line of a recipe for the maestro shell: automatic
+// variables are replaced by their values and the prefixes of make become line
+// modifiers.
+func convertRecipe(line, target string, prereqs []string) string {
+	var mods strings.Builder
+	for len(line) > 0 && strings.IndexByte("@-+", line[0]) >= 0 {
+		if line[0] == '+' {
+			mods.WriteRune(bang)
+		} else {
+			mods.WriteByte(line[0])
+		}
+		line = strings.TrimLeft(line[1:], " \t")
+	}
+	var first string
+	if len(prereqs) > 0 {
+		first = prereqs[0]
+	}
+	replace := strings.NewReplacer(
+		"$@", target,
+		"$<", first,
+		"$^", strings.Join(prereqs, " "),
+		"$+", strings.Join(prereqs, " "),
+		"$?", strings.Join(prereqs, " "),
+	)
+	parts := strings.Split(line, "$$")
+	for i := range parts {
+		parts[i] = convertMakeRef(replace.Replace(parts[i]))
+	}
+	return mods.String() + strings.Join(parts, "$")
+}
+
+// convertMakeRefs rewrites $(name) references as ${name} and $(shell cmd) as
+// a command substitution. Other functions of make are kept as is. An escaped
+// dollar ($$) becomes a single dollar.
+func convertMakeRefs(str string) string {
+	parts := strings.Split(str, "$$")
+	for i := range parts {
+		parts[i] = convertMakeRef(parts[i])
+	}
+	return strings.Join(parts, "$")
+}
+
+func convertMakeRef(str string) string {
+	return makeRef.ReplaceAllStringFunc(str, func(ref string) string {
+		inner := ref[2 : len(ref)-1]
+		switch {
+		case inner == "MAKE":
+			return "make"
+		case strings.HasPrefix(inner, "shell "):
+			return "$(" + strings.TrimSpace(strings.TrimPrefix(inner, "shell ")) + ")"
+		case strings.ContainsAny(inner, " \t,:"):
+			return ref
+		default:
+			return "${" + importName(inner) + "}"
+		}
+	})
+}
+
+// makeDirective gives the directive used by the line if any.
+func makeDirective(line string) string {
+	word := line
+	if x := strings.IndexAny(line, " \t("); x >= 0 {
+		word = line[:x]
+	}
+	for _, d := range makeDirectives {
+		if d == word {
+			return word
+		}
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/midbel/maestro/internal/copyslice"
+	"github.com/midbel/maestro/internal/stdio"
+	"github.com/midbel/tish"
+)
+
+// MatrixAxis is a variable of the matrix of a command with all the values it
+// can take.
+type MatrixAxis struct {
+	Name   string
+	Values []string
+}
+
+// combinations gives all the combinations of the values of the matrix. The
+// values of the last axis change first.
+func combinations(matrix []MatrixAxis) [][][2]string {
+	list := [][][2]string{nil}
+	for _, a := range matrix {
+		var next [][][2]string
+		for _, c := range list {
+			for _, v := range a.Values {
+				x := append(append([][2]string{}, c...), [2]string{a.Name, v})
+				next = append(next, x)
+			}
+		}
+		list = next
+	}
+	return list
+}
+
+// matrixCommand executes the script of a command once for each combination of
+// the values of its matrix. The values of a combination are exported to the
+// script as environment variables.
+type matrixCommand struct {
+	Executer
+
+	list   []Executer
+	labels []string
+	limit  int
+}
+
+// PrepareMatrix prepares the command once for each combination of the values
+// of its matrix.
+func (s CommandSettings) PrepareMatrix(options ...tish.ShellOption) (Executer, error) {
+	mc := matrixCommand{
+		limit: int(s.MatrixParallel),
+	}
+	for _, c := range combinations(s.Matrix) {
+		var (
+			other = s
+			parts []string
+		)
+		other.Ev = copyslice.CopyMap[string, string](s.Ev)
+		for _, v := range c {
+			other.Ev[v[0]] = v[1]
+			parts = append(parts, fmt.Sprintf("%s=%s", v[0], v[1]))
+		}
+		ex, err := other.Prepare(options...)
+		if err != nil {
+			return nil, err
+		}
+		mc.list = append(mc.list, ex)
+		mc.labels = append(mc.labels, strings.Join(parts, ","))
+	}
+	if len(mc.list) == 0 {
+		return nil, fmt.Errorf("%s: empty matrix", s.Command())
+	}
+	mc.Executer = mc.list[0]
+	return &mc, nil
+}
+
+func (c *matrixCommand) SetOut(w io.Writer) {
+	w = stdio.Lock(w)
+	for _, ex := range c.list {
+		ex.SetOut(w)
+	}
+}
+
+func (c *matrixCommand) SetErr(w io.Writer) {
+	w = stdio.Lock(w)
+	for _, ex := range c.list {
+		ex.SetErr(w)
+	}
+}
+
+// SetIn gives the content of r to each combination.
+func (c *matrixCommand) SetIn(r io.Reader) {
+	buf, _ := io.ReadAll(r)
+	for _, ex := range c.list {
+		if i, ok := ex.(interface{ SetIn(io.Reader) }); ok {
+			i.SetIn(bytes.NewReader(buf))
+		}
+	}
+}
+
+func (c *matrixCommand) SetEcho(echo bool) {
+	for _, ex := range c.list {
+		if e, ok := ex.(interface{ SetEcho(bool) }); ok {
+			e.SetEcho(echo)
+		}
+	}
+}
+
+func (c *matrixCommand) Script(args []string) ([]string, error) {
+	var list []string
+	for i, ex := range c.list {
+		script, err := ex.Script(args)
+		if err != nil {
+			return nil, c.wrap(i, err)
+		}
+		list = append(list, script...)
+	}
+	return list, nil
+}
+
+func (c *matrixCommand) Dry(args []string) error {
+	for i, ex := range c.list {
+		if err := ex.Dry(args); err != nil {
+			return c.wrap(i, err)
+		}
+	}
+	return nil
+}
+
+// Execute runs the combinations one after the other or, with a limit, at
+// most limit of them at the same time. No new combination is started once one
+// of them has failed.
+func (c *matrixCommand) Execute(ctx context.Context, args []string) error {
+	if r := stdinFrom(ctx); r != nil {
+		c.SetIn(r)
+		ctx = withStdin(ctx, nil)
+	}
+	limit := c.limit
+	if limit <= 0 {
+		limit = 1
+	}
+	pool, sub := createPool(ctx, limit)
+	for i := range c.list {
+		i := i
+		err := pool.Go(sub, func() error {
+			return c.wrap(i, c.list[i].Execute(sub, args))
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err := pool.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (c *matrixCommand) wrap(i int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s[%s]: %w", c.Command(), c.labels[i], err)
+}
@@ -0,0 +1,95 @@
+package maestro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	mimeText = "text/plain"
+	mimeHtml = "text/html"
+)
+
+func checkOutput(format string) error {
+	switch format {
+	case "", FormatText, FormatJson:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported output format", format)
+	}
+}
+
+// negotiateFormat gives the format of the response for a command writing its
+// output in the given format. The format is given by the format parameter of
+// the request or by its Accept header. The output of the command is used as is
+// when none of them are given.
+func negotiateFormat(r *http.Request, output string) (string, bool) {
+	if str := r.URL.Query().Get("format"); str != "" {
+		switch str {
+		case FormatText, FormatJson, FormatHtml:
+			return str, true
+		default:
+			return "", false
+		}
+	}
+	for _, a := range strings.Split(r.Header.Get(httpHdrAccept), ",") {
+		a, _, _ = strings.Cut(a, ";")
+		switch strings.TrimSpace(a) {
+		case mimeJson:
+			return FormatJson, true
+		case mimeHtml:
+			return FormatHtml, true
+		case mimeText:
+			return FormatText, true
+		case "", "*/*", "text/*", "application/*":
+			return output, true
+		}
+	}
+	return output, true
+}
+
+func mimeOf(format string) string {
+	switch format {
+	case FormatJson:
+		return mimeJson
+	case FormatHtml:
+		return mimeHtml
+	default:
+		return mimeText
+	}
+}
+
+// convertOutput writes the output of a command in the given format.
+func convertOutput(w io.Writer, output []byte, from, to string) error {
+	if from == FormatJson {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, output, "", "  "); err == nil {
+			output = buf.Bytes()
+		}
+	}
+	switch to {
+	case FormatHtml:
+		io.WriteString(w, "<!DOCTYPE html>\n<html><body><pre>")
+		io.WriteString(w, html.EscapeString(string(output)))
+		_, err := io.WriteString(w, "</pre></body></html>\n")
+		return err
+	case FormatJson:
+		if from == FormatJson {
+			_, err := w.Write(output)
+			return err
+		}
+		lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+		if len(output) == 0 {
+			lines = []string{}
+		}
+		return json.NewEncoder(w).Encode(lines)
+	default:
+		_, err := w.Write(output)
+		return err
+	}
+}
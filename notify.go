@@ -0,0 +1,267 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NotifyError   = "error"
+	NotifySuccess = "success"
+	NotifyAlways  = "always"
+
+	notifyLines   = 20
+	notifyTimeout = 10 * time.Second
+)
+
+func checkNotifyOn(on string) error {
+	switch on {
+	case "", NotifyError, NotifySuccess, NotifyAlways:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported notification trigger (use %s, %s or %s)", on, NotifyError, NotifySuccess, NotifyAlways)
+	}
+}
+
+// notification is the payload posted to the webhooks once a command has been
+// executed. The text field makes it usable as is by Slack compatible webhooks
+// (Slack, Mattermost, Rocket.Chat...). The other fields are for the generic
+// webhooks.
+type notification struct {
+	Text     string    `json:"text"`
+	Command  string    `json:"command"`
+	Args     []string  `json:"args,omitempty"`
+	Remote   bool      `json:"remote,omitempty"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"`
+	Exit     int       `json:"exit"`
+	Error    string    `json:"error,omitempty"`
+	Output   []string  `json:"output,omitempty"`
+}
+
+// notifier posts a notification to the webhooks configured with .NOTIFY_URL
+// or with the notify_url property of a command and sends it by email when a
+// SMTP server is configured. The last lines written by the command are given
+// in the notification.
+type notifier struct {
+	file string
+	urls []string
+	on   string
+	mail *mailer
+	to   []string
+	tail tailWriter
+}
+
+// notifier gives the notifier of a command. The notification is sent by email
+// to the given addresses or, without addresses, to the address of .EMAIL. It
+// is nil when neither a webhook nor an email is configured for the command.
+func (m *Maestro) notifier(name string, to ...string) *notifier {
+	cmd, err := m.Commands.Lookup(name)
+	if err != nil {
+		return nil
+	}
+	n := notifier{
+		file: m.Name(),
+		urls: m.MetaExec.NotifyURL,
+		on:   m.MetaExec.NotifyOn,
+		mail: m.mailer(),
+		to:   mailAddresses(to...),
+		tail: tailWriter{limit: notifyLines},
+	}
+	if len(cmd.NotifyURL) > 0 {
+		n.urls = cmd.NotifyURL
+	}
+	if cmd.NotifyOn != "" {
+		n.on = cmd.NotifyOn
+	}
+	if len(n.to) == 0 {
+		n.to = mailAddresses(m.MetaAbout.Email)
+	}
+	if n.mail == nil || len(n.to) == 0 {
+		n.mail, n.to = nil, nil
+	}
+	if n.mail != nil {
+		n.tail.limit = mailLines
+	}
+	if len(n.urls) == 0 && n.mail == nil {
+		return nil
+	}
+	return &n
+}
+
+// Reset gives a new notifier with the same settings but without the lines of
+// output of the previous executions.
+func (n *notifier) Reset() *notifier {
+	if n == nil {
+		return nil
+	}
+	return &notifier{
+		file: n.file,
+		urls: n.urls,
+		on:   n.on,
+		mail: n.mail,
+		to:   n.to,
+		tail: tailWriter{limit: n.tail.limit},
+	}
+}
+
+// Writer gives a writer that keeps the last lines written to w.
+func (n *notifier) Writer(w io.Writer) io.Writer {
+	return teeWriter(w, &n.tail)
+}
+
+func (n *notifier) Accept(err error) bool {
+	switch n.on {
+	case NotifyError:
+		return err != nil
+	case NotifySuccess:
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// Message gives the notification of the execution of a command.
+func (n *notifier) Message(name string, args []string, when time.Time, elapsed time.Duration, err error) notification {
+	msg := notification{
+		Command:  name,
+		Args:     args,
+		Start:    when,
+		Duration: elapsed.Seconds(),
+		Exit:     exitCode(err),
+		Output:   n.tail.Lines(),
+	}
+	status := "succeeded"
+	if err != nil {
+		status, msg.Error = "failed", err.Error()
+	}
+	msg.Text = fmt.Sprintf("%s: %s %s in %s", n.file, msg.Command, status, humanDuration(elapsed))
+	if err != nil {
+		msg.Text = fmt.Sprintf("%s (exit %d): %s", msg.Text, msg.Exit, msg.Error)
+	}
+	return msg
+}
+
+// Notify posts the notification to each webhook and sends it by email. All the
+// webhooks are tried even if one of them fails. The output given to the
+// webhooks is limited to its last lines while the email gets all the output
+// kept.
+func (n *notifier) Notify(msg notification) error {
+	var errs []error
+	if n.mail != nil {
+		body := strings.Join(append([]string{msg.Text, ""}, msg.Output...), "\n")
+		if err := n.mail.Send(n.to, msg.Text, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", strings.Join(n.to, ", "), err))
+		}
+	}
+	if len(msg.Output) > notifyLines {
+		msg.Output = msg.Output[len(msg.Output)-notifyLines:]
+	}
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: notifyTimeout}
+	for _, u := range n.urls {
+		if err := n.post(&client, u, buf); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u, err))
+		}
+	}
+	return hasError(errs...)
+}
+
+func (n *notifier) post(client *http.Client, url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// notify sends the notification of the execution of a command if it has to be
+// sent according to the result of the command. The values of the sensitive
+// options are masked. Failing to notify does not make the execution of the
+// command fail.
+func (m *Maestro) notify(n *notifier, name string, args []string, when time.Time, err error, stderr io.Writer) {
+	if n == nil || !n.Accept(err) {
+		return
+	}
+	var values []string
+	if cmd, e := m.Commands.Lookup(name); e == nil {
+		name = cmd.Name
+		args, values = cmd.maskArgs(args)
+	}
+	msg := n.Message(name, args, when, m.clock().Now().Sub(when), err)
+	msg.Remote = m.Remote
+	msg.Text = redact(msg.Text, values...)
+	msg.Error = redact(msg.Error, values...)
+	if e := n.Notify(msg); e != nil {
+		fmt.Fprintf(stderr, "fail to notify: %s", e)
+		fmt.Fprintln(stderr)
+	}
+}
+
+// tailWriter keeps the last lines written to it without their colors.
+type tailWriter struct {
+	mu    sync.Mutex
+	limit int
+	lines []string
+	rest  []byte
+}
+
+func (w *tailWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rest = append(w.rest, b...)
+	for {
+		x := bytes.IndexByte(w.rest, '\n')
+		if x < 0 {
+			break
+		}
+		w.add(string(w.rest[:x]))
+		w.rest = w.rest[x+1:]
+	}
+	return len(b), nil
+}
+
+// Lines gives the last lines written including the last line even if it is
+// not terminated by a newline.
+func (w *tailWriter) Lines() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := append([]string{}, w.lines...)
+	if len(w.rest) > 0 {
+		list = append(list, ansiPattern.ReplaceAllString(string(w.rest), ""))
+		if len(list) > w.limit {
+			list = list[1:]
+		}
+	}
+	return list
+}
+
+func (w *tailWriter) add(line string) {
+	line = ansiPattern.ReplaceAllString(line, "")
+	w.lines = append(w.lines, strings.TrimRight(line, "\r"))
+	if len(w.lines) > w.limit {
+		w.lines = w.lines[1:]
+	}
+}
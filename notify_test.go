@@ -0,0 +1,208 @@
+package maestro
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const notifyFile = `
+.NOTIFY_URL = "%[1]s"
+.NOTIFY_ON  = error
+
+build: {
+	true
+}
+
+deploy(
+	notify_on = always,
+	options   = (short = p, long = password, sensitive = true),
+): {
+	true
+}
+
+check(
+	notify_url = "%[1]s/check",
+	notify_on  = success,
+): {
+	true
+}
+`
+
+func TestNotify(t *testing.T) {
+	var list []notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("fail to decode notification: %s", err)
+		}
+		list = append(list, n)
+	}))
+	defer srv.Close()
+
+	d, err := NewDecoder(strings.NewReader(fmt.Sprintf(notifyFile, srv.URL)))
+	if err != nil {
+		t.Fatalf("fail to create decoder: %s", err)
+	}
+	mst, err := d.Decode()
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	tests := []struct {
+		Command string
+		Err     error
+		Sent    bool
+	}{
+		{Command: "build", Err: nil, Sent: false},
+		{Command: "build", Err: errors.New("failure"), Sent: true},
+		{Command: "deploy", Err: nil, Sent: true},
+		{Command: "check", Err: errors.New("failure"), Sent: false},
+		{Command: "check", Err: nil, Sent: true},
+	}
+	for _, tt := range tests {
+		list = list[:0]
+		n := mst.notifier(tt.Command)
+		if n == nil {
+			t.Fatalf("%s: no notifier created", tt.Command)
+		}
+		w := n.Writer(io.Discard)
+		for i := 0; i < notifyLines+5; i++ {
+			fmt.Fprintf(w, "\x1b[1;31mline %d\x1b[0m\n", i)
+		}
+		io.WriteString(w, "last")
+		mst.notify(n, tt.Command, nil, time.Now(), tt.Err, io.Discard)
+		if !tt.Sent {
+			if len(list) > 0 {
+				t.Errorf("%s: unexpected notification sent", tt.Command)
+			}
+			continue
+		}
+		if len(list) != 1 {
+			t.Errorf("%s: notification not sent", tt.Command)
+			continue
+		}
+		got := list[0]
+		if got.Command != tt.Command || (got.Error != "") != (tt.Err != nil) {
+			t.Errorf("%s: unexpected notification %+v", tt.Command, got)
+		}
+		if len(got.Output) != notifyLines || got.Output[0] != "line 6" || got.Output[notifyLines-1] != "last" {
+			t.Errorf("%s: unexpected output %q", tt.Command, got.Output)
+		}
+	}
+
+	list = list[:0]
+	n := mst.notifier("deploy")
+	mst.notify(n, "deploy", []string{"-p", "hunter22"}, time.Now(), errors.New("access denied with hunter22"), io.Discard)
+	if len(list) != 1 {
+		t.Fatalf("deploy: notification not sent")
+	}
+	got := list[0]
+	if want := []string{"-p", "***"}; fmt.Sprint(got.Args) != fmt.Sprint(want) {
+		t.Errorf("deploy: arguments mismatched! want %q, got %q", want, got.Args)
+	}
+	if strings.Contains(got.Error, "hunter22") || strings.Contains(got.Text, "hunter22") {
+		t.Errorf("deploy: sensitive value sent: %+v", got)
+	}
+}
+
+const mailFile = `
+.EMAIL     = "ops@example.org, dev@example.org"
+.SMTP_HOST = "%s"
+.SMTP_FROM = maestro@example.org
+
+build: {
+	true
+}
+`
+
+func TestNotifyMail(t *testing.T) {
+	srv, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen: %s", err)
+	}
+	defer srv.Close()
+
+	var (
+		rcpt []string
+		data strings.Builder
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		conn, err := srv.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var (
+			scan  = bufio.NewScanner(conn)
+			write = func(str string) { io.WriteString(conn, str+"\r\n") }
+			body  bool
+		)
+		write("220 localhost ESMTP")
+		for scan.Scan() {
+			line := scan.Text()
+			if body {
+				if line == "." {
+					body = false
+					write("250 OK")
+				} else {
+					data.WriteString(line + "\n")
+				}
+				continue
+			}
+			switch cmd := strings.ToUpper(line); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				rcpt = append(rcpt, strings.Trim(line[8:], "<>"))
+				write("250 OK")
+			case cmd == "DATA":
+				body = true
+				write("354 go ahead")
+			case cmd == "QUIT":
+				write("221 bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+
+	d, err := NewDecoder(strings.NewReader(fmt.Sprintf(mailFile, srv.Addr())))
+	if err != nil {
+		t.Fatalf("fail to create decoder: %s", err)
+	}
+	mst, err := d.Decode()
+	if err != nil {
+		t.Fatalf("fail to decode file: %s", err)
+	}
+	n := mst.notifier("build")
+	if n == nil {
+		t.Fatalf("no notifier created")
+	}
+	w := n.Writer(io.Discard)
+	for i := 0; i < notifyLines+5; i++ {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+	mst.notify(n, "build", nil, time.Now(), errors.New("failure"), io.Discard)
+	<-done
+
+	if want := []string{"ops@example.org", "dev@example.org"}; fmt.Sprint(rcpt) != fmt.Sprint(want) {
+		t.Errorf("recipients mismatched! want %q, got %q", want, rcpt)
+	}
+	str := data.String()
+	for _, want := range []string{"From: maestro@example.org", "build failed", "line 0\n", "line 24\n"} {
+		if !strings.Contains(str, want) {
+			t.Errorf("%q not found in mail %q", want, str)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package maestro
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+const npmBin = "node_modules/.bin"
+
+// parsePackageJson converts the scripts of a package.json. The pre and post
+// scripts of npm are respectively given as dependency and called at the end of
+// the script of the command they belong to.
+func parsePackageJson(r io.Reader) (*importFile, error) {
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.NewDecoder(r).Decode(&pkg); err != nil {
+		return nil, err
+	}
+	var (
+		mf    importFile
+		names []string
+	)
+	for n := range pkg.Scripts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		cmd := importCommand{
+			Name:   commandName(n),
+			Paths:  []string{npmBin},
+			Script: []string{pkg.Scripts[n]},
+		}
+		for _, prefix := range []string{"pre", "post"} {
+			other := strings.TrimPrefix(n, prefix)
+			if _, ok := pkg.Scripts[other]; ok && other != n {
+				cmd.Help = prefix + " script of " + other
+			}
+		}
+		if _, ok := pkg.Scripts["pre"+n]; ok {
+			cmd.Deps = append(cmd.Deps, commandName("pre"+n))
+		}
+		if _, ok := pkg.Scripts["post"+n]; ok {
+			cmd.Script = append(cmd.Script, commandName("post"+n))
+		}
+		mf.Commands = append(mf.Commands, cmd)
+	}
+	if _, ok := pkg.Scripts["start"]; ok {
+		mf.Default = commandName("start")
+	}
+	return &mf, nil
+}
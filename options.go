@@ -12,6 +12,7 @@ type Dirs struct {
 }
 
 func (d *Dirs) Set(str string) error {
+	str = normalizePath(str)
 	if i, err := os.Stat(str); err != nil || !i.IsDir() {
 		return fmt.Errorf("%s is not a directory", str)
 	}
@@ -26,13 +27,56 @@ func (d *Dirs) String() string {
 	return strings.Join(d.List, ", ")
 }
 
-func (d *Dirs) Exists(file string) (string, bool) {
-	for i := range d.List {
-		f := filepath.Join(d.List[i], file)
-		if i, err := os.Stat(f); err == nil && i.Mode().IsRegular() {
-			return f, true
+// Lookup gives the files matching the given pattern. A relative pattern is
+// searched first in dir, then in the list of directories and finally in the
+// current directory. A directory gives all the maestro files it contains.
+func (d *Dirs) Lookup(pattern, dir string) ([]string, error) {
+	if filepath.IsAbs(pattern) {
+		files, err := expandInclude(pattern)
+		if err == nil && len(files) == 0 {
+			err = fmt.Errorf("%s: no such file", pattern)
 		}
+		return files, err
 	}
-	i, err := os.Stat(file)
-	return file, err == nil && i.Mode().IsRegular()
+	dirs := append([]string{dir}, d.List...)
+	if dir != "." {
+		dirs = append(dirs, ".")
+	}
+	for i := range dirs {
+		files, err := expandInclude(filepath.Join(dirs[i], pattern))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			return files, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no such file in %s", pattern, strings.Join(dirs, ", "))
+}
+
+func expandInclude(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pattern, err)
+	}
+	var files []string
+	for _, m := range matches {
+		i, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		if i.IsDir() {
+			list, _ := filepath.Glob(filepath.Join(m, "*"+filepath.Ext(DefaultFile)))
+			for _, f := range list {
+				if i, err := os.Stat(f); err == nil && i.Mode().IsRegular() {
+					files = append(files, f)
+				}
+			}
+			continue
+		}
+		if i.Mode().IsRegular() {
+			files = append(files, m)
+		}
+	}
+	return files, nil
 }
@@ -0,0 +1,85 @@
+package maestro
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandInclude(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Want    []string
+	}{
+		{Pattern: "self.mf", Want: []string{"self.mf"}},
+		{Pattern: "*.mf", Want: []string{"maestro.mf", "self.mf"}},
+		{Pattern: "cmds", Want: []string{"cmds/build.mf", "cmds/test.mf"}},
+		{Pattern: "lib/*.mf", Want: []string{"lib/fmt.mf", "lib/lint.mf"}},
+		{Pattern: "cmds/*", Want: []string{"cmds/build.mf", "cmds/nested/skip.mf", "cmds/notes.txt", "cmds/test.mf"}},
+		{Pattern: "missing.mf"},
+	}
+	dir := filepath.Join("testdata", "include")
+	for _, tt := range tests {
+		files, err := expandInclude(filepath.Join(dir, tt.Pattern))
+		if err != nil {
+			t.Errorf("%s: fail to expand: %s", tt.Pattern, err)
+			continue
+		}
+		var got []string
+		for _, f := range files {
+			rel, _ := filepath.Rel(dir, f)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.Want, " ") {
+			t.Errorf("%s: want %q, got %q", tt.Pattern, tt.Want, got)
+		}
+	}
+	if _, err := expandInclude("[-"); err == nil {
+		t.Errorf("invalid pattern should fail")
+	}
+}
+
+func TestDirsLookup(t *testing.T) {
+	var (
+		root = filepath.Join("testdata", "include")
+		dirs Dirs
+	)
+	if err := dirs.Set(filepath.Join(root, "lib")); err != nil {
+		t.Fatalf("fail to add directory: %s", err)
+	}
+	if err := dirs.Set(filepath.Join(root, "self.mf")); err == nil {
+		t.Errorf("a file should not be accepted as directory")
+	}
+	abs, _ := filepath.Abs(filepath.Join(root, "self.mf"))
+	tests := []struct {
+		Pattern string
+		Dir     string
+		Want    []string
+		Fail    bool
+	}{
+		{Pattern: "self.mf", Dir: root, Want: []string{filepath.Join(root, "self.mf")}},
+		{Pattern: "fmt.mf", Dir: root, Want: []string{filepath.Join(root, "lib", "fmt.mf")}},
+		{Pattern: "*.mf", Dir: filepath.Join(root, "cmds"), Want: []string{filepath.Join(root, "cmds", "build.mf"), filepath.Join(root, "cmds", "test.mf")}},
+		{Pattern: "lint.mf", Dir: filepath.Join(root, "cmds"), Want: []string{filepath.Join(root, "lib", "lint.mf")}},
+		{Pattern: "go.mod", Dir: root, Want: []string{"go.mod"}},
+		{Pattern: abs, Dir: ".", Want: []string{abs}},
+		{Pattern: "missing.mf", Dir: root, Fail: true},
+		{Pattern: filepath.Join(abs, "missing.mf"), Dir: root, Fail: true},
+	}
+	for _, tt := range tests {
+		got, err := dirs.Lookup(tt.Pattern, tt.Dir)
+		if tt.Fail {
+			if err == nil {
+				t.Errorf("%s: lookup should have failed", tt.Pattern)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: fail to lookup: %s", tt.Pattern, err)
+			continue
+		}
+		if strings.Join(got, " ") != strings.Join(tt.Want, " ") {
+			t.Errorf("%s: want %q, got %q", tt.Pattern, tt.Want, got)
+		}
+	}
+}
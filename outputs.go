@@ -0,0 +1,144 @@
+package maestro
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/midbel/tish"
+)
+
+// OutputEnv is the variable giving to the commands that declare outputs the
+// file where they write them.
+const OutputEnv = "MAESTRO_OUTPUT"
+
+type outputsKey struct{}
+
+// withOutputs gives the outputs shared by the commands executed with ctx.
+func withOutputs(ctx context.Context, o *outputs) context.Context {
+	return context.WithValue(ctx, outputsKey{}, o)
+}
+
+func outputsFrom(ctx context.Context) *outputs {
+	o, _ := ctx.Value(outputsKey{}).(*outputs)
+	return o
+}
+
+// outputs keeps the values written by the commands that declare outputs. They
+// are exported to the environment of the commands executed after them.
+type outputs struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func createOutputs() *outputs {
+	return &outputs{
+		values: make(map[string]string),
+	}
+}
+
+// Export defines the outputs as environment variables of the shell.
+func (o *outputs) Export(sh *tish.Shell) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for k, v := range o.values {
+		sh.Export(k, v)
+	}
+}
+
+// Set keeps the value of a variable captured by a command.
+func (o *outputs) Set(name, value string) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.values[name] = value
+}
+
+// Read reads the key=value lines written by a command in file and keeps the
+// values. Only the names declared by the command can be written. The values
+// are checked even when they are not kept.
+func (o *outputs) Read(name, file string, names []string) error {
+	values, err := readOutputFile(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for k := range values {
+		if !hasOutput(names, k) {
+			return fmt.Errorf("%s: %s: output not declared", name, k)
+		}
+	}
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for k, v := range values {
+		o.values[k] = v
+	}
+	return nil
+}
+
+func hasOutput(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readOutputFile reads the key=value lines of file. The blank lines and the
+// comments are skipped and the quoted values are unquoted.
+func readOutputFile(file string) (map[string]string, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var (
+		values = make(map[string]string)
+		scan   = bufio.NewScanner(r)
+	)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return nil, fmt.Errorf("%s: invalid output", line)
+		}
+		value = strings.TrimSpace(value)
+		if str, err := strconv.Unquote(value); err == nil {
+			value = str
+		}
+		values[key] = value
+	}
+	return values, scan.Err()
+}
+
+// createOutputFile creates the file where the command writes its outputs and
+// gives its path to the command with $MAESTRO_OUTPUT. Nothing is created when
+// the command does not declare outputs.
+func (c *command) createOutputFile() (string, func(), error) {
+	if len(c.outputs) == 0 {
+		return "", func() {}, nil
+	}
+	f, err := os.CreateTemp("", "maestro-output-*")
+	if err != nil {
+		return "", nil, err
+	}
+	f.Close()
+	c.shell.Export(OutputEnv, f.Name())
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
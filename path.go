@@ -0,0 +1,169 @@
+package maestro
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/midbel/tish"
+)
+
+// normalizePath expands the environment variables and the leading ~ of the
+// given path and converts it to the form expected by the current OS. Paths in
+// a maestro file can then always be written with forward slashes.
+func normalizePath(str string) string {
+	if str == "" {
+		return str
+	}
+	str = os.ExpandEnv(str)
+	if str == "~" || strings.HasPrefix(str, "~/") || strings.HasPrefix(str, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			str = home + str[1:]
+		}
+	}
+	return filepath.Clean(filepath.FromSlash(str))
+}
+
+func normalizePaths(list []string) []string {
+	for i := range list {
+		list[i] = normalizePath(list[i])
+	}
+	return list
+}
+
+func absPath(file, dir string) string {
+	file = normalizePath(file)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+		if a, err := filepath.Abs(file); err == nil {
+			file = a
+		}
+	}
+	return file
+}
+
+// relPath gives the path of file relative to dir with forward slashes. The
+// file is returned unchanged when it is outside of dir.
+func relPath(file, dir string) string {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return file
+	}
+	return filepath.ToSlash(rel)
+}
+
+func lookPath(dirs []string, name string) (string, bool) {
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return "", false
+	}
+	for _, d := range filepath.SplitList(strings.Join(dirs, string(os.PathListSeparator))) {
+		file, err := exec.LookPath(filepath.Join(d, name))
+		if err == nil {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+type pathCommand struct {
+	*exec.Cmd
+	name string
+	// pseudo terminal of the interactive command starting the program
+	console *console
+}
+
+func makePathCommand(ctx context.Context, name, file, dir string) *pathCommand {
+	cmd := exec.CommandContext(ctx, file)
+	cmd.Dir = dir
+	return &pathCommand{
+		Cmd:  cmd,
+		name: name,
+	}
+}
+
+func (c *pathCommand) Command() string {
+	return c.name
+}
+
+func (c *pathCommand) Type() tish.CommandType {
+	return tish.TypeRegular
+}
+
+func (c *pathCommand) SetArgs(args []string) {
+	c.Args = append(c.Args[:1], args...)
+}
+
+func (c *pathCommand) SetEnv(env []string) {
+	c.Env = append(c.Env[:0], env...)
+}
+
+// SetIn, SetOut and SetErr give the files wrapped by the shell as is to the
+// program (as the shell does for the programs it starts) so that the program
+// reads (or writes) them directly instead of through a pipe.
+func (c *pathCommand) SetIn(r io.Reader) {
+	if u, ok := r.(interface{ Unwrap() io.Reader }); ok {
+		if f, ok := u.Unwrap().(*os.File); ok {
+			r = f
+		}
+	}
+	c.Stdin = r
+}
+
+func (c *pathCommand) SetOut(w io.Writer) {
+	c.Stdout = unwrapFile(w)
+}
+
+func (c *pathCommand) SetErr(w io.Writer) {
+	c.Stderr = unwrapFile(w)
+}
+
+func unwrapFile(w io.Writer) io.Writer {
+	if u, ok := w.(interface{ Unwrap() io.Writer }); ok {
+		if f, ok := u.Unwrap().(*os.File); ok {
+			return f
+		}
+	}
+	return w
+}
+
+// Start starts the program. The program started by an interactive command
+// gets the pseudo terminal of the command as controlling terminal when it is
+// one of its standard files and no other program has it.
+func (c *pathCommand) Start() error {
+	if c.console != nil {
+		if fd, ok := c.console.Claim(c, c.Stdin, c.Stdout, c.Stderr); ok {
+			setControllingTerminal(c.Cmd, fd)
+		}
+	}
+	err := c.Cmd.Start()
+	if err != nil && c.console != nil {
+		c.console.Release(c)
+	}
+	return err
+}
+
+func (c *pathCommand) Wait() error {
+	defer func() {
+		if c.console != nil {
+			c.console.Release(c)
+		}
+	}()
+	return c.Cmd.Wait()
+}
+
+func (c *pathCommand) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *pathCommand) Exit() (int, int) {
+	if c.ProcessState == nil {
+		return 0, 255
+	}
+	return c.ProcessState.Pid(), c.ProcessState.ExitCode()
+}
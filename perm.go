@@ -0,0 +1,57 @@
+package maestro
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+const (
+	PermWarn   = "warn"
+	PermRefuse = "refuse"
+)
+
+func checkPermMode(mode string) error {
+	switch mode {
+	case "", PermWarn, PermRefuse:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported permissions check (use %s or %s)", mode, PermWarn, PermRefuse)
+	}
+}
+
+// checkPermissions verifies that the maestro file can only be modified by the
+// user running maestro since its scripts are executed as this user. A file
+// writable by its group or by everyone or owned by another user (except root)
+// is reported as a warning or refused according to CheckPerms. Nothing is
+// checked when CheckPerms is not set.
+func (m *Maestro) checkPermissions(file string) error {
+	if m.CheckPerms == "" {
+		return nil
+	}
+	if err := checkPermMode(m.CheckPerms); err != nil {
+		return err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	var reason string
+	if perm := fi.Mode().Perm(); perm&0o002 != 0 {
+		reason = "writable by everyone"
+	} else if perm&0o020 != 0 {
+		reason = "writable by its group"
+	} else if uid, ok := fileOwner(fi); ok && uid != 0 && uid != os.Getuid() {
+		reason = fmt.Sprintf("owned by another user (uid %d)", uid)
+	}
+	if reason == "" {
+		return nil
+	}
+	if m.CheckPerms == PermRefuse {
+		return fmt.Errorf("%s: unsafe permissions: file is %s", file, reason)
+	}
+	fmt.Fprintf(stdio.Stderr, "warning: %s: file is %s", file, reason)
+	fmt.Fprintln(stdio.Stderr)
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package maestro
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner gives the id of the user owning the file.
+func fileOwner(fi os.FileInfo) (int, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(st.Uid), true
+}
@@ -0,0 +1,10 @@
+package maestro
+
+import (
+	"os"
+)
+
+// fileOwner is not supported on windows: only the mode of the file is checked.
+func fileOwner(fi os.FileInfo) (int, bool) {
+	return 0, false
+}
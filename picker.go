@@ -0,0 +1,187 @@
+package maestro
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+const pickerSize = 10
+
+var errCancel = errors.New("cancelled")
+
+type pickItem struct {
+	Name  string
+	Short string
+	Icon  string
+	score int
+}
+
+func (i pickItem) Label() string {
+	if i.Icon == "" {
+		return i.Name
+	}
+	return i.Icon + " " + i.Name
+}
+
+// picker lets the user select a command by typing part of its name. Items
+// are filtered with a fuzzy match: the characters typed should appear in the
+// same order in the name of the command.
+type picker struct {
+	in    *os.File
+	out   io.Writer
+	items []pickItem
+
+	query    []rune
+	selected int
+	drawn    int
+}
+
+func (m *Maestro) pick() (string, error) {
+	p := picker{
+		in:  os.Stdin,
+		out: os.Stdout,
+	}
+	for _, c := range m.Commands {
+		if c.Blocked() {
+			continue
+		}
+		p.items = append(p.items, pickItem{Name: c.Name, Short: c.Short, Icon: c.Icon})
+	}
+	if len(p.items) == 0 {
+		return "", fmt.Errorf("no command available")
+	}
+	sort.Slice(p.items, func(i, j int) bool {
+		return p.items[i].Name < p.items[j].Name
+	})
+	return p.Pick()
+}
+
+func (p *picker) Pick() (string, error) {
+	state, err := term.MakeRaw(int(p.in.Fd()))
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(int(p.in.Fd()), state)
+
+	buf := make([]byte, 16)
+	for {
+		list := p.filter()
+		if p.selected >= len(list) {
+			p.selected = len(list) - 1
+		}
+		if p.selected < 0 {
+			p.selected = 0
+		}
+		p.draw(list)
+		n, err := p.in.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		switch key := string(buf[:n]); key {
+		case "\r", "\n":
+			p.clear()
+			if len(list) == 0 {
+				continue
+			}
+			return list[p.selected].Name, nil
+		case "\x03", "\x1b":
+			p.clear()
+			return "", errCancel
+		case "\x1b[A", "\x10":
+			p.selected--
+		case "\x1b[B", "\x0e", "\t":
+			p.selected++
+		case "\x7f", "\b":
+			if len(p.query) > 0 {
+				p.query = p.query[:len(p.query)-1]
+			}
+		default:
+			for _, r := range key {
+				if r >= ' ' && r != 0x7f {
+					p.query = append(p.query, r)
+				}
+			}
+			p.selected = 0
+		}
+	}
+}
+
+func (p *picker) filter() []pickItem {
+	var list []pickItem
+	for _, i := range p.items {
+		score, ok := fuzzyMatch(string(p.query), i.Name)
+		if !ok {
+			continue
+		}
+		i.score = score
+		list = append(list, i)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].score < list[j].score
+	})
+	return list
+}
+
+func (p *picker) draw(list []pickItem) {
+	p.clear()
+	var size int
+	for _, i := range list {
+		if n := utf8.RuneCountInString(i.Label()); n > size {
+			size = n
+		}
+	}
+	fmt.Fprintf(p.out, "command> %s\r\n", string(p.query))
+	p.drawn = 1
+	start := 0
+	if p.selected >= pickerSize {
+		start = p.selected - pickerSize + 1
+	}
+	for i := start; i < len(list) && i < start+pickerSize; i++ {
+		mark := " "
+		if i == p.selected {
+			mark = ">"
+		}
+		fmt.Fprintf(p.out, "%s %-*s  %s\r\n", mark, size, list[i].Label(), list[i].Short)
+		p.drawn++
+	}
+	if len(list) == 0 {
+		fmt.Fprint(p.out, "  no matching command\r\n")
+		p.drawn++
+	}
+}
+
+func (p *picker) clear() {
+	if p.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(p.out, "\x1b[%dA\r\x1b[J", p.drawn)
+	p.drawn = 0
+}
+
+// fuzzyMatch reports whether all the characters of query appear in str in the
+// same order. The score is lower for better matches: matches at the start of
+// str and consecutive characters are preferred.
+func fuzzyMatch(query, str string) (int, bool) {
+	var (
+		score int
+		last  = -1
+		lower = strings.ToLower(str)
+	)
+	for _, r := range strings.ToLower(query) {
+		x := strings.IndexRune(lower[last+1:], r)
+		if x < 0 {
+			return 0, false
+		}
+		x += last + 1
+		score += x - last - 1
+		last = x
+	}
+	return score, true
+}
@@ -0,0 +1,102 @@
+package maestro
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+type stdinKey struct{}
+
+// withStdin gives a context where the command executed reads r instead of
+// the standard input. Unlike withInput, only the command itself reads r: its
+// dependencies and its hooks keep the standard input.
+func withStdin(ctx context.Context, r io.Reader) context.Context {
+	return context.WithValue(ctx, stdinKey{}, r)
+}
+
+func stdinFrom(ctx context.Context) io.Reader {
+	r, _ := ctx.Value(stdinKey{}).(io.Reader)
+	return r
+}
+
+// execpipe runs the commands of a pipe at the same time, the output of each
+// command being the input of the next one. The output of the last command is
+// the output of the pipe.
+//
+// A command writing faster than the next one reads is blocked once the buffer
+// of the pipe is full. The first command that fails cancels the others and
+// its error is the error of the pipe. A command that stops reading makes the
+// previous one fail as soon as it writes again: this failure is ignored as the
+// output of the previous command is not wanted anymore (eg: head).
+type execpipe struct {
+	list       []executer
+	background bool
+}
+
+func (e execpipe) Execute(ctx context.Context, stdout, stderr io.Writer) error {
+	var (
+		pool, sub = createPool(ctx, 0)
+		in        = stdinFrom(ctx)
+		prev      *os.File
+		files     []*os.File
+		done      = make([]int32, len(e.list))
+		err       error
+	)
+	for i := range e.list {
+		var (
+			i     = i
+			ex    = e.list[i]
+			stdin = in
+			rd    = prev
+			wr    *os.File
+			out   = stdout
+		)
+		if i < len(e.list)-1 {
+			var r *os.File
+			if r, wr, err = os.Pipe(); err != nil {
+				pool.Cancel()
+				break
+			}
+			files = append(files, r, wr)
+			in, prev, out = r, r, wr
+		}
+		err = pool.Go(sub, func() error {
+			// closing its ends of the pipes once the command is done gives
+			// the end of its input to the next command and makes the previous
+			// command fail when it writes again
+			defer closeFile(rd)
+			defer closeFile(wr)
+			ctx := sub
+			if stdin != nil {
+				ctx = withStdin(ctx, stdin)
+			}
+			err := ex.Execute(ctx, out, stderr)
+			if err != nil && i < len(done)-1 && atomic.LoadInt32(&done[i+1]) == 1 {
+				// the next command has stopped reading
+				err = nil
+			}
+			atomic.StoreInt32(&done[i], 1)
+			return err
+		})
+		if err != nil {
+			break
+		}
+	}
+	err = hasError(pool.Wait(), err, ctx.Err())
+	for _, f := range files {
+		f.Close()
+	}
+	return err
+}
+
+func (e execpipe) Bg() bool {
+	return e.background
+}
+
+func closeFile(f *os.File) {
+	if f != nil {
+		f.Close()
+	}
+}
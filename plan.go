@@ -0,0 +1,189 @@
+package maestro
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// planStep is a command of the plan of an execution with the lines of its
+// script.
+type planStep struct {
+	Command    string
+	Args       []string
+	Role       string
+	Level      int
+	Background bool
+	Lines      []string
+}
+
+// plan gives the commands of the tree in the order they are executed: the
+// dependencies, the commands executed before the command, the command itself
+// and the commands executed after it.
+func plan(ex executer) ([]planStep, error) {
+	var (
+		list []planStep
+		walk func(executer, int) error
+		add  func(Executer, []string, string, int, bool) error
+	)
+	add = func(cmd Executer, args []string, role string, level int, bg bool) error {
+		lines, err := cmd.Script(args)
+		if err != nil {
+			return err
+		}
+		lines, args = maskPlan(cmd, lines, args)
+		list = append(list, planStep{
+			Command:    cmd.Command(),
+			Args:       args,
+			Role:       role,
+			Level:      level,
+			Background: bg,
+			Lines:      lines,
+		})
+		return nil
+	}
+	addHooks := func(list []Executer, role string, level int) error {
+		for _, h := range list {
+			if err := add(h, nil, role, level, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	walk = func(ex executer, level int) error {
+		var (
+			cmd  Executer
+			args []string
+			deps deplist
+			hk   hooks
+			role = "command"
+			bg   bool
+			main bool
+		)
+		switch e := ex.(type) {
+		case *ctree:
+			return walk(e.root, level)
+		case exectap:
+			return walk(e.inner, level)
+		case exectapPlan:
+			return walk(e.inner, level)
+		case exectrace:
+			return walk(e.inner, level)
+		case execprogress:
+			return walk(e.inner, level)
+		case execpipe:
+			for _, x := range e.list {
+				if err := walk(x, level); err != nil {
+					return err
+				}
+			}
+			return nil
+		case execmain:
+			cmd, args, deps, hk = e.Executer, e.args, e.list, e.hooks
+			main = true
+		case execdep:
+			cmd, args, deps, hk = e.Executer, e.args, e.list, e.hooks
+			role, bg = "dependency", e.background
+		default:
+			return fmt.Errorf("unexpected executer %T", ex)
+		}
+		// the commands executed before the main command are executed before
+		// its dependencies
+		if main {
+			if err := addHooks(hk.pre, "before", level); err != nil {
+				return err
+			}
+		}
+		for _, d := range deps {
+			if err := walk(d, level+1); err != nil {
+				return err
+			}
+		}
+		if !main {
+			if err := addHooks(hk.pre, "before", level); err != nil {
+				return err
+			}
+		}
+		if err := add(cmd, args, role, level, bg); err != nil {
+			return err
+		}
+		if err := addHooks(hk.success, "on success", level); err != nil {
+			return err
+		}
+		if err := addHooks(hk.errors, "on error", level); err != nil {
+			return err
+		}
+		return addHooks(hk.post, "after", level)
+	}
+	return list, walk(ex, 0)
+}
+
+// maskPlan replaces the secrets of the command (the values of its sensitive
+// variables and options) in the lines of its script and in its arguments.
+func maskPlan(cmd Executer, lines, args []string) ([]string, []string) {
+	s, ok := cmd.(interface{ Secrets() []string })
+	if !ok {
+		return lines, args
+	}
+	values := s.Secrets()
+	mask := func(list []string) []string {
+		var res []string
+		for _, str := range list {
+			res = append(res, redact(str, values...))
+		}
+		return res
+	}
+	return mask(lines), mask(args)
+}
+
+// printPlan writes the commands of the plan with their scripts.
+func printPlan(w io.Writer, list []planStep) {
+	fmt.Fprintln(w, "plan:")
+	for i, s := range list {
+		var (
+			indent = strings.Repeat("  ", s.Level)
+			name   = strings.Join(append([]string{s.Command}, s.Args...), " ")
+		)
+		fmt.Fprintf(w, "%3d. %s%s (%s", i+1, indent, name, s.Role)
+		if s.Background {
+			fmt.Fprint(w, ", background")
+		}
+		fmt.Fprintln(w, ")")
+		for _, line := range s.Lines {
+			fmt.Fprintf(w, "       %s%s", indent, line)
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// confirmPlan prints the plan of the execution and asks the user to confirm
+// it before the execution starts.
+func (m *Maestro) confirmPlan(ex executer, w io.Writer) error {
+	list, err := plan(ex)
+	if err != nil {
+		return err
+	}
+	printPlan(w, list)
+	return m.confirmed("plan")
+}
+
+// confirmed asks the user to type yes to go on. The confirmation is not asked
+// with --yes and is an error when the user can not be prompted.
+func (m *Maestro) confirmed(what string) error {
+	if m.Yes {
+		return nil
+	}
+	if !m.interactive() {
+		return fmt.Errorf("%s: confirmation required (use --yes)", what)
+	}
+	p := createPrompter(os.Stdin, os.Stderr)
+	str, err := p.Ask("type yes to continue", false)
+	if err != nil {
+		return err
+	}
+	if str != "yes" {
+		return fmt.Errorf("%s: %w", what, errCancel)
+	}
+	return nil
+}
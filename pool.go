@@ -0,0 +1,153 @@
+package maestro
+
+import (
+	"context"
+	"sync"
+)
+
+// pool runs functions with at most a given number of them at the same time.
+// The functions can also be put in groups having their own limit. The first
+// error returned by one of the functions cancels the context given by
+// createPool so that the functions still running can stop early unless the
+// pool keeps going after a failure.
+type pool struct {
+	sema   chan struct{}
+	cancel context.CancelFunc
+
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	groups map[string]chan struct{}
+	keep   bool
+	errs   []error
+}
+
+// createPool gives a pool executing at most limit functions at the same time.
+// Without limit, the number of functions executed is not bounded.
+func createPool(ctx context.Context, limit int) (*pool, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	p := pool{
+		cancel: cancel,
+		groups: make(map[string]chan struct{}),
+	}
+	if limit > 0 {
+		p.sema = make(chan struct{}, limit)
+	}
+	return &p, ctx
+}
+
+// Limit restricts the number of functions of the group executed at the same
+// time. It has to be called before the functions of the group are given to
+// the pool: changing the limit of a group does not apply to the functions
+// already given. Setting the same limit again has no effect.
+func (p *pool) Limit(group string, limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if limit <= 0 {
+		delete(p.groups, group)
+		return
+	}
+	if gs, ok := p.groups[group]; ok && cap(gs) == limit {
+		return
+	}
+	p.groups[group] = make(chan struct{}, limit)
+}
+
+// KeepGoing makes the pool execute all the functions even if some of them
+// fail. Wait then gives the errors of all the functions that have failed.
+func (p *pool) KeepGoing() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keep = true
+}
+
+// Go waits for a free worker then executes fn in its own goroutine. It gives
+// up without executing fn if ctx is done before a worker is available.
+func (p *pool) Go(ctx context.Context, fn func() error) error {
+	return p.GoGroup(ctx, "", fn)
+}
+
+// GoGroup is like Go but fn also waits for a free worker of its group.
+func (p *pool) GoGroup(ctx context.Context, group string, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	gs := p.groups[group]
+	p.mu.Unlock()
+
+	if err := acquire(ctx, gs); err != nil {
+		return err
+	}
+	if err := acquire(ctx, p.sema); err != nil {
+		release(gs)
+		return err
+	}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			release(p.sema)
+			release(gs)
+			p.wg.Done()
+		}()
+		p.fail(fn())
+	}()
+	return nil
+}
+
+// Run executes fn in the current goroutine. Its error is handled as the error
+// of the functions executed by Go and is also returned.
+func (p *pool) Run(fn func() error) error {
+	err := fn()
+	p.fail(err)
+	return err
+}
+
+// Cancel cancels the context of the pool without waiting for the functions
+// still running.
+func (p *pool) Cancel() {
+	p.cancel()
+}
+
+// Wait blocks until all the functions are done and gives the first error
+// returned by one of them or, when the pool keeps going, all of them.
+func (p *pool) Wait() error {
+	p.wg.Wait()
+	p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keep {
+		return joinErrors(p.errs...)
+	}
+	return hasError(p.errs...)
+}
+
+func (p *pool) fail(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errs = append(p.errs, err)
+	if !p.keep {
+		p.cancel()
+	}
+}
+
+func acquire(ctx context.Context, sema chan struct{}) error {
+	if sema == nil {
+		return nil
+	}
+	select {
+	case sema <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func release(sema chan struct{}) {
+	if sema != nil {
+		<-sema
+	}
+}
@@ -0,0 +1,166 @@
+package maestro
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolLimit(t *testing.T) {
+	var (
+		curr  int32
+		max   int32
+		count int32
+		limit = 3
+	)
+	p, ctx := createPool(context.Background(), limit)
+	for i := 0; i < 20; i++ {
+		err := p.Go(ctx, func() error {
+			n := atomic.AddInt32(&curr, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&curr, -1)
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 20 {
+		t.Errorf("not all functions executed: want 20, got %d", count)
+	}
+	if max > int32(limit) {
+		t.Errorf("too many functions executed at the same time: want %d, got %d", limit, max)
+	}
+}
+
+func TestPoolError(t *testing.T) {
+	var (
+		fail      = errors.New("fail")
+		cancelled int32
+	)
+	p, ctx := createPool(context.Background(), 2)
+	p.Go(ctx, func() error {
+		<-ctx.Done()
+		atomic.StoreInt32(&cancelled, 1)
+		return ctx.Err()
+	})
+	p.Go(ctx, func() error {
+		return fail
+	})
+	if err := p.Wait(); !errors.Is(err, fail) {
+		t.Fatalf("unexpected error: want %s, got %v", fail, err)
+	}
+	if atomic.LoadInt32(&cancelled) != 1 {
+		t.Errorf("running function not cancelled")
+	}
+	if err := p.Go(ctx, func() error { return nil }); err == nil {
+		t.Errorf("function executed after cancellation")
+	}
+}
+
+func TestPoolGroup(t *testing.T) {
+	var (
+		curr  = make(map[string]*int32)
+		max   = make(map[string]*int32)
+		count int32
+	)
+	p, ctx := createPool(context.Background(), 4)
+	p.Limit("db", 1)
+	p.Limit("web", 2)
+	for _, g := range []string{"db", "web", ""} {
+		curr[g], max[g] = new(int32), new(int32)
+	}
+	for i := 0; i < 30; i++ {
+		group := []string{"db", "web", ""}[i%3]
+		err := p.GoGroup(ctx, group, func() error {
+			n := atomic.AddInt32(curr[group], 1)
+			for {
+				m := atomic.LoadInt32(max[group])
+				if n <= m || atomic.CompareAndSwapInt32(max[group], m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(curr[group], -1)
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 30 {
+		t.Errorf("not all functions executed: want 30, got %d", count)
+	}
+	for g, want := range map[string]int32{"db": 1, "web": 2, "": 4} {
+		if got := *max[g]; got > want {
+			t.Errorf("%q: too many functions executed at the same time: want %d, got %d", g, want, got)
+		}
+	}
+}
+
+func TestPoolKeepGoing(t *testing.T) {
+	var (
+		fail1 = errors.New("fail1")
+		fail2 = errors.New("fail2")
+		calls int32
+	)
+	p, ctx := createPool(context.Background(), 0)
+	p.KeepGoing()
+	p.Go(ctx, func() error {
+		atomic.AddInt32(&calls, 1)
+		return fail1
+	})
+	if err := p.Run(func() error { return fail2 }); !errors.Is(err, fail2) {
+		t.Errorf("unexpected error: want %s, got %v", fail2, err)
+	}
+	p.Go(ctx, func() error {
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&calls, 1)
+		return ctx.Err()
+	})
+	err := p.Wait()
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("functions not executed after failure")
+	}
+	errs, ok := err.(failures)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("failures not combined: %v", err)
+	}
+	if !errors.Is(errs[0], fail1) && !errors.Is(errs[1], fail1) {
+		t.Errorf("%s not found in %v", fail1, err)
+	}
+}
+
+func TestPoolLimitAgain(t *testing.T) {
+	p, _ := createPool(context.Background(), 0)
+	p.Limit("deploy", 1)
+	gs := p.groups["deploy"]
+	p.Limit("deploy", 1)
+	if p.groups["deploy"] != gs {
+		t.Errorf("setting the same limit should keep the workers of the group")
+	}
+	p.Limit("deploy", 2)
+	if cap(p.groups["deploy"]) != 2 {
+		t.Errorf("limit of the group not changed")
+	}
+	p.Limit("deploy", 0)
+	if _, ok := p.groups["deploy"]; ok {
+		t.Errorf("limit of the group not removed")
+	}
+}
@@ -0,0 +1,92 @@
+package maestro
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/midbel/maestro/help"
+)
+
+// Preset is a named invocation of a command with a fixed list of arguments.
+// The arguments given when the preset is invoked are appended to the ones of
+// the preset.
+type Preset struct {
+	Name    string
+	Command string
+	Args    []string
+}
+
+func (p Preset) String() string {
+	list := []string{p.Command}
+	for _, a := range p.Args {
+		list = append(list, shellQuote(a))
+	}
+	return strings.Join(list, " ")
+}
+
+func (m *Maestro) RegisterPreset(name, command string, args []string) error {
+	if m.Presets == nil {
+		m.Presets = make(map[string]Preset)
+	}
+	if _, ok := m.Presets[name]; ok {
+		return fmt.Errorf("%s preset already registered", name)
+	}
+	m.Presets[name] = Preset{
+		Name:    name,
+		Command: command,
+		Args:    args,
+	}
+	return nil
+}
+
+// expand gives the command and the arguments to execute for name. Commands
+// (and their aliases) take precedence over the presets having the same name.
+func (m *Maestro) expand(name string, args []string) (string, []string) {
+	if _, err := m.Commands.Lookup(name); err == nil {
+		return name, args
+	}
+	p, ok := m.Presets[name]
+	if !ok {
+		return name, args
+	}
+	return p.Command, append(append([]string{}, p.Args...), args...)
+}
+
+// checkPresets verifies that the presets refer to an existing command and
+// that their options are accepted by this command. The remaining checks are
+// done when the preset is invoked since arguments can be added then.
+func (m *Maestro) checkPresets() error {
+	for _, p := range m.Presets {
+		cmd, err := m.Commands.Lookup(p.Command)
+		if err != nil {
+			return fmt.Errorf("%s: preset of unknown command %s", p.Name, p.Command)
+		}
+		x, err := cmd.Prepare()
+		if err != nil {
+			return err
+		}
+		c, ok := x.(*command)
+		if !ok {
+			continue
+		}
+		if _, err := c.prepareArgs(p.Args); err != nil {
+			return fmt.Errorf("%s: invalid arguments for %s: %w", p.Name, p.Command, err)
+		}
+	}
+	return nil
+}
+
+func (m *Maestro) helpPresets() []help.Preset {
+	var list []help.Preset
+	for _, p := range m.Presets {
+		list = append(list, help.Preset{
+			Name:    p.Name,
+			Command: p.String(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
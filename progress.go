@@ -0,0 +1,252 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressRate = 100 * time.Millisecond
+
+// progress renders one line per task running concurrently (dependencies in
+// background or remote hosts) with its status and its elapsed time. The view
+// is drawn while at least one task is running. The output of the tasks is
+// buffered and written once all of them are done so that it does not break
+// the view.
+type progress struct {
+	w     io.Writer
+	clock Clock
+	theme Theme
+
+	mu       sync.Mutex
+	tasks    []*progressTask
+	finished []*progressTask
+	running  int
+	lines    int
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// progress tells whether the progress of the commands running concurrently
+// should be rendered. Without a terminal, the output is written as it comes.
+func (m *Maestro) progress() bool {
+	if m.NoProgress || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stderr)
+}
+
+func createProgress(w io.Writer, clock Clock, theme Theme) *progress {
+	return &progress{
+		w:     w,
+		clock: clock,
+		theme: theme,
+	}
+}
+
+// Run executes fn as a task of the progress view. fn is given the writers of
+// the task.
+func (p *progress) Run(name string, stdout, stderr io.Writer, fn func(io.Writer, io.Writer) error) error {
+	t := p.start(name, stdout, stderr)
+	err := fn(&t.stdout, &t.stderr)
+	p.finish(t, err)
+	return err
+}
+
+func (p *progress) start(name string, stdout, stderr io.Writer) *progressTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := progressTask{
+		name:  name,
+		start: p.clock.Now(),
+		out:   stdout,
+		err:   stderr,
+	}
+	p.tasks = append(p.tasks, &t)
+	if p.running++; p.running == 1 {
+		p.done = make(chan struct{})
+		p.wg.Add(1)
+		go p.refresh(p.done)
+	}
+	p.draw()
+	return &t
+}
+
+func (p *progress) finish(t *progressTask, err error) {
+	p.mu.Lock()
+	t.end = p.clock.Now()
+	t.failure = err
+	t.over = true
+	p.finished = append(p.finished, t)
+	p.running--
+	p.draw()
+	if p.running > 0 {
+		p.mu.Unlock()
+		return
+	}
+	close(p.done)
+	finished := p.finished
+	p.tasks, p.finished, p.lines = nil, nil, 0
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	for _, t := range finished {
+		t.flush()
+	}
+}
+
+func (p *progress) refresh(done <-chan struct{}) {
+	defer p.wg.Done()
+	tick := time.NewTicker(progressRate)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			p.mu.Lock()
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// draw moves the cursor back to the first line of the view and writes again
+// the line of each task.
+func (p *progress) draw() {
+	var (
+		buf   bytes.Buffer
+		width int
+		now   = p.clock.Now()
+	)
+	for _, t := range p.tasks {
+		if len(t.name) > width {
+			width = len(t.name)
+		}
+	}
+	if p.lines > 0 {
+		fmt.Fprintf(&buf, "\x1b[%dF", p.lines)
+	}
+	for _, t := range p.tasks {
+		buf.WriteString("\x1b[2K")
+		buf.WriteString(t.Line(now, width, p.theme))
+		buf.WriteString("\n")
+	}
+	p.lines = len(p.tasks)
+	p.w.Write(buf.Bytes())
+}
+
+type progressTask struct {
+	name    string
+	start   time.Time
+	end     time.Time
+	over    bool
+	failure error
+
+	out    io.Writer
+	err    io.Writer
+	stdout taskWriter
+	stderr taskWriter
+}
+
+func (t *progressTask) Line(now time.Time, width int, theme Theme) string {
+	var (
+		status  = "running"
+		elapsed = now.Sub(t.start)
+		glyph   string
+	)
+	if t.over {
+		status, elapsed = "done", t.end.Sub(t.start)
+		if t.failure != nil {
+			status = fmt.Sprintf("failed: %s", t.failure)
+		}
+		glyph = theme.Status(t.failure)
+	}
+	line := fmt.Sprintf("%-*s  %-8s %s", width, t.name, humanDuration(elapsed), status)
+	if glyph != "" {
+		line = glyph + " " + line
+	}
+	return strings.TrimSpace(line)
+}
+
+func (t *progressTask) flush() {
+	t.stdout.WriteTo(t.out)
+	t.stderr.WriteTo(t.err)
+}
+
+// taskWriter buffers the output of a task with the prefix that was set when
+// it was written.
+type taskWriter struct {
+	mu       sync.Mutex
+	prefix   string
+	segments []taskSegment
+}
+
+type taskSegment struct {
+	prefix string
+	bytes.Buffer
+}
+
+func (w *taskWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(w.segments)
+	if n == 0 || w.segments[n-1].prefix != w.prefix {
+		w.segments = append(w.segments, taskSegment{prefix: w.prefix})
+		n++
+	}
+	return w.segments[n-1].Write(b)
+}
+
+func (w *taskWriter) SetPrefix(prefix string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prefix = prefix
+}
+
+// WriteTo writes the buffered output to out with the prefix it had.
+func (w *taskWriter) WriteTo(out io.Writer) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var total int64
+	for _, s := range w.segments {
+		setPrefix(out, s.prefix)
+		n, err := out.Write(s.Bytes())
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+type execprogress struct {
+	inner    executer
+	name     string
+	progress *progress
+}
+
+func withProgress(ex executer, name string, p *progress) executer {
+	return execprogress{
+		inner:    ex,
+		name:     name,
+		progress: p,
+	}
+}
+
+func (e execprogress) Execute(ctx context.Context, stdout, stderr io.Writer) error {
+	return e.progress.Run(e.name, stdout, stderr, func(stdout, stderr io.Writer) error {
+		return e.inner.Execute(ctx, stdout, stderr)
+	})
+}
+
+func (e execprogress) Bg() bool {
+	return inBackground(e.inner)
+}
@@ -0,0 +1,183 @@
+package maestro
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// prompter asks the user the values of the options and arguments that are
+// missing from the command line. Answers are remembered so that the user is
+// asked only once even if the arguments of the command are parsed again.
+type prompter struct {
+	in  *os.File
+	out io.Writer
+	buf *bufio.Reader
+
+	answers map[string]string
+}
+
+func createPrompter(in *os.File, out io.Writer) *prompter {
+	return &prompter{
+		in:      in,
+		out:     out,
+		buf:     bufio.NewReader(in),
+		answers: make(map[string]string),
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Answer asks the value of key. An empty answer gives the default value which
+// is shown with the label unless the answer is hidden.
+func (p *prompter) Answer(key, label, value string, hidden bool, choices []string) (string, error) {
+	if str, ok := p.answers[key]; ok {
+		return str, nil
+	}
+	if value != "" && !hidden {
+		label = fmt.Sprintf("%s [%s]", label, value)
+	}
+	var (
+		str string
+		err error
+	)
+	if len(choices) > 0 {
+		str, err = p.Choose(label, value, choices)
+	} else {
+		str, err = p.Ask(label, hidden)
+	}
+	if str == "" {
+		str = value
+	}
+	if err == nil {
+		p.answers[key] = str
+	}
+	return str, err
+}
+
+// Choose presents a numbered menu with the given choices and asks the user
+// until a valid number (or one of the choices) is given.
+func (p *prompter) Choose(label, value string, choices []string) (string, error) {
+	fmt.Fprintln(p.out, label)
+	for i, c := range choices {
+		fmt.Fprintf(p.out, "  %d) %s", i+1, c)
+		fmt.Fprintln(p.out)
+	}
+	for {
+		str, err := p.Ask(fmt.Sprintf("choice [1-%d]", len(choices)), false)
+		if err != nil {
+			return "", err
+		}
+		if str == "" && value != "" {
+			return value, nil
+		}
+		if n, err := strconv.Atoi(str); err == nil && n >= 1 && n <= len(choices) {
+			return choices[n-1], nil
+		}
+		for _, c := range choices {
+			if c == str {
+				return c, nil
+			}
+		}
+		fmt.Fprintf(p.out, "%s: invalid choice", str)
+		fmt.Fprintln(p.out)
+	}
+}
+
+func (p *prompter) Ask(label string, hidden bool) (string, error) {
+	fmt.Fprintf(p.out, "%s: ", label)
+	if hidden {
+		buf, err := term.ReadPassword(int(p.in.Fd()))
+		fmt.Fprintln(p.out)
+		return string(buf), err
+	}
+	str, err := p.buf.ReadString('\n')
+	if err != nil && (err != io.EOF || str == "") {
+		return "", err
+	}
+	return strings.TrimSpace(str), nil
+}
+
+func (c *command) SetPrompt(p *prompter) {
+	c.prompt = p
+}
+
+// askOptions asks the values of the required options without value and of
+// the options with a prompt that are not given on the command line. The
+// default value of an option is used when the answer is empty.
+func (c *command) askOptions(set *flag.FlagSet) error {
+	if c.prompt == nil {
+		return nil
+	}
+	given := make(map[string]bool)
+	set.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for i, o := range c.options {
+		if o.Flag || given[o.Short] || given[o.Long] {
+			continue
+		}
+		if o.Prompt == "" && (!o.Required || o.Target != "") {
+			continue
+		}
+		name := o.Long
+		if name == "" {
+			name = o.Short
+		}
+		label := o.Prompt
+		if label == "" {
+			label = name
+		}
+		str, err := c.prompt.Answer("-"+name, label, o.Default, o.Sensitive, o.Choices)
+		if err != nil {
+			return err
+		}
+		c.options[i].Target = str
+	}
+	return nil
+}
+
+func (c *command) askArguments(args []string) ([]string, error) {
+	if c.prompt == nil {
+		return args, nil
+	}
+	for i := len(args); i < len(c.args); i++ {
+		str, err := c.prompt.Answer(c.args[i].Name, c.args[i].Name, "", false, nil)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, str)
+	}
+	return args, nil
+}
+
+// confirm asks the user to type yes before executing a command with a confirm
+// property. The confirmation is not asked with --yes and it fails when maestro
+// can not prompt the user.
+func (m *Maestro) confirm(name string) error {
+	cmd, err := m.Commands.Lookup(name)
+	if err != nil || cmd.Confirm == "" || m.Yes {
+		return nil
+	}
+	if !m.interactive() {
+		return fmt.Errorf("%s: %s: confirmation required (use --yes)", cmd.Command(), cmd.Confirm)
+	}
+	p := createPrompter(os.Stdin, os.Stderr)
+	fmt.Fprintln(p.out, cmd.Confirm)
+	str, err := p.Ask("type yes to continue", false)
+	if err != nil {
+		return err
+	}
+	if str != "yes" {
+		return fmt.Errorf("%s: %w", cmd.Command(), errCancel)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package maestro
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	checksumFile   = "SHA256SUMS"
+	provenanceFile = "provenance.json"
+	signatureExt   = ".sig"
+)
+
+type provenanceArtifact struct {
+	Name   string `json:"name"`
+	Sha256 string `json:"sha256"`
+}
+
+type provenanceBuilder struct {
+	Host string `json:"host"`
+	User string `json:"user"`
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+type provenanceSource struct {
+	File   string `json:"file,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	Dirty  bool   `json:"dirty"`
+}
+
+// provenance describes how the artifacts of a command have been built.
+type provenance struct {
+	Command   string               `json:"command"`
+	Args      []string             `json:"args"`
+	Version   string               `json:"version,omitempty"`
+	Source    provenanceSource     `json:"source"`
+	Builder   provenanceBuilder    `json:"builder"`
+	Started   time.Time            `json:"started"`
+	Finished  time.Time            `json:"finished"`
+	Artifacts []provenanceArtifact `json:"artifacts"`
+}
+
+// attest writes in dir the SHA256SUMS and/or provenance.json files of the
+// artifacts and, with a key, their signatures. It gives the list of the files
+// written.
+func (c *publishCommand) attest(dir string, files, args []string, started time.Time) ([]string, error) {
+	var (
+		list []string
+		sums []provenanceArtifact
+	)
+	for _, f := range files {
+		sum, err := sha256File(f)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, provenanceArtifact{Name: filepath.Base(f), Sha256: sum})
+	}
+	if c.checksums {
+		var buf bytes.Buffer
+		for _, s := range sums {
+			fmt.Fprintf(&buf, "%s  %s\n", s.Sha256, s.Name)
+		}
+		file := filepath.Join(dir, checksumFile)
+		if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+			return nil, err
+		}
+		list = append(list, file)
+	}
+	if c.provenance {
+		prov := provenance{
+			Command:   c.Command(),
+			Args:      args,
+			Version:   c.mst.Version,
+			Source:    c.source(),
+			Builder:   builder(),
+			Started:   started.UTC(),
+			Finished:  c.mst.clock().Now().UTC(),
+			Artifacts: sums,
+		}
+		buf, err := json.MarshalIndent(prov, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		file := filepath.Join(dir, provenanceFile)
+		if err := os.WriteFile(file, append(buf, '\n'), 0o644); err != nil {
+			return nil, err
+		}
+		list = append(list, file)
+	}
+	if c.mst.MetaExec.SignKey == nil {
+		return list, nil
+	}
+	for _, f := range list {
+		sig, err := signFile(c.mst.MetaExec.SignKey, f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: fail to sign: %w", filepath.Base(f), err)
+		}
+		list = append(list, sig)
+	}
+	return list, nil
+}
+
+// source gives the git information of the directory of the command. They are
+// left empty when the command is not in a git repository.
+func (c *publishCommand) source() provenanceSource {
+	src := provenanceSource{
+		File: c.mst.MetaAbout.File,
+	}
+	src.Commit, _ = runGit(c.dir, "rev-parse", "HEAD")
+	src.Branch, _ = runGit(c.dir, "rev-parse", "--abbrev-ref", "HEAD")
+	if src.Commit != "" {
+		status, _ := runGit(c.dir, "status", "--porcelain")
+		src.Dirty = status != ""
+	}
+	return src
+}
+
+func builder() provenanceBuilder {
+	b := provenanceBuilder{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+	b.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		b.User = u.Username
+	}
+	return b
+}
+
+func sha256File(file string) (string, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+const (
+	sigMagic     = "SSHSIG"
+	sigNamespace = "file"
+	sigHash      = "sha512"
+)
+
+// signFile writes next to file its signature in the format of ssh-keygen -Y
+// sign. The signature can be checked with:
+//
+//	ssh-keygen -Y verify -f allowed_signers -I <identity> -n file -s <file>.sig < <file>
+func signFile(key ssh.Signer, file string) (string, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	digest := sha512.Sum512(buf)
+	signed := struct {
+		Namespace string
+		Reserved  string
+		Hash      string
+		Digest    string
+	}{
+		Namespace: sigNamespace,
+		Hash:      sigHash,
+		Digest:    string(digest[:]),
+	}
+	data := append([]byte(sigMagic), ssh.Marshal(signed)...)
+
+	var sig *ssh.Signature
+	if a, ok := key.(ssh.AlgorithmSigner); ok && key.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-keygen refuses signatures made with sha1
+		sig, err = a.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2512)
+	} else {
+		sig, err = key.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return "", err
+	}
+	blob := struct {
+		Version   uint32
+		PublicKey string
+		Namespace string
+		Reserved  string
+		Hash      string
+		Signature string
+	}{
+		Version:   1,
+		PublicKey: string(key.PublicKey().Marshal()),
+		Namespace: sigNamespace,
+		Hash:      sigHash,
+		Signature: string(ssh.Marshal(sig)),
+	}
+	str := base64.StdEncoding.EncodeToString(append([]byte(sigMagic), ssh.Marshal(blob)...))
+
+	var out bytes.Buffer
+	out.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(str) > 70 {
+		out.WriteString(str[:70])
+		out.WriteString("\n")
+		str = str[70:]
+	}
+	out.WriteString(str)
+	out.WriteString("\n-----END SSH SIGNATURE-----\n")
+
+	file += signatureExt
+	return file, os.WriteFile(file, out.Bytes(), 0o644)
+}
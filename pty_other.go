@@ -0,0 +1,18 @@
+//go:build !linux
+
+package maestro
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// openPty allocates a pseudo terminal and gives its master and its slave.
+func openPty() (*os.File, *os.File, error) {
+	return nil, nil, errors.New("pseudo terminal not supported: interactive commands require a terminal")
+}
+
+// setControllingTerminal is never called since no pseudo terminal can be
+// allocated.
+func setControllingTerminal(cmd *exec.Cmd, fd int) {}
@@ -0,0 +1,334 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Uploader copies the artifacts of a command to the destination given by its
+// publish property.
+type Uploader interface {
+	Upload(ctx context.Context, file, remote string) error
+	Close() error
+}
+
+// UploaderFunc creates the uploader for a destination.
+type UploaderFunc func(dest *url.URL, mst *Maestro) (Uploader, error)
+
+var uploaders = struct {
+	sync.Mutex
+	set map[string]UploaderFunc
+}{
+	set: map[string]UploaderFunc{
+		"ssh":  sshUploader,
+		"file": fileUploader,
+	},
+}
+
+// RegisterUploader makes an uploader available for the destinations using the
+// given scheme (eg: s3).
+func RegisterUploader(scheme string, fn UploaderFunc) {
+	uploaders.Lock()
+	defer uploaders.Unlock()
+	uploaders.set[strings.ToLower(scheme)] = fn
+}
+
+func lookupUploader(scheme string) (UploaderFunc, error) {
+	uploaders.Lock()
+	defer uploaders.Unlock()
+	fn, ok := uploaders.set[strings.ToLower(scheme)]
+	if !ok {
+		return nil, fmt.Errorf("%s: no uploader for scheme", scheme)
+	}
+	return fn, nil
+}
+
+// publishCommand uploads the artifacts of a command once it has been executed
+// successfully.
+type publishCommand struct {
+	Executer
+
+	mst       *Maestro
+	dest      string
+	dir       string
+	artifacts []string
+	stdout    io.Writer
+
+	checksums  bool
+	provenance bool
+}
+
+func (m *Maestro) publish(ex Executer, cmd CommandSettings) Executer {
+	return &publishCommand{
+		Executer:   ex,
+		mst:        m,
+		dest:       cmd.Publish,
+		dir:        cmd.workDir(),
+		artifacts:  cmd.Artifacts,
+		stdout:     io.Discard,
+		checksums:  cmd.Checksums,
+		provenance: cmd.Provenance,
+	}
+}
+
+func (c *publishCommand) SetOut(w io.Writer) {
+	c.stdout = w
+	c.Executer.SetOut(w)
+}
+
+func (c *publishCommand) Dry(args []string) error {
+	if err := c.Executer.Dry(args); err != nil {
+		return err
+	}
+	dest, err := c.destination(c.mst.clock().Now())
+	if err != nil {
+		return err
+	}
+	where := dest.Redacted()
+	if dest.Host == "" {
+		where = dest.Path
+	}
+	for _, a := range c.artifacts {
+		fmt.Fprintf(c.stdout, "publish %s to %s", a, where)
+		fmt.Fprintln(c.stdout)
+	}
+	for _, f := range c.generated() {
+		fmt.Fprintf(c.stdout, "publish %s to %s", f, where)
+		fmt.Fprintln(c.stdout)
+	}
+	return nil
+}
+
+func (c *publishCommand) Execute(ctx context.Context, args []string) error {
+	started := c.mst.clock().Now()
+	dest, err := c.destination(started)
+	if err != nil {
+		return err
+	}
+	if err := c.Executer.Execute(ctx, args); err != nil {
+		return err
+	}
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	if c.checksums || c.provenance {
+		tmp, err := os.MkdirTemp("", "maestro-publish-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		others, err := c.attest(tmp, files, args, started)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Command(), err)
+		}
+		files = append(files, others...)
+	}
+	remotes, err := remoteFiles(dest.Path, files)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Command(), err)
+	}
+	create, err := lookupUploader(dest.Scheme)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Command(), err)
+	}
+	up, err := create(dest, c.mst)
+	if err != nil {
+		return fmt.Errorf("%s: fail to connect to %s: %w", c.Command(), dest.Host, err)
+	}
+	defer up.Close()
+	for i, f := range files {
+		remote := remotes[i]
+		if err := up.Upload(ctx, f, remote); err != nil {
+			return fmt.Errorf("%s: fail to publish %s: %w", c.Command(), f, err)
+		}
+		fmt.Fprintf(c.stdout, "%s published to %s", f, remote)
+		fmt.Fprintln(c.stdout)
+	}
+	return nil
+}
+
+// remoteFiles gives the paths of the files once published in dir. The files
+// are published without their directories: two files with the same name would
+// overwrite each other and are rejected before anything is published.
+func remoteFiles(dir string, files []string) ([]string, error) {
+	var (
+		list = make([]string, 0, len(files))
+		seen = make(map[string]string)
+	)
+	for _, f := range files {
+		base := filepath.Base(f)
+		if other, ok := seen[base]; ok {
+			return nil, fmt.Errorf("%s and %s would be published with the same name %s", other, f, base)
+		}
+		seen[base] = f
+		list = append(list, path.Join(dir, base))
+	}
+	return list, nil
+}
+
+// destination gives the URL where the artifacts are published once the
+// placeholders of its path are replaced. The date and time are the ones of the
+// start of the command so that all the files of a publication (and its
+// provenance) share them.
+func (c *publishCommand) destination(now time.Time) (*url.URL, error) {
+	replace := strings.NewReplacer(
+		"{version}", c.mst.Version,
+		"{command}", c.Command(),
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("150405"),
+	)
+	dest, err := url.Parse(replace.Replace(c.dest))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid publish destination: %w", c.Command(), err)
+	}
+	if dest.Scheme == "" {
+		dest.Scheme = "file"
+	}
+	return dest, nil
+}
+
+// generated gives the names of the files created by maestro and published
+// with the artifacts.
+func (c *publishCommand) generated() []string {
+	var list []string
+	if c.checksums {
+		list = append(list, checksumFile)
+	}
+	if c.provenance {
+		list = append(list, provenanceFile)
+	}
+	if c.mst.MetaExec.SignKey == nil {
+		return list
+	}
+	for _, f := range list {
+		list = append(list, f+signatureExt)
+	}
+	return list
+}
+
+func (c *publishCommand) files() ([]string, error) {
+	var files []string
+	for _, a := range c.artifacts {
+		list, err := filepath.Glob(absPath(a, c.dir))
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("%s: %s: artifact not found", c.Command(), a)
+		}
+		files = append(files, list...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+type sshUpload struct {
+	client *ssh.Client
+}
+
+// sshUploader copies the files with the ssh connection used to execute the
+// commands on remote servers (.SSH_USER, .SSH_PASSWORD, .SSH_PUBKEY...).
+func sshUploader(dest *url.URL, mst *Maestro) (Uploader, error) {
+	user := dest.User.Username()
+	if user == "" {
+		user = mst.MetaSSH.User
+	}
+	port := dest.Port()
+	if port == "" {
+		port = fmt.Sprint(DefaultSSHPort)
+	}
+	config := ssh.ClientConfig{
+		User:            user,
+		Auth:            mst.MetaSSH.AuthMethod(),
+		HostKeyCallback: mst.CheckHostKey,
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(dest.Hostname(), port), &config)
+	if err != nil {
+		return nil, err
+	}
+	return sshUpload{client: client}, nil
+}
+
+func (u sshUpload) Upload(ctx context.Context, file, remote string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	script := fmt.Sprintf("mkdir -p %s && cat > %s", shellQuote(path.Dir(remote)), shellQuote(remote))
+	sess, err := u.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	sess.Stdin = r
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sess.Close()
+		case <-done:
+		}
+	}()
+	if out, err := sess.CombinedOutput(script); err != nil {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		if str := strings.TrimSpace(string(out)); str != "" {
+			err = fmt.Errorf("%s: %w", str, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (u sshUpload) Close() error {
+	return u.client.Close()
+}
+
+type fileUpload struct{}
+
+// fileUploader copies the files to a local directory (eg: a network share).
+func fileUploader(_ *url.URL, _ *Maestro) (Uploader, error) {
+	return fileUpload{}, nil
+}
+
+func (fileUpload) Upload(_ context.Context, file, remote string) error {
+	if err := os.MkdirAll(filepath.Dir(remote), 0o755); err != nil {
+		return err
+	}
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(remote)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (fileUpload) Close() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileUploader(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		file   = filepath.Join(dir, "app")
+		remote = filepath.Join(dir, "out", "v1", "app")
+	)
+	writeTestFile(t, file, "binary")
+	up, err := fileUploader(&url.URL{Scheme: "file", Path: filepath.Dir(remote)}, nil)
+	if err != nil {
+		t.Fatalf("fail to create uploader: %s", err)
+	}
+	defer up.Close()
+	for _, content := range []string{"binary", "binary v2"} {
+		writeTestFile(t, file, content)
+		if err := up.Upload(context.Background(), file, remote); err != nil {
+			t.Fatalf("fail to upload file: %s", err)
+		}
+		buf, err := os.ReadFile(remote)
+		if err != nil {
+			t.Fatalf("file not uploaded: %s", err)
+		}
+		if string(buf) != content {
+			t.Errorf("content mismatched: want %q, got %q", content, buf)
+		}
+	}
+	if err := up.Upload(context.Background(), filepath.Join(dir, "missing"), remote); err == nil {
+		t.Errorf("uploading a missing file should have failed")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"bin/app", "bin/app.sig", "linux/app", "doc/README"} {
+		writeTestFile(t, filepath.Join(dir, f), f)
+	}
+	file := fmt.Sprintf(`
+release(
+	workdir = %[1]q,
+	artifacts = "bin/*" doc/README,
+	publish = "file://%[1]s/out/{command}-{date}",
+): {
+	true
+}
+invalid(
+	workdir = %[1]q,
+	artifacts = bin/app,
+	publish = "ssh://[web1/{date}",
+): {
+	echo should not be executed
+}
+clash(
+	workdir = %[1]q,
+	artifacts = bin/app linux/app,
+	publish = "%[1]s/clash",
+): {
+	true
+}
+`, dir)
+	mst := decodeFile(t, file)
+	mst.Clock = &fakeClock{now: time.Date(2022, 3, 8, 14, 5, 9, 0, time.UTC)}
+
+	ex := resolveCommand(t, mst, "release", ctreeOption{})
+	if err := ex.Execute(context.Background(), io.Discard, io.Discard); err != nil {
+		t.Fatalf("release should have succeeded: %s", err)
+	}
+	for _, f := range []string{"app", "app.sig", "README"} {
+		if _, err := os.Stat(filepath.Join(dir, "out", "release-2022-03-08", f)); err != nil {
+			t.Errorf("%s: file not published: %s", f, err)
+		}
+	}
+
+	var buf strings.Builder
+	ex = resolveCommand(t, mst, "invalid", ctreeOption{})
+	if err := ex.Execute(context.Background(), &buf, io.Discard); err == nil {
+		t.Errorf("invalid destination should have been rejected")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("command executed with an invalid destination: %q", buf.String())
+	}
+
+	ex = resolveCommand(t, mst, "clash", ctreeOption{})
+	err := ex.Execute(context.Background(), io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "same name") {
+		t.Errorf("files with the same name should have been rejected: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "clash")); err == nil {
+		t.Errorf("files published despite the clash")
+	}
+
+	if _, err := lookupUploader("sftp"); err == nil {
+		t.Errorf("sftp should not be supported without registering an uploader")
+	}
+}
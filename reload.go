@@ -0,0 +1,136 @@
+package maestro
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+// reloader serves the requests with the routes created for the last version of
+// the maestro file loaded. The requests in progress are not interrupted when
+// the routes are replaced.
+type reloader struct {
+	mu sync.RWMutex
+	http.Handler
+}
+
+func createReloader(h http.Handler) *reloader {
+	return &reloader{
+		Handler: h,
+	}
+}
+
+func (r *reloader) Reload(h http.Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Handler = h
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	h := r.Handler
+	r.mu.RUnlock()
+	h.ServeHTTP(w, req)
+}
+
+// serverLog is a file where the messages of the server are appended. It is
+// opened again when maestro receives SIGHUP so that it can be moved away by a
+// log rotation tool.
+type serverLog struct {
+	mu   sync.Mutex
+	file string
+	w    *os.File
+}
+
+func openServerLog(file string) (*serverLog, error) {
+	f := serverLog{
+		file: file,
+	}
+	return &f, f.Reopen()
+}
+
+func (f *serverLog) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.w.Write(b)
+}
+
+// Reopen closes the file and opens it again (creating it if it has been moved
+// away).
+func (f *serverLog) Reopen() error {
+	w, err := os.OpenFile(f.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.w != nil {
+		f.w.Close()
+	}
+	f.w = w
+	return nil
+}
+
+func (f *serverLog) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.w.Close()
+}
+
+// reloadOnHangup loads again the maestro file each time maestro receives
+// SIGHUP and gives the new version to fn. The current version is kept when
+// the file can not be loaded. The messages are written to w which is opened
+// again first when it is a log file. The returned function stops listening
+// for SIGHUP.
+func (m *Maestro) reloadOnHangup(w io.Writer, fn func(*Maestro)) func() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			if f, ok := w.(*serverLog); ok {
+				if err := f.Reopen(); err != nil {
+					fmt.Fprintf(stdio.Stderr, "reload: %s: %s", f.file, err)
+					fmt.Fprintln(stdio.Stderr)
+				}
+			}
+			x, err := m.reload()
+			if err != nil {
+				fmt.Fprintf(w, "reload: %s: %s", m.File, err)
+				fmt.Fprintln(w)
+				continue
+			}
+			fn(x)
+			fmt.Fprintf(w, "reload: %s loaded", m.File)
+			fmt.Fprintln(w)
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(sig)
+	}
+}
+
+// reload gives a new maestro loaded from the file of m with the options given
+// on the command line.
+func (m *Maestro) reload() (*Maestro, error) {
+	x := New()
+	if m.defines != nil {
+		x.Locals = m.defines.Copy()
+	}
+	x.Options = m.Options
+	// options of the command line that can also be set by the file
+	x.MetaExec.Dry = m.MetaExec.Dry
+	x.MetaExec.Ignore = m.MetaExec.Ignore
+	x.MetaExec.Trace = m.MetaExec.Trace
+	x.MetaExec.TraceLines = m.MetaExec.TraceLines
+	if err := x.Load(m.File); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
@@ -0,0 +1,86 @@
+package maestro
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "maestro.mf")
+	writeTestFile(t, file, "build: {\n\techo $target\n}\n")
+
+	mst := New()
+	mst.Color = "always"
+	mst.NoProgress = true
+	mst.KeepGoing = true
+	mst.LockTimeout = time.Minute
+	mst.Options.LogDir = "logs"
+	mst.MetaExec.Dry = true
+	if err := mst.Locals.Set("target=prod"); err != nil {
+		t.Fatalf("fail to define variable: %s", err)
+	}
+	if err := mst.Load(file); err != nil {
+		t.Fatalf("fail to load file: %s", err)
+	}
+	writeTestFile(t, file, "build: {\n\techo $target\n}\ntest: {\n\ttrue\n}\n")
+
+	x, err := mst.reload()
+	if err != nil {
+		t.Fatalf("fail to reload file: %s", err)
+	}
+	if !reflect.DeepEqual(x.Options, mst.Options) {
+		t.Errorf("options mismatched: want %+v, got %+v", mst.Options, x.Options)
+	}
+	if !x.MetaExec.Dry {
+		t.Errorf("dry option not kept")
+	}
+	if _, err := x.Commands.Lookup("test"); err != nil {
+		t.Errorf("new command not loaded: %s", err)
+	}
+	if vs, err := x.Locals.Resolve("target"); err != nil || strings.Join(vs, " ") != "prod" {
+		t.Errorf("variable of the command line not kept: %q (%v)", vs, err)
+	}
+
+	writeTestFile(t, file, "build: {\n")
+	if _, err := mst.reload(); err == nil {
+		t.Errorf("reloading an invalid file should fail")
+	}
+}
+
+func TestServerLog(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "serve.log")
+		old  = file + ".1"
+	)
+	w, err := openServerLog(file)
+	if err != nil {
+		t.Fatalf("fail to open log: %s", err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("first\n"))
+	if err := os.Rename(file, old); err != nil {
+		t.Fatalf("fail to move log: %s", err)
+	}
+	w.Write([]byte("second\n"))
+	if err := w.Reopen(); err != nil {
+		t.Fatalf("fail to reopen log: %s", err)
+	}
+	w.Write([]byte("third\n"))
+
+	for f, want := range map[string]string{old: "first\nsecond\n", file: "third\n"} {
+		buf, err := os.ReadFile(f)
+		if err != nil {
+			t.Errorf("fail to read %s: %s", f, err)
+			continue
+		}
+		if got := string(buf); got != want {
+			t.Errorf("%s: want %q, got %q", filepath.Base(f), want, got)
+		}
+	}
+}
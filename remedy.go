@@ -0,0 +1,77 @@
+package maestro
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var notFoundPattern = regexp.MustCompile(`([^\s:]+): command not found`)
+
+// missingError is returned when the script of a command calls a program that
+// can not be found.
+type missingError struct {
+	Command string
+	Program string
+	err     error
+}
+
+func (e missingError) Error() string {
+	return fmt.Sprintf("%s: %s: command not found", e.Command, e.Program)
+}
+
+func (e missingError) Unwrap() error {
+	return e.err
+}
+
+// missingProgram gives the name of the program not found by the shell or by
+// the os/exec package.
+func missingProgram(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	var e *exec.Error
+	if errors.As(err, &e) && errors.Is(e.Err, exec.ErrNotFound) {
+		return e.Name, true
+	}
+	if m := notFoundPattern.FindStringSubmatch(err.Error()); len(m) > 0 {
+		return m[1], true
+	}
+	return "", false
+}
+
+// remedy gives hints about how to fix an error caused by a missing program:
+// the package providing it (from the PACKAGES meta) and the command that
+// should require it.
+func (m *Maestro) remedy(err error) []string {
+	var e missingError
+	if !errors.As(err, &e) {
+		return nil
+	}
+	var list []string
+	if pkg, ok := m.MetaExec.Packages[e.Program]; ok {
+		list = append(list, fmt.Sprintf("hint: %s can be installed with: %s", e.Program, pkg))
+	}
+	cmd, err := m.Commands.Lookup(e.Command)
+	if err != nil {
+		return list
+	}
+	for _, r := range cmd.Requires {
+		if r == e.Program {
+			return list
+		}
+	}
+	list = append(list, fmt.Sprintf("hint: add %s to the requires property of %s to check it is available before executing the command", e.Program, e.Command))
+	return list
+}
+
+func parsePackage(str string) (string, string, error) {
+	prog, pkg, ok := strings.Cut(str, ":")
+	prog, pkg = strings.TrimSpace(prog), strings.TrimSpace(pkg)
+	if !ok || prog == "" || pkg == "" {
+		return "", "", fmt.Errorf("%s: package should be given as program:package", str)
+	}
+	return prog, pkg, nil
+}
@@ -0,0 +1,107 @@
+package maestro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatText = "text"
+	FormatTap  = "tap"
+	FormatJson = "json"
+	FormatHtml = "html"
+)
+
+func checkFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatTap:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported output format", format)
+	}
+}
+
+type tapReport struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (t *tapReport) Report(w io.Writer, name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.count++
+	status := "ok"
+	if err != nil {
+		status = "not ok"
+	}
+	setPrefix(w, "")
+	fmt.Fprintf(w, "%s %d - %s", status, t.count, name)
+	fmt.Fprintln(w)
+	if err != nil {
+		for _, str := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(w, "# %s", str)
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func (t *tapReport) Plan(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	setPrefix(w, "")
+	fmt.Fprintf(w, "1..%d", t.count)
+	fmt.Fprintln(w)
+}
+
+type exectap struct {
+	inner  executer
+	report *tapReport
+}
+
+func tap(ex executer, report *tapReport) executer {
+	return exectap{
+		inner:  ex,
+		report: report,
+	}
+}
+
+func (e exectap) Execute(ctx context.Context, stdout, stderr io.Writer) error {
+	err := e.inner.Execute(ctx, stdout, stderr)
+	e.report.Report(stdout, executerName(e.inner), err)
+	return err
+}
+
+func (e exectap) Bg() bool {
+	return inBackground(e.inner)
+}
+
+type exectapPlan struct {
+	inner  executer
+	report *tapReport
+}
+
+func tapPlan(ex executer, report *tapReport) executer {
+	return exectapPlan{
+		inner:  ex,
+		report: report,
+	}
+}
+
+func (e exectapPlan) Execute(ctx context.Context, stdout, stderr io.Writer) error {
+	setPrefix(stdout, "")
+	fmt.Fprintln(stdout, "TAP version 13")
+	err := e.inner.Execute(ctx, stdout, stderr)
+	e.report.Plan(stdout)
+	return err
+}
+
+func executerName(ex executer) string {
+	if n, ok := ex.(interface{ Command() string }); ok {
+		return n.Command()
+	}
+	return ""
+}
@@ -0,0 +1,319 @@
+package maestro
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/midbel/tish"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	ReportJson  = "json"
+	ReportJunit = "junit"
+)
+
+func checkReportFormat(format string) error {
+	switch format {
+	case "", ReportJson, ReportJunit:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported report format", format)
+	}
+}
+
+// runReport describes the execution of a command: each command executed (the
+// command called and its dependencies, or each host for remote commands) gets
+// an entry in the report.
+type runReport struct {
+	mu    sync.Mutex
+	clock Clock
+	// values of the sensitive options masked in the errors
+	values []string
+
+	Command  string        `json:"command"`
+	Args     []string      `json:"args,omitempty"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration float64       `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Entries  []reportEntry `json:"commands"`
+}
+
+type reportEntry struct {
+	Command    string    `json:"command"`
+	Dependency bool      `json:"dependency,omitempty"`
+	Host       string    `json:"host,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Duration   float64   `json:"duration"`
+	Exit       int       `json:"exit"`
+	Error      string    `json:"error,omitempty"`
+	Retries    int       `json:"retries"`
+	Stdout     int64     `json:"stdout_bytes"`
+	Stderr     int64     `json:"stderr_bytes"`
+}
+
+func (e *reportEntry) finish(end time.Time, err error) {
+	e.End = end
+	e.Duration = e.End.Sub(e.Start).Seconds()
+	e.Exit = exitCode(err)
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
+
+// createRunReport gives the report of the execution of cmd. The values of its
+// sensitive options are masked in the arguments and in the errors.
+func createRunReport(cmd CommandSettings, args []string, clock Clock) *runReport {
+	args, values := cmd.maskArgs(args)
+	return &runReport{
+		Command: cmd.Command(),
+		Args:    args,
+		Start:   clock.Now(),
+		clock:   clock,
+		values:  values,
+	}
+}
+
+func (r *runReport) Add(e reportEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Error = redact(e.Error, r.values...)
+	r.Entries = append(r.Entries, e)
+}
+
+// Finish marks the end of the execution of the command and writes the report
+// into file in the given format.
+func (r *runReport) Finish(file, format string, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.End = r.clock.Now()
+	r.Duration = r.End.Sub(r.Start).Seconds()
+	if err != nil {
+		r.Error = redact(err.Error(), r.values...)
+	}
+	if dir := filepath.Dir(file); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	var buf []byte
+	switch format {
+	case ReportJunit:
+		buf, err = r.junit()
+	default:
+		buf, err = json.MarshalIndent(r, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf, 0644)
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     float64      `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      float64     `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junit gives the report as a JUnit XML document: the command called is a test
+// suite and each command executed (or each host) is a test case.
+func (r *runReport) junit() ([]byte, error) {
+	suite := junitSuite{
+		Name:      r.Command,
+		Tests:     len(r.Entries),
+		Time:      r.Duration,
+		Timestamp: r.Start.Format("2006-01-02T15:04:05"),
+	}
+	for _, e := range r.Entries {
+		c := junitCase{
+			Name:      e.Command,
+			Classname: r.Command,
+			Time:      e.Duration,
+		}
+		if e.Host != "" {
+			c.Name = fmt.Sprintf("%s@%s", e.Command, e.Host)
+		}
+		if e.Error != "" {
+			suite.Failures++
+			c.Failure = &junitFailure{
+				Message: e.Error,
+				Type:    fmt.Sprintf("exit status %d", e.Exit),
+				Text:    e.Error,
+			}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	doc := junitSuites{
+		Name:     r.Command,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+	buf, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(buf, '\n')...), nil
+}
+
+type reportCommand struct {
+	Executer
+	report *runReport
+	dep    bool
+
+	stdout countWriter
+	stderr countWriter
+}
+
+func reportExecuter(ex Executer, report *runReport, dep bool) Executer {
+	return &reportCommand{
+		Executer: ex,
+		report:   report,
+		dep:      dep,
+	}
+}
+
+func (r *reportCommand) SetOut(w io.Writer) {
+	r.stdout.Writer = w
+	r.Executer.SetOut(&r.stdout)
+}
+
+func (r *reportCommand) SetErr(w io.Writer) {
+	r.stderr.Writer = w
+	r.Executer.SetErr(&r.stderr)
+}
+
+func (r *reportCommand) Execute(ctx context.Context, args []string) error {
+	var (
+		clock    = clockFrom(ctx)
+		attempts int
+		entry    = reportEntry{
+			Command:    r.Command(),
+			Dependency: r.dep,
+			Start:      clock.Now(),
+		}
+		err = r.Executer.Execute(withAttempts(ctx, &attempts), args)
+	)
+	entry.finish(clock.Now(), err)
+	if attempts > 1 {
+		entry.Retries = attempts - 1
+	}
+	entry.Stdout = r.stdout.Count()
+	entry.Stderr = r.stderr.Count()
+	r.report.Add(entry)
+	return err
+}
+
+type attemptsKey struct{}
+
+// withAttempts gives a context used by a command to report the number of
+// times its script has been executed.
+func withAttempts(ctx context.Context, n *int) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, n)
+}
+
+func attemptsFrom(ctx context.Context) *int {
+	n, _ := ctx.Value(attemptsKey{}).(*int)
+	return n
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if code, ok := statusCode(err); ok {
+		return code
+	}
+	return 1
+}
+
+// statusCode gives the exit status of the program (local or remote) whose
+// failure is reported by err.
+func statusCode(err error) (int, bool) {
+	var (
+		code tish.ExitCode
+		exit *ssh.ExitError
+		prog *exec.ExitError
+	)
+	switch {
+	case errors.As(err, &code):
+		return int(code), true
+	case errors.As(err, &exit):
+		return exit.ExitStatus(), true
+	case errors.As(err, &prog) && prog.ExitCode() >= 0:
+		return prog.ExitCode(), true
+	case errors.Is(err, tish.ErrExit):
+		// the exit builtin only gives its code in the message of the error
+		str := err.Error()
+		c, err := strconv.Atoi(str[strings.LastIndex(str, " ")+1:])
+		return int(uint8(c)), err == nil
+	default:
+		return 0, false
+	}
+}
+
+// countWriter counts the bytes written to its underlying writer.
+type countWriter struct {
+	io.Writer
+
+	mu    sync.Mutex
+	count int64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.mu.Lock()
+	w.count += int64(n)
+	w.mu.Unlock()
+	return n, err
+}
+
+func (w *countWriter) Count() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (w *countWriter) SetPrefix(prefix string) {
+	setPrefix(w.Writer, prefix)
+}
@@ -0,0 +1,37 @@
+package maestro
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunReportMask(t *testing.T) {
+	const file = `
+deploy(options = (short = p, long = password, sensitive = true)): {
+	true
+}
+`
+	mst := decodeFile(t, file)
+	cmd, err := mst.Commands.Lookup("deploy")
+	if err != nil {
+		t.Fatalf("deploy not decoded: %s", err)
+	}
+	var (
+		report = createRunReport(cmd, []string{"--password", "hunter22"}, SystemClock())
+		fail   = errors.New("access denied with hunter22")
+		out    = filepath.Join(t.TempDir(), "report.json")
+	)
+	report.Add(reportEntry{Command: "deploy", Error: fail.Error()})
+	for _, format := range []string{ReportJson, ReportJunit} {
+		if err := report.Finish(out, format, fail); err != nil {
+			t.Fatalf("%s: fail to write report: %s", format, err)
+		}
+		buf, _ := os.ReadFile(out)
+		if strings.Contains(string(buf), "hunter22") {
+			t.Errorf("%s: sensitive value written in report: %s", format, buf)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package maestro
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+const (
+	runCancel = "cancel"
+	runInfo   = "info"
+	runExt    = ".sock"
+)
+
+const runTimeout = 5 * time.Second
+
+// runDir gives the directory where the sockets of the runs in progress are
+// created: $XDG_RUNTIME_DIR/maestro or, when it is not set, a directory of the
+// user in the temporary directory.
+func runDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "maestro")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("maestro-runs-%d", os.Getuid()))
+}
+
+// createRunDir creates the directory of the sockets if it does not exist yet
+// and checks it.
+func createRunDir() error {
+	if err := os.MkdirAll(runDir(), 0o700); err != nil {
+		return err
+	}
+	return checkRunDir()
+}
+
+// checkRunDir checks that the directory of the sockets is a directory owned
+// by the user running maestro that the other users can not access. Otherwise,
+// another user could create (or replace) the sockets of the runs.
+func checkRunDir() error {
+	dir := runDir()
+	i, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !i.IsDir() {
+		return fmt.Errorf("%s: not a directory", dir)
+	}
+	uid, ok := fileOwner(i)
+	if !ok {
+		return nil
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("%s: owned by another user", dir)
+	}
+	if i.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("%s: accessible to other users (mode %s)", dir, i.Mode().Perm())
+	}
+	return nil
+}
+
+// run is the execution of a command (from the command line, a schedule or the
+// serve sub-command). While the command is executed, a unix socket named after
+// the id of the run accepts the requests of the cancel sub-command.
+type run struct {
+	ID      string
+	Command string
+
+	cancel context.CancelFunc
+	ln     net.Listener
+}
+
+// startRun prints the id of the run to w and starts listening for cancel
+// requests. The run can still be executed if the socket can not be created:
+// it can then only be interrupted by a signal.
+func startRun(id, command string, cancel context.CancelFunc, w io.Writer) *run {
+	r := run{
+		ID:      id,
+		Command: command,
+		cancel:  cancel,
+	}
+	fmt.Fprintf(w, "run %s: %s", r.ID, r.Command)
+	fmt.Fprintln(w)
+	if err := createRunDir(); err != nil {
+		fmt.Fprintf(w, "run %s: %s (the run can not be cancelled)", r.ID, err)
+		fmt.Fprintln(w)
+		return &r
+	}
+	ln, err := net.Listen("unix", r.file())
+	if err != nil {
+		return &r
+	}
+	r.ln = ln
+	go r.serve()
+	return &r
+}
+
+// startRunContext creates a new run for a command executed with ctx. The
+// context returned is cancelled when the run is cancelled.
+func startRunContext(ctx context.Context, command string, w io.Writer) (context.Context, *run) {
+	id, _ := jobID()
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, startRun(id, command, cancel, w)
+}
+
+func (r *run) Close() error {
+	r.cancel()
+	if r.ln == nil {
+		return nil
+	}
+	return r.ln.Close()
+}
+
+func (r *run) file() string {
+	return filepath.Join(runDir(), r.ID+runExt)
+}
+
+func (r *run) serve() {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *run) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(runTimeout))
+
+	scan := bufio.NewScanner(conn)
+	if !scan.Scan() {
+		return
+	}
+	switch scan.Text() {
+	case runCancel:
+		r.cancel()
+		fmt.Fprintln(conn, "cancelled")
+	case runInfo:
+		fmt.Fprintln(conn, r.Command)
+	default:
+		fmt.Fprintln(conn, "unknown request")
+	}
+}
+
+// Cancel cancels the runs having the given ids. Without ids, the runs in
+// progress are listed.
+func (m *Maestro) Cancel(args []string) error {
+	set := flag.NewFlagSet(CmdCancel, flag.ExitOnError)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() == 0 {
+		return listRuns(stdio.Stdout)
+	}
+	var errs []string
+	for _, id := range set.Args() {
+		if _, err := requestRun(id, runCancel); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		fmt.Fprintf(stdio.Stdout, "run %s cancelled", id)
+		fmt.Fprintln(stdio.Stdout)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func listRuns(w io.Writer) error {
+	if err := checkRunDir(); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(runDir(), "*"+runExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), runExt)
+		cmd, err := requestRun(id, runInfo)
+		if err != nil {
+			// the socket of a run that has not been stopped properly
+			os.Remove(f)
+			continue
+		}
+		fmt.Fprintf(w, "%s  %s", id, cmd)
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+func requestRun(id, req string) (string, error) {
+	if err := checkRunDir(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	r := run{ID: id}
+	conn, err := net.DialTimeout("unix", r.file(), runTimeout)
+	if err != nil {
+		return "", fmt.Errorf("%s: run not found", id)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(runTimeout))
+
+	fmt.Fprintln(conn, req)
+	scan := bufio.NewScanner(conn)
+	if !scan.Scan() {
+		return "", fmt.Errorf("%s: no response from run", id)
+	}
+	return scan.Text(), nil
+}
@@ -0,0 +1,66 @@
+package maestro
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets not supported")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := startRun("0123456789abcdef", "deploy", cancel, io.Discard)
+	defer r.Close()
+
+	var list strings.Builder
+	if err := listRuns(&list); err != nil {
+		t.Fatalf("fail to list runs: %s", err)
+	}
+	if want := "0123456789abcdef  deploy\n"; list.String() != want {
+		t.Errorf("runs mismatched: want %q, got %q", want, list.String())
+	}
+	if _, err := requestRun(r.ID, runCancel); err != nil {
+		t.Fatalf("fail to cancel run: %s", err)
+	}
+	<-ctx.Done()
+
+	i, err := os.Stat(runDir())
+	if err != nil {
+		t.Fatalf("directory of the runs not created: %s", err)
+	}
+	if perm := i.Mode().Perm(); perm != 0o700 {
+		t.Errorf("directory of the runs accessible to other users: %s", perm)
+	}
+}
+
+func TestRunDirUnsafe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions of the files not supported")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	if err := os.Mkdir(runDir(), 0o777); err != nil {
+		t.Fatalf("fail to create directory: %s", err)
+	}
+	if err := os.Chmod(runDir(), 0o777); err != nil {
+		t.Fatalf("fail to change mode: %s", err)
+	}
+	var out strings.Builder
+	r := startRun("0123456789abcdef", "deploy", func() {}, &out)
+	defer r.Close()
+	if r.ln != nil {
+		t.Errorf("socket created in a directory accessible to other users")
+	}
+	if !strings.Contains(out.String(), "accessible to other users") {
+		t.Errorf("no warning printed: %q", out.String())
+	}
+	if _, err := requestRun(r.ID, runCancel); err == nil {
+		t.Errorf("request to a run of a directory accessible to other users should fail")
+	}
+}
@@ -2,8 +2,10 @@ package maestro
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/midbel/maestro/internal/stack"
@@ -21,6 +23,8 @@ const (
 	rcurly     = '}'
 	lparen     = '('
 	rparen     = ')'
+	lsquare    = '['
+	rsquare    = ']'
 	dot        = '.'
 	underscore = '_'
 	comma      = ','
@@ -32,6 +36,7 @@ const (
 	semicolon  = ';'
 	ampersand  = '&'
 	langle     = '<'
+	rangle     = '>'
 	minus      = '-'
 	bang       = '!'
 	arobase    = '@'
@@ -41,8 +46,17 @@ const (
 	plus       = '+'
 	caret      = '^'
 	star       = '*'
+	backtick   = '`'
 )
 
+// bom is the byte order mark written by some editors at the beginning of the
+// files encoded in UTF-8.
+var bom = []byte{0xef, 0xbb, 0xbf}
+
+// UnicodeIdent allows the letters of any script (eg: é, ß, ж) in identifiers.
+// Only ASCII letters are accepted when it is false.
+var UnicodeIdent = true
+
 type Scanner struct {
 	input []byte
 	curr  int
@@ -57,6 +71,23 @@ type Scanner struct {
 
 	keepBlank bool
 	state     *scanstack
+
+	// start of the quoted string and of the script being scanned: reported
+	// when the input ends before their end
+	quote  Position
+	script Position
+
+	// cond is set after an if keyword: the rest of the line is the condition.
+	// block is set after an else keyword: the next curly opens a block.
+	cond   bool
+	block  bool
+	blocks int
+	// loop is set after a for keyword: the rest of the line is the header of
+	// the loop and its body is read as is.
+	loop bool
+	// attr is set after the opening bracket of the attributes of a dependency:
+	// the closing bracket ends the value of the last attribute.
+	attr bool
 }
 
 func Scan(r io.Reader) (*Scanner, error) {
@@ -64,8 +95,12 @@ func Scan(r io.Reader) (*Scanner, error) {
 	if err != nil {
 		return nil, err
 	}
+	buf = bytes.TrimPrefix(buf, bom)
+	if bytes.IndexByte(buf, cr) >= 0 {
+		buf = bytes.ReplaceAll(buf, []byte{cr, nl}, []byte{nl})
+	}
 	s := Scanner{
-		input:  bytes.ReplaceAll(buf, []byte{cr, nl}, []byte{nl}),
+		input:  buf,
 		line:   1,
 		column: 0,
 		state:  defaultStack(),
@@ -78,7 +113,7 @@ func (s *Scanner) Scan() Token {
 	var tok Token
 	tok.Position = s.currentPosition()
 	if isEOF(s.char) {
-		tok.Type = Eof
+		s.scanEof(&tok)
 		return tok
 	}
 	if s.keepBlank && isBlank(s.char) && s.state.KeepBlank() {
@@ -88,16 +123,29 @@ func (s *Scanner) Scan() Token {
 	}
 	s.reset()
 	tok.Position = s.currentPosition()
+	if s.cond {
+		s.scanCondition(&tok)
+		return tok
+	}
+	if s.loop {
+		s.scanLoop(&tok)
+		return tok
+	}
 	if s.char != rcurly && s.state.Script() {
 		s.scanScript(&tok)
 		return tok
 	}
 	if s.state.Quote() && !isDouble(s.char) {
-		scan := s.scanText
-		if isVariable(s.char) {
-			scan = s.scanVariable
+		// the scan functions are called directly: calling them via a func
+		// value makes tok escape to the heap for each token
+		switch {
+		case isVariable(s.char):
+			s.scanVariable(&tok)
+		case isBuiltin(s.char, s.peek()):
+			s.scanBuiltin(&tok)
+		default:
+			s.scanText(&tok)
 		}
-		scan(&tok)
 		return tok
 	}
 	s.skipBlank()
@@ -108,10 +156,20 @@ func (s *Scanner) Scan() Token {
 		s.scanComment(&tok)
 	case isVariable(s.char):
 		s.scanVariable(&tok)
+	case isBuiltin(s.char, s.peek()):
+		s.scanBuiltin(&tok)
+	case s.state.Value() && s.char == backtick:
+		s.scanSubstitution(&tok)
 	case isSingle(s.char):
 		s.scanString(&tok)
 	case isDouble(s.char):
 		s.scanQuote(&tok)
+	case s.state.Default() && isAssignment(s.char, s.peek()):
+		s.scanAssignment(&tok)
+	case s.state.Default() && isPipe(s.char, s.peek()):
+		s.scanPipe(&tok)
+	case s.attr && s.char == rsquare:
+		s.scanOperator(&tok)
 	case s.state.Default() && isOperator(s.char):
 		s.scanOperator(&tok)
 	case isDelimiter(s.char):
@@ -127,6 +185,43 @@ func (s *Scanner) Scan() Token {
 	return tok
 }
 
+// scanEof gives the end of the input or an invalid token when the input ends
+// in the middle of a quoted string or of a script or with an invalid UTF-8
+// character. The state is left so that
+// the next token is the end of the input.
+func (s *Scanner) scanEof(tok *Token) {
+	tok.Type = Eof
+	switch {
+	case s.char == utf8.RuneError:
+		s.char = zero
+		tok.Type = Invalid
+		tok.reason = "invalid UTF-8 character"
+	case s.state.Quote():
+		s.state.ToggleQuote()
+		s.unterminated(tok, "string", s.quote)
+	case s.state.Script() && !s.loop:
+		s.state.Pop()
+		s.unterminated(tok, "script", s.script)
+	default:
+	}
+}
+
+// unterminated makes tok an invalid token starting at pos.
+func (s *Scanner) unterminated(tok *Token, what string, pos Position) {
+	tok.Type = Invalid
+	tok.Position = pos
+	tok.reason = fmt.Sprintf("unterminated %s", what)
+}
+
+// LineAt gives the line n of the input.
+func (s *Scanner) LineAt(n int) string {
+	lines := bytes.Split(s.input, []byte{nl})
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.ReplaceAll(string(lines[n-1]), "\t", " ")
+}
+
 func (s *Scanner) CurrentLine() string {
 	var (
 		pos = s.curr - s.column
@@ -175,6 +270,77 @@ func (s *Scanner) scanScript(tok *Token) {
 	s.skipBlank()
 }
 
+// scanCondition reads the condition of an if up to the curly that opens its
+// block.
+func (s *Scanner) scanCondition(tok *Token) {
+	s.cond = false
+	s.skipBlank()
+	for !isNL(s.char) && !s.done() {
+		s.str.WriteRune(s.char)
+		s.read()
+	}
+	str := strings.TrimSpace(s.str.String())
+	if !strings.HasSuffix(str, string(lcurly)) {
+		tok.Type = Invalid
+		return
+	}
+	tok.Literal = strings.TrimSpace(strings.TrimSuffix(str, string(lcurly)))
+	tok.Type = Condition
+	s.blocks++
+}
+
+// scanLoop reads the header of a for loop and its body. The body ends with the
+// line starting with the curly that closes the block opened on the line of the
+// header. The body is given in the literal of the token after a newline.
+func (s *Scanner) scanLoop(tok *Token) {
+	s.loop = false
+	s.skipBlank()
+	for !isNL(s.char) && !s.done() {
+		s.str.WriteRune(s.char)
+		s.read()
+	}
+	header := strings.TrimSpace(s.str.String())
+	if !strings.HasSuffix(header, string(lcurly)) {
+		tok.Type = Invalid
+		return
+	}
+	header = strings.TrimSpace(strings.TrimSuffix(header, string(lcurly)))
+	// only the end of the header is skipped: the lines of the body keep
+	// their position relative to the header
+	if isNL(s.char) {
+		s.read()
+	}
+
+	var (
+		body  strings.Builder
+		line  strings.Builder
+		depth = 1
+	)
+	for !s.done() {
+		line.Reset()
+		for !isNL(s.char) && !s.done() {
+			line.WriteRune(s.char)
+			s.read()
+		}
+		str := strings.TrimSpace(line.String())
+		if strings.HasPrefix(str, string(rcurly)) {
+			depth--
+		}
+		if depth == 0 {
+			tok.Literal = header + string(nl) + body.String()
+			tok.Type = Loop
+			return
+		}
+		if strings.HasSuffix(str, string(lcurly)) {
+			depth++
+		}
+		body.WriteString(line.String())
+		body.WriteRune(nl)
+		s.read()
+	}
+	s.unterminated(tok, "loop", tok.Position)
+}
+
 func (s *Scanner) scanEol(tok *Token) {
 	tok.Type = Eol
 	s.skipNL()
@@ -204,15 +370,16 @@ func (s *Scanner) scanHeredoc(tok *Token) {
 	var (
 		tmp    bytes.Buffer
 		prefix = s.str.String()
+		found  bool
 	)
 	s.str.Reset()
 	s.skipNL()
 	for !s.done() {
-		for !isNL(s.char) {
+		for !isNL(s.char) && !s.done() {
 			tmp.WriteRune(s.char)
 			s.read()
 		}
-		if tmp.String() == prefix {
+		if found = tmp.String() == prefix; found {
 			break
 		}
 		for isNL(s.char) {
@@ -221,6 +388,10 @@ func (s *Scanner) scanHeredoc(tok *Token) {
 		}
 		io.Copy(&s.str, &tmp)
 	}
+	if !found {
+		s.unterminated(tok, "heredoc", tok.Position)
+		return
+	}
 	tok.Literal = strings.TrimSpace(s.str.String())
 	tok.Type = String
 }
@@ -228,6 +399,9 @@ func (s *Scanner) scanHeredoc(tok *Token) {
 func (s *Scanner) scanQuote(tok *Token) {
 	tok.Type = Quote
 	s.state.ToggleQuote()
+	if s.state.Quote() {
+		s.quote = tok.Position
+	}
 	s.read()
 	if !s.keepBlank && !s.state.Quote() {
 		s.skipBlank()
@@ -236,7 +410,7 @@ func (s *Scanner) scanQuote(tok *Token) {
 
 func (s *Scanner) scanText(tok *Token) {
 	accept := func(r rune) bool {
-		return !isDouble(r) && !isVariable(r)
+		return !isDouble(r) && !isVariable(r) && !isBuiltin(r, s.peek()) && !isEOF(r)
 	}
 	for accept(s.char) {
 		s.str.WriteRune(s.char)
@@ -246,15 +420,29 @@ func (s *Scanner) scanText(tok *Token) {
 	tok.Type = String
 }
 
+func (s *Scanner) scanSubstitution(tok *Token) {
+	s.scanString(tok)
+	switch tok.Type {
+	case String:
+		tok.Type = Substitution
+	case Invalid:
+		tok.reason = "unterminated command substitution"
+	default:
+	}
+}
+
 func (s *Scanner) scanString(tok *Token) {
-	quote := s.char
+	var (
+		quote = s.char
+		pos   = s.currentPosition()
+	)
 	s.read()
 	for s.char != quote && !s.done() {
 		s.str.WriteRune(s.char)
 		s.read()
 	}
 	if s.char != quote {
-		tok.Type = Invalid
+		s.unterminated(tok, "string", pos)
 		return
 	}
 	s.read()
@@ -262,6 +450,25 @@ func (s *Scanner) scanString(tok *Token) {
 	tok.Type = String
 }
 
+func (s *Scanner) scanBuiltin(tok *Token) {
+	s.read()
+	s.read()
+	for isIdent(s.char) || s.char == dot {
+		s.str.WriteRune(s.char)
+		s.read()
+	}
+	tok.Literal = s.str.String()
+	tok.Type = Variable
+	if s.char != rparen || tok.Literal == "" {
+		tok.Type = Invalid
+		if isNL(s.char) || s.done() {
+			tok.reason = "unterminated variable"
+		}
+		return
+	}
+	s.read()
+}
+
 func (s *Scanner) scanVariable(tok *Token) {
 	s.read()
 	if s.char == lparen {
@@ -274,6 +481,7 @@ func (s *Scanner) scanVariable(tok *Token) {
 		tok.Type = Script
 		if s.char != rparen {
 			tok.Type = Invalid
+			tok.reason = "unterminated script"
 		} else {
 			s.read()
 		}
@@ -293,6 +501,9 @@ func (s *Scanner) scanVariable(tok *Token) {
 	if enclosed {
 		if s.char != rcurly {
 			tok.Type = Invalid
+			if isNL(s.char) || s.done() {
+				tok.reason = "unterminated variable"
+			}
 			return
 		}
 		s.read()
@@ -308,6 +519,12 @@ func (s *Scanner) scanLiteral(tok *Token) {
 		accept = isLiteral
 	}
 	for accept(s.char) {
+		if s.attr && s.char == rsquare {
+			break
+		}
+		if s.state.Default() && isPipe(s.char, s.peek()) {
+			break
+		}
 		if ident && !isIdent(s.char) {
 			ident = !ident
 		}
@@ -324,6 +541,38 @@ func (s *Scanner) scanLiteral(tok *Token) {
 		tok.Type = Boolean
 	case kwInclude, kwExport, kwDelete, kwAlias:
 		tok.Type = Keyword
+	case kwFor:
+		tok.Type = Ident
+		if s.state.Default() {
+			tok.Type = Keyword
+			s.loop = true
+		}
+	case kwPreset:
+		tok.Type = Ident
+		if s.state.Default() {
+			tok.Type = Keyword
+		}
+	case kwPrint, kwEcho:
+		tok.Type = Ident
+		if s.state.Default() && s.isStatement(s.curr-len(tok.Literal)) {
+			// the message is read as the value of a variable
+			tok.Type = Keyword
+			s.keepBlank = true
+			s.skipBlank()
+			s.state.Push(scanValue)
+		}
+	case kwOptions:
+		tok.Type = Ident
+		if s.state.Default() && s.isStatement(s.curr-len(tok.Literal)) {
+			tok.Type = Keyword
+		}
+	case kwIf, kwElse:
+		tok.Type = Ident
+		if s.state.Default() {
+			tok.Type = Keyword
+			s.cond = tok.Literal == kwIf
+			s.block = tok.Literal == kwElse
+		}
 	default:
 		tok.Type = Ident
 	}
@@ -339,12 +588,37 @@ func (s *Scanner) scanOperator(tok *Token) {
 		tok.Type = Mandatory
 	case percent:
 		tok.Type = Hidden
+	case lsquare:
+		tok.Type = BegAttr
+		s.attr = true
+	case rsquare:
+		tok.Type = EndAttr
+		s.attr = false
 	default:
 		tok.Type = Invalid
 	}
 	s.read()
 }
 
+func (s *Scanner) scanAssignment(tok *Token) {
+	switch s.char {
+	case question:
+		tok.Type = AssignDefault
+	case colon:
+		tok.Type = AssignImmediate
+	case bang:
+		tok.Type = AssignShell
+	}
+	s.read()
+	s.read()
+}
+
+func (s *Scanner) scanPipe(tok *Token) {
+	tok.Type = Pipe
+	s.read()
+	s.read()
+}
+
 func (s *Scanner) scanDelimiter(tok *Token) {
 	switch s.char {
 	case colon:
@@ -369,9 +643,19 @@ func (s *Scanner) scanDelimiter(tok *Token) {
 		tok.Type = EndList
 	case lcurly:
 		tok.Type = BegScript
+		if s.block {
+			s.block = false
+			s.blocks++
+			break
+		}
 		s.state.Push(scanScript)
+		s.script = tok.Position
 	case rcurly:
 		tok.Type = EndScript
+		if s.blocks > 0 && !s.state.Script() {
+			s.blocks--
+			break
+		}
 		s.state.Pop()
 	default:
 		tok.Type = Invalid
@@ -388,7 +672,7 @@ func (s *Scanner) scanDelimiter(tok *Token) {
 func (s *Scanner) scanComment(tok *Token) {
 	s.read()
 	s.skipBlank()
-	for !isNL(s.char) {
+	for !isNL(s.char) && !s.done() {
 		s.str.WriteRune(s.char)
 		s.read()
 	}
@@ -406,11 +690,11 @@ func (s *Scanner) toggleBlank(tok Token) {
 		return
 	}
 	switch tok.Type {
-	case Assign, Append:
+	case Assign, Append, AssignDefault, AssignImmediate, AssignShell:
 		s.keepBlank = true
 		s.skipBlank()
 		s.state.Push(scanValue)
-	case Comment, Comma, BegList, EndList, Dependency, Eol:
+	case Comment, Comma, BegList, EndList, EndAttr, Dependency, Eol:
 		s.keepBlank = false
 		s.skipBlank()
 		s.state.Pop()
@@ -429,6 +713,24 @@ func (s *Scanner) reset() {
 	s.str.Reset()
 }
 
+// isStatement tells whether the keyword starting at offset begins a statement
+// (print, echo, options): it is the first word of its line and it is not the
+// name of a variable, of an alias, of a command or of a property.
+func (s *Scanner) isStatement(offset int) bool {
+	if x := bytes.LastIndexByte(s.input[:offset], byte(nl)); len(bytes.Trim(s.input[x+1:offset], " \t")) > 0 {
+		return false
+	}
+	rest := bytes.TrimLeft(s.input[s.curr:], " \t")
+	if len(rest) == 0 {
+		return true
+	}
+	var c, p rune = rune(rest[0]), 0
+	if len(rest) > 1 {
+		p = rune(rest[1])
+	}
+	return !isDelimiter(c) && !isAssignment(c, p)
+}
+
 func (s *Scanner) peek() rune {
 	r, _ := utf8.DecodeRune(s.input[s.next:])
 	return r
@@ -441,7 +743,11 @@ func (s *Scanner) read() {
 	}
 	r, n := utf8.DecodeRune(s.input[s.next:])
 	if r == utf8.RuneError {
-		s.char = 0
+		// invalid input ends the input. It is reported by scanEof
+		if n == 0 {
+			r = zero
+		}
+		n = 0
 		s.next = len(s.input)
 	}
 	last := s.char
@@ -470,13 +776,17 @@ func (s *Scanner) skip(fn func(rune) bool) {
 }
 
 func isValue(b rune) bool {
-	return !isVariable(b) && !isBlank(b) && !isNL(b) && !isDelimiter(b)
+	return !isVariable(b) && !isBlank(b) && !isNL(b) && !isDelimiter(b) && !isEOF(b)
 }
 
 func isLiteral(b rune) bool {
 	return isValue(b) && !isOperator(b)
 }
 
+func isPipe(c, p rune) bool {
+	return c == minus && p == rangle
+}
+
 func isHeredoc(c, p rune) bool {
 	return c == p && c == langle
 }
@@ -510,6 +820,9 @@ func isDouble(b rune) bool {
 }
 
 func isLetter(b rune) bool {
+	if UnicodeIdent && b >= utf8.RuneSelf {
+		return unicode.IsLetter(b)
+	}
 	return isLower(b) || isUpper(b)
 }
 
@@ -533,8 +846,17 @@ func isVariable(b rune) bool {
 	return b == dollar
 }
 
+func isBuiltin(b, next rune) bool {
+	return b == percent && next == lparen
+}
+
 func isOperator(b rune) bool {
-	return b == ampersand || b == question || b == star || b == percent
+	return b == ampersand || b == question || b == star || b == percent ||
+		b == lsquare || b == rsquare
+}
+
+func isAssignment(c, p rune) bool {
+	return p == equal && (c == question || c == colon || c == bang)
 }
 
 func isDelimiter(b rune) bool {
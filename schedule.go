@@ -48,13 +48,17 @@ type ScheduleContext struct {
 	CommandSettings
 	Prefix bool
 	Trace  bool
+
+	breaker  *breaker
+	notifier *notifier
 }
 
-func scheduleContext(cmd CommandSettings, prefix, trace bool) ScheduleContext {
+func scheduleContext(cmd CommandSettings, prefix, trace bool, brk *breaker) ScheduleContext {
 	return ScheduleContext{
 		CommandSettings: cmd,
 		Prefix:          prefix,
 		Trace:           trace,
+		breaker:         brk,
 	}
 }
 
@@ -75,6 +79,7 @@ func (s *Schedule) Run(ctx context.Context, reg Registry, cmd ScheduleContext, s
 	if c, ok := r.(io.Closer); ok {
 		defer c.Close()
 	}
+	s.Sched.SetClock(clockFrom(ctx))
 	return s.Sched.Run(ctx, r)
 }
 
@@ -94,7 +99,7 @@ func (s *Schedule) makeRunner(reg Registry, cmd ScheduleContext, stdout, stderr
 	if cmd.Prefix {
 		stderr = writePrefix(stderr, cmd.Name)
 	}
-	r := createRunner(reg, cmd.CommandSettings, s.Args, stdout, stderr)
+	r := createRunner(reg, cmd, s.Args, stdout, stderr)
 	if !s.Overlap {
 		r = schedule.SkipRunning(r)
 	}
@@ -107,15 +112,21 @@ type runner struct {
 	args []string
 	out  io.Writer
 	err  io.Writer
+
+	breaker  *breaker
+	notifier *notifier
 }
 
-func createRunner(reg Registry, cmd CommandSettings, args []string, stdout, stderr io.Writer) schedule.Runner {
+func createRunner(reg Registry, cmd ScheduleContext, args []string, stdout, stderr io.Writer) schedule.Runner {
 	return runner{
 		reg:  reg,
-		cmd:  cmd,
+		cmd:  cmd.CommandSettings,
 		args: args,
 		out:  stdout,
 		err:  stderr,
+
+		breaker:  cmd.breaker,
+		notifier: cmd.notifier,
 	}
 }
 
@@ -136,12 +147,34 @@ func (r runner) Run(ctx context.Context) error {
 	if err != nil {
 		return nil
 	}
-	x.SetOut(r.out)
-	x.SetErr(r.err)
+	var (
+		notif = r.notifier.Reset()
+		now   = clockFrom(ctx).Now()
+	)
+	if notif != nil {
+		x.SetOut(notif.Writer(r.out))
+		x.SetErr(notif.Writer(r.err))
+	} else {
+		x.SetOut(r.out)
+		x.SetErr(r.err)
+	}
+	ctx, run := startRunContext(ctx, r.cmd.Command(), r.err)
+	defer run.Close()
 	err = x.Execute(ctx, r.args)
 	if err != nil {
 		fmt.Fprintf(r.err, "[%s] %s", r.cmd.Command(), err)
 		fmt.Fprintln(r.err)
+		if r.breaker.Fail() {
+			fmt.Fprintf(r.err, "[%s] %s", r.cmd.Command(), r.breaker.Err())
+			fmt.Fprintln(r.err)
+		}
+	}
+	if notif != nil && notif.Accept(err) {
+		msg := notif.Message(r.cmd.Command(), r.args, now, clockFrom(ctx).Now().Sub(now), err)
+		if e := notif.Notify(msg); e != nil {
+			fmt.Fprintf(r.err, "[%s] fail to notify: %s", r.cmd.Command(), e)
+			fmt.Fprintln(r.err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,45 @@
+package schedule
+
+import (
+	"context"
+	"time"
+)
+
+// Clock gives the current time and the channels used to wait until a time is
+// reached. It can be replaced to run the schedulers deterministically.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// SystemClock gives the clock of the system.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+func (_ systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (_ systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type clockKey struct{}
+
+// WithClock gives a context carrying the clock used by the runners executed
+// with it.
+func WithClock(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+// ClockFrom gives the clock carried by ctx or the clock of the system.
+func ClockFrom(ctx context.Context) Clock {
+	c, ok := ctx.Value(clockKey{}).(Clock)
+	if !ok || c == nil {
+		return SystemClock()
+	}
+	return c
+}
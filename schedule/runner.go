@@ -130,7 +130,11 @@ type delayRunner struct {
 }
 
 func (r *delayRunner) Run(ctx context.Context) error {
-	<-time.After(r.wait)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ClockFrom(ctx).After(r.wait):
+	}
 	return r.Runner.Run(ctx)
 }
 
@@ -178,12 +182,13 @@ type traceRunner struct {
 func (r *traceRunner) Run(ctx context.Context) error {
 	log.Printf("[%s] start", r.name)
 	var (
-		now = time.Now()
-		err = r.Runner.Run(ctx)
+		clock = ClockFrom(ctx)
+		now   = clock.Now()
+		err   = r.Runner.Run(ctx)
 	)
 	if err != nil {
 		log.Printf("[%s] error: %s", r.name, err)
 	}
-	log.Printf("[%s] done (elapsed: %s)", r.name, time.Since(now))
+	log.Printf("[%s] done (elapsed: %s)", r.name, clock.Now().Sub(now))
 	return err
 }
@@ -18,7 +18,8 @@ type Scheduler struct {
 	month Ticker
 	week  Ticker
 
-	when time.Time
+	when  time.Time
+	clock Clock
 }
 
 func ScheduleFromList(ls []string) (*Scheduler, error) {
@@ -47,18 +48,33 @@ func Schedule(min, hour, day, month, week string) (*Scheduler, error) {
 	if err := hasError(err1, err2, err3, err4, err5); err != nil {
 		return nil, err
 	}
-	sched.Reset(time.Now().Local())
+	if !sched.possible() {
+		return nil, fmt.Errorf("schedule: %s %s: %w", day, month, ErrNever)
+	}
+	sched.clock = SystemClock()
+	sched.Reset(sched.clock.Now().Local())
 	return &sched, nil
 }
 
+// SetClock replaces the clock used by the scheduler and resets the scheduler
+// to the current time of the clock.
+func (s *Scheduler) SetClock(c Clock) {
+	s.clock = c
+	s.Reset(c.Now().Local())
+}
+
 func (s *Scheduler) RunFunc(ctx context.Context, fn func(context.Context) error) error {
 	return s.Run(ctx, runFunc(fn))
 }
 
+// Run executes r each time the schedule is reached until ctx is cancelled or
+// r returns an error. The runners get the clock of the scheduler in their
+// context.
 func (s *Scheduler) Run(ctx context.Context, r Runner) error {
 	var grp *errgroup.Group
-	grp, ctx = errgroup.WithContext(ctx)
-	for now := time.Now(); ; now = time.Now() {
+	grp, ctx = errgroup.WithContext(WithClock(ctx, s.clock))
+loop:
+	for now := s.clock.Now(); ; now = s.clock.Now() {
 		var (
 			next = s.Next()
 			wait = next.Sub(now)
@@ -68,8 +84,8 @@ func (s *Scheduler) Run(ctx context.Context, r Runner) error {
 		}
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(wait):
+			break loop
+		case <-s.clock.After(wait):
 		}
 		grp.Go(func() error {
 			return r.Run(ctx)
@@ -77,7 +93,10 @@ func (s *Scheduler) Run(ctx context.Context, r Runner) error {
 	}
 	err := grp.Wait()
 	if errors.Is(err, ErrDone) {
-		err = nil
+		return nil
+	}
+	if err == nil {
+		err = ctx.Err()
 	}
 	return err
 }
@@ -194,6 +213,45 @@ func (s *Scheduler) reset() {
 	}
 }
 
+// possible tells whether one of the days of the scheduler exists in one of its
+// months (eg: the 30th of february never exists).
+func (s *Scheduler) possible() bool {
+	defer func() {
+		s.day.reset()
+		s.month.reset()
+	}()
+	var (
+		dlist = values(s.day, 31)
+		mlist = values(s.month, 12)
+	)
+	for _, m := range mlist {
+		n := days[m-1]
+		if m == 2 {
+			n++
+		}
+		for _, d := range dlist {
+			if d <= n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// values gives the values of a ticker over one of its cycles.
+func values(t Ticker, max int) []int {
+	t.reset()
+	var list []int
+	for i := 0; i <= max; i++ {
+		list = append(list, t.Curr())
+		t.Next()
+		if t.one() || t.isReset() {
+			break
+		}
+	}
+	return list
+}
+
 func (s *Scheduler) get() (time.Time, bool) {
 	var (
 		year  = s.when.Year()
@@ -1,7 +1,9 @@
 package schedule_test
 
 import (
+	"context"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -213,7 +215,120 @@ func TestScheduler(t *testing.T) {
 	}
 }
 
+// fakeClock moves its time forward each time After is called. Once limit
+// calls have been made, the channels given by After never fire.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+	limit int
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waits) >= c.limit {
+		return nil
+	}
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestSchedulerClock(t *testing.T) {
+	sched, err := schedule.Schedule("*/5", "*", "*", "*", "*")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	clock := fakeClock{
+		now:   today,
+		limit: 3,
+	}
+	sched.SetClock(&clock)
+
+	var (
+		mu    sync.Mutex
+		count int
+	)
+	err = sched.RunFunc(context.Background(), func(_ context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if count++; count == 3 {
+			return schedule.ErrDone
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []time.Duration{4*time.Minute + 15*time.Second, 5 * time.Minute, 5 * time.Minute}
+	for i := range want {
+		if i >= len(clock.waits) || clock.waits[i] != want[i] {
+			t.Fatalf("waits mismatched! want %s, got %s", want, clock.waits)
+		}
+	}
+}
+
 func parseTime(str string) time.Time {
 	w, _ := time.Parse("2006-01-02 15:04:05", str)
 	return w
 }
+
+func TestScheduleInvalid(t *testing.T) {
+	data := [][]string{
+		{"4-0/2", "*", "*", "*", "*"},
+		{"*", "*", "1-10/0", "*", "*"},
+		{"*", "5/-1", "*", "*", "*"},
+		{"0", "0", "30", "2", "*"},
+		{"0", "0", "31", "4;6", "*"},
+	}
+	for _, d := range data {
+		_, err := schedule.ScheduleFromList(d)
+		if err == nil {
+			t.Errorf("%s: expected error but got none", strings.Join(d, " "))
+		}
+	}
+}
+
+func TestDelayRunner(t *testing.T) {
+	var (
+		clock = fakeClock{now: today, limit: 1}
+		ctx   = schedule.WithClock(context.Background(), &clock)
+		count int
+		run   = schedule.Trace(schedule.DelayRunner(runFunc(func(_ context.Context) error {
+			count++
+			return nil
+		}), time.Minute), "delay")
+	)
+	if err := run.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 1 || len(clock.waits) != 1 || clock.waits[0] != time.Minute {
+		t.Fatalf("runner not executed after its delay! count: %d, waits: %s", count, clock.waits)
+	}
+
+	// the clock does not fire anymore: only the cancellation ends the wait
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := run.Run(ctx); err == nil {
+		t.Fatalf("delay should have been interrupted")
+	}
+	if count != 1 {
+		t.Fatalf("runner executed after the cancellation")
+	}
+}
+
+type runFunc func(context.Context) error
+
+func (r runFunc) Run(ctx context.Context) error {
+	return r(ctx)
+}
@@ -281,8 +281,11 @@ var monthnames = []string{
 }
 
 var (
-	ErrInvalid = errors.New("invalid")
-	ErrRange   = errors.New("not in range")
+	ErrInvalid  = errors.New("invalid")
+	ErrRange    = errors.New("not in range")
+	ErrStep     = errors.New("invalid step")
+	ErrInterval = errors.New("invalid interval")
+	ErrNever    = errors.New("never matches a date")
 )
 
 func parse(cron string, min, max int, names []string) (Ticker, error) {
@@ -310,6 +313,9 @@ func createSingle(base, step string, names []string, min, max int) (Ticker, erro
 	if err != nil && step != "" {
 		return nil, err
 	}
+	if s < 0 {
+		return nil, stepError(step)
+	}
 	if base == "*" {
 		e := All(min, max)
 		if s > 0 {
@@ -342,20 +348,30 @@ func createInterval(from, to, step string, names []string, min, max int) (Ticker
 		}
 		s = s1
 	}
+	if s <= 0 {
+		return nil, stepError(step)
+	}
+	if err := hasError(err1, err2); err != nil {
+		return nil, err
+	}
 	if f < min || f > max {
 		return nil, rangeError(from, min, max)
 	}
 	if t < min || t > max {
 		return nil, rangeError(to, min, max)
 	}
-	if err := hasError(err1, err2); err != nil {
-		return nil, err
+	if f > t {
+		return nil, fmt.Errorf("%s-%s: %w", from, to, ErrInterval)
 	}
 	e := Interval(f, t, min, max)
 	e.By(s)
 	return e, nil
 }
 
+func stepError(v string) error {
+	return fmt.Errorf("%s: %w", v, ErrStep)
+}
+
 func rangeError(v string, min, max int) error {
 	return fmt.Errorf("%s %w [%d,%d]", v, ErrRange, min, max)
 }
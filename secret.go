@@ -0,0 +1,212 @@
+package maestro
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	secretEnv  = "env:"
+	secretFile = "file:"
+	secretExec = "exec:"
+)
+
+const secretMask = "***"
+
+// readSecret gives the value of a secret. The reference tells where the secret
+// is read from:
+//
+//	env:NAME       value of the environment variable NAME
+//	file:path      content of the file (trailing newline removed)
+//	exec:command   output of the command executed by the shell
+//
+// A reference without one of these prefixes is the secret itself.
+func readSecret(ref string) (string, error) {
+	var (
+		str string
+		err error
+	)
+	switch {
+	case strings.HasPrefix(ref, secretEnv):
+		name := strings.TrimPrefix(ref, secretEnv)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%s: environment variable not set", name)
+		}
+		str = val
+	case strings.HasPrefix(ref, secretFile):
+		var buf []byte
+		buf, err = os.ReadFile(strings.TrimPrefix(ref, secretFile))
+		str = string(buf)
+	case strings.HasPrefix(ref, secretExec):
+		var (
+			cmd = exec.Command("sh", "-c", strings.TrimPrefix(ref, secretExec))
+			buf []byte
+		)
+		cmd.Stderr = os.Stderr
+		if buf, err = cmd.Output(); err != nil {
+			err = fmt.Errorf("fail to read secret: %w", err)
+		}
+		str = string(buf)
+	default:
+		str = ref
+	}
+	return strings.TrimRight(str, "\r\n"), err
+}
+
+// secrets is the set of values replaced by a mask in the output of the
+// commands. Values can be added while the output is written (eg: the values of
+// the sensitive options once the arguments of a command are parsed).
+type secrets struct {
+	mu   sync.RWMutex
+	list [][]byte
+}
+
+func (s *secrets) Add(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range values {
+		if v != "" && !s.has(v) {
+			s.list = append(s.list, []byte(v))
+		}
+	}
+	// longest first so that a value containing another one is fully masked
+	sort.SliceStable(s.list, func(i, j int) bool {
+		return len(s.list[i]) > len(s.list[j])
+	})
+}
+
+func (s *secrets) has(value string) bool {
+	for _, v := range s.list {
+		if string(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *secrets) Values() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []string
+	for _, v := range s.list {
+		list = append(list, string(v))
+	}
+	return list
+}
+
+func (s *secrets) Redact(b []byte) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.list {
+		b = bytes.ReplaceAll(b, v, []byte(secretMask))
+	}
+	return b
+}
+
+// redact replaces the values found in str by a mask.
+func redact(str string, values ...string) string {
+	var set secrets
+	if set.Add(values...); len(set.list) == 0 {
+		return str
+	}
+	return string(set.Redact([]byte(str)))
+}
+
+// pending gives the length of the longest end of b that is the beginning of
+// one of the secrets.
+func (s *secrets) pending(b []byte) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var n int
+	for _, v := range s.list {
+		for i := len(v) - 1; i > n; i-- {
+			if bytes.HasSuffix(b, v[:i]) {
+				n = i
+				break
+			}
+		}
+	}
+	return n
+}
+
+// Writer gives a writer masking the secrets in everything written to w.
+func (s *secrets) Writer(w io.Writer) io.Writer {
+	return &maskWriter{
+		Writer: w,
+		set:    s,
+	}
+}
+
+// maskWriter masks the secrets written to the underlying writer even when a
+// secret is written in several calls: the end of the data that could be the
+// beginning of a secret is kept until the next call or until Flush.
+type maskWriter struct {
+	io.Writer
+	set *secrets
+
+	mu   sync.Mutex
+	tail []byte
+}
+
+// mask replaces the given secrets by a mask in everything written to w.
+func mask(w io.Writer, values ...string) io.Writer {
+	var set secrets
+	if set.Add(values...); len(set.list) == 0 {
+		return w
+	}
+	return set.Writer(w)
+}
+
+// Write masks the secrets found in b and in the end kept from the previous
+// call.
+func (w *maskWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	buf := w.set.Redact(append(w.tail, b...))
+	n := len(buf) - w.set.pending(buf)
+	w.tail = append([]byte{}, buf[n:]...)
+	if n == 0 {
+		return len(b), nil
+	}
+	if _, err := w.Writer.Write(buf[:n]); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes the end of the data kept by the previous call to Write.
+func (w *maskWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.tail) == 0 {
+		return nil
+	}
+	_, err := w.Writer.Write(w.tail)
+	w.tail = w.tail[:0]
+	return err
+}
+
+// flushMask writes what is kept by w when it masks secrets.
+func flushMask(w io.Writer) {
+	if m, ok := w.(*maskWriter); ok {
+		m.Flush()
+	}
+}
+
+// sudoScript makes line executed with the privileges of the super user on
+// the remote server. With a password, sudo reads it from the standard input
+// and prints no prompt.
+func sudoScript(line string, password bool) string {
+	if password {
+		return fmt.Sprintf("sudo -S -p '' -- sh -c %s", shellQuote(line))
+	}
+	return fmt.Sprintf("sudo -n -- sh -c %s", shellQuote(line))
+}
@@ -0,0 +1,64 @@
+package maestro
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMaskWriter(t *testing.T) {
+	tests := []struct {
+		Writes []string
+		Want   string
+	}{
+		{Writes: []string{"token: supersecret123\n"}, Want: "token: ***\n"},
+		{Writes: []string{"super", "secret123\n"}, Want: "***\n"},
+		{Writes: []string{"s", "u", "p", "e", "rsecret", "123", " done"}, Want: "*** done"},
+		{Writes: []string{"super", "man\n"}, Want: "superman\n"},
+		{Writes: []string{"a hunter", "2 and a hunter22"}, Want: "a hunter2 and a ***"},
+		{Writes: []string{"prompt: sup"}, Want: "prompt: sup"},
+	}
+	for _, tt := range tests {
+		var (
+			buf strings.Builder
+			set secrets
+		)
+		set.Add("supersecret123", "hunter22")
+		w := set.Writer(&buf)
+		for _, str := range tt.Writes {
+			if n, err := io.WriteString(w, str); err != nil || n != len(str) {
+				t.Fatalf("%q: fail to write: %d, %v", str, n, err)
+			}
+		}
+		flushMask(w)
+		if got := buf.String(); got != tt.Want {
+			t.Errorf("%q: output mismatched! want %q, got %q", tt.Writes, tt.Want, got)
+		}
+	}
+}
+
+func TestMaskCommand(t *testing.T) {
+	const file = `
+.SENSITIVE = token
+
+token = supersecret123
+
+leak: {
+	printf 'super'
+	printf 'secret123\n'
+	printf 'end with sup'
+}
+`
+	var (
+		mst = decodeFile(t, file)
+		ex  = resolveCommand(t, mst, "leak", ctreeOption{})
+		buf strings.Builder
+	)
+	if err := ex.Execute(context.Background(), &buf, io.Discard); err != nil {
+		t.Fatalf("leak should have succeeded: %s", err)
+	}
+	if want, got := "***\nend with sup\n", buf.String(); got != want {
+		t.Errorf("output mismatched! want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,158 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// composePoll is the delay between two checks of the state of the services
+// when docker compose can not wait for them.
+const composePoll = time.Second
+
+// composeState gives the health of a container or its status when it has no
+// health check.
+const composeState = "{{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}"
+
+// servicesCommand starts the docker compose services needed by a command
+// before its execution and removes them once it is done.
+type servicesCommand struct {
+	Executer
+
+	file     string
+	services []string
+	stderr   io.Writer
+}
+
+func (m *Maestro) services(ex Executer, cmd CommandSettings) Executer {
+	return &servicesCommand{
+		Executer: ex,
+		file:     m.MetaExec.ComposeFile,
+		services: cmd.Services,
+		stderr:   io.Discard,
+	}
+}
+
+func (c *servicesCommand) SetErr(w io.Writer) {
+	c.stderr = w
+	c.Executer.SetErr(w)
+}
+
+func (c *servicesCommand) Execute(ctx context.Context, args []string) error {
+	compose, wait, err := composeCommand()
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Command(), err)
+	}
+	up := []string{"up", "-d"}
+	if wait {
+		up = append(up, "--wait")
+	}
+	defer func() {
+		// services are removed even if the command has been cancelled
+		_, err := c.compose(context.Background(), compose, append([]string{"rm", "-s", "-f", "-v"}, c.services...)...)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: fail to remove services: %s", c.Command(), err)
+			fmt.Fprintln(c.stderr)
+		}
+	}()
+	if _, err := c.compose(ctx, compose, append(up, c.services...)...); err != nil {
+		return fmt.Errorf("%s: fail to start services: %w", c.Command(), err)
+	}
+	if !wait {
+		if err := c.wait(ctx, compose); err != nil {
+			return fmt.Errorf("%s: services not ready: %w", c.Command(), err)
+		}
+	}
+	return c.Executer.Execute(ctx, args)
+}
+
+// wait waits until the containers of the services are healthy (or running
+// when they have no health check). It replaces the --wait option that
+// docker-compose does not have.
+func (c *servicesCommand) wait(ctx context.Context, compose []string) error {
+	for {
+		ok, err := c.ready(ctx, compose)
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clockFrom(ctx).After(composePoll):
+		}
+	}
+}
+
+func (c *servicesCommand) ready(ctx context.Context, compose []string) (bool, error) {
+	out, err := c.compose(ctx, compose, append([]string{"ps", "-q"}, c.services...)...)
+	if err != nil {
+		return false, err
+	}
+	ids := strings.Fields(out)
+	if len(ids) == 0 {
+		return false, nil
+	}
+	out, err = runOutput(ctx, []string{"docker"}, append([]string{"inspect", "-f", composeState}, ids...)...)
+	if err != nil {
+		return false, fmt.Errorf("docker inspect: %w", err)
+	}
+	for _, state := range strings.Fields(out) {
+		switch state {
+		case "healthy", "running":
+		case "starting", "created", "restarting":
+			return false, nil
+		default:
+			return false, fmt.Errorf("container %s", state)
+		}
+	}
+	return true, nil
+}
+
+func (c *servicesCommand) compose(ctx context.Context, compose []string, args ...string) (string, error) {
+	name := args[0]
+	if c.file != "" {
+		args = append([]string{"-f", c.file}, args...)
+	}
+	out, err := runOutput(ctx, compose, args...)
+	if err != nil {
+		err = fmt.Errorf("%s %s: %w", strings.Join(compose, " "), name, err)
+	}
+	return out, err
+}
+
+// runOutput executes the program with the given arguments and gives what it
+// writes on its standard output. The error gives what it writes on its
+// standard error.
+func runOutput(ctx context.Context, prog []string, args ...string) (string, error) {
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+		cmd    = exec.CommandContext(ctx, prog[0], append(prog[1:], args...)...)
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// composeCommand gives the command to use to run docker compose and reports
+// whether it can wait for the services to be healthy.
+func composeCommand() ([]string, bool, error) {
+	if err := exec.Command("docker", "compose", "version").Run(); err == nil {
+		return []string{"docker", "compose"}, true, nil
+	}
+	if _, err := exec.LookPath("docker-compose"); err == nil {
+		return []string{"docker-compose"}, false, nil
+	}
+	return nil, false, fmt.Errorf("docker compose not found")
+}
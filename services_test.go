@@ -0,0 +1,101 @@
+package maestro
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeDocker installs in the PATH a docker that has no compose sub-command
+// and a docker-compose. Their calls are written in the returned file. The
+// container is starting the first time it is inspected and healthy after.
+func fakeDocker(t *testing.T, up string) string {
+	t.Helper()
+	var (
+		dir  = t.TempDir()
+		log  = filepath.Join(dir, "calls")
+		seen = filepath.Join(dir, "seen")
+	)
+	writeTestFile(t, filepath.Join(dir, "docker"), `#!/bin/sh
+echo "docker $*" >> `+log+`
+case "$1" in
+compose) exit 1 ;;
+inspect)
+	if [ -f `+seen+` ]; then echo healthy; else touch `+seen+`; echo starting; fi ;;
+esac
+`)
+	writeTestFile(t, filepath.Join(dir, "docker-compose"), `#!/bin/sh
+echo "docker-compose $*" >> `+log+`
+case "$3" in
+up) `+up+` ;;
+ps) echo c1 ;;
+esac
+`)
+	for _, f := range []string{"docker", "docker-compose"} {
+		if err := os.Chmod(filepath.Join(dir, f), 0755); err != nil {
+			t.Fatalf("fail to make %s executable: %s", f, err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestServices(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake docker commands are shell scripts")
+	}
+	const file = `
+.COMPOSE_FILE = compose.yml
+test(services = db): {
+	echo testing
+}
+`
+	execute := func() (string, error) {
+		var (
+			mst = decodeFile(t, file)
+			ctx = withClock(context.Background(), &fakeClock{})
+			buf strings.Builder
+		)
+		ex := resolveCommand(t, mst, "test", ctreeOption{})
+		err := ex.Execute(ctx, &buf, io.Discard)
+		return buf.String(), err
+	}
+
+	log := fakeDocker(t, "true")
+	out, err := execute()
+	if err != nil {
+		t.Fatalf("test should have succeeded: %s", err)
+	}
+	if out != "testing\n" {
+		t.Errorf("output mismatched: %q", out)
+	}
+	calls, _ := os.ReadFile(log)
+	want := []string{
+		"docker compose version",
+		"docker-compose -f compose.yml up -d db",
+		"docker-compose -f compose.yml ps -q db",
+		"docker inspect",
+		"docker-compose -f compose.yml ps -q db",
+		"docker inspect",
+		"docker-compose -f compose.yml rm -s -f -v db",
+	}
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("calls mismatched: want %q, got %q", want, lines)
+	}
+	for i := range want {
+		if !strings.HasPrefix(lines[i], want[i]) {
+			t.Errorf("call %d mismatched: want %q, got %q", i, want[i], lines[i])
+		}
+	}
+
+	fakeDocker(t, "echo no such service >&2; exit 1")
+	_, err = execute()
+	if err == nil || !strings.Contains(err.Error(), "docker-compose up: no such service") {
+		t.Errorf("failure of compose not reported with its sub-command: %v", err)
+	}
+}
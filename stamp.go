@@ -0,0 +1,189 @@
+package maestro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const stampDir = "stamps"
+
+// stamps records the content hash of each file used as a source of a command.
+type stamps map[string]string
+
+// workGlobs resolves the patterns relative to the working directory of a
+// command so that they do not depend on the directory maestro is run from.
+func workGlobs(patterns []string, dir string) []string {
+	list := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		list = append(list, absPath(p, dir))
+	}
+	return list
+}
+
+// expandGlobs returns the sorted list of files matching the given patterns.
+// Patterns can use ** to match any number of directories. Files rejected by
+// the ignore list are left out.
+func expandGlobs(patterns []string, ign *ignoreList) ([]string, error) {
+	var (
+		list []string
+		seen = make(map[string]struct{})
+	)
+	for _, p := range patterns {
+		var (
+			files []string
+			err   error
+		)
+		if strings.Contains(p, "**") {
+			files, err = walkGlob(p, ign)
+		} else {
+			files, err = filepath.Glob(p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		for _, f := range files {
+			if _, ok := seen[f]; ok || ign.Ignored(f) {
+				continue
+			}
+			seen[f] = struct{}{}
+			list = append(list, f)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func walkGlob(pattern string, ign *ignoreList) ([]string, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	root := globRoot(pattern)
+	rule, err := parseIgnoreRule("/" + strings.TrimPrefix(pattern, "./"))
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	err = filepath.WalkDir(filepath.FromSlash(root), func(file string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if file != root && ign.Ignored(file) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if rule.re.MatchString(filepath.ToSlash(filepath.Clean(file))) {
+			list = append(list, file)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// globRoot gives the directory part of a pattern that does not contain any
+// special characters.
+func globRoot(pattern string) string {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	root := "."
+	if i := strings.IndexAny(pattern, "*?["); i > 0 {
+		if j := strings.LastIndexByte(pattern[:i], '/'); j >= 0 {
+			root = pattern[:j]
+		}
+	} else if i < 0 {
+		root = path.Dir(pattern)
+	}
+	if root == "" {
+		root = "/"
+	}
+	return filepath.FromSlash(root)
+}
+
+func hashFiles(files []string) (stamps, error) {
+	set := make(stamps)
+	for _, f := range files {
+		sum, err := hashFile(f)
+		if err != nil {
+			return nil, err
+		}
+		set[f] = sum
+	}
+	return set, nil
+}
+
+func hashFile(file string) (string, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+func (s stamps) Equal(other stamps) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for k, v := range s {
+		if other[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func stampFile(dir, name string) string {
+	return filepath.Join(dir, stampDir, name+".json")
+}
+
+func readStamps(dir, name string) (stamps, error) {
+	buf, err := os.ReadFile(stampFile(dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return nil, err
+	}
+	var set stamps
+	return set, json.Unmarshal(buf, &set)
+}
+
+func writeStamps(dir, name string, set stamps) error {
+	file := stampFile(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf, 0644)
+}
+
+// sourcesChanged reports whether the content of the given files differs from
+// the one recorded by the last successful run of the command. The computed
+// stamps are returned so that they can be saved once the command succeeds.
+func sourcesChanged(dir, name string, files []string) (bool, stamps, error) {
+	curr, err := hashFiles(files)
+	if err != nil {
+		return true, nil, err
+	}
+	prev, err := readStamps(dir, name)
+	if err != nil {
+		return true, curr, err
+	}
+	return prev == nil || !prev.Equal(curr), curr, nil
+}
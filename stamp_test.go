@@ -0,0 +1,177 @@
+package maestro
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"main.go", "cmd/app/app.go", "cmd/app/app_test.go", "vendor/lib/lib.go", "doc.md"} {
+		writeTestFile(t, filepath.Join(dir, f), f)
+	}
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "vendor/\n*_test.go\n")
+	ign, err := loadIgnore(dir)
+	if err != nil {
+		t.Fatalf("fail to load ignore files: %s", err)
+	}
+	tests := []struct {
+		Patterns []string
+		Want     []string
+	}{
+		{Patterns: []string{"*.go"}, Want: []string{"main.go"}},
+		{Patterns: []string{"**/*.go"}, Want: []string{"cmd/app/app.go", "main.go"}},
+		{Patterns: []string{"cmd/**/*.go", "*.md", "cmd/app/app.go"}, Want: []string{"cmd/app/app.go", "doc.md"}},
+		{Patterns: []string{"*.txt"}},
+	}
+	for _, tt := range tests {
+		var patterns []string
+		for _, p := range tt.Patterns {
+			patterns = append(patterns, filepath.Join(dir, p))
+		}
+		list, err := expandGlobs(patterns, ign)
+		if err != nil {
+			t.Errorf("%q: fail to expand: %s", tt.Patterns, err)
+			continue
+		}
+		var got []string
+		for _, f := range list {
+			rel, _ := filepath.Rel(dir, f)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.Want, " ") {
+			t.Errorf("%q: want %q, got %q", tt.Patterns, tt.Want, got)
+		}
+	}
+}
+
+func TestSourcesChanged(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		file = filepath.Join(dir, "main.go")
+	)
+	writeTestFile(t, file, "package main")
+	changed, set, err := sourcesChanged(dir, "build", []string{file})
+	if err != nil || !changed {
+		t.Fatalf("sources should have changed without stamps (%v)", err)
+	}
+	if err := writeStamps(dir, "build", set); err != nil {
+		t.Fatalf("fail to write stamps: %s", err)
+	}
+	if changed, _, err = sourcesChanged(dir, "build", []string{file}); err != nil || changed {
+		t.Errorf("sources should not have changed (%v)", err)
+	}
+	writeTestFile(t, file, "package app")
+	if changed, _, err = sourcesChanged(dir, "build", []string{file}); err != nil || !changed {
+		t.Errorf("sources should have changed after a write (%v)", err)
+	}
+}
+
+func TestFreshHash(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		source = filepath.Join(dir, "main.go")
+		target = filepath.Join(dir, "app")
+		mst    = decodeFile(t, "build(fresh = hash): {\n\ttrue\n}\n")
+	)
+	cmd, err := mst.Commands.Lookup("build")
+	if err != nil {
+		t.Fatalf("command not found: %s", err)
+	}
+	ex, err := cmd.Prepare()
+	if err != nil {
+		t.Fatalf("fail to prepare command: %s", err)
+	}
+	fc := freshCommand{
+		Executer: ex,
+		dir:      dir,
+		mode:     cmd.Fresh,
+		sources:  []string{source},
+		targets:  []string{target},
+	}
+	check := func(want bool) stamps {
+		t.Helper()
+		ok, set, err := fc.upToDate()
+		if err != nil {
+			t.Fatalf("fail to check sources: %s", err)
+		}
+		if ok != want {
+			t.Errorf("up to date: want %t, got %t", want, ok)
+		}
+		return set
+	}
+	writeTestFile(t, source, "package main")
+	writeTestFile(t, target, "binary")
+	if err := writeStamps(dir, cmd.Name, check(false)); err != nil {
+		t.Fatalf("fail to write stamps: %s", err)
+	}
+	check(true)
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(source, future, future); err != nil {
+		t.Fatalf("fail to touch source: %s", err)
+	}
+	check(true)
+	fc.mode = FreshTime
+	check(false)
+	fc.mode = FreshHash
+
+	if err := os.Remove(target); err != nil {
+		t.Fatalf("fail to remove target: %s", err)
+	}
+	check(false)
+	writeTestFile(t, target, "binary")
+	writeTestFile(t, source, "package app")
+	check(false)
+}
+
+func TestFreshWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	file := fmt.Sprintf(`
+build(
+	workdir = %q,
+	sources = "src/*.go",
+	targets = "bin/app",
+): {
+	true
+}
+`, dir)
+	mst := decodeFile(t, file)
+	cmd, err := mst.Commands.Lookup("build")
+	if err != nil {
+		t.Fatalf("command not found: %s", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "src", "main.go"), "package main")
+	if ok, err := mst.upToDate(cmd); err != nil || ok {
+		t.Fatalf("command without target should not be up to date (%v)", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "bin", "app"), "binary")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "src", "main.go"), past, past); err != nil {
+		t.Fatalf("fail to touch source: %s", err)
+	}
+	if ok, err := mst.upToDate(cmd); err != nil || !ok {
+		t.Errorf("targets in workdir should be up to date (%v)", err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "src", "main.go"), future, future); err != nil {
+		t.Fatalf("fail to touch source: %s", err)
+	}
+	if ok, err := mst.upToDate(cmd); err != nil || ok {
+		t.Errorf("source in workdir newer than target should not be up to date (%v)", err)
+	}
+}
+
+func writeTestFile(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("fail to create directory: %s", err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("fail to write %s: %s", file, err)
+	}
+}
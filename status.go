@@ -0,0 +1,121 @@
+package maestro
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+// Env prints the variables describing the project of the maestro file. With
+// --shell, the variables are printed as export statements to be used with
+// eval in an interactive shell.
+func (m *Maestro) Env(args []string) error {
+	var (
+		set   = flag.NewFlagSet(CmdEnv, flag.ExitOnError)
+		shell = set.Bool("shell", false, "print variables as shell export statements")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	for _, v := range m.environ() {
+		if *shell {
+			fmt.Fprintf(stdio.Stdout, "export %s=%s", v[0], shellQuote(v[1]))
+		} else {
+			fmt.Fprintf(stdio.Stdout, "%s=%s", v[0], v[1])
+		}
+		fmt.Fprintln(stdio.Stdout)
+	}
+	return nil
+}
+
+func (m *Maestro) environ() [][2]string {
+	file, err := filepath.Abs(m.File)
+	if err != nil {
+		file = m.File
+	}
+	list := [][2]string{
+		{"MAESTRO_FILE", file},
+		{"MAESTRO_ROOT", filepath.Dir(file)},
+		{"MAESTRO_NAME", m.Name()},
+		{"MAESTRO_VERSION", m.Version},
+	}
+	if m.Namespace != "" {
+		list = append(list, [2]string{"MAESTRO_NAMESPACE", m.Namespace})
+	}
+	return list
+}
+
+// Status prints a summary of the project: its name, version, number of
+// commands and the commands whose targets are out of date. With --porcelain,
+// the summary is printed on a single line of key=value pairs that is stable
+// enough to be used in a prompt segment.
+func (m *Maestro) Status(args []string) error {
+	var (
+		set       = flag.NewFlagSet(CmdStatus, flag.ExitOnError)
+		porcelain = set.Bool("porcelain", false, "print status in a stable, easy to parse format")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	var (
+		count int
+		stale []string
+	)
+	for _, c := range m.Commands {
+		if c.Blocked() {
+			continue
+		}
+		count++
+		if len(c.Sources) == 0 {
+			continue
+		}
+		if ok, err := m.upToDate(c); err == nil && !ok {
+			stale = append(stale, c.Name)
+		}
+	}
+	sort.Strings(stale)
+	if *porcelain {
+		return m.writeStatus(stdio.Stdout, count, stale)
+	}
+	fmt.Fprintf(stdio.Stdout, "%s %s", m.Name(), m.Version)
+	fmt.Fprintln(stdio.Stdout)
+	fmt.Fprintf(stdio.Stdout, "%d command(s) available", count)
+	fmt.Fprintln(stdio.Stdout)
+	if len(stale) > 0 {
+		fmt.Fprintf(stdio.Stdout, "out of date: %s", strings.Join(stale, ", "))
+		fmt.Fprintln(stdio.Stdout)
+	}
+	return nil
+}
+
+func (m *Maestro) writeStatus(w io.Writer, count int, stale []string) error {
+	fields := []string{
+		fmt.Sprintf("name=%s", m.Name()),
+		fmt.Sprintf("version=%s", m.Version),
+		fmt.Sprintf("commands=%d", count),
+		fmt.Sprintf("stale=%d", len(stale)),
+	}
+	if len(stale) > 0 {
+		fields = append(fields, fmt.Sprintf("outdated=%s", strings.Join(stale, ",")))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(fields, " "))
+	return err
+}
+
+func (m *Maestro) upToDate(cmd CommandSettings) (bool, error) {
+	ex, err := cmd.Prepare()
+	if err != nil {
+		return false, err
+	}
+	fc, err := m.fresh(ex, cmd)
+	if err != nil {
+		return false, err
+	}
+	ok, _, err := fc.(*freshCommand).upToDate()
+	return ok, err
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package maestro
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyInput writes what maestro reads on its standard input to w until the
+// returned function is called. The input is read in non blocking mode so that
+// the copy can be stopped while it waits for data.
+func copyInput(w io.Writer) func() {
+	fd, err := unix.Dup(int(os.Stdin.Fd()))
+	if err != nil {
+		return func() {}
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return func() {}
+	}
+	var (
+		in   = os.NewFile(uintptr(fd), os.Stdin.Name())
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		io.Copy(w, in)
+	}()
+	return func() {
+		// the input can not be interrupted when it can not be polled (eg: a
+		// regular file) but reading it does not block either
+		if err := in.SetReadDeadline(time.Now()); err == nil {
+			<-done
+		}
+		// the descriptor shares its mode with the standard input of maestro
+		unix.SetNonblock(fd, false)
+		in.Close()
+	}
+}
@@ -0,0 +1,191 @@
+package maestro
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var taskVar = regexp.MustCompile(`{{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+type taskfile struct {
+	Vars  map[string]interface{} `yaml:"vars"`
+	Env   map[string]interface{} `yaml:"env"`
+	Tasks map[string]taskDef     `yaml:"tasks"`
+}
+
+type taskDef struct {
+	Desc      string     `yaml:"desc"`
+	Dir       string     `yaml:"dir"`
+	Deps      []taskCall `yaml:"deps"`
+	Cmds      []taskCmd  `yaml:"cmds"`
+	Sources   []string   `yaml:"sources"`
+	Generates []string   `yaml:"generates"`
+	Silent    bool       `yaml:"silent"`
+	Vars      yaml.Node  `yaml:"vars"`
+	Env       yaml.Node  `yaml:"env"`
+}
+
+// UnmarshalYAML accepts the short forms of a task: a single command or a list
+// of commands.
+func (t *taskDef) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		t.Cmds = []taskCmd{{Cmd: node.Value}}
+		return nil
+	case yaml.SequenceNode:
+		return node.Decode(&t.Cmds)
+	default:
+		type plain taskDef
+		return node.Decode((*plain)(t))
+	}
+}
+
+type taskCall struct {
+	Task string `yaml:"task"`
+}
+
+func (t *taskCall) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t.Task = node.Value
+		return nil
+	}
+	type plain taskCall
+	return node.Decode((*plain)(t))
+}
+
+type taskCmd struct {
+	Cmd    string `yaml:"cmd"`
+	Task   string `yaml:"task"`
+	Defer  string `yaml:"defer"`
+	Silent bool   `yaml:"silent"`
+	Ignore bool   `yaml:"ignore_error"`
+}
+
+func (t *taskCmd) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t.Cmd = node.Value
+		return nil
+	}
+	type plain taskCmd
+	return node.Decode((*plain)(t))
+}
+
+// parseTaskfile converts the tasks of a Taskfile (go-task). Dynamic variables
+// and deferred commands have no equivalent and are reported as skipped.
+func parseTaskfile(r io.Reader) (*importFile, error) {
+	var (
+		tf taskfile
+		mf importFile
+	)
+	if err := yaml.NewDecoder(r).Decode(&tf); err != nil {
+		return nil, err
+	}
+	for _, k := range sortedKeys(tf.Vars) {
+		v, ok := taskValue(tf.Vars[k])
+		if !ok {
+			mf.Skipped = append(mf.Skipped, fmt.Sprintf("vars: %s", k))
+			continue
+		}
+		mf.Vars = append(mf.Vars, importVar{Name: importName(k), Values: []string{v}})
+	}
+	for _, k := range sortedKeys(tf.Env) {
+		v, ok := taskValue(tf.Env[k])
+		if !ok {
+			mf.Skipped = append(mf.Skipped, fmt.Sprintf("env: %s", k))
+			continue
+		}
+		mf.Env = append(mf.Env, importVar{Name: k, Values: []string{v}})
+	}
+	var names []string
+	for n := range tf.Tasks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		var (
+			t   = tf.Tasks[n]
+			cmd = importCommand{
+				Name:    commandName(n),
+				Help:    t.Desc,
+				WorkDir: t.Dir,
+				Sources: t.Sources,
+				Targets: t.Generates,
+			}
+		)
+		if n == "default" {
+			mf.Default = cmd.Name
+		}
+		if !t.Vars.IsZero() || !t.Env.IsZero() {
+			mf.Skipped = append(mf.Skipped, fmt.Sprintf("tasks.%s: vars and env", n))
+		}
+		for _, d := range t.Deps {
+			cmd.Deps = append(cmd.Deps, commandName(d.Task))
+		}
+		for _, c := range t.Cmds {
+			var lines []string
+			switch {
+			case c.Task != "":
+				lines = append(lines, commandName(c.Task))
+			case c.Defer != "":
+				mf.Skipped = append(mf.Skipped, fmt.Sprintf("tasks.%s: defer: %s", n, c.Defer))
+				continue
+			default:
+				for _, str := range strings.Split(c.Cmd, "\n") {
+					if str = strings.TrimSpace(str); str != "" {
+						lines = append(lines, convertTaskVars(str))
+					}
+				}
+			}
+			for _, line := range lines {
+				if c.Ignore {
+					line = string(minus) + line
+				}
+				if c.Silent || t.Silent {
+					line = string(arobase) + line
+				}
+				cmd.Script = append(cmd.Script, line)
+			}
+		}
+		mf.Commands = append(mf.Commands, cmd)
+	}
+	return &mf, nil
+}
+
+// convertTaskVars rewrites the {{.NAME}} templates as variables. The special
+// CLI_ARGS variable becomes the arguments of the command.
+func convertTaskVars(str string) string {
+	return taskVar.ReplaceAllStringFunc(str, func(ref string) string {
+		name := taskVar.FindStringSubmatch(ref)[1]
+		if name == "CLI_ARGS" {
+			return "$@"
+		}
+		return "${" + importName(name) + "}"
+	})
+}
+
+// taskValue gives the value of a static variable. Dynamic variables (sh) are
+// not supported.
+func taskValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return convertTaskVars(v), true
+	case int, float64, bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
+
+func sortedKeys(set map[string]interface{}) []string {
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
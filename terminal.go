@@ -0,0 +1,138 @@
+package maestro
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// console is the pseudo terminal allocated for an interactive command when
+// maestro has no terminal. The programs started by the command get it as
+// their controlling terminal (see pathCommand).
+type console struct {
+	mu  sync.Mutex
+	tty *os.File
+	// program having the terminal as controlling terminal. A terminal can
+	// only be the controlling terminal of one session at a time.
+	owner *pathCommand
+}
+
+// attachTerminal tells whether the interactive commands are connected to the
+// terminal of maestro (or to a pseudo terminal).
+func attachTerminal() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stdout)
+}
+
+func (c *console) Set(tty *os.File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tty, c.owner = tty, nil
+}
+
+// Claim gives the descriptor of the terminal in the files given to the
+// program and records the program as its owner. It fails when the program
+// does not use the terminal or when another program has it.
+func (c *console) Claim(p *pathCommand, files ...interface{}) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tty == nil || c.owner != nil {
+		return 0, false
+	}
+	for i, f := range files {
+		if f, ok := f.(*os.File); ok && f == c.tty {
+			c.owner = p
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (c *console) Release(p *pathCommand) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owner == p {
+		c.owner = nil
+	}
+}
+
+func (c *command) SetConsole(cs *console) {
+	c.console = cs
+}
+
+// attach connects an interactive command to the terminal of maestro so that
+// the command can prompt its user. The output of the command is then written
+// as is to the terminal: it is neither prefixed nor masked.
+//
+// Without terminal, a pseudo terminal is allocated for the command: what
+// maestro reads on its standard input is written to it and what the command
+// writes to it is written to the output of the command.
+func (c *command) attach() (func(), error) {
+	if attachTerminal() {
+		c.shell.SetIn(os.Stdin)
+		c.shell.SetOut(os.Stdout)
+		c.shell.SetErr(os.Stderr)
+		return func() {}, nil
+	}
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	c.shell.SetIn(slave)
+	c.shell.SetOut(slave)
+	c.shell.SetErr(slave)
+	if c.console != nil {
+		c.console.Set(slave)
+	}
+
+	var (
+		done = make(chan struct{})
+		stop = copyInput(master)
+	)
+	go func() {
+		defer close(done)
+		io.Copy(c.output(), master)
+	}()
+	return func() {
+		stop()
+		if c.console != nil {
+			c.console.Set(nil)
+		}
+		// reading the master fails once the slave is closed and all the
+		// output of the command has been read
+		slave.Close()
+		<-done
+		master.Close()
+	}, nil
+}
+
+// requestPty allocates a pseudo terminal for sess having the size of the
+// terminal of maestro. The terminal is put in raw mode so that what the user
+// types is given as is to the remote command until restore is called.
+func requestPty(sess *ssh.Session) (func(), error) {
+	var (
+		width, height = 80, 24
+		kind          = os.Getenv("TERM")
+	)
+	if kind == "" {
+		kind = "xterm"
+	}
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		width, height = w, h
+	}
+	if err := sess.RequestPty(kind, height, width, ssh.TerminalModes{}); err != nil {
+		return nil, err
+	}
+	if !isTerminal(os.Stdin) {
+		return func() {}, nil
+	}
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		term.Restore(int(os.Stdin.Fd()), state)
+	}, nil
+}
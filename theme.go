@@ -0,0 +1,32 @@
+package maestro
+
+import (
+	"fmt"
+)
+
+const (
+	colorGreen = "32"
+	colorRed   = "31"
+)
+
+// Theme gives the glyphs used to report the status of the commands. Without
+// glyphs, the status is not reported.
+type Theme struct {
+	// icon of the commands without icon property
+	Icon    string
+	Success string
+	Failure string
+	Color   bool
+}
+
+// Status gives the glyph of the status of a command that has returned err.
+func (t Theme) Status(err error) string {
+	glyph, color := t.Success, colorGreen
+	if err != nil {
+		glyph, color = t.Failure, colorRed
+	}
+	if glyph == "" || !t.Color {
+		return glyph
+	}
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, glyph)
+}
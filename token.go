@@ -11,6 +11,14 @@ const (
 	kwExport  = "export"
 	kwDelete  = "delete"
 	kwAlias   = "alias"
+	kwPreset  = "preset"
+	kwIf      = "if"
+	kwElse    = "else"
+	kwFor     = "for"
+	kwIn      = "in"
+	kwPrint   = "print"
+	kwEcho    = "echo"
+	kwOptions = "options"
 )
 
 const (
@@ -28,6 +36,9 @@ const (
 	Quote
 	Assign
 	Append
+	AssignDefault
+	AssignImmediate
+	AssignShell
 	Comma
 	Background
 	Dependency
@@ -41,11 +52,20 @@ const (
 	Mandatory
 	Hidden
 	Resolution
+	Condition
+	Loop
+	Substitution
+	BegAttr
+	EndAttr
+	Pipe
 )
 
 type Position struct {
 	Line   int
 	Column int
+	// file where the command (or the line of its script) has been defined.
+	// It is only set by the decoder for the commands and their lines
+	File string
 }
 
 func (p Position) String() string {
@@ -56,6 +76,9 @@ type Token struct {
 	Literal string
 	Type    rune
 	Position
+
+	// reason of an invalid token (eg: unterminated string)
+	reason string
 }
 
 func createToken(str string, kind rune) Token {
@@ -88,6 +111,12 @@ func (t Token) String() string {
 		return "<assign>"
 	case Append:
 		return "<append>"
+	case AssignDefault:
+		return "<assign-default>"
+	case AssignImmediate:
+		return "<assign-immediate>"
+	case AssignShell:
+		return "<assign-shell>"
 	case Comma:
 		return "<comma>"
 	case Dependency:
@@ -98,6 +127,10 @@ func (t Token) String() string {
 		return "<beg-list>"
 	case EndList:
 		return "<end-list>"
+	case BegAttr:
+		return "<beg-attr>"
+	case EndAttr:
+		return "<end-attr>"
 	case BegScript:
 		return "<beg-script>"
 	case EndScript:
@@ -108,6 +141,8 @@ func (t Token) String() string {
 		return "<quote>"
 	case Resolution:
 		return "<resolution>"
+	case Pipe:
+		return "<pipe>"
 	case Ident:
 		prefix = "ident"
 	case String:
@@ -124,12 +159,23 @@ func (t Token) String() string {
 		prefix = "script"
 	case Keyword:
 		prefix = "keyword"
+	case Condition:
+		prefix = "condition"
+	case Loop:
+		prefix = "loop"
+	case Substitution:
+		prefix = "substitution"
 	}
 	return fmt.Sprintf("%s(%s)", prefix, t.Literal)
 }
 
 func (t Token) IsAssign() bool {
-	return t.Type == Append || t.Type == Assign
+	switch t.Type {
+	case Assign, Append, AssignDefault, AssignImmediate, AssignShell:
+		return true
+	default:
+		return false
+	}
 }
 
 func (t Token) IsVariable() bool {
@@ -0,0 +1,146 @@
+package maestro
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/midbel/maestro/internal/copyslice"
+)
+
+const nvmrc = ".nvmrc"
+
+// environ gives the variables to export in the environment of the command
+// once the toolchains declared by its properties are activated.
+func (s CommandSettings) environ() (map[string]string, error) {
+	ev := s.Ev
+	if len(s.DotEnv) > 0 {
+		var err error
+		if ev, err = loadDotEnv(ev, s.DotEnv); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.PathPrepend) == 0 && s.Venv == "" && s.Node == "" && len(s.GoFlags) == 0 {
+		return ev, nil
+	}
+	dirs, err := s.searchPaths()
+	if err != nil {
+		return nil, err
+	}
+	ev = copyslice.CopyMap[string, string](ev)
+	if len(dirs) > 0 {
+		path, ok := ev["PATH"]
+		if !ok {
+			path = os.Getenv("PATH")
+		}
+		ev["PATH"] = strings.Join(append(dirs, path), string(os.PathListSeparator))
+	}
+	if s.Venv != "" {
+		ev["VIRTUAL_ENV"] = absPath(s.Venv, s.workDir())
+	}
+	if len(s.GoFlags) > 0 {
+		ev["GOFLAGS"] = strings.Join(s.GoFlags, " ")
+	}
+	return ev, nil
+}
+
+// searchPaths gives the list of directories to add in front of the PATH of
+// the command.
+func (s CommandSettings) searchPaths() ([]string, error) {
+	var (
+		list []string
+		dir  = s.workDir()
+	)
+	for _, d := range s.PathPrepend {
+		list = append(list, absPath(d, dir))
+	}
+	if s.Venv != "" {
+		bin := "bin"
+		if runtime.GOOS == "windows" {
+			bin = "Scripts"
+		}
+		list = append(list, filepath.Join(absPath(s.Venv, dir), bin))
+	}
+	if s.Node != "" {
+		bin, err := nodeBin(s.Node, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		list = append(list, bin)
+	}
+	return list, nil
+}
+
+func (s CommandSettings) workDir() string {
+	if s.WorkDir != "" {
+		return s.WorkDir
+	}
+	dir, _ := os.Getwd()
+	return dir
+}
+
+// nodeBin gives the bin directory of the node version installed with nvm
+// that matches the given version. The version can also be read from a
+// .nvmrc file.
+func nodeBin(version, dir string) (string, error) {
+	if version == "auto" {
+		version = nvmrc
+	}
+	if file := absPath(version, dir); isFile(file) {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		version = strings.TrimSpace(string(buf))
+	}
+	root := os.Getenv("NVM_DIR")
+	if root == "" {
+		root = normalizePath("~/.nvm")
+	}
+	root = filepath.Join(root, "versions", "node")
+	list, err := os.ReadDir(root)
+	if err != nil {
+		return "", fmt.Errorf("node %s: version not installed", version)
+	}
+	var (
+		want  = strings.TrimPrefix(version, "v")
+		found []string
+	)
+	for _, e := range list {
+		v := strings.TrimPrefix(e.Name(), "v")
+		if v == want || strings.HasPrefix(v, want+".") {
+			found = append(found, e.Name())
+		}
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("node %s: version not installed", version)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return compareVersion(found[i], found[j]) > 0
+	})
+	return filepath.Join(root, found[0], "bin"), nil
+}
+
+func compareVersion(v1, v2 string) int {
+	var (
+		p1 = strings.Split(strings.TrimPrefix(v1, "v"), ".")
+		p2 = strings.Split(strings.TrimPrefix(v2, "v"), ".")
+	)
+	for i := 0; i < len(p1) && i < len(p2); i++ {
+		n1, _ := strconv.Atoi(p1[i])
+		n2, _ := strconv.Atoi(p2[i])
+		if n1 != n2 {
+			return n1 - n2
+		}
+	}
+	return len(p1) - len(p2)
+}
+
+func isFile(file string) bool {
+	i, err := os.Stat(file)
+	return err == nil && i.Mode().IsRegular()
+}
@@ -0,0 +1,319 @@
+package maestro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	TraceCompact = "compact"
+	TraceLong    = "long"
+	TraceJson    = "json"
+)
+
+func checkTraceFormat(format string) error {
+	switch format {
+	case "", TraceCompact, TraceLong, TraceJson:
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported trace format", format)
+	}
+}
+
+// slowestCount is the number of commands given in the summary of the trace.
+const slowestCount = 10
+
+// traceReport writes the trace of the commands executed and keeps the
+// cumulative time of the commands executed at each level of the dependency
+// tree (0 being the command called). With lines, the trace of each line of
+// the scripts is written and the cumulative time of each command is kept for
+// the summary written at the end of the run.
+type traceReport struct {
+	mu     sync.Mutex
+	format string
+	theme  Theme
+	levels []time.Duration
+
+	lines    bool
+	commands map[string]*traceTotal
+}
+
+type traceTotal struct {
+	Command string        `json:"command"`
+	Count   int           `json:"count"`
+	Elapsed time.Duration `json:"-"`
+}
+
+func createTraceReport(format string, theme Theme) *traceReport {
+	if format == "" {
+		format = TraceCompact
+	}
+	return &traceReport{
+		format: format,
+		theme:  theme,
+	}
+}
+
+type traceEntry struct {
+	Command    string        `json:"command"`
+	Level      int           `json:"level"`
+	Start      time.Time     `json:"start"`
+	Elapsed    time.Duration `json:"-"`
+	Cumulative time.Duration `json:"-"`
+	Err        error         `json:"-"`
+}
+
+func (t *traceReport) Report(w io.Writer, e traceEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.levels) <= e.Level {
+		t.levels = append(t.levels, 0)
+	}
+	t.levels[e.Level] += e.Elapsed
+	e.Cumulative = t.levels[e.Level]
+
+	if t.lines {
+		if t.commands == nil {
+			t.commands = make(map[string]*traceTotal)
+		}
+		x, ok := t.commands[e.Command]
+		if !ok {
+			x = &traceTotal{Command: e.Command}
+			t.commands[e.Command] = x
+		}
+		x.Count++
+		x.Elapsed += e.Elapsed
+	}
+
+	setPrefix(w, "trace")
+	switch t.format {
+	case TraceLong:
+		t.long(w, e)
+	case TraceJson:
+		t.json(w, e)
+	default:
+		t.compact(w, e)
+	}
+}
+
+type traceLine struct {
+	Command string        `json:"command"`
+	Line    string        `json:"line"`
+	Start   time.Time     `json:"start"`
+	Elapsed time.Duration `json:"-"`
+	Err     error         `json:"-"`
+}
+
+// Line writes the trace of a line of the script of a command. The prefix of w
+// is given back to the command once written.
+func (t *traceReport) Line(w io.Writer, e traceLine) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	setPrefix(w, "trace")
+	defer setPrefix(w, e.Command)
+	if t.format == TraceJson {
+		x := struct {
+			traceLine
+			Duration string  `json:"duration"`
+			Seconds  float64 `json:"seconds"`
+			Error    string  `json:"error,omitempty"`
+		}{
+			traceLine: e,
+			Duration:  humanDuration(e.Elapsed),
+			Seconds:   e.Elapsed.Seconds(),
+		}
+		if e.Err != nil {
+			x.Error = e.Err.Error()
+		}
+		json.NewEncoder(w).Encode(x)
+		return
+	}
+	if glyph := t.theme.Status(e.Err); glyph != "" {
+		fmt.Fprintf(w, "%s ", glyph)
+	}
+	fmt.Fprintf(w, "%s +%s %s: %s", e.Start.Format(time.RFC3339), humanDuration(e.Elapsed), e.Command, e.Line)
+	if e.Err != nil {
+		fmt.Fprintf(w, " (error: %s)", e.Err)
+	}
+	fmt.Fprintln(w)
+}
+
+// Summary writes the commands that have taken the most time during the run
+// with the number of times they have been executed.
+func (t *traceReport) Summary(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.commands) == 0 {
+		return
+	}
+	var list []*traceTotal
+	for _, x := range t.commands {
+		list = append(list, x)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Elapsed == list[j].Elapsed {
+			return list[i].Command < list[j].Command
+		}
+		return list[i].Elapsed > list[j].Elapsed
+	})
+	if len(list) > slowestCount {
+		list = list[:slowestCount]
+	}
+	setPrefix(w, "trace")
+	if t.format == TraceJson {
+		type total struct {
+			*traceTotal
+			Duration string  `json:"duration"`
+			Seconds  float64 `json:"seconds"`
+		}
+		var all []total
+		for _, x := range list {
+			all = append(all, total{
+				traceTotal: x,
+				Duration:   humanDuration(x.Elapsed),
+				Seconds:    x.Elapsed.Seconds(),
+			})
+		}
+		json.NewEncoder(w).Encode(struct {
+			Slowest []total `json:"slowest"`
+		}{
+			Slowest: all,
+		})
+		return
+	}
+	tw := tabwriter.NewWriter(w, 8, 2, 2, ' ', 0)
+	fmt.Fprint(tw, "command\truns\ttotal\taverage")
+	fmt.Fprintln(tw)
+	for _, x := range list {
+		avg := x.Elapsed / time.Duration(x.Count)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s", x.Command, x.Count, humanDuration(x.Elapsed), humanDuration(avg))
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+func (t *traceReport) compact(w io.Writer, e traceEntry) {
+	if glyph := t.theme.Status(e.Err); glyph != "" {
+		fmt.Fprintf(w, "%s ", glyph)
+	}
+	fmt.Fprintf(w, "%s %s: %s", e.Start.Format("15:04:05.000"), e.Command, humanDuration(e.Elapsed))
+	if e.Err != nil {
+		fmt.Fprintf(w, " (error: %s)", e.Err)
+	}
+	fmt.Fprintln(w)
+}
+
+func (t *traceReport) long(w io.Writer, e traceEntry) {
+	status := "ok"
+	if e.Err != nil {
+		status = e.Err.Error()
+	}
+	if glyph := t.theme.Status(e.Err); glyph != "" {
+		status = fmt.Sprintf("%s %s", glyph, status)
+	}
+	fmt.Fprintf(w, "command: %s", e.Command)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "status:  %s", status)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "start:   %s", e.Start.Format("2006-01-02 15:04:05.000"))
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "time:    %s", humanDuration(e.Elapsed))
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "level:   %d (cumulative: %s)", e.Level, humanDuration(e.Cumulative))
+	fmt.Fprintln(w)
+}
+
+func (t *traceReport) json(w io.Writer, e traceEntry) {
+	x := struct {
+		traceEntry
+		Duration   string  `json:"duration"`
+		Seconds    float64 `json:"seconds"`
+		Cumulative string  `json:"cumulative"`
+		Error      string  `json:"error,omitempty"`
+	}{
+		traceEntry: e,
+		Duration:   humanDuration(e.Elapsed),
+		Seconds:    e.Elapsed.Seconds(),
+		Cumulative: humanDuration(e.Cumulative),
+	}
+	if e.Err != nil {
+		x.Error = e.Err.Error()
+	}
+	json.NewEncoder(w).Encode(x)
+}
+
+type exectrace struct {
+	inner  executer
+	name   string
+	level  int
+	report *traceReport
+}
+
+func trace(ex executer, name string, level int, report *traceReport) executer {
+	return exectrace{
+		inner:  ex,
+		name:   name,
+		level:  level,
+		report: report,
+	}
+}
+
+func (e exectrace) Execute(ctx context.Context, stdout, stderr io.Writer) error {
+	if e.report.lines {
+		ctx = withTrace(ctx, e.report)
+	}
+	var (
+		clock = clockFrom(ctx)
+		now   = clock.Now()
+		err   = e.inner.Execute(ctx, stdout, stderr)
+	)
+	e.report.Report(stderr, traceEntry{
+		Command: e.name,
+		Level:   e.level,
+		Start:   now,
+		Elapsed: clock.Now().Sub(now),
+		Err:     err,
+	})
+	return err
+}
+
+func (e exectrace) Bg() bool {
+	return inBackground(e.inner)
+}
+
+type traceKey struct{}
+
+// withTrace gives a context used by the commands to trace each line of their
+// scripts.
+func withTrace(ctx context.Context, t *traceReport) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func traceFrom(ctx context.Context) *traceReport {
+	t, _ := ctx.Value(traceKey{}).(*traceReport)
+	return t
+}
+
+// humanDuration rounds d to keep only its significant units (eg: 1m32s,
+// 1.25s, 120ms).
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(10 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
@@ -14,13 +14,14 @@ import (
 type ValidateFunc func(string) error
 
 const (
-	validNot  = "not"
-	validSome = "some"
-	validAll  = "all"
+	validNot   = "not"
+	validSome  = "some"
+	validAll   = "all"
+	validOneOf = "oneof"
 )
 
 var validations = map[string]func([]string) (ValidateFunc, error){
-	"oneof":      validateOneOf,
+	validOneOf:   validateOneOf,
 	"noneof":     validateNoneOf,
 	"notempty":   validateNotEmpty,
 	"match":      validateMatch,
@@ -0,0 +1,178 @@
+package maestro
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/midbel/maestro/internal/stdio"
+)
+
+const defaultDebounce = 300 * time.Millisecond
+
+func (m *Maestro) Watch(args []string) error {
+	var (
+		set   = flag.NewFlagSet(CmdWatch, flag.ExitOnError)
+		delay = set.Duration("d", defaultDebounce, "wait for no change during the given delay before executing command")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	name := set.Arg(0)
+	if name == "" {
+		name = m.MetaExec.Default
+	}
+	cmd, err := m.Commands.Lookup(name)
+	if err != nil {
+		return m.suggest(err, name)
+	}
+	if len(cmd.Watch) == 0 {
+		return fmt.Errorf("%s: no files to watch", cmd.Name)
+	}
+	ign, err := loadIgnore(filepath.Dir(m.File))
+	if err != nil {
+		return err
+	}
+	w, err := createWatcher(cmd.Watch, ign)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	defer m.onClose(w)()
+
+	var rest []string
+	if set.NArg() > 1 {
+		rest = set.Args()[1:]
+	}
+	run := func(ctx context.Context) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := m.executeContext(ctx, cmd.Name, rest, stdio.Stdout, stdio.Stderr)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Fprintf(stdio.Stderr, "watch: %s: %s", cmd.Name, err)
+				fmt.Fprintln(stdio.Stderr)
+			}
+		}()
+		return done
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	return w.Run(ctx, *delay, stdio.Stderr, run)
+}
+
+type watcher struct {
+	*fsnotify.Watcher
+	rules []ignoreRule
+	ign   *ignoreList
+}
+
+func createWatcher(patterns []string, ign *ignoreList) (*watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := watcher{
+		Watcher: fw,
+		ign:     ign,
+	}
+	for _, p := range patterns {
+		p = filepath.ToSlash(filepath.Clean(p))
+		rule, err := compileGlob(p)
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		w.rules = append(w.rules, rule)
+		if err := w.addTree(globRoot(p)); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return &w, nil
+}
+
+// Run executes the command once then each time a watched file changes. A run
+// still in progress when a new one is triggered is cancelled first.
+func (w *watcher) Run(ctx context.Context, delay time.Duration, stderr io.Writer, run func(context.Context) <-chan struct{}) error {
+	var (
+		start = func() func() {
+			sub, cancel := context.WithCancel(ctx)
+			done := run(sub)
+			return func() {
+				cancel()
+				<-done
+			}
+		}
+		stop    = start()
+		timer   = time.NewTimer(delay)
+		changed string
+	)
+	timer.Stop()
+	defer func() {
+		stop()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if e.Op&fsnotify.Create != 0 && isDir(e.Name) {
+				w.addTree(e.Name)
+			}
+			if !w.Match(e.Name) {
+				continue
+			}
+			changed = e.Name
+			timer.Reset(delay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(stderr, "watch: %s", err)
+			fmt.Fprintln(stderr)
+		case <-timer.C:
+			stop()
+			fmt.Fprintf(stderr, "watch: %s changed", changed)
+			fmt.Fprintln(stderr)
+			stop = start()
+		}
+	}
+}
+
+func (w *watcher) Match(file string) bool {
+	if w.ign.Ignored(file) {
+		return false
+	}
+	file = filepath.ToSlash(filepath.Clean(file))
+	for _, r := range w.rules {
+		if r.re.MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(file string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !e.IsDir() {
+			return nil
+		}
+		if file != root && w.ign.Ignored(file) {
+			return fs.SkipDir
+		}
+		return w.Add(file)
+	})
+}
@@ -0,0 +1,103 @@
+package maestro
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "main.go"), "package main")
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "gen/\n")
+	ign, err := loadIgnore(dir)
+	if err != nil {
+		t.Fatalf("fail to load ignore files: %s", err)
+	}
+	w, err := createWatcher([]string{filepath.Join(dir, "src", "**", "*.go")}, ign)
+	if err != nil {
+		t.Fatalf("fail to create watcher: %s", err)
+	}
+	defer w.Close()
+
+	var (
+		runs        = make(chan context.Context, 10)
+		errc        = make(chan error, 1)
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+	// each run lasts until it is cancelled
+	run := func(ctx context.Context) <-chan struct{} {
+		done := make(chan struct{})
+		runs <- ctx
+		go func() {
+			defer close(done)
+			<-ctx.Done()
+		}()
+		return done
+	}
+	go func() {
+		errc <- w.Run(ctx, 50*time.Millisecond, io.Discard, run)
+	}()
+	next := func(what string) context.Context {
+		t.Helper()
+		select {
+		case c := <-runs:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: command not executed", what)
+		}
+		return nil
+	}
+	none := func(what string) {
+		t.Helper()
+		select {
+		case <-runs:
+			t.Fatalf("%s: command executed", what)
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	first := next("start")
+	none("without changes")
+
+	for i := 0; i < 5; i++ {
+		writeTestFile(t, filepath.Join(dir, "src", "main.go"), "package main\n")
+		time.Sleep(10 * time.Millisecond)
+	}
+	second := next("change")
+	if first.Err() == nil {
+		t.Errorf("previous run not cancelled")
+	}
+	none("changes debounced")
+
+	writeTestFile(t, filepath.Join(dir, "src", "doc.md"), "doc")
+	writeTestFile(t, filepath.Join(dir, "src", "gen", "gen.go"), "package gen")
+	none("unmatched and ignored files")
+
+	if err := os.Mkdir(filepath.Join(dir, "src", "pkg"), 0755); err != nil {
+		t.Fatalf("fail to create directory: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	writeTestFile(t, filepath.Join(dir, "src", "pkg", "lib.go"), "package pkg")
+	last := next("file in new directory")
+	if second.Err() == nil {
+		t.Errorf("previous run not cancelled")
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watcher not stopped")
+	}
+	if last.Err() == nil {
+		t.Errorf("last run not cancelled when the watcher stops")
+	}
+}